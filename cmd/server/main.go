@@ -68,7 +68,8 @@ var RootCmd = &cobra.Command{
 		return server.Listen(route.InitRoute,
 			server.WithCustomConfigFilePath(configPath),
 			server.WithServerBindHost(serverBindHost),
-			server.WithServerBindPort(serverBindPort))
+			server.WithServerBindPort(serverBindPort),
+			server.WithBackgroundJobs(route.BackgroundJobs...))
 	},
 }
 
@@ -15,6 +15,7 @@ import (
 var Migrations = []migrations.Migration{
 	CreateAdministrator,
 	AddRoleManagerRepo,
+	AddRoleManagerChartRepo,
 }
 
 // 创建默认系统角色: Admin |Manage Cluster| Manage User|Read only|Common User | Manage Chart
@@ -172,3 +173,31 @@ var AddRoleManagerRepo = migrations.Migration{
 		return db.Save(&roleManageRepo)
 	},
 }
+
+var AddRoleManagerChartRepo = migrations.Migration{
+	Version: 3,
+	Message: "Add role chart repository manager",
+	Handler: func(db storm.Node) error {
+		roleManageChartRepo := v1Role.Role{
+			BaseModel: v1.BaseModel{
+				ApiVersion: "v1",
+				Kind:       "Role",
+				BuiltIn:    true,
+				CreateAt:   time.Now(),
+				UpdateAt:   time.Now(),
+			},
+			Metadata: v1.Metadata{
+				Name:        "Manage Chart Repository",
+				Description: "i18n_user_manage_chart_repo",
+				UUID:        uuid.New().String(),
+			},
+			Rules: []v1Role.PolicyRule{
+				{
+					Resource: []string{"chartrepos"},
+					Verbs:    []string{"*"},
+				},
+			},
+		}
+		return db.Save(&roleManageChartRepo)
+	},
+}
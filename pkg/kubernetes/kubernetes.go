@@ -47,6 +47,8 @@ type Interface interface {
 	CreateOrUpdateClusterRoleBinding(clusterRoleName string, username string, builtIn bool) error
 	CreateOrUpdateRolebinding(namespace string, clusterRoleName string, username string, builtIn bool) error
 	CreateAppMarketCRD() error
+	ApplyPullSecret(repo string, namespaces []string, dockerConfig []byte, patchServiceAccount bool) error
+	DeletePullSecrets(repo string) error
 }
 
 type Kubernetes struct {
@@ -0,0 +1,243 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	coreV1 "k8s.io/api/core/v1"
+	k8sError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	LabelImageRepo = "kubepi.org/image-repo"
+	// AnnotationImageRepoName holds the name of the image repo, the name of the secret and the label only stand for it
+	AnnotationImageRepoName = "kubepi.org/image-repo-name"
+)
+
+var invalidSecretNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// PullSecretName is the name of the pull secret of an image repo, a valid dns label. Names of repos which only
+// differ in the characters a label can not hold get distinct secrets through the hash of the repo name.
+func PullSecretName(repo string) string {
+	sum := sha256.Sum256([]byte(repo))
+	hash := hex.EncodeToString(sum[:])[:8]
+	name := strings.Trim(invalidSecretNameChars.ReplaceAllString(strings.ToLower(repo), "-"), "-")
+	const prefix = "kubepi-registry-"
+	if max := 63 - len(prefix) - len(hash) - 1; len(name) > max {
+		name = strings.TrimRight(name[:max], "-")
+	}
+	return prefix + name + "-" + hash
+}
+
+// DockerConfigJSON builds the .dockerconfigjson of a registry
+func DockerConfigJSON(server, username, password string) ([]byte, error) {
+	auth := map[string]string{
+		"username": username,
+		"password": password,
+		"auth":     base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+	}
+	return json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{server: auth},
+	})
+}
+
+// ApplyPullSecret creates or updates the pull secret of the image repo in the namespaces, all namespaces
+// when none are given. Secrets of the repo left in other namespaces are removed.
+func (k *Kubernetes) ApplyPullSecret(repo string, namespaces []string, dockerConfig []byte, patchServiceAccount bool) error {
+	client, err := k.Client()
+	if err != nil {
+		return err
+	}
+	return k.applyPullSecret(client, repo, namespaces, dockerConfig, patchServiceAccount)
+}
+
+func (k *Kubernetes) applyPullSecret(client kubernetes.Interface, repo string, namespaces []string, dockerConfig []byte, patchServiceAccount bool) error {
+	if len(namespaces) == 0 {
+		nss, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		for _, ns := range nss.Items {
+			if ns.Status.Phase != coreV1.NamespaceTerminating {
+				namespaces = append(namespaces, ns.Name)
+			}
+		}
+	}
+	name := PullSecretName(repo)
+	targets := map[string]bool{}
+	var errs []string
+	for _, ns := range namespaces {
+		targets[ns] = true
+		if err := k.applySecret(client, ns, name, repo, dockerConfig); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", ns, err))
+			continue
+		}
+		if patchServiceAccount {
+			if err := addImagePullSecret(client, ns, name); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", ns, err))
+			}
+		} else if err := removeImagePullSecret(client, ns, name); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", ns, err))
+		}
+	}
+	secrets, err := k.listPullSecrets(client, repo)
+	if err != nil {
+		return err
+	}
+	for _, s := range secrets {
+		// secrets named differently, e.g. by earlier versions, are replaced by the one applied
+		if targets[s.Namespace] && s.Name == name {
+			continue
+		}
+		if err := deletePullSecret(client, s); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", s.Namespace, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("apply pull secret %s failed in %s", name, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// DeletePullSecrets removes the pull secrets of the image repo, of all image repos when repo is empty
+func (k *Kubernetes) DeletePullSecrets(repo string) error {
+	client, err := k.Client()
+	if err != nil {
+		return err
+	}
+	return k.deletePullSecrets(client, repo)
+}
+
+func (k *Kubernetes) deletePullSecrets(client kubernetes.Interface, repo string) error {
+	secrets, err := k.listPullSecrets(client, repo)
+	if err != nil {
+		return err
+	}
+	for _, s := range secrets {
+		if err := deletePullSecret(client, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *Kubernetes) listPullSecrets(client kubernetes.Interface, repo string) ([]coreV1.Secret, error) {
+	selector := map[string]string{
+		LabelManageKey: "kubepi",
+		LabelClusterId: k.UUID,
+	}
+	secrets, err := client.CoreV1().Secrets("").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result []coreV1.Secret
+	for _, s := range secrets.Items {
+		if _, ok := s.Labels[LabelImageRepo]; !ok {
+			continue
+		}
+		if repo == "" || s.Annotations[AnnotationImageRepoName] == repo {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+func (k *Kubernetes) applySecret(client kubernetes.Interface, namespace, name, repo string, dockerConfig []byte) error {
+	item := &coreV1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				LabelManageKey: "kubepi",
+				LabelClusterId: k.UUID,
+				// label values are restricted like names, so the secret name stands for the repo
+				LabelImageRepo: name,
+			},
+			Annotations: map[string]string{
+				AnnotationImageRepoName: repo,
+			},
+		},
+		Type: coreV1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{coreV1.DockerConfigJsonKey: dockerConfig},
+	}
+	old, err := client.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		if !k8sError.IsNotFound(err) {
+			return err
+		}
+		_, err = client.CoreV1().Secrets(namespace).Create(context.TODO(), item, metav1.CreateOptions{})
+		return err
+	}
+	if old.Labels[LabelManageKey] != "kubepi" {
+		return fmt.Errorf("secret %s exists and is not managed by kubepi", name)
+	}
+	if owner := old.Annotations[AnnotationImageRepoName]; owner != repo {
+		return fmt.Errorf("secret %s belongs to the image repo %s", name, owner)
+	}
+	item.ResourceVersion = old.ResourceVersion
+	_, err = client.CoreV1().Secrets(namespace).Update(context.TODO(), item, metav1.UpdateOptions{})
+	return err
+}
+
+func deletePullSecret(client kubernetes.Interface, secret coreV1.Secret) error {
+	if err := removeImagePullSecret(client, secret.Namespace, secret.Name); err != nil {
+		return err
+	}
+	err := client.CoreV1().Secrets(secret.Namespace).Delete(context.TODO(), secret.Name, metav1.DeleteOptions{})
+	if err != nil && !k8sError.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// addImagePullSecret references the secret in the default service account of the namespace
+func addImagePullSecret(client kubernetes.Interface, namespace, name string) error {
+	sa, err := client.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), "default", metav1.GetOptions{})
+	if err != nil {
+		if k8sError.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, ref := range sa.ImagePullSecrets {
+		if ref.Name == name {
+			return nil
+		}
+	}
+	sa.ImagePullSecrets = append(sa.ImagePullSecrets, coreV1.LocalObjectReference{Name: name})
+	_, err = client.CoreV1().ServiceAccounts(namespace).Update(context.TODO(), sa, metav1.UpdateOptions{})
+	return err
+}
+
+func removeImagePullSecret(client kubernetes.Interface, namespace, name string) error {
+	sa, err := client.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), "default", metav1.GetOptions{})
+	if err != nil {
+		if k8sError.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	refs := make([]coreV1.LocalObjectReference, 0, len(sa.ImagePullSecrets))
+	for _, ref := range sa.ImagePullSecrets {
+		if ref.Name != name {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == len(sa.ImagePullSecrets) {
+		return nil
+	}
+	sa.ImagePullSecrets = refs
+	_, err = client.CoreV1().ServiceAccounts(namespace).Update(context.TODO(), sa, metav1.UpdateOptions{})
+	return err
+}
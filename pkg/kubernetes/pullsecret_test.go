@@ -0,0 +1,78 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "github.com/KubeOperator/kubepi/service/model/v1"
+	v1Cluster "github.com/KubeOperator/kubepi/service/model/v1/cluster"
+	coreV1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPullSecret(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&coreV1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&coreV1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		&coreV1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "apps"}},
+	)
+	k := &Kubernetes{Cluster: &v1Cluster.Cluster{Metadata: v1.Metadata{UUID: "c1"}}}
+	name := PullSecretName("My_Harbor")
+	if !strings.HasPrefix(name, "kubepi-registry-my-harbor-") || name == PullSecretName("my-harbor") {
+		t.Fatalf("unexpected secret name %s", name)
+	}
+	if long := PullSecretName(strings.Repeat("harbor", 20)); len(long) > 63 {
+		t.Fatalf("secret name %s is too long", long)
+	}
+	config, _ := DockerConfigJSON("harbor.example.com", "admin", "secret")
+	if err := k.applyPullSecret(client, "My_Harbor", nil, config, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, ns := range []string{"default", "apps"} {
+		s, err := client.CoreV1().Secrets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Type != coreV1.SecretTypeDockerConfigJson || string(s.Data[coreV1.DockerConfigJsonKey]) != string(config) {
+			t.Fatalf("unexpected secret %v", s)
+		}
+	}
+	sa, _ := client.CoreV1().ServiceAccounts("apps").Get(context.TODO(), "default", metav1.GetOptions{})
+	if len(sa.ImagePullSecrets) != 1 || sa.ImagePullSecrets[0].Name != name {
+		t.Fatalf("expected the service account to reference the secret, got %v", sa.ImagePullSecrets)
+	}
+
+	// narrowing the namespaces removes the secret from the others
+	config, _ = DockerConfigJSON("harbor.example.com", "admin", "changed")
+	if err := k.applyPullSecret(client, "My_Harbor", []string{"default"}, config, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoreV1().Secrets("apps").Get(context.TODO(), name, metav1.GetOptions{}); err == nil {
+		t.Fatal("expected the secret to be removed from apps")
+	}
+	sa, _ = client.CoreV1().ServiceAccounts("apps").Get(context.TODO(), "default", metav1.GetOptions{})
+	if len(sa.ImagePullSecrets) != 0 {
+		t.Fatalf("expected the reference to be removed, got %v", sa.ImagePullSecrets)
+	}
+	s, _ := client.CoreV1().Secrets("default").Get(context.TODO(), name, metav1.GetOptions{})
+	if string(s.Data[coreV1.DockerConfigJsonKey]) != string(config) {
+		t.Fatal("expected the secret to be updated with the new credentials")
+	}
+
+	// a repo whose name only differs in case gets a secret of its own
+	if err := k.applyPullSecret(client, "my-harbor", []string{"default"}, config, false); err != nil {
+		t.Fatal(err)
+	}
+	if secrets, _ := k.listPullSecrets(client, "My_Harbor"); len(secrets) != 1 || secrets[0].Name != name {
+		t.Fatalf("expected only the secret of the repo, got %v", secrets)
+	}
+
+	if err := k.deletePullSecrets(client, "My_Harbor"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoreV1().Secrets("default").Get(context.TODO(), name, metav1.GetOptions{}); err == nil {
+		t.Fatal("expected the secret to be deleted")
+	}
+}
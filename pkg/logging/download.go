@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DownloadLogs copies the logs of the container to w as they come from the kubelet, the follow and filter
+// options do not apply to downloads
+func DownloadLogs(ctx context.Context, k8sClient kubernetes.Interface, namespace, pod, container string, opts MultiLogOptions, w io.Writer) error {
+	opts.Follow = false
+	stream, err := k8sClient.CoreV1().Pods(namespace).GetLogs(pod, opts.podLogOptions(container)).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	_, err = io.Copy(w, stream)
+	return err
+}
+
+// ArchiveLogs writes a zip archive to w with the logs of the containers of the pods matching the container
+// option, one <pod>/<container>.log entry per container. The logs of a container which can not be read are
+// replaced by a <pod>/<container>.error entry with the reason, so one failing container does not fail the archive.
+func ArchiveLogs(ctx context.Context, k8sClient kubernetes.Interface, pods []v1.Pod, opts MultiLogOptions, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for i := range pods {
+		pod := &pods[i]
+		statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if opts.Container != nil && !opts.Container.MatchString(status.Name) {
+				continue
+			}
+			if opts.Previous && status.LastTerminationState.Terminated == nil {
+				continue
+			}
+			if !opts.Previous && status.State.Running == nil && status.State.Terminated == nil {
+				continue
+			}
+			name := fmt.Sprintf("%s/%s", pod.Name, status.Name)
+			entry, err := zw.CreateHeader(&zip.FileHeader{Name: name + ".log", Method: zip.Deflate, Modified: time.Now()})
+			if err != nil {
+				return err
+			}
+			if downloadErr := DownloadLogs(ctx, k8sClient, pod.Namespace, pod.Name, status.Name, opts, entry); downloadErr != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				entry, err = zw.CreateHeader(&zip.FileHeader{Name: name + ".error", Method: zip.Deflate, Modified: time.Now()})
+				if err != nil {
+					return err
+				}
+				if _, err := io.WriteString(entry, downloadErr.Error()+"\n"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return zw.Close()
+}
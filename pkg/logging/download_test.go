@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestArchiveLogs(t *testing.T) {
+	pods := []v1.Pod{
+		*runningPod("web-1", nil, "app", "sidecar"),
+		*runningPod("web-2", nil, "app"),
+	}
+	client := fake.NewSimpleClientset(&pods[0], &pods[1])
+
+	var buf bytes.Buffer
+	if err := ArchiveLogs(context.Background(), client, pods, MultiLogOptions{Container: regexp.MustCompile("^app$")}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		if string(content) != "fake logs" {
+			t.Fatalf("unexpected logs %q of %s", content, f.Name)
+		}
+	}
+	if len(names) != 2 || names[0] != "web-1/app.log" || names[1] != "web-2/app.log" {
+		t.Fatalf("unexpected entries %q", names)
+	}
+}
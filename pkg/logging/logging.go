@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/igm/sockjs-go.v2/sockjs"
 	v1 "k8s.io/api/core/v1"
@@ -27,11 +28,27 @@ func GenLoggingSessionId() (string, error) {
 }
 
 type LogSession struct {
-	Id            string
+	Id string
+	// LoginSessionId is the login session which opened this log stream
+	LoginSessionId string
+	// User opened this log stream of the Target in the Cluster
+	User          string
+	Cluster       string
+	Target        string
+	CreatedAt     time.Time
 	Bound         chan error
 	sockJSSession sockjs.Session
 }
 
+// bindTimeout is how long a session waits for its client to bind it before it stops counting towards the
+// sessions of its user
+const bindTimeout = time.Minute
+
+// Connected reports whether the client of the session has bound it
+func (s LogSession) Connected() bool {
+	return s.sockJSSession != nil
+}
+
 type SessionMap struct {
 	Sessions map[string]LogSession
 	Lock     sync.Mutex
@@ -55,13 +72,53 @@ func (sm *SessionMap) Close(sessionId, reason string, status uint32) {
 	}
 	sm.Lock.Lock()
 	defer sm.Lock.Unlock()
-	err := sm.Sessions[sessionId].sockJSSession.Close(status, reason)
-	if err != nil {
-		log.Println(err)
+	if sockJSSession := sm.Sessions[sessionId].sockJSSession; sockJSSession != nil {
+		if err := sockJSSession.Close(status, reason); err != nil {
+			log.Println(err)
+		}
 	}
 	delete(sm.Sessions, sessionId)
 }
 
+// CloseByLoginSession closes all log streams opened by the given login session
+func (sm *SessionMap) CloseByLoginSession(loginSessionId string, reason string) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	for id, v := range sm.Sessions {
+		if v.LoginSessionId != loginSessionId {
+			continue
+		}
+		if v.sockJSSession != nil {
+			_ = v.sockJSSession.Close(2, reason)
+		}
+		delete(sm.Sessions, id)
+	}
+}
+
+// CountByUser returns the number of log streams of the user which are bound or still waiting to be
+func (sm *SessionMap) CountByUser(user string) int {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	count := 0
+	for _, s := range sm.Sessions {
+		if s.User == user && (s.sockJSSession != nil || time.Since(s.CreatedAt) < bindTimeout) {
+			count++
+		}
+	}
+	return count
+}
+
+// List returns the live log sessions
+func (sm *SessionMap) List() []LogSession {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	sessions := make([]LogSession, 0, len(sm.Sessions))
+	for _, s := range sm.Sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
 func (sm *SessionMap) Clean() {
 	for _, v := range sm.Sessions {
 		v.sockJSSession.Close(2, "system is logout, please retry...")
@@ -0,0 +1,344 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// maxLogStreams bounds the containers an aggregated log session streams at once
+const maxLogStreams = 50
+
+var (
+	podColors       = []int{31, 32, 33, 34, 35, 36}
+	containerColors = []int{91, 92, 93, 94, 95, 96}
+)
+
+// MultiLogOptions selects the containers of an aggregated log session and filters its lines
+type MultiLogOptions struct {
+	Namespace string
+	Selector  labels.Selector
+	// Container matches the names of the streamed containers, all containers are streamed if nil
+	Container    *regexp.Regexp
+	TailLines    *int64
+	SinceSeconds *int64
+	SinceTime    *metav1.Time
+	Follow       bool
+	Previous     bool
+	Timestamps   bool
+	// Include keeps the lines matching any of its expressions, Exclude drops the lines matching any of its expressions
+	Include []*regexp.Regexp
+	Exclude []*regexp.Regexp
+}
+
+// Match reports whether the line passes the include and exclude filters
+func (o MultiLogOptions) Match(line string) bool {
+	for _, e := range o.Exclude {
+		if e.MatchString(line) {
+			return false
+		}
+	}
+	if len(o.Include) == 0 {
+		return true
+	}
+	for _, e := range o.Include {
+		if e.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o MultiLogOptions) podLogOptions(container string) *v1.PodLogOptions {
+	return &v1.PodLogOptions{
+		Container:    container,
+		Follow:       o.Follow && !o.Previous,
+		Previous:     o.Previous,
+		Timestamps:   o.Timestamps,
+		TailLines:    o.TailLines,
+		SinceSeconds: o.SinceSeconds,
+		SinceTime:    o.SinceTime,
+	}
+}
+
+// WorkloadSelector returns the selector of the pods of the workload
+func WorkloadSelector(k8sClient kubernetes.Interface, namespace, kind, name string) (labels.Selector, error) {
+	var selector *metav1.LabelSelector
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments":
+		d, err := k8sClient.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = d.Spec.Selector
+	case "statefulset", "statefulsets":
+		s, err := k8sClient.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = s.Spec.Selector
+	case "daemonset", "daemonsets":
+		d, err := k8sClient.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = d.Spec.Selector
+	case "job", "jobs":
+		j, err := k8sClient.BatchV1().Jobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = j.Spec.Selector
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %s", kind)
+	}
+	if selector == nil {
+		return nil, fmt.Errorf("%s %s has no pod selector", kind, name)
+	}
+	return metav1.LabelSelectorAsSelector(selector)
+}
+
+// WaitForMultiLoggingStream streams the logs of all containers of the pods the options select once the session is
+// bound, interleaved and prefixed with the colored pod and container names. When following, the containers of
+// new pods and restarted containers are picked up as they start.
+func WaitForMultiLoggingStream(k8sClient kubernetes.Interface, opts MultiLogOptions, sessionId string) {
+	select {
+	case <-LogSessions.Get(sessionId).Bound:
+		close(LogSessions.Get(sessionId).Bound)
+		session := LogSessions.Get(sessionId)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			// the client sends nothing after binding, receiving fails once it disconnects
+			for {
+				if _, err := session.sockJSSession.Recv(); err != nil {
+					cancel()
+					return
+				}
+			}
+		}()
+
+		s := newMultiLogStream(k8sClient, opts, session.sockJSSession.Send)
+		if err := s.run(ctx); err != nil {
+			LogSessions.Close(sessionId, err.Error(), 2)
+			return
+		}
+		LogSessions.Close(sessionId, "Process exited", 1)
+	}
+}
+
+type multiLogStream struct {
+	client  kubernetes.Interface
+	opts    MultiLogOptions
+	started time.Time
+
+	sendLock sync.Mutex
+	send     func(string) error
+
+	lock sync.Mutex
+	// tails are the containers being streamed, ended the ones whose stream ended, which are not streamed again
+	tails     map[string]context.CancelFunc
+	ended     map[string]bool
+	truncated bool
+	wg        sync.WaitGroup
+}
+
+func newMultiLogStream(k8sClient kubernetes.Interface, opts MultiLogOptions, send func(string) error) *multiLogStream {
+	if opts.Selector == nil {
+		opts.Selector = labels.Everything()
+	}
+	return &multiLogStream{
+		client:  k8sClient,
+		opts:    opts,
+		started: time.Now(),
+		send:    send,
+		tails:   map[string]context.CancelFunc{},
+		ended:   map[string]bool{},
+	}
+}
+
+func (s *multiLogStream) run(ctx context.Context) error {
+	if !s.opts.Follow || s.opts.Previous {
+		pods, err := s.client.CoreV1().Pods(s.opts.Namespace).List(ctx, metav1.ListOptions{LabelSelector: s.opts.Selector.String()})
+		if err != nil {
+			return err
+		}
+		if len(pods.Items) == 0 {
+			s.notice("no pods match the selector")
+		}
+		for i := range pods.Items {
+			s.sync(ctx, &pods.Items[i])
+		}
+		s.wg.Wait()
+		return nil
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(s.client, 0,
+		informers.WithNamespace(s.opts.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = s.opts.Selector.String()
+		}))
+	informer := factory.Core().V1().Pods().Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				s.sync(ctx, pod)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				s.sync(ctx, pod)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*v1.Pod); ok {
+				s.stopPod(pod)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	s.wg.Wait()
+	return nil
+}
+
+// sync starts streaming the containers of the pod which have logs and are not streamed yet
+func (s *multiLogStream) sync(ctx context.Context, pod *v1.Pod) {
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if s.opts.Container != nil && !s.opts.Container.MatchString(status.Name) {
+			continue
+		}
+		var startedAt time.Time
+		switch {
+		case s.opts.Previous:
+			if status.LastTerminationState.Terminated == nil {
+				continue
+			}
+		case status.State.Running != nil:
+			startedAt = status.State.Running.StartedAt.Time
+		case status.State.Terminated != nil && !s.opts.Follow:
+		default:
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%s", pod.UID, status.Name, status.ContainerID)
+
+		s.lock.Lock()
+		if _, ok := s.tails[key]; ok || s.ended[key] {
+			s.lock.Unlock()
+			continue
+		}
+		if len(s.tails) >= maxLogStreams {
+			truncated := s.truncated
+			s.truncated = true
+			s.lock.Unlock()
+			if !truncated {
+				s.notice(fmt.Sprintf("only the logs of %d containers are streamed, narrow the selector to see the others", maxLogStreams))
+			}
+			return
+		}
+		tailCtx, cancel := context.WithCancel(ctx)
+		s.tails[key] = cancel
+		s.wg.Add(1)
+		s.lock.Unlock()
+
+		// containers started after the session was opened are streamed from their first line
+		fromStart := startedAt.After(s.started)
+		go s.tail(tailCtx, key, pod.Namespace, pod.Name, status.Name, fromStart)
+	}
+}
+
+func (s *multiLogStream) stopPod(pod *v1.Pod) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	prefix := string(pod.UID) + "/"
+	for key, cancel := range s.tails {
+		if strings.HasPrefix(key, prefix) {
+			cancel()
+			delete(s.tails, key)
+		}
+	}
+	for key := range s.ended {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.ended, key)
+		}
+	}
+}
+
+// end releases the stream of the container once its tail returns, e.g. when the container restarted
+func (s *multiLogStream) end(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if cancel, ok := s.tails[key]; ok {
+		cancel()
+		delete(s.tails, key)
+		s.ended[key] = true
+	}
+}
+
+func (s *multiLogStream) tail(ctx context.Context, key, namespace, pod, container string, fromStart bool) {
+	defer s.wg.Done()
+	defer s.end(key)
+	opts := s.opts.podLogOptions(container)
+	if fromStart {
+		opts.TailLines, opts.SinceSeconds, opts.SinceTime = nil, nil, nil
+	}
+	stream, err := s.client.CoreV1().Pods(namespace).GetLogs(pod, opts).Stream(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.notice(fmt.Sprintf("%s %s: %s", pod, container, err.Error()))
+		}
+		return
+	}
+	defer stream.Close()
+
+	prefix := fmt.Sprintf("\x1b[%dm%s\x1b[0m \x1b[%dm%s\x1b[0m ", color(podColors, pod), pod, color(containerColors, container), container)
+	reader := bufio.NewReader(stream)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && s.opts.Match(line) {
+			if sendErr := s.write(prefix + line + "\r\n"); sendErr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *multiLogStream) notice(message string) {
+	_ = s.write(fmt.Sprintf("\x1b[2m[kubepi] %s\x1b[0m\r\n", message))
+}
+
+func (s *multiLogStream) write(message string) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	return s.send(message)
+}
+
+func color(palette []int, name string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return palette[h.Sum32()%uint32(len(palette))]
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func runningPod(name string, podLabels map[string]string, containers ...string) *v1.Pod {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name), Labels: podLabels}}
+	for _, c := range containers {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, v1.ContainerStatus{
+			Name:        c,
+			ContainerID: "containerd://" + name + c,
+			State:       v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+		})
+	}
+	return pod
+}
+
+func TestMultiLogStream(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		runningPod("web-1", map[string]string{"app": "web"}, "app", "sidecar"),
+		runningPod("web-2", map[string]string{"app": "web"}, "app"),
+		runningPod("db-1", map[string]string{"app": "db"}, "db"),
+	)
+	collect := func(opts MultiLogOptions) []string {
+		var lock sync.Mutex
+		var lines []string
+		s := newMultiLogStream(client, opts, func(message string) error {
+			lock.Lock()
+			defer lock.Unlock()
+			lines = append(lines, message)
+			return nil
+		})
+		if err := s.run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return lines
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{"app": "web"})
+	lines := collect(MultiLogOptions{Namespace: "default", Selector: selector, Container: regexp.MustCompile("^app$")})
+	if len(lines) != 2 {
+		t.Fatalf("expected a line of each app container, got %q", lines)
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "mapp\x1b[0m ") || !strings.HasSuffix(line, "fake logs\r\n") {
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+	if all := strings.Join(lines, ""); !strings.Contains(all, "web-1") || !strings.Contains(all, "web-2") {
+		t.Fatalf("expected the logs of both pods, got %q", lines)
+	}
+
+	lines = collect(MultiLogOptions{Namespace: "default", Selector: selector, Exclude: []*regexp.Regexp{regexp.MustCompile("fake")}})
+	if len(lines) != 0 {
+		t.Fatalf("expected the lines to be excluded, got %q", lines)
+	}
+
+	lines = collect(MultiLogOptions{Namespace: "default", Selector: labels.SelectorFromSet(labels.Set{"app": "none"})})
+	if len(lines) != 1 || !strings.Contains(lines[0], "no pods match") {
+		t.Fatalf("expected a notice, got %q", lines)
+	}
+}
+
+func TestMultiLogStreamRestarts(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var lock sync.Mutex
+	var lines []string
+	s := newMultiLogStream(client, MultiLogOptions{Namespace: "default", Follow: true}, func(message string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		lines = append(lines, message)
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// every restart of the container is a new stream, the ended ones do not count toward the limit
+	pod := runningPod("web-1", nil, "app")
+	restarts := maxLogStreams + 10
+	for i := 0; i < restarts; i++ {
+		pod.Status.ContainerStatuses[0].ContainerID = fmt.Sprintf("containerd://restart-%d", i)
+		s.sync(ctx, pod)
+		s.wg.Wait()
+		// the pod is synced again before it reports the restart
+		s.sync(ctx, pod)
+		s.wg.Wait()
+	}
+	if len(lines) != restarts {
+		t.Fatalf("expected a line of each run of the container, got %d: %q", len(lines), lines[len(lines)-1])
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.tails) != 0 || s.truncated {
+		t.Fatalf("expected the ended streams to be released, %d are left", len(s.tails))
+	}
+}
@@ -0,0 +1,178 @@
+package loginsession
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm/v3"
+)
+
+const (
+	MethodCookie = "cookie"
+	MethodJwt    = "jwt"
+
+	// touchInterval bounds how often the last seen time of a session is written to the db
+	touchInterval = time.Minute
+)
+
+// LoginSession describes one successful login of a user, whether it is
+// carried by the session cookie or by jwt tokens.
+type LoginSession struct {
+	Id        string    `json:"id" storm:"id"`
+	UserName  string    `json:"userName"`
+	Ip        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Method    string    `json:"method"`
+	CreateAt  time.Time `json:"createAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+	ExpireAt  time.Time `json:"expireAt"`
+	// CookieId is the id of the server side cookie session, empty for jwt logins.
+	CookieId string `json:"-"`
+	Current  bool   `json:"current"`
+}
+
+// SessionMap stores all live login sessions and a lock to avoid concurrent conflict,
+// the changes are written through to the db once it is persisted
+type SessionMap struct {
+	Sessions map[string]LoginSession
+	Lock     sync.RWMutex
+
+	db      storm.Node
+	touched map[string]time.Time
+}
+
+var LoginSessions = SessionMap{Sessions: make(map[string]LoginSession)}
+
+// RevokeHook releases what a revoked login session holds besides its entry: its cookie session, its jwt
+// tokens and its terminals. It is set by the session api, which owns them.
+var RevokeHook func(session LoginSession, reason string)
+
+// Persist loads the login sessions kept in the db and writes the later changes of the sessions to it, so jwt
+// logins outlive a restart of the server. Cookie logins are dropped, the cookie sessions are not kept.
+func (sm *SessionMap) Persist(db storm.Node) error {
+	var sessions []LoginSession
+	if err := db.All(&sessions); err != nil {
+		return err
+	}
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	now := time.Now()
+	for i := range sessions {
+		if sessions[i].Method == MethodCookie || sessions[i].ExpireAt.Before(now) {
+			_ = db.DeleteStruct(&sessions[i])
+			continue
+		}
+		sm.Sessions[sessions[i].Id] = sessions[i]
+	}
+	sm.db = db
+	sm.touched = map[string]time.Time{}
+	return nil
+}
+
+// save and remove write a change to the db if the sessions are persisted, the sessions in memory stay the
+// reference if the db fails
+func (sm *SessionMap) save(session LoginSession) {
+	if sm.db != nil {
+		_ = sm.db.Save(&session)
+	}
+}
+
+func (sm *SessionMap) remove(session LoginSession) {
+	if sm.db != nil {
+		_ = sm.db.DeleteStruct(&session)
+		delete(sm.touched, session.Id)
+	}
+}
+
+func GenLoginSessionId() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// Get returns the session and whether it exists and is not expired
+func (sm *SessionMap) Get(id string) (LoginSession, bool) {
+	sm.Lock.RLock()
+	defer sm.Lock.RUnlock()
+	s, ok := sm.Sessions[id]
+	if !ok || s.ExpireAt.Before(time.Now()) {
+		return LoginSession{}, false
+	}
+	return s, true
+}
+
+func (sm *SessionMap) Set(session LoginSession) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	sm.Sessions[session.Id] = session
+	sm.save(session)
+}
+
+// Touch refreshes the last seen time of a session
+func (sm *SessionMap) Touch(id string) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	if s, ok := sm.Sessions[id]; ok {
+		s.LastSeen = time.Now()
+		sm.Sessions[id] = s
+		if sm.db != nil && s.LastSeen.Sub(sm.touched[id]) > touchInterval {
+			sm.touched[id] = s.LastSeen
+			sm.save(s)
+		}
+	}
+}
+
+// List returns the live sessions of the given user, or of all users when userName is empty
+func (sm *SessionMap) List(userName string) []LoginSession {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	now := time.Now()
+	result := make([]LoginSession, 0)
+	for id, s := range sm.Sessions {
+		if s.ExpireAt.Before(now) {
+			delete(sm.Sessions, id)
+			sm.remove(s)
+			continue
+		}
+		if userName == "" || s.UserName == userName {
+			result = append(result, s)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreateAt.After(result[j].CreateAt)
+	})
+	return result
+}
+
+// Revoke removes the session and releases what it holds, it reports whether the session existed
+func (sm *SessionMap) Revoke(id string, reason string) bool {
+	s, ok := sm.Delete(id)
+	if ok && RevokeHook != nil {
+		RevokeHook(s, reason)
+	}
+	return ok
+}
+
+// RevokeUser revokes all sessions of the user
+func (sm *SessionMap) RevokeUser(userName string, reason string) {
+	for _, s := range sm.List(userName) {
+		sm.Revoke(s.Id, reason)
+	}
+}
+
+// Delete removes a session and returns it
+func (sm *SessionMap) Delete(id string) (LoginSession, bool) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	s, ok := sm.Sessions[id]
+	if ok {
+		delete(sm.Sessions, id)
+		sm.remove(s)
+	}
+	return s, ok
+}
@@ -0,0 +1,164 @@
+package terminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/KubeOperator/kubepi/pkg/util/podtool"
+	v1 "k8s.io/api/core/v1"
+	k8sError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const LabelNodeShell = "kubepi.org/node-shell"
+
+// nodeShellDeadline bounds the lifetime of node shell pods, in case KubePi stops before deleting them
+const nodeShellDeadline = int64(24 * 60 * 60)
+
+const attachHint = "If you don't see a command prompt, try pressing enter.\r\n"
+
+// WaitForDebugTerminal adds an ephemeral container of the image targeting the container to the pod once the
+// terminal is bound and attaches to it. The stdin of the container is closed when the terminal disconnects,
+// so its shell and the container exit.
+func WaitForDebugTerminal(k8sClient kubernetes.Interface, cfg *rest.Config, namespace string, podName string, containerName string, image string, sessionId string, shell string) {
+	select {
+	case <-TerminalSessions.Get(sessionId).Bound:
+		close(TerminalSessions.Get(sessionId).Bound)
+
+		name, err := addDebugContainer(k8sClient, namespace, podName, containerName, image, shell)
+		if err == nil {
+			_, _ = TerminalSessions.Get(sessionId).Write([]byte(attachHint))
+			err = attachProcess(k8sClient, cfg, namespace, podName, name, TerminalSessions.Get(sessionId))
+		}
+		if err != nil {
+			TerminalSessions.Close(sessionId, 2, err.Error())
+			return
+		}
+		TerminalSessions.Close(sessionId, 1, "Process exited")
+	}
+}
+
+func addDebugContainer(k8sClient kubernetes.Interface, namespace, podName, containerName, image, shell string) (string, error) {
+	pod, err := k8sClient.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	name := "kubepi-debug-" + rand.String(5)
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, v1.EphemeralContainer{
+		EphemeralContainerCommon: v1.EphemeralContainerCommon{
+			Name:      name,
+			Image:     image,
+			Command:   []string{shell},
+			Stdin:     true,
+			StdinOnce: true,
+			TTY:       true,
+		},
+		TargetContainerName: containerName,
+	})
+	if _, err := k8sClient.CoreV1().Pods(namespace).UpdateEphemeralContainers(context.TODO(), podName, pod, metav1.UpdateOptions{}); err != nil {
+		if k8sError.IsNotFound(err) || k8sError.IsMethodNotSupported(err) {
+			return "", errors.New("the cluster does not support ephemeral containers")
+		}
+		return "", err
+	}
+	err = podtool.WaitForContainer(k8sClient, namespace, podName, podtool.EphemeralContainerStatuses, name)
+	return name, err
+}
+
+// WaitForNodeShell creates a privileged pod in the host namespaces of the node once the terminal is bound and
+// enters the host with nsenter. The pod is deleted when the terminal disconnects.
+func WaitForNodeShell(k8sClient kubernetes.Interface, cfg *rest.Config, namespace string, nodeName string, image string, sessionId string, shell string) {
+	select {
+	case <-TerminalSessions.Get(sessionId).Bound:
+		close(TerminalSessions.Get(sessionId).Bound)
+
+		podName, err := createNodeShellPod(k8sClient, namespace, nodeName, image)
+		if podName != "" {
+			defer func() {
+				grace := int64(0)
+				_ = k8sClient.CoreV1().Pods(namespace).Delete(context.Background(), podName, metav1.DeleteOptions{GracePeriodSeconds: &grace})
+			}()
+		}
+		if err == nil {
+			cmd := []string{"nsenter", "-t", "1", "-m", "-u", "-i", "-n", "-p", "--", shell}
+			err = startProcess(k8sClient, cfg, cmd, namespace, podName, "shell", TerminalSessions.Get(sessionId))
+		}
+		if err != nil {
+			TerminalSessions.Close(sessionId, 2, err.Error())
+			return
+		}
+		TerminalSessions.Close(sessionId, 1, "Process exited")
+	}
+}
+
+func createNodeShellPod(k8sClient kubernetes.Interface, namespace, nodeName, image string) (string, error) {
+	privileged := true
+	deadline := nodeShellDeadline
+	grace := int64(0)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubepi-node-shell-",
+			Namespace:    namespace,
+			Labels: map[string]string{
+				"kubepi.org/manage": "kubepi",
+				LabelNodeShell:      nodeName,
+			},
+		},
+		Spec: v1.PodSpec{
+			NodeName:                      nodeName,
+			HostPID:                       true,
+			HostNetwork:                   true,
+			HostIPC:                       true,
+			RestartPolicy:                 v1.RestartPolicyNever,
+			ActiveDeadlineSeconds:         &deadline,
+			TerminationGracePeriodSeconds: &grace,
+			Tolerations:                   []v1.Toleration{{Operator: v1.TolerationOpExists}},
+			Containers: []v1.Container{{
+				Name:            "shell",
+				Image:           image,
+				Command:         []string{"sleep", fmt.Sprint(nodeShellDeadline)},
+				SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+			}},
+		},
+	}
+	created, err := k8sClient.CoreV1().Pods(namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	err = podtool.WaitForContainer(k8sClient, namespace, created.Name, func(pod *v1.Pod) []v1.ContainerStatus {
+		return pod.Status.ContainerStatuses
+	}, "shell")
+	return created.Name, err
+}
+
+func attachProcess(k8sClient kubernetes.Interface, cfg *rest.Config, namespace string, podName string, containerName string, ptyHandler PtyHandler) error {
+	req := k8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(namespace).
+		SubResource("attach")
+
+	req.VersionedParams(&v1.PodAttachOptions{
+		Container: containerName,
+		Stdin:     true,
+		Stdout:    true,
+		TTY:       true,
+	}, scheme.ParameterCodec)
+
+	attach, err := remotecommand.NewSPDYExecutor(cfg, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+	return attach.Stream(remotecommand.StreamOptions{
+		Stdin:             ptyHandler,
+		Stdout:            ptyHandler,
+		TerminalSizeQueue: ptyHandler,
+		Tty:               true,
+	})
+}
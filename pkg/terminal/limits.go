@@ -0,0 +1,111 @@
+package terminal
+
+import (
+	"fmt"
+	"time"
+)
+
+// bindTimeout is how long a session waits for its client to bind it before it stops counting towards the
+// sessions of its owner
+const bindTimeout = time.Minute
+
+// Limits bound how long a terminal session is kept open, 0 is unlimited
+type Limits struct {
+	// IdleTimeout closes the terminal when there has been no input or output for this long
+	IdleTimeout time.Duration
+	// MaxDuration closes the terminal this long after it was opened
+	MaxDuration time.Duration
+	// WarnBefore is how long before a limit closes the terminal its owner is warned
+	WarnBefore time.Duration
+}
+
+// DefaultLimits close terminals idle for 5 minutes
+var DefaultLimits = Limits{IdleTimeout: 5 * time.Minute}
+
+// deadline returns when the first limit closes the terminal and whether it is the idle timeout, the zero time if
+// no limit applies
+func (t TerminalSession) deadline() (time.Time, bool) {
+	var deadline time.Time
+	idle := false
+	if t.Limits.IdleTimeout > 0 {
+		deadline, idle = t.TimeOut, true
+	}
+	if t.Limits.MaxDuration > 0 {
+		if end := t.CreatedAt.Add(t.Limits.MaxDuration); deadline.IsZero() || end.Before(deadline) {
+			deadline, idle = end, false
+		}
+	}
+	return deadline, idle
+}
+
+// watch closes the terminal session once one of its limits is reached, the owner is warned before
+func (sm *SessionMap) watch(sessionId string) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var warned time.Time
+	for range ticker.C {
+		session := sm.Get(sessionId)
+		if session.Id == "" {
+			return
+		}
+		deadline, idle := session.deadline()
+		if deadline.IsZero() {
+			return
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			reason := "the terminal reached its maximum duration"
+			if idle {
+				reason = "the terminal has been idle for too long"
+			}
+			sm.Close(sessionId, 2, reason)
+			return
+		}
+		if session.Limits.WarnBefore > 0 && left <= session.Limits.WarnBefore && !warned.Equal(deadline) {
+			// the idle deadline moves with activity, which warns again before the next one
+			warned = deadline
+			warning := "The terminal reaches its maximum duration"
+			if idle {
+				warning = "The terminal is idle"
+			}
+			_ = session.Toast(fmt.Sprintf("%s, it will be disconnected in %d seconds", warning, int(left.Round(time.Second).Seconds())))
+		}
+	}
+}
+
+// CountByOwner returns the number of terminals of the user which are bound or still waiting to be
+func (sm *SessionMap) CountByOwner(owner string) int {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	count := 0
+	for _, s := range sm.Sessions {
+		if s.Owner == owner && (s.sockJSSession != nil || time.Since(s.CreatedAt) < bindTimeout) {
+			count++
+		}
+	}
+	return count
+}
+
+// List returns the live terminal sessions
+func (sm *SessionMap) List() []TerminalSession {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	sessions := make([]TerminalSession, 0, len(sm.Sessions))
+	for _, s := range sm.Sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// Connected reports whether the client of the session has bound it
+func (t TerminalSession) Connected() bool {
+	return t.sockJSSession != nil
+}
+
+// Viewers returns the clients attached to the shared terminal
+func (t TerminalSession) Viewers() []Viewer {
+	if t.shared == nil {
+		return nil
+	}
+	return t.shared.listViewers()
+}
@@ -0,0 +1,36 @@
+package terminal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchLimits(t *testing.T) {
+	session := NewTerminalSession("limits-test", "login", "alice", "test", "default/web/app")
+	session.Limits = Limits{IdleTimeout: time.Hour, MaxDuration: 1500 * time.Millisecond, WarnBefore: time.Second}
+	conn := newFakeSockJSSession()
+	session.sockJSSession = conn
+	TerminalSessions.Set(session.Id, session)
+	if TerminalSessions.CountByOwner("alice") != 1 {
+		t.Fatal("expected the session to count towards its owner")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		TerminalSessions.watch(session.Id)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the session to be closed at its maximum duration")
+	}
+	if TerminalSessions.Get(session.Id).Id != "" {
+		t.Fatal("expected the session to be removed")
+	}
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if !conn.closed || len(conn.sent) != 1 || conn.sent[0].Op != "toast" {
+		t.Fatalf("expected a warning before the session was closed, got %+v", conn.sent)
+	}
+}
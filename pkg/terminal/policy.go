@@ -0,0 +1,156 @@
+package terminal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// clearLine moves the cursor to the end of the line and kills it, which discards a denied command line in the
+// line editors of bash and busybox
+const clearLine = "\x05\x15"
+
+// CommandRule allows or denies the command lines typed into a terminal, the expressions match anywhere in the
+// line unless they are anchored
+type CommandRule struct {
+	Name string
+	// Allow lists the only command lines the rule permits when not empty
+	Allow []*regexp.Regexp
+	Deny  []*regexp.Regexp
+}
+
+// denies reports whether the rule rejects the command line
+func (r CommandRule) denies(command string) bool {
+	for _, e := range r.Deny {
+		if e.MatchString(command) {
+			return true
+		}
+	}
+	if len(r.Allow) == 0 {
+		return false
+	}
+	for _, e := range r.Allow {
+		if e.MatchString(command) {
+			return false
+		}
+	}
+	return true
+}
+
+// CommandAudit records a command line typed into the terminal session by the user, deniedBy names the rule
+// which rejected it or is "" if it was passed to the process
+type CommandAudit func(session TerminalSession, user, command, deniedBy string)
+
+// unverifiable names the denial of command lines the rules can not be checked against
+const unverifiable = "unverifiable command line"
+
+// commandLine reassembles the command line typed into a terminal from its keystrokes. Only lines typed from
+// start to end are known: once the line editor of the shell edits the line, by moving the cursor, recalling
+// history, completing, yanking or pasting, the line the shell runs can not be told from the keystrokes.
+type commandLine struct {
+	line []rune
+	// escape is 1 after an escape and 2 inside an escape sequence, whose keys do not add to the line
+	escape int
+	// edited is set once the line editor changed the line in a way the keystrokes do not show
+	edited bool
+}
+
+// filterCommands passes the keystrokes of the writer, the owner if viewer is nil, to the process, except for the
+// line ends of command lines the rules of the writer deny, those lines are discarded instead. The rules of the
+// owner are the ones of the session, the ones of a writable viewer are its own. Command lines are audited if the
+// session has an audit.
+//
+// The rules are best effort: they see the keystrokes rather than what the shell runs. When the writer has
+// rules, lines edited through escape sequences (cursor and history keys, bracketed paste) or the editing keys
+// Ctrl-A, Ctrl-E, Ctrl-K, Ctrl-Y, Ctrl-R, Tab and the like are denied as unverifiable, those lines have to be
+// typed out. Without rules such lines are audited as far as they were typed.
+func (t TerminalSession) filterCommands(viewer *Viewer, data string) string {
+	user, rules, toast := t.Owner, t.CommandRules, t.Toast
+	if viewer != nil {
+		user, rules = viewer.User, viewer.commandRules
+		toast = func(p string) error {
+			return sendToast(viewer.sockJSSession, p)
+		}
+	}
+	if len(rules) == 0 && t.CommandAudit == nil {
+		return data
+	}
+	c := t.shared.commandLine(viewer)
+	var out strings.Builder
+	for _, r := range data {
+		switch {
+		case c.escape == 1:
+			c.escape = 0
+			if r == '[' || r == 'O' {
+				c.escape = 2
+			}
+		case c.escape == 2:
+			if r >= 0x40 && r <= 0x7e {
+				c.escape = 0
+			}
+		case r == '\x1b':
+			c.escape = 1
+			c.edited = true
+		case r == '\r' || r == '\n':
+			command := strings.TrimSpace(string(c.line))
+			edited := c.edited
+			c.line = c.line[:0]
+			c.edited = false
+			if command == "" && !edited {
+				break
+			}
+			rule := deniedBy(rules, command)
+			if rule == "" && edited && len(rules) > 0 {
+				rule = unverifiable
+			}
+			if rule == "" {
+				if command != "" {
+					t.audit(user, command, "")
+				}
+				break
+			}
+			out.WriteString(clearLine)
+			if rule == unverifiable {
+				_ = toast("The command line was edited and can not be checked against the command policies, type it out instead")
+			} else {
+				_ = toast(fmt.Sprintf("The command is denied by the policy %s", rule))
+			}
+			t.audit(user, command, rule)
+			continue
+		case r == '\x7f' || r == '\b':
+			if len(c.line) > 0 {
+				c.line = c.line[:len(c.line)-1]
+			}
+		case r == '\x03':
+			c.line = c.line[:0]
+			c.edited = false
+		case r == '\x15':
+			// kills up to the cursor, which is at the end of the line unless it was edited
+			c.line = c.line[:0]
+		case r == '\x17':
+			trimmed := strings.TrimRight(string(c.line), " ")
+			c.line = []rune(trimmed[:strings.LastIndex(trimmed, " ")+1])
+		case r < 0x20:
+			c.edited = true
+		default:
+			c.line = append(c.line, r)
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+func deniedBy(rules []CommandRule, command string) string {
+	for _, rule := range rules {
+		if rule.denies(command) {
+			return rule.Name
+		}
+	}
+	return ""
+}
+
+func (t TerminalSession) audit(user, command, deniedBy string) {
+	if t.CommandAudit != nil {
+		t.CommandAudit(t, user, command, deniedBy)
+	}
+}
@@ -0,0 +1,63 @@
+package terminal
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFilterCommands(t *testing.T) {
+	session := NewTerminalSession("policy-test", "login", "alice", "test", "default/web/app")
+	session.sockJSSession = newFakeSockJSSession()
+	session.CommandRules = []CommandRule{{Name: "no-delete", Deny: []*regexp.Regexp{regexp.MustCompile(`^rm\s+-rf`)}}}
+	bob := &Viewer{Id: "bob-viewer", User: "bob", Writable: true, sockJSSession: newFakeSockJSSession(), commandRules: session.CommandRules}
+	type audited struct{ user, command, deniedBy string }
+	var audits []audited
+	session.CommandAudit = func(_ TerminalSession, user, command, deniedBy string) {
+		audits = append(audits, audited{user, command, deniedBy})
+	}
+
+	// the typo is corrected with a backspace
+	if out := session.filterCommands(nil, "lss\x7f -l\r"); out != "lss\x7f -l\r" {
+		t.Fatalf("expected the allowed line to pass, got %q", out)
+	}
+	if out := session.filterCommands(bob, "rm -rf /\r"); out != "rm -rf /"+clearLine {
+		t.Fatalf("expected the denied line to be discarded, got %q", out)
+	}
+	// the shell runs what the line editor made of the keys, e.g. "rm -rf /" from moving the cursor, recalling
+	// history or pasting, so edited lines are denied
+	for _, keys := range []string{"m -rf /\x01r\r", "\x1b[A\r", "\x1b[200~rm -rf /\x1b[201~\r", "\x12rm\r", "rm -r\tf /\r"} {
+		if out := session.filterCommands(nil, keys); !strings.HasSuffix(out, clearLine) {
+			t.Fatalf("expected the edited line %q to be discarded, got %q", keys, out)
+		}
+	}
+	// interrupting the line starts a new one
+	if out := session.filterCommands(nil, "\x1b[Dx\x03pwd\r"); out != "\x1b[Dx\x03pwd\r" {
+		t.Fatalf("expected the new line to pass, got %q", out)
+	}
+	expected := []audited{{"alice", "ls -l", ""}, {"bob", "rm -rf /", "no-delete"}}
+	if len(audits) != 8 || audits[0] != expected[0] || audits[1] != expected[1] || audits[2].deniedBy != unverifiable || audits[7].command != "pwd" {
+		t.Fatalf("unexpected audits %+v", audits)
+	}
+
+	// every writer types its own line and is held to its own rules
+	carol := &Viewer{Id: "carol-viewer", User: "carol", Writable: true, sockJSSession: newFakeSockJSSession()}
+	session.filterCommands(nil, "rm -r")
+	if out := session.filterCommands(carol, "echo\r"); out != "echo\r" {
+		t.Fatalf("expected the line of the viewer to pass, got %q", out)
+	}
+	if out := session.filterCommands(nil, "f /\r"); out != "f /"+clearLine {
+		t.Fatalf("expected the line of the owner to be discarded, got %q", out)
+	}
+	if out := session.filterCommands(carol, "rm -rf /tmp/x\r"); out != "rm -rf /tmp/x\r" {
+		t.Fatalf("expected the rules of the owner not to apply to the viewer, got %q", out)
+	}
+	if audits[9] != (audited{"alice", "rm -rf /", "no-delete"}) || audits[10] != (audited{"carol", "rm -rf /tmp/x", ""}) {
+		t.Fatalf("unexpected audits %+v", audits[8:])
+	}
+
+	allowOnly := CommandRule{Name: "read-only", Allow: []*regexp.Regexp{regexp.MustCompile(`^(ls|cat)\b`)}}
+	if allowOnly.denies("cat /etc/hosts") || !allowOnly.denies("touch x") {
+		t.Fatal("expected only the allowed commands to pass")
+	}
+}
@@ -0,0 +1,409 @@
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/igm/sockjs-go.v2/sockjs"
+)
+
+const (
+	// ShareTTL is how long a share link of a terminal can be joined when no other expiry is asked for
+	ShareTTL = time.Hour
+	// historySize bounds the recent output replayed to clients attaching to a shared terminal
+	historySize = 32 * 1024
+	// viewerBindTimeout is how long a viewer is kept without its client binding it
+	viewerBindTimeout = time.Minute
+)
+
+var (
+	ErrShareNotFound = errors.New("the share link is invalid or has expired")
+	ErrShareDenied   = errors.New("the share link is not shared with you")
+)
+
+// Share is a link other users join a terminal session with
+type Share struct {
+	Token    string `json:"token"`
+	Writable bool   `json:"writable"`
+	// Users restricts the users who may join, any user authorized on the cluster may join if empty
+	Users     []string  `json:"users,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (s Share) allows(user string) bool {
+	if len(s.Users) == 0 {
+		return true
+	}
+	for _, u := range s.Users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Viewer is a client attached to a terminal session through a share link, it sees the output of the
+// terminal and types into it if it is writable
+type Viewer struct {
+	Id       string    `json:"id"`
+	User     string    `json:"user"`
+	Writable bool      `json:"writable"`
+	JoinedAt time.Time `json:"joinedAt"`
+	// Connected is false until the client of the viewer binds its SockJS connection
+	Connected bool `json:"connected"`
+
+	loginSessionId string
+	sockJSSession  sockjs.Session
+	// commandRules deny the command lines a writable viewer types, they are the ones of its user rather than the
+	// ones of the owner
+	commandRules []CommandRule
+}
+
+type terminalInput struct {
+	msg TerminalMessage
+	err error
+	// viewer is the viewer who typed the input, nil for the owner
+	viewer *Viewer
+}
+
+// sharedTerminal is the state of a terminal session shared by all copies of its TerminalSession: the input of
+// the owner and of the writable viewers, and the viewers the output fans out to
+type sharedTerminal struct {
+	input     chan terminalInput
+	done      chan struct{}
+	closeOnce sync.Once
+
+	lock    sync.Mutex
+	owner   sockjs.Session
+	shares  map[string]Share
+	viewers map[string]*Viewer
+	history []byte
+	// commands are the command lines being typed by the owner, keyed "", and by the writable viewers, keyed by
+	// their ids. A line is only used by the reader of the input.
+	commands map[string]*commandLine
+}
+
+func newSharedTerminal() *sharedTerminal {
+	return &sharedTerminal{
+		input:    make(chan terminalInput),
+		done:     make(chan struct{}),
+		shares:   map[string]Share{},
+		viewers:  map[string]*Viewer{},
+		commands: map[string]*commandLine{},
+	}
+}
+
+// receive reads the messages of the client until it disconnects. The messages of the owner are all passed to
+// the process, the ones of viewers only if they are keystrokes of a writable viewer.
+func (s *sharedTerminal) receive(sockJSSession sockjs.Session, viewer *Viewer) {
+	for {
+		var msg TerminalMessage
+		m, err := sockJSSession.Recv()
+		if err == nil {
+			err = json.Unmarshal([]byte(m), &msg)
+		}
+		if viewer != nil {
+			if err != nil {
+				s.detach(viewer.Id, "")
+				return
+			}
+			if msg.Op == "stdin" && viewer.Writable && !s.push(terminalInput{msg: msg, viewer: viewer}) {
+				return
+			}
+			continue
+		}
+		if !s.push(terminalInput{msg: msg, err: err}) || err != nil {
+			return
+		}
+	}
+}
+
+func (s *sharedTerminal) push(in terminalInput) bool {
+	select {
+	case s.input <- in:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// broadcast sends the output to the viewers and keeps it to replay it to the ones attaching later
+func (s *sharedTerminal) broadcast(msg string, data []byte) {
+	s.lock.Lock()
+	s.history = append(s.history, data...)
+	if len(s.history) > historySize {
+		s.history = append([]byte{}, s.history[len(s.history)-historySize:]...)
+	}
+	var failed []string
+	for id, v := range s.viewers {
+		if v.sockJSSession == nil {
+			continue
+		}
+		if err := v.sockJSSession.Send(msg); err != nil {
+			failed = append(failed, id)
+		}
+	}
+	s.lock.Unlock()
+	for _, id := range failed {
+		s.detach(id, "")
+	}
+}
+
+// addShare creates a share link of the terminal valid for ttl
+func (s *sharedTerminal) addShare(writable bool, users []string, ttl time.Duration) (Share, error) {
+	token, err := GenTerminalSessionId()
+	if err != nil {
+		return Share{}, err
+	}
+	now := time.Now()
+	share := Share{Token: token, Writable: writable, Users: users, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.shares[token] = share
+	return share, nil
+}
+
+func (s *sharedTerminal) share(token string) (Share, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	share, ok := s.shares[token]
+	if ok && share.ExpiresAt.Before(time.Now()) {
+		delete(s.shares, token)
+		return Share{}, false
+	}
+	return share, ok
+}
+
+// revoke removes the share links and detaches all viewers
+func (s *sharedTerminal) revoke(reason string) {
+	s.lock.Lock()
+	s.shares = map[string]Share{}
+	var ids []string
+	for id := range s.viewers {
+		ids = append(ids, id)
+	}
+	s.lock.Unlock()
+	for _, id := range ids {
+		s.detach(id, reason)
+	}
+}
+
+func (s *sharedTerminal) attach(viewer *Viewer) {
+	s.lock.Lock()
+	for id, v := range s.viewers {
+		if v.sockJSSession == nil && time.Since(v.JoinedAt) > viewerBindTimeout {
+			delete(s.viewers, id)
+		}
+	}
+	s.viewers[viewer.Id] = viewer
+	s.lock.Unlock()
+	s.notifyOwner()
+}
+
+// bind connects the SockJS connection of the viewer, it is sent the recent output first
+func (s *sharedTerminal) bind(viewerId string, sockJSSession sockjs.Session) (*Viewer, error) {
+	s.lock.Lock()
+	viewer, ok := s.viewers[viewerId]
+	if !ok || viewer.sockJSSession != nil {
+		s.lock.Unlock()
+		return nil, fmt.Errorf("can't find viewer '%s'", viewerId)
+	}
+	viewer.sockJSSession = sockJSSession
+	viewer.Connected = true
+	if len(s.history) > 0 {
+		if msg, err := json.Marshal(TerminalMessage{Op: "stdout", Data: string(s.history)}); err == nil {
+			// a failed send shows up as a failed receive of the viewer, which detaches it
+			_ = sockJSSession.Send(string(msg))
+		}
+	}
+	s.lock.Unlock()
+	s.notifyOwner()
+	return viewer, nil
+}
+
+// detach disconnects the viewer, the reason is shown to it unless ""
+func (s *sharedTerminal) detach(viewerId string, reason string) {
+	s.lock.Lock()
+	viewer, ok := s.viewers[viewerId]
+	delete(s.viewers, viewerId)
+	delete(s.commands, viewerId)
+	s.lock.Unlock()
+	if !ok {
+		return
+	}
+	if viewer.sockJSSession != nil {
+		_ = viewer.sockJSSession.Close(2, reason)
+	}
+	s.notifyOwner()
+}
+
+// commandLine returns the command line being typed by the viewer, by the owner if viewer is nil
+func (s *sharedTerminal) commandLine(viewer *Viewer) *commandLine {
+	key := ""
+	if viewer != nil {
+		key = viewer.Id
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, ok := s.commands[key]
+	if !ok {
+		c = &commandLine{}
+		s.commands[key] = c
+	}
+	return c
+}
+
+func (s *sharedTerminal) listViewers() []Viewer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	viewers := make([]Viewer, 0, len(s.viewers))
+	for _, v := range s.viewers {
+		viewers = append(viewers, *v)
+	}
+	return viewers
+}
+
+func (s *sharedTerminal) listShares() []Share {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	shares := make([]Share, 0, len(s.shares))
+	for token, share := range s.shares {
+		if share.ExpiresAt.Before(time.Now()) {
+			delete(s.shares, token)
+			continue
+		}
+		shares = append(shares, share)
+	}
+	return shares
+}
+
+// notifyOwner sends the owner the viewers attached to its terminal
+func (s *sharedTerminal) notifyOwner() {
+	data, err := json.Marshal(s.listViewers())
+	if err != nil {
+		return
+	}
+	msg, err := json.Marshal(TerminalMessage{Op: "viewers", Data: string(data)})
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.owner != nil {
+		_ = s.owner.Send(string(msg))
+	}
+}
+
+// close stops the input of the terminal and disconnects its viewers
+func (s *sharedTerminal) close(reason string) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.revoke(reason)
+}
+
+// ShareTerminal creates a share link of the terminal session of the owner
+func (sm *SessionMap) ShareTerminal(sessionId, owner string, writable bool, users []string, ttl time.Duration) (Share, error) {
+	session, err := sm.owned(sessionId, owner)
+	if err != nil {
+		return Share{}, err
+	}
+	return session.shared.addShare(writable, users, ttl)
+}
+
+// RevokeShares invalidates the share links of the terminal session of the owner and detaches its viewers
+func (sm *SessionMap) RevokeShares(sessionId, owner string) error {
+	session, err := sm.owned(sessionId, owner)
+	if err != nil {
+		return err
+	}
+	session.shared.revoke("the owner stopped sharing the terminal")
+	return nil
+}
+
+// ListSharing returns the share links and the viewers of the terminal session of the owner
+func (sm *SessionMap) ListSharing(sessionId, owner string) ([]Share, []Viewer, error) {
+	session, err := sm.owned(sessionId, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session.shared.listShares(), session.shared.listViewers(), nil
+}
+
+// JoinTerminal adds the user as a viewer of the terminal session shared with the token, the returned viewer is
+// bound by the client like a terminal session. Writable shares of terminals which needed a dedicated verb are only
+// joined by users for whom hasVerb reports it. The input of writable viewers is checked against the rules
+// commandRules returns for the user in the namespace of the terminal.
+func (sm *SessionMap) JoinTerminal(cluster, token, user, loginSessionId string, hasVerb func(verb string) bool, commandRules func(namespace string) ([]CommandRule, error)) (TerminalSession, Viewer, error) {
+	sm.Lock.Lock()
+	var session TerminalSession
+	var share Share
+	found := false
+	for _, s := range sm.Sessions {
+		if s.Cluster != cluster || s.shared == nil {
+			continue
+		}
+		if share, found = s.shared.share(token); found {
+			session = s
+			break
+		}
+	}
+	sm.Lock.Unlock()
+	if !found {
+		return TerminalSession{}, Viewer{}, ErrShareNotFound
+	}
+	if !share.allows(user) {
+		return TerminalSession{}, Viewer{}, ErrShareDenied
+	}
+	if share.Writable && session.Verb != "" && !hasVerb(session.Verb) {
+		return TerminalSession{}, Viewer{}, fmt.Errorf("%w: writing to the terminal needs the %s permission", ErrShareDenied, session.Verb)
+	}
+	var rules []CommandRule
+	if share.Writable {
+		var err error
+		if rules, err = commandRules(session.Namespace); err != nil {
+			return TerminalSession{}, Viewer{}, err
+		}
+	}
+	id, err := GenTerminalSessionId()
+	if err != nil {
+		return TerminalSession{}, Viewer{}, err
+	}
+	viewer := &Viewer{Id: id, User: user, Writable: share.Writable, JoinedAt: time.Now(), loginSessionId: loginSessionId, commandRules: rules}
+	session.shared.attach(viewer)
+	return session, *viewer, nil
+}
+
+func (sm *SessionMap) owned(sessionId, owner string) (TerminalSession, error) {
+	session := sm.Get(sessionId)
+	if session.Id == "" || session.shared == nil || session.Owner != owner {
+		return TerminalSession{}, errors.New("the terminal session does not exist or is not yours")
+	}
+	return session, nil
+}
+
+// bindViewer binds the SockJS connection of a viewer of one of the shared terminals
+func (sm *SessionMap) bindViewer(viewerId string, sockJSSession sockjs.Session) (*sharedTerminal, *Viewer, error) {
+	sm.Lock.Lock()
+	var shared []*sharedTerminal
+	for _, s := range sm.Sessions {
+		if s.shared != nil {
+			shared = append(shared, s.shared)
+		}
+	}
+	sm.Lock.Unlock()
+	for _, s := range shared {
+		s.lock.Lock()
+		_, ok := s.viewers[viewerId]
+		s.lock.Unlock()
+		if ok {
+			viewer, err := s.bind(viewerId, sockJSSession)
+			return s, viewer, err
+		}
+	}
+	return nil, nil, fmt.Errorf("can't find session '%s'", viewerId)
+}
@@ -0,0 +1,209 @@
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeSockJSSession struct {
+	recv chan string
+
+	lock   sync.Mutex
+	sent   []TerminalMessage
+	closed bool
+}
+
+func newFakeSockJSSession() *fakeSockJSSession {
+	return &fakeSockJSSession{recv: make(chan string, 10)}
+}
+
+func (f *fakeSockJSSession) ID() string { return "fake" }
+
+func (f *fakeSockJSSession) Recv() (string, error) {
+	m, ok := <-f.recv
+	if !ok {
+		return "", errors.New("closed")
+	}
+	return m, nil
+}
+
+func (f *fakeSockJSSession) Send(m string) error {
+	var msg TerminalMessage
+	if err := json.Unmarshal([]byte(m), &msg); err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeSockJSSession) Close(uint32, string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeSockJSSession) stdout() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var out strings.Builder
+	for _, m := range f.sent {
+		if m.Op == "stdout" {
+			out.WriteString(m.Data)
+		}
+	}
+	return out.String()
+}
+
+func (f *fakeSockJSSession) stdin(data string) {
+	m, _ := json.Marshal(TerminalMessage{Op: "stdin", Data: data})
+	f.recv <- string(m)
+}
+
+func hasNoVerb(string) bool { return false }
+
+func noCommandRules(string) ([]CommandRule, error) { return nil, nil }
+
+func TestSharedTerminal(t *testing.T) {
+	session := NewTerminalSession("shared-test", "login", "alice", "test", "default/web/app")
+	owner := newFakeSockJSSession()
+	session.sockJSSession = owner
+	session.shared.owner = owner
+	TerminalSessions.Set(session.Id, session)
+	defer TerminalSessions.Close(session.Id, 1, "done")
+	go session.shared.receive(owner, nil)
+
+	if _, err := TerminalSessions.ShareTerminal(session.Id, "bob", false, nil, ShareTTL); err == nil {
+		t.Fatal("expected only the owner to share the terminal")
+	}
+	_, _ = session.Write([]byte("before "))
+
+	join := func(writable bool) *fakeSockJSSession {
+		share, err := TerminalSessions.ShareTerminal(session.Id, "alice", writable, []string{"bob"}, ShareTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := TerminalSessions.JoinTerminal("test", share.Token, "carol", "other", hasNoVerb, noCommandRules); !errors.Is(err, ErrShareDenied) {
+			t.Fatalf("expected the share to be denied to other users, got %v", err)
+		}
+		_, viewer, err := TerminalSessions.JoinTerminal("test", share.Token, "bob", "other", hasNoVerb, noCommandRules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := newFakeSockJSSession()
+		shared, v, err := TerminalSessions.bindViewer(viewer.Id, conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go shared.receive(conn, v)
+		return conn
+	}
+	observer := join(false)
+	writer := join(true)
+
+	_, _ = session.Write([]byte("after"))
+	if out := observer.stdout(); out != "before after" {
+		t.Fatalf("unexpected output of the observer %q", out)
+	}
+
+	observer.stdin("ignored")
+	writer.stdin("typed")
+	p := make([]byte, 32)
+	n, err := session.Read(p)
+	if err != nil || string(p[:n]) != "typed" {
+		t.Fatalf("expected the input of the writer, got %q, %v", p[:n], err)
+	}
+
+	var viewers []Viewer
+	owner.lock.Lock()
+	for _, m := range owner.sent {
+		if m.Op == "viewers" {
+			viewers = nil
+			_ = json.Unmarshal([]byte(m.Data), &viewers)
+		}
+	}
+	owner.lock.Unlock()
+	if len(viewers) != 2 || viewers[0].User != "bob" || !viewers[0].Connected {
+		t.Fatalf("unexpected viewers %+v", viewers)
+	}
+
+	if err := TerminalSessions.RevokeShares(session.Id, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	observer.lock.Lock()
+	closed := observer.closed
+	observer.lock.Unlock()
+	if !closed {
+		t.Fatal("expected the viewers to be detached")
+	}
+}
+
+func TestSharedDebugTerminal(t *testing.T) {
+	session := NewTerminalSession("shared-debug-test", "login", "alice", "test", "default/web/app (debug)")
+	session.Verb = "debug"
+	TerminalSessions.Set(session.Id, session)
+	defer TerminalSessions.Close(session.Id, 1, "done")
+
+	share, err := TerminalSessions.ShareTerminal(session.Id, "alice", true, []string{"bob"}, ShareTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := TerminalSessions.JoinTerminal("test", share.Token, "bob", "other", hasNoVerb, noCommandRules); !errors.Is(err, ErrShareDenied) {
+		t.Fatalf("expected writing to need the debug verb, got %v", err)
+	}
+	hasDebug := func(verb string) bool { return verb == "debug" }
+	if _, _, err := TerminalSessions.JoinTerminal("test", share.Token, "bob", "other", hasDebug, noCommandRules); err != nil {
+		t.Fatal(err)
+	}
+
+	share, err = TerminalSessions.ShareTerminal(session.Id, "alice", false, []string{"bob"}, ShareTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := TerminalSessions.JoinTerminal("test", share.Token, "bob", "other", hasNoVerb, noCommandRules); err != nil {
+		t.Fatalf("expected observing to need no verb, got %v", err)
+	}
+}
+
+func TestSharedTerminalCommandRules(t *testing.T) {
+	session := NewTerminalSession("shared-rules-test", "login", "alice", "test", "default/web/app")
+	session.Namespace = "default"
+	TerminalSessions.Set(session.Id, session)
+	defer TerminalSessions.Close(session.Id, 1, "done")
+
+	var namespaces []string
+	rulesOf := func(namespace string) ([]CommandRule, error) {
+		namespaces = append(namespaces, namespace)
+		return []CommandRule{{Name: "bob-only"}}, nil
+	}
+	for _, writable := range []bool{false, true} {
+		share, err := TerminalSessions.ShareTerminal(session.Id, "alice", writable, nil, ShareTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, viewer, err := TerminalSessions.JoinTerminal("test", share.Token, "bob", "other", hasNoVerb, rulesOf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if writable != (len(viewer.commandRules) == 1) {
+			t.Fatalf("expected only the writable viewer to get the rules of its user, got %+v", viewer.commandRules)
+		}
+	}
+	if len(namespaces) != 1 || namespaces[0] != "default" {
+		t.Fatalf("expected the rules of the namespace of the terminal, got %v", namespaces)
+	}
+
+	share, err := TerminalSessions.ShareTerminal(session.Id, "alice", true, nil, ShareTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := func(string) ([]CommandRule, error) { return nil, errors.New("invalid policy") }
+	if _, _, err := TerminalSessions.JoinTerminal("test", share.Token, "bob", "other", hasNoVerb, failing); err == nil {
+		t.Fatal("expected the join to fail without the rules of the user")
+	}
+}
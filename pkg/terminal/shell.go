@@ -21,7 +21,6 @@ import (
 )
 
 const END_OF_TRANSMISSION = "\u0004"
-const SessionTerminalStoreTime = 5 // session timeout (minute)
 
 // PtyHandler is what remotecommand expects from a pty
 type PtyHandler interface {
@@ -32,12 +31,46 @@ type PtyHandler interface {
 
 // TerminalSession implements PtyHandler (using a SockJS connection)
 type TerminalSession struct {
-	Id            string
+	Id string
+	// LoginSessionId is the login session which opened this terminal
+	LoginSessionId string
+	// Owner is the user who opened this terminal, Cluster and Target tell what it is a terminal of
+	Owner   string
+	Cluster string
+	Target  string
+	// Namespace is the namespace of the target, "" for node shells
+	Namespace string
+	// Verb is the dedicated verb of clusters opening the terminal needed, e.g. debug or nodeshell, the users who
+	// write to a share of the terminal need it as well
+	Verb          string
 	Bound         chan error
 	sockJSSession sockjs.Session
 	SizeChan      chan remotecommand.TerminalSize
 	doneChan      chan struct{}
-	TimeOut       time.Time
+	// TimeOut is when the terminal is idle for too long, it is pushed back by every input and output
+	TimeOut   time.Time
+	CreatedAt time.Time
+	Limits    Limits
+	// CommandRules deny command lines typed into the terminal, CommandAudit records them
+	CommandRules []CommandRule
+	CommandAudit CommandAudit
+	shared       *sharedTerminal
+}
+
+// NewTerminalSession returns a terminal session of the owner which waits to be bound by its SockJS connection
+func NewTerminalSession(id, loginSessionId, owner, cluster, target string) TerminalSession {
+	return TerminalSession{
+		Id:             id,
+		LoginSessionId: loginSessionId,
+		Owner:          owner,
+		Cluster:        cluster,
+		Target:         target,
+		Bound:          make(chan error),
+		SizeChan:       make(chan remotecommand.TerminalSize),
+		CreatedAt:      time.Now(),
+		Limits:         DefaultLimits,
+		shared:         newSharedTerminal(),
+	}
 }
 
 // TerminalMessage is the messaging protocol between ShellController and TerminalSession.
@@ -49,6 +82,7 @@ type TerminalSession struct {
 // resize  fe->be     Rows, Cols     New terminal size
 // stdout  be->fe     Data           Output from the process
 // toast   be->fe     Data           OOB message to be shown to the user
+// viewers be->fe     Data           JSON list of the viewers attached to a shared terminal, sent to its owner
 type TerminalMessage struct {
 	Op, Data, SessionID string
 	Rows, Cols          uint16
@@ -69,25 +103,27 @@ func (t TerminalSession) Next() *remotecommand.TerminalSize {
 // Called in a loop from remotecommand as long as the process is running
 func (t TerminalSession) Read(p []byte) (int, error) {
 	session := TerminalSessions.Get(t.Id)
-	if session.TimeOut.Before(time.Now()) {
-		_ = TerminalSessions.Sessions[session.Id].sockJSSession.Close(2, "the connection has been disconnected. Please reconnect")
-		return 0, errors.New("the connection has been disconnected. Please reconnect")
+	if session.Id == "" {
+		// the session was closed from outside, e.g. its login session was revoked
+		return copy(p, END_OF_TRANSMISSION), errors.New("the terminal session has been closed")
 	}
 	TerminalSessions.Set(session.Id, session)
-	m, err := session.sockJSSession.Recv()
-	if err != nil {
-		// Send terminated signal to process to avoid resource leak
-		return copy(p, END_OF_TRANSMISSION), err
+	// the input of the owner and of the writable viewers is received by sharedTerminal.receive
+	var in terminalInput
+	select {
+	case in = <-session.shared.input:
+	case <-session.shared.done:
+		return copy(p, END_OF_TRANSMISSION), errors.New("the terminal session has been closed")
 	}
-
-	var msg TerminalMessage
-	if err := json.Unmarshal([]byte(m), &msg); err != nil {
-		return copy(p, END_OF_TRANSMISSION), err
+	if in.err != nil {
+		// Send terminated signal to process to avoid resource leak
+		return copy(p, END_OF_TRANSMISSION), in.err
 	}
 
+	msg := in.msg
 	switch msg.Op {
 	case "stdin":
-		return copy(p, msg.Data), nil
+		return copy(p, session.filterCommands(in.viewer, msg.Data)), nil
 	case "resize":
 		session.SizeChan <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}
 		return 0, nil
@@ -100,9 +136,8 @@ func (t TerminalSession) Read(p []byte) (int, error) {
 // Called from remotecommand whenever there is any output
 func (t TerminalSession) Write(p []byte) (int, error) {
 	session := TerminalSessions.Get(t.Id)
-	if session.TimeOut.Before(time.Now()) {
-		_ = TerminalSessions.Sessions[session.Id].sockJSSession.Close(2, "the connection has been disconnected. Please reconnect")
-		return 0, errors.New("the connection has been disconnected. Please reconnect")
+	if session.Id == "" {
+		return 0, errors.New("the terminal session has been closed")
 	}
 	TerminalSessions.Set(session.Id, session)
 	msg, err := json.Marshal(TerminalMessage{
@@ -116,12 +151,17 @@ func (t TerminalSession) Write(p []byte) (int, error) {
 	if err = session.sockJSSession.Send(string(msg)); err != nil {
 		return 0, err
 	}
+	session.shared.broadcast(string(msg), p)
 	return len(p), nil
 }
 
 // Toast can be used to send the user any OOB messages
 // hterm puts these in the center of the terminal
 func (t TerminalSession) Toast(p string) error {
+	return sendToast(t.sockJSSession, p)
+}
+
+func sendToast(sockJSSession sockjs.Session, p string) error {
 	msg, err := json.Marshal(TerminalMessage{
 		Op:   "toast",
 		Data: p,
@@ -130,7 +170,7 @@ func (t TerminalSession) Toast(p string) error {
 		return err
 	}
 
-	if err = t.sockJSSession.Send(string(msg)); err != nil {
+	if err = sockJSSession.Send(string(msg)); err != nil {
 		return err
 	}
 	return nil
@@ -149,11 +189,11 @@ func (sm *SessionMap) Get(sessionId string) TerminalSession {
 	return sm.Sessions[sessionId]
 }
 
-// Set store a TerminalSession to SessionMap
+// Set store a TerminalSession to SessionMap, which counts as activity of the session
 func (sm *SessionMap) Set(sessionId string, session TerminalSession) {
 	sm.Lock.Lock()
 	defer sm.Lock.Unlock()
-	session.TimeOut = time.Now().Add(SessionTerminalStoreTime * time.Minute)
+	session.TimeOut = time.Now().Add(session.Limits.IdleTimeout)
 	sm.Sessions[sessionId] = session
 }
 
@@ -166,18 +206,51 @@ func (sm *SessionMap) Close(sessionId string, status uint32, reason string) {
 	}
 	sm.Lock.Lock()
 	defer sm.Lock.Unlock()
-	err := sm.Sessions[sessionId].sockJSSession.Close(status, reason)
-	if err != nil && status != 1 {
-		log.Println(err)
+	if sockJSSession := sm.Sessions[sessionId].sockJSSession; sockJSSession != nil {
+		if err := sockJSSession.Close(status, reason); err != nil && status != 1 {
+			log.Println(err)
+		}
+	}
+	if shared := sm.Sessions[sessionId].shared; shared != nil {
+		shared.close(reason)
 	}
 
 	delete(sm.Sessions, sessionId)
 }
 
+// CloseByLoginSession closes all terminals opened by the given login session and detaches it from the
+// terminals it joined
+func (sm *SessionMap) CloseByLoginSession(loginSessionId string, reason string) {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	for id, v := range sm.Sessions {
+		if v.LoginSessionId != loginSessionId {
+			if v.shared != nil {
+				for _, viewer := range v.shared.listViewers() {
+					if viewer.loginSessionId == loginSessionId {
+						v.shared.detach(viewer.Id, reason)
+					}
+				}
+			}
+			continue
+		}
+		if v.sockJSSession != nil {
+			_ = v.sockJSSession.Close(2, reason)
+		}
+		if v.shared != nil {
+			v.shared.close(reason)
+		}
+		delete(sm.Sessions, id)
+	}
+}
+
 // Clean all session when system logout
 func (sm *SessionMap) Clean() {
 	for _, v := range sm.Sessions {
 		v.sockJSSession.Close(2, "system is logout, please retry...")
+		if v.shared != nil {
+			v.shared.close("system is logout, please retry...")
+		}
 	}
 	sm.Sessions = make(map[string]TerminalSession)
 }
@@ -209,12 +282,23 @@ func handleTerminalSession(session sockjs.Session) {
 	}
 
 	if terminalSession = TerminalSessions.Get(msg.SessionID); terminalSession.Id == "" {
-		log.Printf("handleTerminalSession: can't find session '%s'", msg.SessionID)
+		// the client may be a viewer joining a shared terminal
+		shared, viewer, err := TerminalSessions.bindViewer(msg.SessionID, session)
+		if err != nil {
+			log.Printf("handleTerminalSession: %v", err)
+			return
+		}
+		shared.receive(session, viewer)
 		return
 	}
 
 	terminalSession.sockJSSession = session
 	TerminalSessions.Set(msg.SessionID, terminalSession)
+	terminalSession.shared.lock.Lock()
+	terminalSession.shared.owner = session
+	terminalSession.shared.lock.Unlock()
+	go terminalSession.shared.receive(session, nil)
+	go TerminalSessions.watch(msg.SessionID)
 	terminalSession.Bound <- nil
 }
 
@@ -0,0 +1,187 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// MaxArchiveSize is the largest chart archive accepted for upload
+const MaxArchiveSize = 20 << 20
+
+// LoadArchive reads a packaged chart, the chart and its dependencies are validated by the loader
+func LoadArchive(data []byte) (*chart.Chart, error) {
+	if len(data) > MaxArchiveSize {
+		return nil, fmt.Errorf("chart archive is larger than %d bytes", MaxArchiveSize)
+	}
+	ct, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid chart archive: %v", err)
+	}
+	return ct, nil
+}
+
+// InstallArchive installs a chart which is not located in a repo, e.g. an uploaded archive
+func (c Client) InstallArchive(ctx context.Context, name string, ct *chart.Chart, values map[string]interface{}, options Options) (*release.Release, error) {
+	client := action.NewInstall(c.actionConfig)
+	client.Wait = options.Wait
+	client.Timeout = options.timeout()
+	client.ReleaseName = name
+	client.Namespace = c.Namespace
+	re, err := client.RunWithContext(ctx, ct, values)
+	if err != nil {
+		return re, errors.Wrap(err, fmt.Sprintf("install %s with chart %s failed: %v", name, ct.Name(), err))
+	}
+	return re, nil
+}
+
+// UpgradeArchive upgrades the release to a chart which is not located in a repo
+func (c Client) UpgradeArchive(ctx context.Context, name string, ct *chart.Chart, values map[string]interface{}, options Options) (*release.Release, error) {
+	client := action.NewUpgrade(c.actionConfig)
+	client.Wait = options.Wait
+	client.Timeout = options.timeout()
+	client.Namespace = c.Namespace
+	re, err := client.RunWithContext(ctx, name, ct, values)
+	if err != nil {
+		return re, errors.Wrap(err, fmt.Sprintf("upgrade tool %s with chart %s failed: %v", name, ct.Name(), err))
+	}
+	return re, nil
+}
+
+var localRepoLock sync.Mutex
+
+// LocalRepo is a chart repository kept in a directory, the archives
+// are stored next to the index.yaml and referenced by relative urls.
+type LocalRepo struct {
+	Dir string
+}
+
+// ArchiveName is the file name helm package gives the chart
+func ArchiveName(ct *chart.Chart) string {
+	return fmt.Sprintf("%s-%s.tgz", ct.Name(), ct.Metadata.Version)
+}
+
+// archiveNamePart matches the chart names and versions which are safe as part of a file name
+var archiveNamePart = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// archivePath is the path of the archive of the chart in the repo, the name and version of the chart come from
+// its Chart.yaml and must not lead out of the repo
+func (l LocalRepo) archivePath(ct *chart.Chart) (string, error) {
+	name := ArchiveName(ct)
+	if !archiveNamePart.MatchString(ct.Name()) || !archiveNamePart.MatchString(ct.Metadata.Version) || name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid chart name %q or version %q", ct.Name(), ct.Metadata.Version)
+	}
+	return filepath.Join(l.Dir, name), nil
+}
+
+// ErrVersionExists is returned when a chart version is saved again without overwrite
+var ErrVersionExists = errors.New("chart version already exists")
+
+// Save stores the archive of the chart and regenerates the index
+func (l LocalRepo) Save(ct *chart.Chart, data []byte, overwrite bool) error {
+	p, err := l.archivePath(ct)
+	if err != nil {
+		return err
+	}
+	localRepoLock.Lock()
+	defer localRepoLock.Unlock()
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(p); err == nil && !overwrite {
+		return ErrVersionExists
+	}
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		return err
+	}
+	return l.reindex()
+}
+
+// Delete removes a chart version and regenerates the index
+func (l LocalRepo) Delete(name, version string) error {
+	localRepoLock.Lock()
+	defer localRepoLock.Unlock()
+	index, err := l.loadIndex()
+	if err != nil {
+		return err
+	}
+	cv, err := index.Get(name, version)
+	if err != nil || cv.Version != version {
+		return os.ErrNotExist
+	}
+	for _, u := range cv.URLs {
+		if u != filepath.Base(u) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(l.Dir, u)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return l.reindex()
+}
+
+// Charts returns the versions of the charts in the repo, newest first
+func (l LocalRepo) Charts() (map[string]repo.ChartVersions, error) {
+	if _, err := l.IndexFile(); err != nil {
+		return nil, err
+	}
+	localRepoLock.Lock()
+	defer localRepoLock.Unlock()
+	index, err := l.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	return index.Entries, nil
+}
+
+func (l LocalRepo) loadIndex() (*repo.IndexFile, error) {
+	return repo.LoadIndexFile(filepath.Join(l.Dir, "index.yaml"))
+}
+
+// IndexFile is the path of the index.yaml, it is generated when missing
+func (l LocalRepo) IndexFile() (string, error) {
+	localRepoLock.Lock()
+	defer localRepoLock.Unlock()
+	p := filepath.Join(l.Dir, "index.yaml")
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		if err := os.MkdirAll(l.Dir, 0755); err != nil {
+			return "", err
+		}
+		if err := l.reindex(); err != nil {
+			return "", err
+		}
+	}
+	return p, nil
+}
+
+// Archive is the path of a chart archive in the repo
+func (l LocalRepo) Archive(file string) (string, error) {
+	if file != filepath.Base(file) || filepath.Ext(file) != ".tgz" {
+		return "", fmt.Errorf("invalid chart archive name %s", file)
+	}
+	p := filepath.Join(l.Dir, file)
+	if _, err := os.Stat(p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func (l LocalRepo) reindex() error {
+	index, err := repo.IndexDirectory(l.Dir, "")
+	if err != nil {
+		return err
+	}
+	index.SortEntries()
+	return index.WriteFile(filepath.Join(l.Dir, "index.yaml"), 0644)
+}
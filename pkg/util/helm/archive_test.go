@@ -0,0 +1,97 @@
+package helm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func packageChart(t *testing.T, version string) []byte {
+	ct := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "app", Version: version},
+		Values:   map[string]interface{}{"replicas": 1},
+		Templates: []*chart.File{
+			{Name: "templates/configmap.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n")},
+		},
+	}
+	p, err := chartutil.Save(ct, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLocalRepo(t *testing.T) {
+	if _, err := LoadArchive([]byte("not a chart")); err == nil {
+		t.Fatal("expected an error for an invalid archive")
+	}
+	l := LocalRepo{Dir: t.TempDir()}
+	for _, version := range []string{"0.1.0", "0.2.0"} {
+		data := packageChart(t, version)
+		ct, err := LoadArchive(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Save(ct, data, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := l.IndexFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := repo.LoadIndexFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := index.Entries["app"]
+	if len(versions) != 2 || versions[0].Version != "0.2.0" || versions[0].URLs[0] != "app-0.2.0.tgz" {
+		t.Fatalf("unexpected index entries %v", versions)
+	}
+	if _, err := l.Archive("app-0.1.0.tgz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Archive("../app-0.1.0.tgz"); err == nil {
+		t.Fatal("expected an error for a path outside the repo")
+	}
+
+	// the name comes from the Chart.yaml of the archive
+	escape := &chart.Chart{Metadata: &chart.Metadata{Name: "../../escape", Version: "1.0.0"}}
+	if err := l.Save(escape, []byte("archive"), false); err == nil {
+		t.Fatal("expected an error for a chart name leading out of the repo")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(l.Dir, "..", "..", "escape-*")); len(matches) > 0 {
+		t.Fatalf("the archive was written outside the repo: %v", matches)
+	}
+
+	data := packageChart(t, "0.2.0")
+	ct, _ := LoadArchive(data)
+	if err := l.Save(ct, data, false); !errors.Is(err, ErrVersionExists) {
+		t.Fatalf("expected ErrVersionExists, got %v", err)
+	}
+	if err := l.Delete("app", "0.1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete("app", "0.1.0"); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+	charts, err := l.Charts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(charts["app"]) != 1 || charts["app"][0].Version != "0.2.0" {
+		t.Fatalf("unexpected charts after delete %v", charts["app"])
+	}
+	if _, err := l.Archive("app-0.1.0.tgz"); !os.IsNotExist(err) {
+		t.Fatalf("expected the archive to be removed, got %v", err)
+	}
+}
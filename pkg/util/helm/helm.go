@@ -2,6 +2,7 @@ package helm
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/KubeOperator/kubepi/service/server"
 	"github.com/gofrs/flock"
@@ -14,6 +15,7 @@ import (
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/getter"
 	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/registry"
 	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/repo"
 	"io/ioutil"
@@ -21,6 +23,7 @@ import (
 	"k8s.io/client-go/rest"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -40,7 +43,25 @@ type Config struct {
 	Architectures string
 	ClusterName   string
 	KubeConfig    *rest.Config
+	// Log receives the output of the helm actions, it is discarded when nil
+	Log action.DebugLog
 }
+
+// Options control how long an install, upgrade or uninstall waits for its resources
+type Options struct {
+	// Wait until the resources are ready or deleted
+	Wait bool
+	// Timeout of the kubernetes operations and of the wait, helm defaults to 5 minutes
+	Timeout time.Duration
+}
+
+func (o Options) timeout() time.Duration {
+	if o.Timeout <= 0 {
+		return 5 * time.Minute
+	}
+	return o.Timeout
+}
+
 type Client struct {
 	actionConfig  *action.Configuration
 	Namespace     string
@@ -96,10 +117,19 @@ func NewClient(config *Config) (*Client, error) {
 		cf.Namespace = &client.Namespace
 	}
 	client.ClusterName = config.ClusterName
+	log := config.Log
+	if log == nil {
+		log = nolog
+	}
 	actionConfig := new(action.Configuration)
-	if err := actionConfig.Init(cf, config.Namespace, helmDriver, nolog); err != nil {
+	if err := actionConfig.Init(cf, config.Namespace, helmDriver, log); err != nil {
 		return nil, err
 	}
+	registryClient, err := newRegistryClient(client.settings)
+	if err != nil {
+		return nil, err
+	}
+	actionConfig.RegistryClient = registryClient
 	client.actionConfig = actionConfig
 
 	return &client, nil
@@ -138,27 +168,52 @@ func (c Client) GetDetail(name string) (*release.Release, error) {
 	return result, nil
 }
 
-func (c Client) Install(name, repoName, chartName, chartVersion string, values map[string]interface{}) (*release.Release, error) {
-	repos, err := c.ListRepo()
+// GetRevision returns the release at the given revision, 0 is the latest one
+func (c Client) GetRevision(name string, revision int) (*release.Release, error) {
+	client := action.NewGet(c.actionConfig)
+	client.Version = revision
+	result, err := client.Run(name)
 	if err != nil {
 		return nil, err
 	}
-	var rp *repo.Entry
-	for _, r := range repos {
-		if r.Name == repoName {
-			rp = r
-		}
+	return result, nil
+}
+
+// History returns the revisions of the release, the oldest first, max limits them to the latest ones
+func (c Client) History(name string, max int) ([]*release.Release, error) {
+	client := action.NewHistory(c.actionConfig)
+	client.Max = max
+	result, err := client.Run(name)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("get history of %s failed: %v", name, err))
 	}
-	if rp == nil {
-		return nil, errors.New("get chart detail failed, repo not found")
+	return result, nil
+}
+
+// Rollback rolls the release back to the given revision, 0 is the previous one
+func (c Client) Rollback(name string, revision int) error {
+	client := action.NewRollback(c.actionConfig)
+	client.Version = revision
+	if err := client.Run(name); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("rollback %s to revision %d failed: %v", name, revision, err))
 	}
+	return nil
+}
+
+func (c Client) Install(name, repoName, chartName, chartVersion string, values map[string]interface{}) (*release.Release, error) {
+	return c.InstallWithContext(context.Background(), name, repoName, chartName, chartVersion, values, Options{})
+}
+
+// InstallWithContext installs the chart, the install fails when the context is canceled
+func (c Client) InstallWithContext(ctx context.Context, name, repoName, chartName, chartVersion string, values map[string]interface{}, options Options) (*release.Release, error) {
 	client := action.NewInstall(c.actionConfig)
+	client.Wait = options.Wait
+	client.Timeout = options.timeout()
+	if err := c.setChartSource(&client.ChartPathOptions, repoName, chartName); err != nil {
+		return nil, err
+	}
 	client.ReleaseName = name
 	client.Namespace = c.Namespace
-	client.RepoURL = rp.URL
-	client.Username = rp.Username
-	client.Password = rp.Password
-	client.ChartPathOptions.InsecureSkipTLSverify = true
 	if len(chartVersion) != 0 {
 		client.ChartPathOptions.Version = chartVersion
 	}
@@ -170,7 +225,7 @@ func (c Client) Install(name, repoName, chartName, chartVersion string, values m
 	if err != nil {
 		return nil, fmt.Errorf("load chart %s failed: %v", chartName, err)
 	}
-	re, err := client.Run(ct, values)
+	re, err := client.RunWithContext(ctx, ct, values)
 	if err != nil {
 		return re, errors.Wrap(err, fmt.Sprintf("install %s with chart %s failed: %v", name, chartName, err))
 	}
@@ -178,27 +233,19 @@ func (c Client) Install(name, repoName, chartName, chartVersion string, values m
 }
 
 func (c Client) Upgrade(name, repoName, chartName, chartVersion string, values map[string]interface{}) (*release.Release, error) {
-	repos, err := c.ListRepo()
-	if err != nil {
-		return nil, err
-	}
-	var rp *repo.Entry
-	for _, r := range repos {
-		if r.Name == repoName {
-			rp = r
-		}
-	}
-	if rp == nil {
-		return nil, errors.New("get chart detail failed, repo not found")
-	}
+	return c.UpgradeWithContext(context.Background(), name, repoName, chartName, chartVersion, values, Options{})
+}
 
+// UpgradeWithContext upgrades the release, the upgrade fails when the context is canceled
+func (c Client) UpgradeWithContext(ctx context.Context, name, repoName, chartName, chartVersion string, values map[string]interface{}, options Options) (*release.Release, error) {
 	client := action.NewUpgrade(c.actionConfig)
+	client.Wait = options.Wait
+	client.Timeout = options.timeout()
+	if err := c.setChartSource(&client.ChartPathOptions, repoName, chartName); err != nil {
+		return nil, err
+	}
 	client.Namespace = c.Namespace
-	client.RepoURL = rp.URL
-	client.Username = rp.Username
-	client.Password = rp.Password
 	client.DryRun = false
-	client.ChartPathOptions.InsecureSkipTLSverify = true
 	client.ChartPathOptions.Version = chartVersion
 	p, err := client.ChartPathOptions.LocateChart(chartName, c.settings)
 	if err != nil {
@@ -210,15 +257,43 @@ func (c Client) Upgrade(name, repoName, chartName, chartVersion string, values m
 
 	}
 
-	release, err := client.Run(name, ct, values)
+	release, err := client.RunWithContext(ctx, name, ct, values)
 	if err != nil {
 		return release, errors.Wrap(err, fmt.Sprintf("upgrade tool %s with chart %s failed: %v", name, chartName, err))
 	}
 	return release, nil
 }
 
+// setChartSource points the chart options at the repo of the chart, charts
+// referenced with oci:// are pulled through the registry client instead.
+// The version of an oci chart may be a semver constraint, it is resolved against the registry tags.
+func (c Client) setChartSource(options *action.ChartPathOptions, repoName, chartName string) error {
+	options.InsecureSkipTLSverify = true
+	if registry.IsOCI(chartName) {
+		return nil
+	}
+	rp, err := c.GetRepo(repoName)
+	if err != nil {
+		return err
+	}
+	if rp == nil {
+		return errors.New("get chart detail failed, repo not found")
+	}
+	options.RepoURL = rp.URL
+	options.Username = rp.Username
+	options.Password = rp.Password
+	return nil
+}
+
 func (c Client) Uninstall(name string) (*release.UninstallReleaseResponse, error) {
+	return c.UninstallWithOptions(name, Options{})
+}
+
+// UninstallWithOptions uninstalls the release, helm can not cancel an uninstall once started
+func (c Client) UninstallWithOptions(name string, options Options) (*release.UninstallReleaseResponse, error) {
 	client := action.NewUninstall(c.actionConfig)
+	client.Wait = options.Wait
+	client.Timeout = options.timeout()
 	res, err := client.Run(name)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("uninstall tool %s failed: %v", name, err))
@@ -271,6 +346,15 @@ func (c Client) GetCharts(repoName, name string) ([]*search.Result, error) {
 }
 
 func (c Client) GetChartDetail(repoName, name, version string) (*chart.Chart, error) {
+	if registry.IsOCI(name) {
+		client := action.NewShowWithConfig(action.ShowAll, c.actionConfig)
+		client.Version = version
+		p, err := client.LocateChart(name, c.settings)
+		if err != nil {
+			return nil, err
+		}
+		return loader.Load(p)
+	}
 	repos, err := c.ListRepo()
 	if err != nil {
 		return nil, err
@@ -446,6 +530,60 @@ func (c Client) GetRepo(name string) (*repo.Entry, error) {
 	return re, nil
 }
 
+func newRegistryClient(settings *cli.EnvSettings) (*registry.Client, error) {
+	return registry.NewClient(
+		registry.ClientOptCredentialsFile(settings.RegistryConfig),
+		registry.ClientOptEnableCache(true),
+	)
+}
+
+// RegistryLogin stores the credentials of an oci registry in the registry config of the cluster
+func (c Client) RegistryLogin(host, username, password string, insecure bool) error {
+	if err := c.actionConfig.RegistryClient.Login(host,
+		registry.LoginOptBasicAuth(username, password),
+		registry.LoginOptInsecure(insecure)); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("login registry %s failed: %v", host, err))
+	}
+	return nil
+}
+
+func (c Client) RegistryLogout(host string) error {
+	if err := c.actionConfig.RegistryClient.Logout(host); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("logout registry %s failed: %v", host, err))
+	}
+	return nil
+}
+
+// ListRegistries returns the hosts of the oci registries logged in for the cluster
+func (c Client) ListRegistries() ([]string, error) {
+	b, err := ioutil.ReadFile(c.settings.RegistryConfig)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var config struct {
+		Auths map[string]interface{} `json:"auths"`
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &config); err != nil {
+			return nil, err
+		}
+	}
+	hosts := make([]string, 0, len(config.Auths))
+	for host := range config.Auths {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// OciChartVersions returns the semver tags of an oci chart, the latest first
+func (c Client) OciChartVersions(ref string) ([]string, error) {
+	if !registry.IsOCI(ref) {
+		return nil, errors.Errorf("%s is not an oci reference", ref)
+	}
+	return c.actionConfig.RegistryClient.Tags(strings.TrimPrefix(ref, fmt.Sprintf("%s://", registry.OCIScheme)))
+}
+
 func updateCharts(repos []*repo.ChartRepository) {
 	fmt.Printf("Hang tight while we grab the latest from your chart repositories...")
 	var wg sync.WaitGroup
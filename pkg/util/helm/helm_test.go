@@ -0,0 +1,111 @@
+package helm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// newRegistryStandIn serves the parts of the oci distribution api used to
+// resolve chart versions and to log in
+func newRegistryStandIn(t *testing.T, tls bool) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/" || r.URL.Path == "/v2":
+			if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "secret" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case strings.HasSuffix(r.URL.Path, "/tags/list"):
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"name": "charts/nginx",
+				"tags": []string{"0.1.0", "0.2.0", "0.10.0", "latest"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	if tls {
+		return httptest.NewTLSServer(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+func newTestClient(t *testing.T) Client {
+	dir := t.TempDir()
+	settings := &cli.EnvSettings{
+		RegistryConfig:   filepath.Join(dir, "registry.json"),
+		RepositoryConfig: filepath.Join(dir, "repositories.yaml"),
+		RepositoryCache:  filepath.Join(dir, "repository"),
+	}
+	registryClient, err := newRegistryClient(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Client{
+		actionConfig: &action.Configuration{RegistryClient: registryClient},
+		settings:     settings,
+	}
+}
+
+func TestOciChartVersions(t *testing.T) {
+	server := newRegistryStandIn(t, false)
+	defer server.Close()
+	c := newTestClient(t)
+	ref := "oci://" + strings.TrimPrefix(server.URL, "http://") + "/charts/nginx"
+	versions, err := c.OciChartVersions(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"0.10.0", "0.2.0", "0.1.0"}; !reflect.DeepEqual(versions, expected) {
+		t.Fatalf("expected %v, got %v", expected, versions)
+	}
+	version, err := registry.GetTagMatchingVersionOrConstraint(versions, "~0.2")
+	if err != nil || version != "0.2.0" {
+		t.Fatalf("expected 0.2.0, got %s %v", version, err)
+	}
+	if _, err := c.OciChartVersions("charts/nginx"); err == nil {
+		t.Fatal("expected an error for a reference without the oci scheme")
+	}
+}
+
+func TestRegistryLogin(t *testing.T) {
+	server := newRegistryStandIn(t, true)
+	defer server.Close()
+	c := newTestClient(t)
+	host := strings.TrimPrefix(server.URL, "https://")
+	if err := c.RegistryLogin(host, "admin", "wrong", true); err == nil {
+		t.Fatal("login with a wrong password succeeded")
+	}
+	if err := c.RegistryLogin(host, "admin", "secret", true); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := c.ListRegistries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hosts, []string{host}) {
+		t.Fatalf("expected %s, got %v", host, hosts)
+	}
+	if err := c.RegistryLogout(host); err != nil {
+		t.Fatal(err)
+	}
+	if hosts, _ := c.ListRegistries(); len(hosts) != 0 {
+		t.Fatalf("expected no registry after logout, got %v", hosts)
+	}
+	if _, err := os.Stat(c.settings.RegistryConfig); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,70 @@
+package helm
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// Releases lists the latest revision of every release in all namespaces
+func (c Client) Releases() ([]*release.Release, error) {
+	client := action.NewList(c.actionConfig)
+	client.AllNamespaces = true
+	client.All = true
+	client.SetStateMask()
+	return client.Run()
+}
+
+// RepoIndex loads the cached index of the repo, it is refreshed when the repo is synced
+func (c Client) RepoIndex(repoName string) (*repo.IndexFile, error) {
+	index, err := repo.LoadIndexFile(filepath.Join(c.settings.RepositoryCache, helmpath.CacheIndexFile(repoName)))
+	if err != nil {
+		return nil, fmt.Errorf("load index of repo %s failed: %v", repoName, err)
+	}
+	return index, nil
+}
+
+// NewerVersion reports whether latest is a higher semantic version than current
+func NewerVersion(current, latest string) bool {
+	c, err := semver.NewVersion(current)
+	if err != nil {
+		return false
+	}
+	l, err := semver.NewVersion(latest)
+	if err != nil {
+		return false
+	}
+	return l.GreaterThan(c)
+}
+
+// LatestVersion returns the highest stable version of the list, or the highest pre-release without one
+func LatestVersion(versions []string) string {
+	var latest, latestPre *semver.Version
+	for _, v := range versions {
+		sv, err := semver.NewVersion(v)
+		if err != nil {
+			continue
+		}
+		if sv.Prerelease() != "" {
+			if latestPre == nil || sv.GreaterThan(latestPre) {
+				latestPre = sv
+			}
+			continue
+		}
+		if latest == nil || sv.GreaterThan(latest) {
+			latest = sv
+		}
+	}
+	switch {
+	case latest != nil:
+		return latest.Original()
+	case latestPre != nil:
+		return latestPre.Original()
+	}
+	return ""
+}
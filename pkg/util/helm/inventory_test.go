@@ -0,0 +1,18 @@
+package helm
+
+import "testing"
+
+func TestLatestVersion(t *testing.T) {
+	if v := LatestVersion([]string{"1.2.0", "v1.10.0", "2.0.0-rc.1", "invalid"}); v != "v1.10.0" {
+		t.Fatalf("expected the highest stable version, got %s", v)
+	}
+	if v := LatestVersion([]string{"2.0.0-rc.1", "2.0.0-beta.2"}); v != "2.0.0-rc.1" {
+		t.Fatalf("expected the highest pre-release, got %s", v)
+	}
+	if v := LatestVersion(nil); v != "" {
+		t.Fatalf("expected no version, got %s", v)
+	}
+	if !NewerVersion("1.2.0", "v1.10.0") || NewerVersion("1.10.0", "1.2.0") || NewerVersion("", "1.0.0") {
+		t.Fatal("unexpected version comparison")
+	}
+}
@@ -0,0 +1,327 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/xeipuuv/gojsonschema"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/resource"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ResourceCreate    = "create"
+	ResourceUpdate    = "update"
+	ResourceDelete    = "delete"
+	ResourceUnchanged = "unchanged"
+)
+
+const previewFieldManager = "kubepi"
+
+// Preview is the rendered result of an install or upgrade which is not applied
+type Preview struct {
+	Upgrade      bool           `json:"upgrade"`
+	Manifest     string         `json:"manifest"`
+	Hooks        string         `json:"hooks"`
+	Notes        string         `json:"notes"`
+	Resources    []ResourceDiff `json:"resources"`
+	SchemaErrors []SchemaError  `json:"schemaErrors"`
+}
+
+// ResourceDiff compares one resource of the current release with the rendered one
+type ResourceDiff struct {
+	ApiVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	Action     string `json:"action"`
+	Diff       string `json:"diff"`
+	// Error is the rejection of the api server in a server dry run
+	Error string `json:"error"`
+}
+
+// SchemaError is a violation of the values.schema.json of a chart or one of its dependencies
+type SchemaError struct {
+	Chart       string `json:"chart"`
+	Field       string `json:"field"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// Preview renders the chart with the values without changing the cluster. An existing
+// release is previewed as an upgrade and diffed against its current manifest.
+// Without serverDryRun a new release is rendered on the client only, with it the
+// resources are also sent to the api server as a dry run apply.
+func (c Client) Preview(name, repoName, chartName, chartVersion string, values map[string]interface{}, serverDryRun bool) (*Preview, error) {
+	// the show action carries the registry client for charts referenced with oci://
+	locator := action.NewShowWithConfig(action.ShowAll, c.actionConfig)
+	if err := c.setChartSource(&locator.ChartPathOptions, repoName, chartName); err != nil {
+		return nil, err
+	}
+	locator.Version = chartVersion
+	p, err := locator.LocateChart(chartName, c.settings)
+	if err != nil {
+		return nil, fmt.Errorf("locate chart %s failed: %v", chartName, err)
+	}
+	ct, err := loader.Load(p)
+	if err != nil {
+		return nil, fmt.Errorf("load chart %s failed: %v", chartName, err)
+	}
+	return c.PreviewChart(name, ct, values, serverDryRun)
+}
+
+// PreviewChart is Preview with a loaded chart, e.g. an uploaded archive
+func (c Client) PreviewChart(name string, ct *chart.Chart, values map[string]interface{}, serverDryRun bool) (*Preview, error) {
+	preview := &Preview{Resources: []ResourceDiff{}, SchemaErrors: []SchemaError{}}
+	schemaErrors, err := ValidateValues(ct, values)
+	if err != nil {
+		return nil, err
+	}
+	if len(schemaErrors) > 0 {
+		preview.SchemaErrors = schemaErrors
+		return preview, nil
+	}
+
+	var currentManifest string
+	current, err := c.GetDetail(name)
+	switch {
+	case err == nil:
+		preview.Upgrade = true
+		currentManifest = current.Manifest
+	case errors.Is(err, driver.ErrReleaseNotFound):
+	default:
+		return nil, err
+	}
+
+	if preview.Upgrade {
+		client := action.NewUpgrade(c.actionConfig)
+		client.Namespace = c.Namespace
+		client.DryRun = true
+		re, err := client.Run(name, ct, values)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("render upgrade of %s failed: %v", name, err))
+		}
+		preview.Manifest = re.Manifest
+		preview.Notes = re.Info.Notes
+		preview.Hooks = hooksManifest(re.Hooks)
+	} else {
+		client := action.NewInstall(c.actionConfig)
+		client.ReleaseName = name
+		client.Namespace = c.Namespace
+		client.DryRun = true
+		client.Replace = true
+		client.ClientOnly = !serverDryRun
+		re, err := client.Run(ct, values)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("render %s failed: %v", name, err))
+		}
+		preview.Manifest = re.Manifest
+		preview.Notes = re.Info.Notes
+		preview.Hooks = hooksManifest(re.Hooks)
+	}
+
+	resources, err := DiffManifests(currentManifest, preview.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	preview.Resources = resources
+	if serverDryRun {
+		if err := c.serverDryRun(preview.Manifest, preview.Resources); err != nil {
+			return nil, err
+		}
+	}
+	return preview, nil
+}
+
+func hooksManifest(hooks []*release.Hook) string {
+	var b strings.Builder
+	for _, h := range hooks {
+		b.WriteString(fmt.Sprintf("---\n# Source: %s\n%s\n", h.Path, h.Manifest))
+	}
+	return b.String()
+}
+
+// serverDryRun applies the manifest with a server side dry run and records
+// the rejections of the api server on the matching resources.
+func (c Client) serverDryRun(manifest string, resources []ResourceDiff) error {
+	infos, err := c.actionConfig.KubeClient.Build(bytes.NewBufferString(manifest), true)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("validate manifest failed: %v", err))
+	}
+	force := true
+	for _, info := range infos {
+		data, err := runtime.Encode(unstructured.UnstructuredJSONScheme, info.Object)
+		if err != nil {
+			return err
+		}
+		helper := resource.NewHelper(info.Client, info.Mapping).DryRun(true).WithFieldManager(previewFieldManager)
+		if _, err := helper.Patch(info.Namespace, info.Name, types.ApplyPatchType, data, &metav1.PatchOptions{Force: &force}); err != nil {
+			kind := info.Mapping.GroupVersionKind.Kind
+			for i := range resources {
+				if resources[i].Kind == kind && resources[i].Name == info.Name &&
+					(resources[i].Namespace == "" || resources[i].Namespace == info.Namespace) {
+					resources[i].Error = err.Error()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateValues checks the values against the values.schema.json of the chart and its
+// dependencies, the chart defaults are merged in first like helm does on install.
+func ValidateValues(ct *chart.Chart, values map[string]interface{}) ([]SchemaError, error) {
+	coalesced, err := chartutil.CoalesceValues(ct, values)
+	if err != nil {
+		return nil, err
+	}
+	result := []SchemaError{}
+	if err := validateSchema(ct, coalesced, "", &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func validateSchema(ct *chart.Chart, values map[string]interface{}, prefix string, result *[]SchemaError) error {
+	if ct.Schema != nil {
+		data, err := yaml.Marshal(values)
+		if err != nil {
+			return err
+		}
+		valuesJson, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(valuesJson, []byte("null")) {
+			valuesJson = []byte("{}")
+		}
+		r, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(ct.Schema), gojsonschema.NewBytesLoader(valuesJson))
+		if err != nil {
+			return fmt.Errorf("invalid values.schema.json of chart %s: %v", ct.Name(), err)
+		}
+		for _, e := range r.Errors() {
+			*result = append(*result, SchemaError{
+				Chart:       ct.Name(),
+				Field:       prefix + e.Field(),
+				Type:        e.Type(),
+				Description: e.Description(),
+			})
+		}
+	}
+	for _, sub := range ct.Dependencies() {
+		subValues, _ := values[sub.Name()].(map[string]interface{})
+		if err := validateSchema(sub, subValues, prefix+sub.Name()+".", result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type manifestHead struct {
+	ApiVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+type manifestResource struct {
+	head    manifestHead
+	content string
+}
+
+func parseManifest(manifest string) (map[string]manifestResource, error) {
+	result := map[string]manifestResource{}
+	for _, content := range releaseutil.SplitManifests(manifest) {
+		var head manifestHead
+		if err := yaml.Unmarshal([]byte(content), &head); err != nil {
+			return nil, err
+		}
+		if head.Kind == "" {
+			continue
+		}
+		key := strings.Join([]string{head.Kind, head.Metadata.Namespace, head.Metadata.Name}, "/")
+		result[key] = manifestResource{head: head, content: strings.TrimSpace(content) + "\n"}
+	}
+	return result, nil
+}
+
+// DiffManifests compares two release manifests resource by resource
+func DiffManifests(current, target string) ([]ResourceDiff, error) {
+	currentResources, err := parseManifest(current)
+	if err != nil {
+		return nil, err
+	}
+	targetResources, err := parseManifest(target)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]struct{}{}
+	for k := range currentResources {
+		keys[k] = struct{}{}
+	}
+	for k := range targetResources {
+		keys[k] = struct{}{}
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	result := []ResourceDiff{}
+	for _, k := range sorted {
+		from, inCurrent := currentResources[k]
+		to, inTarget := targetResources[k]
+		r := from
+		if inTarget {
+			r = to
+		}
+		d := ResourceDiff{
+			ApiVersion: r.head.ApiVersion,
+			Kind:       r.head.Kind,
+			Namespace:  r.head.Metadata.Namespace,
+			Name:       r.head.Metadata.Name,
+		}
+		switch {
+		case !inCurrent:
+			d.Action = ResourceCreate
+		case !inTarget:
+			d.Action = ResourceDelete
+		case from.content == to.content:
+			d.Action = ResourceUnchanged
+		default:
+			d.Action = ResourceUpdate
+		}
+		if d.Action != ResourceUnchanged {
+			d.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(from.content),
+				B:        difflib.SplitLines(to.content),
+				FromFile: "current",
+				ToFile:   "target",
+				Context:  3,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
@@ -0,0 +1,133 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+const currentManifest = `---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+spec:
+  ports:
+  - port: 80
+---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 1
+---
+# Source: app/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+`
+
+const targetManifest = `---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+spec:
+  ports:
+  - port: 80
+---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 3
+---
+# Source: app/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+`
+
+func TestDiffManifests(t *testing.T) {
+	resources, err := DiffManifests(currentManifest, targetManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"ConfigMap":  ResourceDelete,
+		"Deployment": ResourceUpdate,
+		"Secret":     ResourceCreate,
+		"Service":    ResourceUnchanged,
+	}
+	if len(resources) != len(expected) {
+		t.Fatalf("expected %d resources, got %d", len(expected), len(resources))
+	}
+	for i, r := range resources {
+		if i > 0 && resources[i-1].Kind > r.Kind {
+			t.Fatalf("resources are not sorted: %s before %s", resources[i-1].Kind, r.Kind)
+		}
+		if expected[r.Kind] != r.Action {
+			t.Fatalf("expected %s to %s, got %s", r.Kind, expected[r.Kind], r.Action)
+		}
+		if r.Action == ResourceUnchanged && r.Diff != "" {
+			t.Fatalf("unchanged %s has a diff", r.Kind)
+		}
+		if r.Kind == "Deployment" && (!strings.Contains(r.Diff, "-  replicas: 1") || !strings.Contains(r.Diff, "+  replicas: 3")) {
+			t.Fatalf("unexpected diff of the deployment:\n%s", r.Diff)
+		}
+	}
+}
+
+func TestValidateValues(t *testing.T) {
+	schema := []byte(`{
+  "type": "object",
+  "required": ["image"],
+  "properties": {
+    "image": {"type": "string"},
+    "replicas": {"type": "integer", "minimum": 1}
+  }
+}`)
+	sub := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "redis", Version: "0.1.0"},
+		Values:   map[string]interface{}{"port": 6379},
+		Schema:   []byte(`{"type": "object", "properties": {"port": {"type": "integer"}}}`),
+	}
+	ct := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "app", Version: "0.1.0"},
+		Values:   map[string]interface{}{"image": "nginx", "replicas": 1},
+		Schema:   schema,
+	}
+	ct.AddDependency(sub)
+
+	errs, err := ValidateValues(ct, map[string]interface{}{"replicas": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("expected no schema errors, got %v", errs)
+	}
+
+	errs, err = ValidateValues(ct, map[string]interface{}{
+		"replicas": 0,
+		"redis":    map[string]interface{}{"port": "6379"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]string{}
+	for _, e := range errs {
+		fields[e.Field] = e.Chart
+	}
+	if fields["replicas"] != "app" || fields["redis.port"] != "redis" || len(errs) != 2 {
+		t.Fatalf("unexpected schema errors %v", errs)
+	}
+}
@@ -6,6 +6,9 @@ type RepoClient interface {
 	ListRepos(request repos.ProjectRequest) ([]string, error)
 	ListImages(request repos.RepoRequest) (response repos.RepoResponse, err error)
 	ListImagesWithoutPage(repository string) (images []string, err error)
+	GetManifest(request repos.ImageRequest) (*repos.Manifest, error)
+	DeleteTag(request repos.ImageRequest) error
+	Retag(request repos.RetagRequest) error
 }
 
 func NewClient(config repos.Config) RepoClient {
@@ -16,6 +19,8 @@ func NewClient(config repos.Config) RepoClient {
 		return repos.NewHarborClient(config.EndPoint, config.Credential.Username, config.Credential.Password, config.Version)
 	case "DockerRegistry":
 		return repos.NewDockerRegistryClient(config.EndPoint, config.Credential.Username, config.Credential.Password)
+	case "OCI":
+		return repos.NewOCIClient(config.EndPoint, config.Credential.Username, config.Credential.Password)
 	}
 	return nil
 }
@@ -0,0 +1,395 @@
+package repos
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+)
+
+var manifestMediaTypes = []string{mediaTypeManifestList, mediaTypeOCIIndex, mediaTypeManifest, mediaTypeOCIManifest}
+
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Platform  *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		Variant      string `json:"variant"`
+	} `json:"platform,omitempty"`
+}
+
+// manifestBody covers the fields of both image manifests and manifest lists
+type manifestBody struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+type imageConfig struct {
+	Created      time.Time `json:"created"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Variant      string    `json:"variant"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+func isIndex(mediaType string) bool {
+	return mediaType == mediaTypeManifestList || mediaType == mediaTypeOCIIndex
+}
+
+func isDigest(ref string) bool {
+	return strings.Contains(ref, ":")
+}
+
+func platformName(os, arch, variant string) string {
+	name := os + "/" + arch
+	if variant != "" {
+		name += "/" + variant
+	}
+	return name
+}
+
+// distributionClient talks the docker registry v2 api, base is the url the /v2/ path is served under
+type distributionClient struct {
+	base   string
+	client *http.Client
+}
+
+func newDistributionClient(base, username, password string) *distributionClient {
+	base = strings.TrimSuffix(base, "/")
+	return &distributionClient{
+		base: base,
+		client: &http.Client{
+			Timeout: 5 * time.Minute,
+			Transport: NewTokenTransport(&http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true, //nolint:gosec
+				},
+			}, username, password),
+		},
+	}
+}
+
+func (d *distributionClient) url(name, kind, ref string) string {
+	return fmt.Sprintf("%s/v2/%s/%s/%s", d.base, name, kind, ref)
+}
+
+func (d *distributionClient) do(req *http.Request, expected ...int) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	return nil, &StatusError{
+		Code:    resp.StatusCode,
+		Message: fmt.Sprintf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body))),
+	}
+}
+
+// StatusError is an unexpected response of the registry
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// nextLink returns the url of the next page from the Link header, empty on the last page
+func nextLink(resp *http.Response) string {
+	for _, link := range strings.Split(resp.Header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 || !strings.Contains(strings.ReplaceAll(parts[1], " ", ""), `rel="next"`) {
+			continue
+		}
+		ref, err := url.Parse(strings.Trim(strings.TrimSpace(parts[0]), "<>"))
+		if err != nil {
+			return ""
+		}
+		return resp.Request.URL.ResolveReference(ref).String()
+	}
+	return ""
+}
+
+// list collects a paginated list of the api, field is the json field of the items
+func (d *distributionClient) list(u, field string) ([]string, error) {
+	items := make([]string, 0)
+	for u != "" {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := d.do(req, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		var page map[string][]string
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page[field]...)
+		u = nextLink(resp)
+	}
+	return items, nil
+}
+
+func (d *distributionClient) catalog() ([]string, error) {
+	return d.list(fmt.Sprintf("%s/v2/_catalog?n=%d", d.base, LIMIT), "repositories")
+}
+
+func (d *distributionClient) tags(name string) ([]string, error) {
+	return d.list(fmt.Sprintf("%s/v2/%s/tags/list?n=%d", d.base, name, LIMIT), "tags")
+}
+
+// manifest fetches the raw manifest with its media type and digest
+func (d *distributionClient) manifest(name, ref string) ([]byte, string, string, error) {
+	req, err := http.NewRequest(http.MethodGet, d.url(name, "manifests", ref), nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	resp, err := d.do(req, http.StatusOK)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+	mediaType := strings.Split(resp.Header.Get("Content-Type"), ";")[0]
+	var m manifestBody
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, "", "", fmt.Errorf("invalid manifest of %s:%s: %v", name, ref, err)
+	}
+	if m.MediaType != "" {
+		mediaType = m.MediaType
+	}
+	if !isIndex(mediaType) && mediaType != mediaTypeManifest && mediaType != mediaTypeOCIManifest {
+		return nil, "", "", fmt.Errorf("unsupported manifest type %s of %s:%s", mediaType, name, ref)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+	return body, mediaType, digest, nil
+}
+
+func (d *distributionClient) blob(name, digest string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, d.url(name, "blobs", digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// inspect reads the manifest of a tag, a multi-arch image is described by its first platform
+func (d *distributionClient) inspect(name, tag string) (*Manifest, error) {
+	body, mediaType, digest, err := d.manifest(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	var m manifestBody
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	var architectures []string
+	if isIndex(mediaType) {
+		if len(m.Manifests) == 0 {
+			return nil, fmt.Errorf("manifest list of %s:%s is empty", name, tag)
+		}
+		for _, child := range m.Manifests {
+			if child.Platform != nil {
+				architectures = append(architectures, platformName(child.Platform.OS, child.Platform.Architecture, child.Platform.Variant))
+			}
+		}
+		body, _, _, err = d.manifest(name, m.Manifests[0].Digest)
+		if err != nil {
+			return nil, err
+		}
+		m = manifestBody{}
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, err
+		}
+	}
+	var config []byte
+	if m.Config.Digest != "" {
+		if config, err = d.blob(name, m.Config.Digest); err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := parseManifest(m, config)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Image = name
+	manifest.Tag = tag
+	manifest.Digest = digest
+	manifest.MediaType = mediaType
+	if architectures != nil {
+		manifest.Architectures = architectures
+	}
+	return manifest, nil
+}
+
+// parseManifest describes an image manifest with the image config it references
+func parseManifest(m manifestBody, config []byte) (*Manifest, error) {
+	manifest := &Manifest{
+		MediaType:     m.MediaType,
+		Size:          m.Config.Size,
+		Labels:        map[string]string{},
+		Layers:        []Layer{},
+		Architectures: []string{},
+	}
+	for _, l := range m.Layers {
+		manifest.Size += l.Size
+		manifest.Layers = append(manifest.Layers, Layer{Digest: l.Digest, MediaType: l.MediaType, Size: l.Size})
+	}
+	if len(config) > 0 {
+		var c imageConfig
+		if err := json.Unmarshal(config, &c); err != nil {
+			return nil, fmt.Errorf("invalid image config: %v", err)
+		}
+		manifest.Created = c.Created
+		if c.Config.Labels != nil {
+			manifest.Labels = c.Config.Labels
+		}
+		if c.Architecture != "" {
+			manifest.Architectures = []string{platformName(c.OS, c.Architecture, c.Variant)}
+		}
+	}
+	return manifest, nil
+}
+
+// deleteManifest deletes the manifest a tag points to, which removes every tag of the same digest
+func (d *distributionClient) deleteManifest(name, ref string) error {
+	if !isDigest(ref) {
+		_, _, digest, err := d.manifest(name, ref)
+		if err != nil {
+			return err
+		}
+		ref = digest
+	}
+	req, err := http.NewRequest(http.MethodDelete, d.url(name, "manifests", ref), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(req, http.StatusAccepted, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// copy tags the manifest again as target:targetRef, the blobs are mounted into the target image first
+func (d *distributionClient) copy(name, ref, target, targetRef string) error {
+	body, mediaType, _, err := d.manifest(name, ref)
+	if err != nil {
+		return err
+	}
+	if target != name {
+		var m manifestBody
+		if err := json.Unmarshal(body, &m); err != nil {
+			return err
+		}
+		if isIndex(mediaType) {
+			for _, child := range m.Manifests {
+				if err := d.copy(name, child.Digest, target, child.Digest); err != nil {
+					return err
+				}
+			}
+		} else {
+			blobs := append([]descriptor{m.Config}, m.Layers...)
+			for _, b := range blobs {
+				if err := d.mountBlob(name, target, b.Digest); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	req, err := http.NewRequest(http.MethodPut, d.url(target, "manifests", targetRef), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := d.do(req, http.StatusCreated, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// mountBlob links a blob of another image into target, it is uploaded when the registry does not mount it
+func (d *distributionClient) mountBlob(from, target, digest string) error {
+	u := fmt.Sprintf("%s/v2/%s/blobs/uploads/?mount=%s&from=%s", d.base, target, url.QueryEscape(digest), url.QueryEscape(from))
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(req, http.StatusCreated, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		return nil
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return err
+	}
+	data, err := d.blob(from, digest)
+	if err != nil {
+		return err
+	}
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+	req, err = http.NewRequest(http.MethodPut, location.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = d.do(req, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
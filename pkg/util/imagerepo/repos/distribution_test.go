@@ -0,0 +1,132 @@
+package repos
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRegistry keeps manifests by image and reference and blobs by image and digest, mounts are refused
+type fakeRegistry struct {
+	lock      sync.Mutex
+	manifests map[string][]byte
+	types     map[string]string
+	blobs     map[string][]byte
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func (f *fakeRegistry) putManifest(name, ref, mediaType string, data []byte) {
+	for _, r := range []string{ref, digestOf(data)} {
+		f.manifests[name+"@"+r] = data
+		f.types[name+"@"+r] = mediaType
+	}
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		key := path[:i] + "@" + path[i+len("/manifests/"):]
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := f.manifests[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", f.types[key])
+			w.Header().Set("Docker-Content-Digest", digestOf(data))
+			_, _ = w.Write(data)
+		case http.MethodPut:
+			data, _ := ioutil.ReadAll(r.Body)
+			f.putManifest(path[:i], path[i+len("/manifests/"):], r.Header.Get("Content-Type"), data)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			for k, v := range f.manifests {
+				if strings.HasPrefix(k, path[:i]+"@") && digestOf(v) == path[i+len("/manifests/"):] {
+					delete(f.manifests, k)
+				}
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+	case strings.HasSuffix(path, "/blobs/uploads/"):
+		w.Header().Set("Location", "/v2/"+path+"session")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasSuffix(path, "/blobs/uploads/session"):
+		data, _ := ioutil.ReadAll(r.Body)
+		name := strings.TrimSuffix(path, "/blobs/uploads/session")
+		f.blobs[name+"@"+r.URL.Query().Get("digest")] = data
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		i := strings.LastIndex(path, "/blobs/")
+		data, ok := f.blobs[path[:i]+"@"+path[i+len("/blobs/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}
+}
+
+func TestDistributionClient(t *testing.T) {
+	f := &fakeRegistry{manifests: map[string][]byte{}, types: map[string]string{}, blobs: map[string][]byte{}}
+	config := []byte(`{"created":"2022-01-02T03:04:05Z","architecture":"amd64","os":"linux","config":{"Labels":{"team":"a"}}}`)
+	layer := []byte("layer")
+	f.blobs["app@"+digestOf(config)] = config
+	f.blobs["app@"+digestOf(layer)] = layer
+	image, _ := json.Marshal(manifestBody{
+		MediaType: mediaTypeManifest,
+		Config:    descriptor{MediaType: "application/vnd.docker.container.image.v1+json", Digest: digestOf(config), Size: int64(len(config))},
+		Layers:    []descriptor{{MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", Digest: digestOf(layer), Size: int64(len(layer))}},
+	})
+	f.putManifest("app", digestOf(image), mediaTypeManifest, image)
+	index := []byte(fmt.Sprintf(`{"mediaType":%q,"manifests":[{"mediaType":%q,"digest":%q,"platform":{"architecture":"amd64","os":"linux"}},{"mediaType":%q,"digest":"sha256:00","platform":{"architecture":"arm64","os":"linux","variant":"v8"}}]}`,
+		mediaTypeManifestList, mediaTypeManifest, digestOf(image), mediaTypeManifest))
+	f.putManifest("app", "1.0", mediaTypeManifestList, index)
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	d := newDistributionClient(server.URL, "", "")
+	m, err := d.inspect("app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Digest != digestOf(index) || m.MediaType != mediaTypeManifestList || m.Size != int64(len(config)+len(layer)) {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	if len(m.Architectures) != 2 || m.Architectures[1] != "linux/arm64/v8" || m.Labels["team"] != "a" || len(m.Layers) != 1 || m.Created.Year() != 2022 {
+		t.Fatalf("unexpected manifest details %+v", m)
+	}
+
+	// the arm64 manifest is missing, so copy the amd64 image into another repository
+	if err := d.copy("app", digestOf(image), "mirror/app", "stable"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.blobs["mirror/app@"+digestOf(layer)]; !ok {
+		t.Fatal("expected the layer to be uploaded to the target")
+	}
+	if m, err := d.inspect("mirror/app", "stable"); err != nil || m.Digest != digestOf(image) {
+		t.Fatalf("unexpected copied manifest %+v: %v", m, err)
+	}
+	if err := d.copy("app", "1.0", "app", "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.deleteManifest("app", "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.inspect("app", "1.0"); err == nil {
+		t.Fatal("expected the tags of the deleted digest to be gone")
+	}
+}
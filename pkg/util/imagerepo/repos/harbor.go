@@ -3,6 +3,8 @@ package repos
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -277,3 +279,117 @@ func getProjectUrl(version string) string {
 		return v2Url + projectUrl
 	}
 }
+
+// splitImage splits an image into the harbor project and the repository name in it
+func splitImage(image string) (string, string, error) {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("image %s is not in a project", image)
+	}
+	return parts[0], parts[1], nil
+}
+
+// artifactsUrl is the v2 api path of the artifacts of an image, the repository name is encoded twice
+func artifactsUrl(image string) (string, error) {
+	project, repoName, err := splitImage(image)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%s/%s%s", getProjectUrl("v2"), project, repositoryUrl, url.PathEscape(url.PathEscape(repoName)), artifactUrl), nil
+}
+
+func (c *harborClient) GetManifest(request ImageRequest) (*Manifest, error) {
+	if c.Version == "v2" {
+		return newDistributionClient(c.EndPoint, c.HttpClient.Username, c.HttpClient.Password).inspect(request.Image, request.Tag)
+	}
+	tagPath := fmt.Sprintf("%s/%s/%s/%s", v1Url, repositoryUrl, request.Image, tagUrl) + "/" + url.PathEscape(request.Tag)
+	body, _, err := c.HttpClient.Get(tagPath + "/manifest?version=v2")
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Manifest manifestBody `json:"manifest"`
+		Config   string       `json:"config"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	manifest, err := parseManifest(result.Manifest, []byte(result.Config))
+	if err != nil {
+		return nil, err
+	}
+	body, _, err = c.HttpClient.Get(tagPath)
+	if err != nil {
+		return nil, err
+	}
+	var detail struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.Unmarshal(body, &detail); err != nil {
+		return nil, err
+	}
+	manifest.Image = request.Image
+	manifest.Tag = request.Tag
+	manifest.Digest = detail.Digest
+	if manifest.MediaType == "" {
+		manifest.MediaType = mediaTypeManifest
+	}
+	return manifest, nil
+}
+
+// DeleteTag removes only the tag, the artifact stays when it has other tags
+func (c *harborClient) DeleteTag(request ImageRequest) error {
+	if c.Version == "v2" {
+		artifacts, err := artifactsUrl(request.Image)
+		if err != nil {
+			return err
+		}
+		tag := url.PathEscape(request.Tag)
+		_, _, err = c.HttpClient.Delete(fmt.Sprintf("%s/%s/%s/%s", artifacts, tag, tagUrl, tag))
+		return err
+	}
+	_, _, err := c.HttpClient.Delete(fmt.Sprintf("%s/%s/%s/%s/%s", v1Url, repositoryUrl, request.Image, tagUrl, url.PathEscape(request.Tag)))
+	return err
+}
+
+// Retag adds the target tag to the artifact, it is copied first when the target is another repository
+func (c *harborClient) Retag(request RetagRequest) error {
+	if c.Version != "v2" {
+		_, _, err := c.HttpClient.Post(fmt.Sprintf("%s/%s/%s/%s", v1Url, repositoryUrl, request.TargetImage, tagUrl), map[string]interface{}{
+			"tag":       request.TargetTag,
+			"src_image": request.Image + ":" + request.Tag,
+			"override":  true,
+		})
+		return err
+	}
+	source, err := artifactsUrl(request.Image)
+	if err != nil {
+		return err
+	}
+	body, _, err := c.HttpClient.Get(fmt.Sprintf("%s/%s", source, url.PathEscape(request.Tag)))
+	if err != nil {
+		return err
+	}
+	var artifact struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.Unmarshal(body, &artifact); err != nil {
+		return err
+	}
+	target, err := artifactsUrl(request.TargetImage)
+	if err != nil {
+		return err
+	}
+	if target != source {
+		from := url.QueryEscape(request.Image + "@" + artifact.Digest)
+		if _, _, err := c.HttpClient.Post(fmt.Sprintf("%s?from=%s", target, from), nil); err != nil {
+			return err
+		}
+	}
+	_, res, err := c.HttpClient.Post(fmt.Sprintf("%s/%s/%s", target, artifact.Digest, tagUrl), map[string]string{"name": request.TargetTag})
+	if res != nil && res.StatusCode == http.StatusConflict {
+		// the artifact already has the tag, e.g. a copy keeps the tags of the source
+		return nil
+	}
+	return err
+}
@@ -1,10 +1,12 @@
 package repos
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -24,11 +26,18 @@ type NameResult struct {
 }
 
 func (h *HttpClient) NewRequest(method, endpoint string) (request *http.Request, err error) {
+	return h.NewRequestWithBody(method, endpoint, nil)
+}
+
+func (h *HttpClient) NewRequestWithBody(method, endpoint string, body io.Reader) (request *http.Request, err error) {
 	url := fmt.Sprintf("%s/%s", h.Host, endpoint)
-	request, err = http.NewRequest(method, url, nil)
+	request, err = http.NewRequest(method, url, body)
 	if err != nil {
 		return
 	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
 	if h.Username != "" && h.Password != "" {
 		request.SetBasicAuth(h.Username, h.Password)
 	}
@@ -46,7 +55,7 @@ func (h *HttpClient) Do(request *http.Request) (body []byte, resp *http.Response
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
 		body, err = ioutil.ReadAll(resp.Body)
 		return
 	}
@@ -68,6 +77,26 @@ func (h *HttpClient) Get(endpoint string) ([]byte, *http.Response, error) {
 	return h.http(http.MethodGet, endpoint)
 }
 
+func (h *HttpClient) Delete(endpoint string) ([]byte, *http.Response, error) {
+	return h.http(http.MethodDelete, endpoint)
+}
+
+// Post sends data as json, a nil data posts an empty body
+func (h *HttpClient) Post(endpoint string, data interface{}) ([]byte, *http.Response, error) {
+	if data == nil {
+		return h.http(http.MethodPost, endpoint)
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	request, err := h.NewRequestWithBody(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.Do(request)
+}
+
 func (h *HttpClient) GetNameResult(url string) ([]NameResult, error) {
 	body, _, err := h.Get(url)
 	if err != nil {
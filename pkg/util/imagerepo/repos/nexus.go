@@ -3,12 +3,16 @@ package repos
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strings"
 )
 
 const (
-	BaseUrl      = "service/rest"
-	RepoUrl      = BaseUrl + "/v1/repositories"
-	ComponentUrl = BaseUrl + "/v1/components?repository="
+	BaseUrl       = "service/rest"
+	RepoUrl       = BaseUrl + "/v1/repositories"
+	ComponentUrl  = BaseUrl + "/v1/components?repository="
+	ComponentsUrl = BaseUrl + "/v1/components"
+	SearchUrl     = BaseUrl + "/v1/search"
 )
 
 func NewNexusClient(endpoint, username, password string) *nexusClient {
@@ -107,3 +111,42 @@ func (c *nexusClient) ListImagesWithoutPage(repository string) (images []string,
 	}
 	return
 }
+
+// distribution serves the docker api of the repository under its path, /repository/<name>/v2/
+func (c *nexusClient) distribution(repository string) *distributionClient {
+	return newDistributionClient(fmt.Sprintf("%s/repository/%s", strings.TrimSuffix(c.EndPoint, "/"), repository), c.Username, c.Password)
+}
+
+func (c *nexusClient) GetManifest(request ImageRequest) (*Manifest, error) {
+	return c.distribution(request.Repo).inspect(request.Image, request.Tag)
+}
+
+// DeleteTag deletes the component of the tag with the nexus api
+func (c *nexusClient) DeleteTag(request ImageRequest) error {
+	body, _, err := c.HttpClient.Get(fmt.Sprintf("%s?repository=%s&format=docker&name=%s&version=%s", SearchUrl,
+		url.QueryEscape(request.Repo), url.QueryEscape(request.Image), url.QueryEscape(request.Tag)))
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Items []struct {
+			ID string `json:"id"`
+		}
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+	if len(result.Items) == 0 {
+		return fmt.Errorf("image %s:%s not found in repository %s", request.Image, request.Tag, request.Repo)
+	}
+	for _, item := range result.Items {
+		if _, _, err := c.HttpClient.Delete(fmt.Sprintf("%s/%s", ComponentsUrl, item.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *nexusClient) Retag(request RetagRequest) error {
+	return c.distribution(request.Repo).copy(request.Image, request.Tag, request.TargetImage, request.TargetTag)
+}
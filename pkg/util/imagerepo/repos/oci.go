@@ -0,0 +1,116 @@
+package repos
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// NewOCIClient is a client of any registry implementing the distribution spec, e.g. GitLab, Quay or GHCR.
+// The repo name of such a registry is a namespace, or a comma separated list of repositories for
+// registries which do not serve the catalog.
+func NewOCIClient(endpoint, username, password string) *ociClient {
+	return &ociClient{
+		dockerRegistryClient: dockerRegistryClient{
+			Username: username,
+			Password: password,
+			EndPoint: endpoint,
+		},
+	}
+}
+
+type ociClient struct {
+	dockerRegistryClient
+}
+
+// catalogUnsupported reports whether the registry refused to list its repositories
+func catalogUnsupported(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	switch statusErr.Code {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusMethodNotAllowed:
+		return true
+	}
+	return false
+}
+
+// ListRepos lists the namespaces of the catalog, a registry without catalog has none to offer
+func (c *ociClient) ListRepos(request ProjectRequest) (names []string, err error) {
+	repositories, err := c.distribution().catalog()
+	if err != nil {
+		if catalogUnsupported(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	namespaces := map[string]bool{}
+	for _, r := range repositories {
+		namespace := strings.SplitN(r, "/", 2)[0]
+		if request.Name == "" || strings.Contains(namespace, request.Name) {
+			namespaces[namespace] = true
+		}
+	}
+	for namespace := range namespaces {
+		names = append(names, namespace)
+	}
+	sort.Strings(names)
+	return
+}
+
+// repositories returns the repositories of the namespace from the catalog, or the repositories named by repoName
+func (c *ociClient) repositories(d *distributionClient, repoName string) ([]string, error) {
+	var named []string
+	for _, r := range strings.Split(repoName, ",") {
+		if r = strings.Trim(strings.TrimSpace(r), "/"); r != "" {
+			named = append(named, r)
+		}
+	}
+	catalog, err := d.catalog()
+	if err != nil {
+		if catalogUnsupported(err) && len(named) > 0 {
+			return named, nil
+		}
+		return nil, err
+	}
+	if len(named) == 0 {
+		return catalog, nil
+	}
+	var repositories []string
+	for _, r := range catalog {
+		for _, n := range named {
+			if r == n || strings.HasPrefix(r, n+"/") {
+				repositories = append(repositories, r)
+				break
+			}
+		}
+	}
+	if len(repositories) == 0 {
+		return named, nil
+	}
+	return repositories, nil
+}
+
+func (c *ociClient) ListImages(request RepoRequest) (response RepoResponse, err error) {
+	d := c.distribution()
+	repositories, err := c.repositories(d, request.Repo)
+	if err != nil {
+		return
+	}
+	items, err := listImageTags(d, repositories)
+	if err != nil {
+		return
+	}
+	return pageImages(items, request), nil
+}
+
+func (c *ociClient) ListImagesWithoutPage(repository string) (images []string, err error) {
+	d := c.distribution()
+	repositories, err := c.repositories(d, repository)
+	if err != nil {
+		return
+	}
+	return listImageTags(d, repositories)
+}
@@ -1,5 +1,7 @@
 package repos
 
+import "time"
+
 type Config struct {
 	Type     string
 	EndPoint string
@@ -33,3 +35,38 @@ type RepoResponse struct {
 	Total         int      `json:"total"`
 	ContinueToken string   `json:"continueToken"`
 }
+
+// ImageRequest names a tag of an image, Repo is the project of Harbor or the repository of Nexus
+type ImageRequest struct {
+	Repo  string `json:"repo"`
+	Image string `json:"image"`
+	Tag   string `json:"tag"`
+}
+
+// RetagRequest tags an image again, the target may be another image of the same registry
+type RetagRequest struct {
+	Repo        string `json:"repo"`
+	Image       string `json:"image"`
+	Tag         string `json:"tag"`
+	TargetImage string `json:"targetImage"`
+	TargetTag   string `json:"targetTag"`
+}
+
+type Manifest struct {
+	Image     string `json:"image"`
+	Tag       string `json:"tag"`
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	// Size is the size of the config and layers, of the first platform for a multi-arch image
+	Size          int64             `json:"size"`
+	Created       time.Time         `json:"created"`
+	Architectures []string          `json:"architectures"`
+	Labels        map[string]string `json:"labels"`
+	Layers        []Layer           `json:"layers"`
+}
+
+type Layer struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+}
@@ -0,0 +1,238 @@
+package repos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenTransport answers the WWW-Authenticate challenge of a registry, with basic auth or with
+// a bearer token fetched from the realm of the challenge. Tokens are cached by host and scope,
+// so only the first request of a scope is sent twice.
+type TokenTransport struct {
+	Transport http.RoundTripper
+	Username  string
+	Password  string
+
+	lock   sync.Mutex
+	tokens map[string]cachedToken
+	basic  map[string]bool
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+type tokenResponse struct {
+	Token       string    `json:"token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int       `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+func NewTokenTransport(transport http.RoundTripper, username, password string) *TokenTransport {
+	return &TokenTransport{
+		Transport: transport,
+		Username:  username,
+		Password:  password,
+		tokens:    map[string]cachedToken{},
+		basic:     map[string]bool{},
+	}
+}
+
+// requestScopes are the scopes a request of the distribution api needs, they are used when the challenge names none
+func requestScopes(req *http.Request) []string {
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	if i := strings.Index(path, "v2/"); i >= 0 {
+		path = path[i+len("v2/"):]
+	}
+	if path == "_catalog" {
+		return []string{"registry:catalog:*"}
+	}
+	name := ""
+	for _, kind := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.LastIndex(path, kind); i > 0 {
+			name = path[:i]
+			break
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	action := "pull"
+	switch req.Method {
+	case http.MethodPut, http.MethodPost, http.MethodPatch:
+		action = "pull,push"
+	case http.MethodDelete:
+		action = "delete"
+	}
+	scopes := []string{fmt.Sprintf("repository:%s:%s", name, action)}
+	if from := req.URL.Query().Get("from"); from != "" {
+		scopes = append(scopes, fmt.Sprintf("repository:%s:pull", from))
+	}
+	return scopes
+}
+
+// parseChallenge parses a WWW-Authenticate header into its scheme and parameters
+func parseChallenge(header string) (string, map[string]string) {
+	header = strings.TrimSpace(header)
+	i := strings.Index(header, " ")
+	if i < 0 {
+		return strings.ToLower(header), map[string]string{}
+	}
+	scheme := strings.ToLower(header[:i])
+	params := map[string]string{}
+	rest := header[i+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if end := strings.Index(rest, ","); end >= 0 {
+			value, rest = rest[:end], rest[end:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
+
+func (t *TokenTransport) cacheKey(host string, scopes []string) string {
+	return host + " " + strings.Join(scopes, " ")
+}
+
+func (t *TokenTransport) authorize(req *http.Request, key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if token, ok := t.tokens[key]; ok && time.Now().Before(token.expires) {
+		req.Header.Set("Authorization", "Bearer "+token.token)
+		return
+	}
+	if t.basic[req.URL.Host] {
+		req.SetBasicAuth(t.Username, t.Password)
+	}
+}
+
+func (t *TokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	scopes := requestScopes(req)
+	key := t.cacheKey(req.URL.Host, scopes)
+	first := req.Clone(req.Context())
+	t.authorize(first, key)
+	resp, err := t.Transport.RoundTrip(first)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	retry := req.Clone(req.Context())
+	switch scheme {
+	case "basic":
+		if t.Username == "" && t.Password == "" {
+			return resp, nil
+		}
+		t.lock.Lock()
+		t.basic[req.URL.Host] = true
+		t.lock.Unlock()
+		retry.SetBasicAuth(t.Username, t.Password)
+	case "bearer":
+		if scope := params["scope"]; scope != "" {
+			scopes = strings.Split(scope, " ")
+		}
+		token, err := t.fetchToken(params["realm"], params["service"], scopes)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		t.lock.Lock()
+		t.tokens[key] = *token
+		t.lock.Unlock()
+		retry.Header.Set("Authorization", "Bearer "+token.token)
+	default:
+		return resp, nil
+	}
+	resp.Body.Close()
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.Transport.RoundTrip(retry)
+}
+
+func (t *TokenTransport) fetchToken(realm, service string, scopes []string) (*cachedToken, error) {
+	if realm == "" {
+		return nil, fmt.Errorf("bearer challenge without realm")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	if service != "" {
+		q.Set("service", service)
+	}
+	for _, scope := range scopes {
+		q.Add("scope", scope)
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if t.Username != "" || t.Password != "" {
+		req.SetBasicAuth(t.Username, t.Password)
+	}
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get token from %s: %s", u.Host, resp.Status)
+	}
+	var result tokenResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("invalid token response: %v", err)
+	}
+	token := result.Token
+	if token == "" {
+		token = result.AccessToken
+	}
+	if token == "" {
+		return nil, fmt.Errorf("token response of %s has no token", u.Host)
+	}
+	expiresIn := result.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = 60
+	}
+	issued := result.IssuedAt
+	if issued.IsZero() {
+		issued = time.Now()
+	}
+	// renew a little early so a token does not expire in flight
+	return &cachedToken{token: token, expires: issued.Add(time.Duration(expiresIn)*time.Second - 10*time.Second)}, nil
+}
@@ -0,0 +1,104 @@
+package repos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull,push"`)
+	if scheme != "bearer" || params["realm"] != "https://auth.example.com/token" || params["service"] != "registry.example.com" || params["scope"] != "repository:a/b:pull,push" {
+		t.Fatalf("unexpected challenge %s %v", scheme, params)
+	}
+	if scheme, params := parseChallenge(`Basic realm=Registry`); scheme != "basic" || params["realm"] != "Registry" {
+		t.Fatalf("unexpected challenge %s %v", scheme, params)
+	}
+}
+
+// tokenRegistry serves a paginated catalog and tags list which need a bearer token of the token server
+func tokenRegistry(t *testing.T) (*httptest.Server, *int32) {
+	var tokenRequests int32
+	auth := http.NewServeMux()
+	auth.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("service") != "registry" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"token": "token " + strings.Join(r.URL.Query()["scope"], " "), "expires_in": 300})
+	})
+	authServer := httptest.NewServer(auth)
+	t.Cleanup(authServer.Close)
+
+	repositories := []string{"team/api", "team/web", "tools/cli"}
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := "registry:catalog:*"
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			scope = fmt.Sprintf("repository:%s:pull", strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v2/"), "/tags/list"))
+		}
+		if r.Header.Get("Authorization") != "Bearer token "+scope {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="%s"`, authServer.URL, scope))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/v2/_catalog" {
+			// two repositories a page, the next page starts after the last one
+			start := 0
+			for i, name := range repositories {
+				if name == r.URL.Query().Get("last") {
+					start = i + 1
+				}
+			}
+			end := start + 2
+			if end < len(repositories) {
+				w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?last=%s&n=2>; rel="next"`, repositories[end-1]))
+			} else {
+				end = len(repositories)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"repositories": repositories[start:end]})
+			return
+		}
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s?last=1.0&n=1>; rel="next"`, r.URL.Path))
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"tags": []string{"1.0"}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"tags": []string{"2.0"}})
+	}))
+	t.Cleanup(registry.Close)
+	return registry, &tokenRequests
+}
+
+func TestTokenTransport(t *testing.T) {
+	registry, tokenRequests := tokenRegistry(t)
+
+	c := NewOCIClient(registry.URL, "admin", "secret")
+	images, err := c.ListImagesWithoutPage("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(images, " ") != "team/api:1.0 team/api:2.0 team/web:1.0 team/web:2.0" {
+		t.Fatalf("unexpected images %v", images)
+	}
+	// one token for the catalog and one per repository, the pages reuse the cached tokens
+	if n := atomic.LoadInt32(tokenRequests); n != 3 {
+		t.Fatalf("expected 3 token requests, got %d", n)
+	}
+	names, err := c.ListRepos(ProjectRequest{})
+	if err != nil || strings.Join(names, " ") != "team tools" {
+		t.Fatalf("unexpected namespaces %v: %v", names, err)
+	}
+
+	if _, err := NewOCIClient(registry.URL, "admin", "wrong").ListImagesWithoutPage(""); err == nil {
+		t.Fatal("expected an error with wrong credentials")
+	}
+}
@@ -14,6 +14,10 @@ type Ldap struct {
 	Password string `json:"password"`
 	Conn     *ldap.Conn
 	TLS      bool `json:"tls"`
+	// StartTLS upgrades a plain connection with the StartTLS extended operation
+	StartTLS bool `json:"startTLS"`
+	// Servers are additional "host:port" addresses tried in order when the primary one is unreachable
+	Servers []string `json:"servers"`
 }
 
 func NewLdapClient(address, port, username, password string, tls bool) *Ldap {
@@ -28,23 +32,60 @@ func NewLdapClient(address, port, username, password string, tls bool) *Ldap {
 
 func (l *Ldap) Connect() error {
 	var err error
-	if l.TLS {
-		l.Conn, err = ldap.DialTLS("tcp", fmt.Sprintf("%s:%s", l.Address, l.Port), &tls.Config{
-			InsecureSkipVerify: true,
-		})
-	} else {
-		l.Conn, err = ldap.Dial("tcp", fmt.Sprintf("%s:%s", l.Address, l.Port))
+	for _, addr := range l.addresses() {
+		if err = l.dial(addr); err == nil {
+			break
+		}
 	}
-
 	if err != nil {
 		return err
 	}
 	if err := l.Conn.Bind(l.Username, l.Password); err != nil {
+		l.Conn.Close()
 		return err
 	}
 	return nil
 }
 
+func (l *Ldap) addresses() []string {
+	addresses := []string{fmt.Sprintf("%s:%s", l.Address, l.Port)}
+	for _, s := range l.Servers {
+		if s != "" {
+			addresses = append(addresses, s)
+		}
+	}
+	return addresses
+}
+
+func (l *Ldap) dial(addr string) error {
+	var err error
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	if l.TLS {
+		l.Conn, err = ldap.DialTLS("tcp", addr, tlsConfig)
+		return err
+	}
+	l.Conn, err = ldap.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if l.StartTLS {
+		if err := l.Conn.StartTLS(tlsConfig); err != nil {
+			l.Conn.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying connection, it is safe to call on a closed client
+func (l *Ldap) Close() {
+	if l.Conn != nil {
+		l.Conn.Close()
+	}
+}
+
 func (l *Ldap) Search(dn, filter string, sizeLimit, timeLimit int, attributes []string) ([]*ldap.Entry, error) {
 	searchRequest := ldap.NewSearchRequest(dn,
 		ldap.ScopeWholeSubtree, ldap.DerefAlways, 0, timeLimit, false,
@@ -84,3 +125,18 @@ func (l *Ldap) Login(dn, filter, password string, sizeLimit, timeLimit int) erro
 
 	return nil
 }
+
+// SearchEntries searches the whole subtree and, unlike Search, does not treat an
+// empty result as an error nor close the connection.
+func (l *Ldap) SearchEntries(dn, filter string, sizeLimit, timeLimit int, attributes []string) ([]*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(dn,
+		ldap.ScopeWholeSubtree, ldap.DerefAlways, 0, timeLimit, false,
+		filter,
+		attributes,
+		nil)
+	sr, err := l.Conn.SearchWithPaging(searchRequest, uint32(sizeLimit))
+	if err != nil {
+		return nil, err
+	}
+	return sr.Entries, nil
+}
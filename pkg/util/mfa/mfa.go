@@ -2,15 +2,27 @@ package mfa
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base32"
 	"encoding/base64"
+	"strings"
+	"time"
+
 	"github.com/skip2/go-qrcode"
 	"github.com/xlzd/gotp"
-	"strconv"
-	"time"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const secretLength = 16
 
+// totpInterval is the step used by gotp.NewDefaultTOTP.
+const totpInterval = 30
+
+const (
+	DefaultRecoveryCodeCount = 10
+	recoveryCodeLength       = 10
+)
+
 type Otp struct {
 	Secret  string `json:"secret"`
 	QrImage string `json:"qrImage"`
@@ -30,10 +42,58 @@ func GetOtp(username string) (otp Otp, err error) {
 	return
 }
 
-func ValidCode(code string, secret string) bool {
+// ValidCode verifies a TOTP code, accepting codes from up to skew intervals
+// before or after the current one to tolerate clock drift.
+func ValidCode(code string, secret string, skew int) bool {
+	if code == "" || secret == "" {
+		return false
+	}
+	if skew < 0 {
+		skew = 0
+	}
 	totp := gotp.NewDefaultTOTP(secret)
-	now := time.Now().Unix()
-	strInt64 := strconv.FormatInt(now, 10)
-	id16, _ := strconv.Atoi(strInt64)
-	return totp.Verify(code, id16)
+	now := int(time.Now().Unix())
+	for i := -skew; i <= skew; i++ {
+		if totp.Verify(code, now+i*totpInterval) {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateRecoveryCodes returns count plain recovery codes together with
+// their bcrypt hashes. Only the hashes should be persisted.
+func GenerateRecoveryCodes(count int) (codes []string, hashes []string, err error) {
+	if count <= 0 {
+		count = DefaultRecoveryCodeCount
+	}
+	for i := 0; i < count; i++ {
+		buf := make([]byte, recoveryCodeLength)
+		if _, err = rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))[:recoveryCodeLength]
+		code := raw[:recoveryCodeLength/2] + "-" + raw[recoveryCodeLength/2:]
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, string(hash))
+	}
+	return codes, hashes, nil
+}
+
+// MatchRecoveryCode returns the index of the hash matching code, or -1.
+func MatchRecoveryCode(code string, hashes []string) int {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return -1
+	}
+	for i := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hashes[i]), []byte(code)) == nil {
+			return i
+		}
+	}
+	return -1
 }
@@ -0,0 +1,39 @@
+package mfa
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xlzd/gotp"
+)
+
+func TestValidCodeSkew(t *testing.T) {
+	secret := gotp.RandomSecret(secretLength)
+	totp := gotp.NewDefaultTOTP(secret)
+	previous := totp.At(int(time.Now().Unix()) - totpInterval)
+	if ValidCode(previous, secret, 0) && previous != totp.Now() {
+		t.Fatal("previous code should be rejected without skew")
+	}
+	if !ValidCode(previous, secret, 1) {
+		t.Fatal("previous code should be accepted with skew 1")
+	}
+	if !ValidCode(totp.Now(), secret, 0) {
+		t.Fatal("current code should be accepted")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 3 || len(hashes) != 3 {
+		t.Fatalf("expected 3 codes, got %d", len(codes))
+	}
+	if i := MatchRecoveryCode(codes[1], hashes); i != 1 {
+		t.Fatalf("expected match at 1, got %d", i)
+	}
+	if i := MatchRecoveryCode("invalid-code", hashes); i != -1 {
+		t.Fatalf("expected no match, got %d", i)
+	}
+}
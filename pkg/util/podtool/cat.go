@@ -3,7 +3,7 @@ package podtool
 import "errors"
 
 func (p *PodTool) CatFile(filePath string) ([]byte, error) {
-	catCommand := []string{"cat", filePath}
+	catCommand := []string{"cat", p.fullPath(filePath)}
 	content, err := p.ExecCommand(catCommand)
 	if err != nil {
 		return nil, err
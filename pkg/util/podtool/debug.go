@@ -0,0 +1,165 @@
+package podtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	coreV1 "k8s.io/api/core/v1"
+	k8sError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// DebugContainerPrefix names the ephemeral containers the files of containers without tools are reached through
+const DebugContainerPrefix = "kubepi-files-"
+
+// debugRootPath is the root of the target container, the debug container joins its process
+// namespace in which the main process of the target is pid 1
+const debugRootPath = "/proc/1/root"
+
+var ErrEphemeralContainersUnsupported = errors.New("the container has no shell and file tools and the cluster does not support ephemeral containers")
+
+// HasFileTools reports whether the container has the shell and the tools the file operations run
+func (p *PodTool) HasFileTools() (bool, error) {
+	probe := *p
+	probe.ExecConfig = ExecConfig{}
+	_, err := probe.ExecCommand([]string{"sh", "-c", "command -v ls && command -v tar && command -v cat"})
+	if err == nil {
+		return true, nil
+	}
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) || strings.Contains(err.Error(), "executable file not found") || strings.Contains(err.Error(), "no such file or directory") {
+		return false, nil
+	}
+	return false, err
+}
+
+// DebugTool returns a PodTool operating on the files of the container through an ephemeral debug container
+// of the image, a running debug container of an earlier call is reused
+func (p *PodTool) DebugTool(image string) (*PodTool, error) {
+	pod, err := p.K8sClient.CoreV1().Pods(p.Namespace).Get(context.TODO(), p.PodName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if pod.Spec.ShareProcessNamespace != nil && *pod.Spec.ShareProcessNamespace {
+		return nil, errors.New("the files of pods sharing the process namespace can not be reached through a debug container")
+	}
+	var target *coreV1.Container
+	for i := range pod.Spec.Containers {
+		if p.ContainerName == "" || pod.Spec.Containers[i].Name == p.ContainerName {
+			target = &pod.Spec.Containers[i]
+			break
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("container %s not found in pod %s", p.ContainerName, p.PodName)
+	}
+	name := runningDebugContainer(pod, target.Name, image)
+	if name == "" {
+		name = DebugContainerPrefix + rand.String(5)
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, coreV1.EphemeralContainer{
+			EphemeralContainerCommon: coreV1.EphemeralContainerCommon{
+				Name:            name,
+				Image:           image,
+				Command:         []string{"sleep", "86400"},
+				SecurityContext: debugSecurityContext(pod, target),
+			},
+			TargetContainerName: target.Name,
+		})
+		_, err := p.K8sClient.CoreV1().Pods(p.Namespace).UpdateEphemeralContainers(context.TODO(), p.PodName, pod, metav1.UpdateOptions{})
+		if err != nil {
+			if k8sError.IsNotFound(err) || k8sError.IsMethodNotSupported(err) {
+				return nil, ErrEphemeralContainersUnsupported
+			}
+			return nil, err
+		}
+		if err := WaitForContainer(p.K8sClient, p.Namespace, p.PodName, EphemeralContainerStatuses, name); err != nil {
+			return nil, err
+		}
+	}
+	debug := *p
+	debug.ContainerName = name
+	debug.RootPath = debugRootPath
+	debug.ExecConfig = ExecConfig{Stdin: p.ExecConfig.Stdin}
+	return &debug, nil
+}
+
+func runningDebugContainer(pod *coreV1.Pod, target, image string) string {
+	running := map[string]bool{}
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		running[status.Name] = status.State.Running != nil
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		if strings.HasPrefix(c.Name, DebugContainerPrefix) && c.TargetContainerName == target && c.Image == image && running[c.Name] {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// debugSecurityContext runs the debug container as the user of the target, a process may only enter the
+// root of processes of its own user. Without a known user the container gets SYS_PTRACE instead.
+func debugSecurityContext(pod *coreV1.Pod, target *coreV1.Container) *coreV1.SecurityContext {
+	sc := &coreV1.SecurityContext{}
+	if psc := pod.Spec.SecurityContext; psc != nil {
+		sc.RunAsUser = psc.RunAsUser
+		sc.RunAsGroup = psc.RunAsGroup
+	}
+	if tsc := target.SecurityContext; tsc != nil {
+		if tsc.RunAsUser != nil {
+			sc.RunAsUser = tsc.RunAsUser
+		}
+		if tsc.RunAsGroup != nil {
+			sc.RunAsGroup = tsc.RunAsGroup
+		}
+	}
+	if sc.RunAsUser == nil {
+		sc.Capabilities = &coreV1.Capabilities{Add: []coreV1.Capability{"SYS_PTRACE"}}
+	}
+	return sc
+}
+
+// WaitForContainer waits for the container of the pod to run, failing early when its image can not be pulled.
+// statuses picks the statuses the container is in, e.g. the ones of the ephemeral containers.
+func WaitForContainer(client kubernetes.Interface, namespace, podName string, statuses func(*coreV1.Pod) []coreV1.ContainerStatus, name string) error {
+	var reason string
+	err := wait.PollImmediate(time.Second, 2*time.Minute, func() (bool, error) {
+		pod, err := client.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, status := range statuses(pod) {
+			if status.Name != name {
+				continue
+			}
+			switch {
+			case status.State.Running != nil:
+				return true, nil
+			case status.State.Terminated != nil:
+				return false, fmt.Errorf("container %s terminated: %s", name, status.State.Terminated.Reason)
+			case status.State.Waiting != nil:
+				reason = status.State.Waiting.Reason
+				switch reason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError", "CreateContainerError":
+					return false, fmt.Errorf("container %s can not start: %s %s", name, reason, status.State.Waiting.Message)
+				}
+			}
+		}
+		return false, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		return fmt.Errorf("timed out waiting for container %s to start %s", name, reason)
+	}
+	return err
+}
+
+// EphemeralContainerStatuses are the statuses of the ephemeral containers of the pod
+func EphemeralContainerStatuses(pod *coreV1.Pod) []coreV1.ContainerStatus {
+	return pod.Status.EphemeralContainerStatuses
+}
@@ -0,0 +1,72 @@
+package podtool
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	coreV1 "k8s.io/api/core/v1"
+)
+
+func TestDebugContainer(t *testing.T) {
+	uid := int64(65532)
+	pod := &coreV1.Pod{
+		Spec: coreV1.PodSpec{
+			SecurityContext: &coreV1.PodSecurityContext{RunAsUser: &uid},
+			Containers:      []coreV1.Container{{Name: "app"}},
+			EphemeralContainers: []coreV1.EphemeralContainer{
+				{EphemeralContainerCommon: coreV1.EphemeralContainerCommon{Name: "kubepi-files-a", Image: "busybox:1.36"}, TargetContainerName: "app"},
+				{EphemeralContainerCommon: coreV1.EphemeralContainerCommon{Name: "kubepi-files-b", Image: "busybox:1.36"}, TargetContainerName: "app"},
+			},
+		},
+		Status: coreV1.PodStatus{
+			EphemeralContainerStatuses: []coreV1.ContainerStatus{
+				{Name: "kubepi-files-a", State: coreV1.ContainerState{Terminated: &coreV1.ContainerStateTerminated{}}},
+				{Name: "kubepi-files-b", State: coreV1.ContainerState{Running: &coreV1.ContainerStateRunning{}}},
+			},
+		},
+	}
+	if name := runningDebugContainer(pod, "app", "busybox:1.36"); name != "kubepi-files-b" {
+		t.Fatalf("expected the running debug container to be reused, got %q", name)
+	}
+	if name := runningDebugContainer(pod, "app", "busybox:latest"); name != "" {
+		t.Fatalf("expected no debug container of another image, got %q", name)
+	}
+
+	sc := debugSecurityContext(pod, &pod.Spec.Containers[0])
+	if sc.RunAsUser == nil || *sc.RunAsUser != uid || sc.Capabilities != nil {
+		t.Fatalf("expected the debug container to run as the user of the target, got %v", sc)
+	}
+	sc = debugSecurityContext(&coreV1.Pod{}, &coreV1.Container{Name: "app"})
+	if sc.RunAsUser != nil || sc.Capabilities == nil || sc.Capabilities.Add[0] != "SYS_PTRACE" {
+		t.Fatalf("expected SYS_PTRACE without a known user, got %v", sc)
+	}
+}
+
+func TestWriteFileScriptRootPath(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc", "config"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// an absolute link of the target container resolves below its root
+	if err := os.Symlink("/etc/config", filepath.Join(root, "config")); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "config")
+	cmd := exec.Command("sh", "-c", writeFileScript, "sh", link, link+".tmp", "0", root)
+	cmd.Stdin = strings.NewReader("new")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if content, _ := os.ReadFile(filepath.Join(root, "etc", "config")); string(content) != "new" {
+		t.Fatalf("unexpected content %q", content)
+	}
+}
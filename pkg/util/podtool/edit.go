@@ -1,21 +1,120 @@
 package podtool
 
-func (p *PodTool) EditFile(path string, content string) error {
-	catCommand := []string{"cat", path}
-	oldContent, err := p.ExecCommand(catCommand)
-	if err != nil {
-		return err
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+)
+
+// ErrFileChanged is returned by EditFile when the file no longer matches the checksum the edit is based on
+var ErrFileChanged = errors.New(fileChangedMessage)
+
+// ErrFileExists is returned by CreateFile when the path is taken
+var ErrFileExists = errors.New(fileExistsMessage)
+
+type EditOptions struct {
+	// Checksum of the content the edit is based on, empty to skip the check
+	Checksum string
+	// Backup keeps the previous content in <path>.bak
+	Backup bool
+}
+
+// writeFileScript writes stdin to the temp file $2 and renames it to $1, the mode and owner of an existing
+// file are kept and $3 = 1 copies it to $1.bak first. Paths are passed as arguments, never interpolated.
+// $4 is the root path, absolute symlinks are resolved below it. When $5 is set the file is only replaced if its
+// sha256 still is $5, it is checked right before the rename so no other write slips in between. $5 = - creates
+// the file, which fails if it exists: the temp file is hard linked to it, which never replaces a file.
+const writeFileScript = `set -e
+f="$1"
+tmp="$2"
+if [ "$5" = "-" ] && { [ -e "$f" ] || [ -L "$f" ]; }; then echo "` + fileExistsMessage + `" >&2; exit 3; fi
+if [ -L "$f" ]; then
+  if [ -z "$4" ]; then
+    f=$(readlink -f "$f")
+  else
+    t=$(readlink "$f")
+    case "$t" in /*) f="$4$t" ;; *) f="$(dirname "$f")/$t" ;; esac
+  fi
+fi
+trap 'rm -f "$tmp"' EXIT
+mkdir -p "$(dirname "$tmp")"
+cat > "$tmp"
+if [ -e "$f" ]; then
+  chmod "$(stat -c %a "$f")" "$tmp"
+  chown "$(stat -c %u:%g "$f")" "$tmp" 2>/dev/null || true
+  if [ "$3" = "1" ]; then cp -p "$f" "$f.bak"; fi
+fi
+if [ "$5" = "-" ]; then
+  if ! ln "$tmp" "$f" 2>/dev/null; then
+    if [ -e "$f" ]; then echo "` + fileExistsMessage + `" >&2; exit 3; fi
+    ln "$tmp" "$f"
+  fi
+  exit 0
+fi
+if [ -n "$5" ]; then
+  sum=""
+  if [ -e "$f" ]; then sum=$(sha256sum < "$f"); fi
+  if [ "${sum%% *}" != "$5" ]; then echo "` + fileChangedMessage + `" >&2; exit 3; fi
+fi
+mv -f "$tmp" "$f"
+`
+
+// fileChangedMessage and fileExistsMessage are what writeFileScript reports when its precondition fails
+const (
+	fileChangedMessage = "file has been changed since it was opened"
+	fileExistsMessage  = "file already exists"
+)
+
+// Checksum is the hex sha256 of the file content
+func Checksum(content []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+// EditFile replaces the content of the file, it is streamed to a temp file next to it which is then
+// renamed over the file, so a failed write leaves the file untouched. Returns the checksum of the new content.
+func (p *PodTool) EditFile(filePath string, content []byte, options EditOptions) (string, error) {
+	if err := p.writeFile(filePath, bytes.NewReader(content), options.Backup, options.Checksum); err != nil {
+		return "", err
 	}
-	_, err = p.ExecCommand([]string{"sh", "-c", "cat /dev/null > " + path})
-	if err != nil {
-		return err
+	return Checksum(content), nil
+}
+
+// CreateFile writes the content to a new file, it fails with ErrFileExists rather than replacing a file
+func (p *PodTool) CreateFile(filePath string, content []byte) error {
+	return p.writeFile(filePath, bytes.NewReader(content), false, "-")
+}
+
+// WriteFile atomically writes the content to the file, creating it when it does not exist
+func (p *PodTool) WriteFile(filePath string, content []byte, backup bool) error {
+	return p.WriteFileFrom(filePath, bytes.NewReader(content), backup)
+}
+
+// WriteFileFrom streams the reader to the file like WriteFile, missing parent directories are created
+func (p *PodTool) WriteFileFrom(filePath string, reader io.Reader, backup bool) error {
+	return p.writeFile(filePath, reader, backup, "")
+}
+
+// writeFile runs writeFileScript, the file is only replaced if it still has the checksum unless it is empty, or
+// created if the checksum is -
+func (p *PodTool) writeFile(filePath string, reader io.Reader, backup bool, checksum string) error {
+	filePath = p.fullPath(filePath)
+	tmp := path.Join(path.Dir(filePath), fmt.Sprintf(".%s.kubepi-%d", path.Base(filePath), time.Now().UnixNano()))
+	backupArg := "0"
+	if backup {
+		backupArg = "1"
 	}
-	command := "echo '" + content + "' >> " + path
-	_, err = p.ExecCommand([]string{"sh", "-c", command})
+	err := p.execScript(writeFileScript, reader, nil, filePath, tmp, backupArg, p.RootPath, checksum)
 	if err != nil {
-		command2 := "echo '" + string(oldContent) + "' >> " + path
-		_, _ = p.ExecCommand([]string{"sh", "-c", command2})
-		return err
+		switch err.Error() {
+		case fileChangedMessage:
+			return ErrFileChanged
+		case fileExistsMessage:
+			return ErrFileExists
+		}
 	}
-	return nil
+	return err
 }
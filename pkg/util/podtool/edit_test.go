@@ -0,0 +1,130 @@
+package podtool
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFileWithScript(file string, content []byte, backup, checksum string) (string, error) {
+	cmd := exec.Command("sh", "-c", writeFileScript, "sh", file, file+".tmp", backup, "", checksum)
+	cmd.Stdin = bytes.NewReader(content)
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+func runWriteFileScript(t *testing.T, file string, content []byte, backup string) {
+	if out, err := writeFileWithScript(file, content, backup, ""); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+}
+
+func TestWriteFileScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "it's a file")
+	if err := os.WriteFile(file, []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("quote ' $(echo no) \x00\x01 binary\n")
+	runWriteFileScript(t, file, content, "1")
+
+	got, _ := os.ReadFile(file)
+	if !bytes.Equal(got, content) {
+		t.Fatalf("unexpected content %q", got)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0640 {
+		t.Fatalf("expected the mode to be kept, got %s", info.Mode())
+	}
+	if backup, _ := os.ReadFile(file + ".bak"); string(backup) != "old" {
+		t.Fatalf("unexpected backup %q", backup)
+	}
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("expected the temp file to be removed")
+	}
+
+	// symlinks are followed, the link itself stays
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(file, link); err != nil {
+		t.Fatal(err)
+	}
+	runWriteFileScript(t, link, []byte("new"), "0")
+	if got, _ := os.ReadFile(file); string(got) != "new" {
+		t.Fatalf("unexpected content %q", got)
+	}
+	if info, _ := os.Lstat(link); info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("expected the link to be kept")
+	}
+
+	if Checksum([]byte("new")) != "11507a0e2f5e69d5dfa40a62a1bd7b6ee57e6bcd85c67c9b8431b36fff21c437" {
+		t.Fatal("unexpected checksum")
+	}
+}
+
+func TestWriteFileScriptChecksum(t *testing.T) {
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not found")
+	}
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the edit is based on an older content
+	out, err := writeFileWithScript(file, []byte("edit"), "0", Checksum([]byte("old")))
+	if err == nil || out != fileChangedMessage {
+		t.Fatalf("expected the write to be refused, got %q, %v", out, err)
+	}
+	if got, _ := os.ReadFile(file); string(got) != "changed" {
+		t.Fatalf("expected the file to be untouched, got %q", got)
+	}
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("expected the temp file to be removed")
+	}
+
+	if out, err := writeFileWithScript(file, []byte("edit"), "0", Checksum([]byte("changed"))); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if got, _ := os.ReadFile(file); string(got) != "edit" {
+		t.Fatalf("unexpected content %q", got)
+	}
+}
+
+func TestWriteFileScriptCreate(t *testing.T) {
+	if _, err := exec.LookPath("ln"); err != nil {
+		t.Skip("ln not found")
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "new")
+	if out, err := writeFileWithScript(file, []byte("created"), "0", "-"); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if got, _ := os.ReadFile(file); string(got) != "created" {
+		t.Fatalf("unexpected content %q", got)
+	}
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("expected the temp file to be removed")
+	}
+
+	// neither the file nor a symlink at the path are replaced
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(filepath.Join(dir, "missing"), link); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{file, link} {
+		out, err := writeFileWithScript(path, []byte("again"), "0", "-")
+		if err == nil || out != fileExistsMessage {
+			t.Fatalf("expected creating %s to fail, got %q, %v", path, out, err)
+		}
+	}
+	if got, _ := os.ReadFile(file); string(got) != "created" {
+		t.Fatalf("expected the file to be untouched, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatal("expected the target of the link not to be created")
+	}
+}
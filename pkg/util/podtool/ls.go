@@ -4,7 +4,7 @@ import "strings"
 
 func (p *PodTool) ListFiles(path string) ([]File, error) {
 	var files []File
-	commands := []string{"ls", "-l", "--full-time", path}
+	commands := []string{"ls", "-l", "--full-time", p.fullPath(path)}
 
 	res, err := p.ExecCommand(commands)
 	if err != nil {
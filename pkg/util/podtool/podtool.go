@@ -8,6 +8,7 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	"path"
 )
 
 type PodTool struct {
@@ -17,6 +18,16 @@ type PodTool struct {
 	K8sClient     *kubernetes.Clientset
 	RestClient    *rest.Config
 	ExecConfig    ExecConfig
+	// RootPath prefixes the paths of the file operations, e.g. the root of the target container
+	// when its files are reached through a debug container
+	RootPath string
+}
+
+func (p *PodTool) fullPath(filePath string) string {
+	if p.RootPath == "" {
+		return filePath
+	}
+	return path.Join(p.RootPath, filePath)
 }
 
 type ExecConfig struct {
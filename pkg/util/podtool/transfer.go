@@ -0,0 +1,204 @@
+package podtool
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// ErrOffsetMismatch is returned by AppendChunk when the chunk does not start at the end of the file
+var ErrOffsetMismatch = errors.New("chunk offset does not match the uploaded size")
+
+// FileStat is the type and size of a path, the size of a directory is its disk usage
+type FileStat struct {
+	Dir  bool
+	Size int64
+}
+
+// execScript runs the shell script with the arguments as $1..$n, the stderr of a failed script is the error
+func (p *PodTool) execScript(script string, stdin io.Reader, stdout io.Writer, args ...string) error {
+	var stderr bytes.Buffer
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	p.ExecConfig.Command = append([]string{"sh", "-c", script, "sh"}, args...)
+	p.ExecConfig.Stdin = stdin
+	p.ExecConfig.Stdout = stdout
+	p.ExecConfig.Stderr = &stderr
+	p.ExecConfig.Tty = false
+	defer func() {
+		p.ExecConfig.Stdin = nil
+		p.ExecConfig.Stderr = nil
+	}()
+	if err := p.Exec(Exec); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+	return nil
+}
+
+const statScript = `set -e
+if [ -d "$1" ]; then s=$(du -sk "$1"); echo "d $s"; else s=$(stat -L -c %s "$1"); echo "f $s"; fi`
+
+func (p *PodTool) Stat(filePath string) (FileStat, error) {
+	var stat FileStat
+	var stdout bytes.Buffer
+	if err := p.execScript(statScript, nil, &stdout, p.fullPath(filePath)); err != nil {
+		return stat, err
+	}
+	fields := strings.Fields(stdout.String())
+	if len(fields) < 2 {
+		return stat, fmt.Errorf("unexpected stat output %q", stdout.String())
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return stat, err
+	}
+	stat.Dir = fields[0] == "d"
+	if stat.Dir {
+		size *= 1024
+	}
+	stat.Size = size
+	return stat, nil
+}
+
+// StreamFile writes the content of the file to w
+func (p *PodTool) StreamFile(filePath string, w io.Writer) error {
+	return p.execScript(`cat "$1"`, nil, w, p.fullPath(filePath))
+}
+
+// StreamArchive writes the file or directory to w as an archive of the format, the entries are
+// named relative to the parent directory of the path
+func (p *PodTool) StreamArchive(filePath string, format string, w io.Writer) error {
+	filePath = path.Clean(p.fullPath(filePath))
+	tarStream := func(w io.Writer) error {
+		return p.execScript(`tar cf - -C "$1" "$2"`, nil, w, path.Dir(filePath), path.Base(filePath))
+	}
+	switch format {
+	case ArchiveTar:
+		return tarStream(w)
+	case ArchiveTarGz:
+		gw := gzip.NewWriter(w)
+		if err := tarStream(gw); err != nil {
+			return err
+		}
+		return gw.Close()
+	case ArchiveZip:
+		reader, writer := io.Pipe()
+		go func() {
+			_ = writer.CloseWithError(tarStream(writer))
+		}()
+		err := TarToZip(reader, w)
+		_ = reader.CloseWithError(err)
+		return err
+	}
+	return fmt.Errorf("unsupported archive format %s", format)
+}
+
+// TarToZip converts the tar stream to a zip archive, entries other than directories, files and
+// symlinks are skipped
+func TarToZip(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	zw := zip.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink:
+		default:
+			continue
+		}
+		fh, err := zip.FileInfoHeader(hdr.FileInfo())
+		if err != nil {
+			return err
+		}
+		fh.Name = strings.TrimPrefix(hdr.Name, "./")
+		fh.Method = zip.Deflate
+		if hdr.Typeflag == tar.TypeDir {
+			fh.Name = strings.TrimSuffix(fh.Name, "/") + "/"
+			fh.Method = zip.Store
+		}
+		fw, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if _, err := io.Copy(fw, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if _, err := io.WriteString(fw, hdr.Linkname); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// appendChunkScript appends stdin to $1 when the file is $2 bytes long. The chunk is received into a
+// part file first, so a broken transfer does not leave a partial chunk in the file.
+const appendChunkScript = `set -e
+f="$1"
+mkdir -p "$(dirname "$f")"
+[ -e "$f" ] || : > "$f"
+trap 'rm -f "$f.part"' EXIT
+cat > "$f.part"
+if [ $(( $(wc -c < "$f") )) -ne "$2" ]; then echo "offset mismatch" >&2; exit 3; fi
+cat "$f.part" >> "$f"`
+
+// AppendChunk appends the chunk to the file when it is offset bytes long, otherwise ErrOffsetMismatch is returned
+func (p *PodTool) AppendChunk(filePath string, offset int64, chunk io.Reader) error {
+	err := p.execScript(appendChunkScript, chunk, nil, p.fullPath(filePath), strconv.FormatInt(offset, 10))
+	if err != nil && err.Error() == "offset mismatch" {
+		return ErrOffsetMismatch
+	}
+	return err
+}
+
+// FileSize is the size of the file, 0 when it does not exist
+func (p *PodTool) FileSize(filePath string) (int64, error) {
+	var stdout bytes.Buffer
+	if err := p.execScript(`if [ -e "$1" ]; then wc -c < "$1"; else echo 0; fi`, nil, &stdout, p.fullPath(filePath)); err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(stdout.String()), 10, 64)
+}
+
+// MoveFile renames the file, replacing the destination
+func (p *PodTool) MoveFile(src, dest string) error {
+	return p.execScript(`mv -f "$1" "$2"`, nil, nil, p.fullPath(src), p.fullPath(dest))
+}
+
+// RemoveFile removes the file and the part file of a chunked upload to it
+func (p *PodTool) RemoveFile(filePath string) error {
+	return p.execScript(`rm -f "$1" "$1.part"`, nil, nil, p.fullPath(filePath))
+}
+
+func (p *PodTool) Remove(filePath string) error {
+	return p.execScript(`rm "$1"`, nil, nil, p.fullPath(filePath))
+}
+
+func (p *PodTool) MakeDir(dirPath string) error {
+	return p.execScript(`mkdir -p "$1"`, nil, nil, p.fullPath(dirPath))
+}
@@ -0,0 +1,72 @@
+package podtool
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTarToZip(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	_ = tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755})
+	_ = tw.WriteHeader(&tar.Header{Name: "dir/a.txt", Typeflag: tar.TypeReg, Mode: 0600, Size: 5})
+	_, _ = tw.Write([]byte("hello"))
+	_ = tw.WriteHeader(&tar.Header{Name: "dir/fifo", Typeflag: tar.TypeFifo})
+	_ = tw.Close()
+
+	var out bytes.Buffer
+	if err := TarToZip(&buf, &out); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, " ") != "dir/ dir/a.txt" {
+		t.Fatalf("unexpected entries %v", names)
+	}
+	r, _ := zr.File[1].Open()
+	content, _ := io.ReadAll(r)
+	if string(content) != "hello" || zr.File[1].Mode().Perm() != 0600 {
+		t.Fatalf("unexpected file %q %s", content, zr.File[1].Mode())
+	}
+}
+
+func TestAppendChunkScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	file := filepath.Join(t.TempDir(), "sub", "upload")
+	appendChunk := func(offset, chunk string) error {
+		cmd := exec.Command("sh", "-c", appendChunkScript, "sh", file, offset)
+		cmd.Stdin = strings.NewReader(chunk)
+		return cmd.Run()
+	}
+	if err := appendChunk("0", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendChunk("3", "def"); err != nil {
+		t.Fatal(err)
+	}
+	// a retried chunk is rejected instead of appended twice
+	if err := appendChunk("3", "def"); err == nil {
+		t.Fatal("expected an offset mismatch")
+	}
+	if content, _ := os.ReadFile(file); string(content) != "abcdef" {
+		t.Fatalf("unexpected content %q", content)
+	}
+	if _, err := os.Stat(file + ".part"); !os.IsNotExist(err) {
+		t.Fatal("expected the part file to be removed")
+	}
+}
@@ -0,0 +1,371 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+// ServiceProvider validates the logins of a single SAML 2.0 identity provider.
+// Assertions must be signed, either directly or through the enclosing response,
+// the signatures are verified by goxmldsig. Encrypted assertions are not supported.
+type ServiceProvider struct {
+	EntityId string
+	AcsUrl   string
+
+	IdpEntityId     string
+	IdpSsoUrl       string
+	IdpCertificates []*x509.Certificate
+
+	// ClockSkew is tolerated between the clocks of the identity provider and KubePi
+	ClockSkew time.Duration
+	// AllowIdpInitiated accepts unsolicited responses which do not answer an AuthnRequest
+	AllowIdpInitiated bool
+
+	// clock replaces the current time in tests
+	clock func() time.Time
+}
+
+// Metadata returns the service provider metadata to register in the identity provider
+func (sp *ServiceProvider) Metadata() ([]byte, error) {
+	data, err := xml.MarshalIndent(entityDescriptor{
+		EntityID: sp.EntityId,
+		SPSSODescriptor: &spSSODescriptor{
+			AuthnRequestsSigned:        false,
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: nsProtocol,
+			NameIDFormats:              []string{nameIdUnspecified},
+			AssertionConsumerServices: []endpoint{
+				{Binding: BindingHttpPost, Location: sp.AcsUrl, Index: 1},
+			},
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// AuthnRequestUrl builds the HTTP-Redirect binding url which starts a SP-initiated login,
+// the returned request id has to be checked against the InResponseTo of the response.
+func (sp *ServiceProvider) AuthnRequestUrl(relayState string) (string, string, error) {
+	id, err := newId()
+	if err != nil {
+		return "", "", err
+	}
+	data, err := xml.Marshal(authnRequest{
+		ID:                          id,
+		Version:                     "2.0",
+		IssueInstant:                time.Now().UTC(),
+		Destination:                 sp.IdpSsoUrl,
+		AssertionConsumerServiceURL: sp.AcsUrl,
+		ProtocolBinding:             BindingHttpPost,
+		Issuer:                      sp.EntityId,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	if _, err := w.Write(data); err != nil {
+		return "", "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", "", err
+	}
+	u, err := url.Parse(sp.IdpSsoUrl)
+	if err != nil {
+		return "", "", err
+	}
+	query := u.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if relayState != "" {
+		query.Set("RelayState", relayState)
+	}
+	u.RawQuery = query.Encode()
+	return id, u.String(), nil
+}
+
+// ParseResponse verifies a base64 encoded response posted to the assertion consumer service.
+// isRequestId reports whether an id was issued by AuthnRequestUrl and not used yet.
+func (sp *ServiceProvider) ParseResponse(encoded string, isRequestId func(string) bool) (*Assertion, error) {
+	data, err := decodeBase64(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMLResponse encoding: %s", err.Error())
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, fmt.Errorf("invalid SAMLResponse: %s", err.Error())
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "Response" || root.NamespaceURI() != nsProtocol {
+		return nil, errors.New("SAMLResponse is not a response")
+	}
+	// duplicated ids and assertions out of their place are the base of signature wrapping attacks
+	ids := map[string]bool{}
+	var assertions []*etree.Element
+	err = etreeutils.NSTraverse(etreeutils.NewDefaultNSContext(), root, func(ctx etreeutils.NSContext, e *etree.Element) error {
+		if id := e.SelectAttrValue("ID", ""); id != "" {
+			if ids[id] {
+				return errors.New("SAMLResponse contains duplicated ids")
+			}
+			ids[id] = true
+		}
+		ns, err := ctx.LookupPrefix(e.Space)
+		if err != nil || ns != nsAssertion {
+			return err
+		}
+		switch e.Tag {
+		case "EncryptedAssertion":
+			return errors.New("encrypted assertions are not supported")
+		case "Assertion":
+			if e.Parent() != root {
+				return errors.New("SAMLResponse contains an assertion out of its place")
+			}
+			// the assertion is verified on its own, with the namespaces it inherits declared on it
+			detached, err := etreeutils.NSDetatch(ctx, e)
+			if err != nil {
+				return err
+			}
+			assertions = append(assertions, detached)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(assertions) != 1 {
+		return nil, errors.New("SAMLResponse must contain exactly one assertion")
+	}
+
+	validation := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: sp.IdpCertificates})
+	validation.Clock = dsig.NewFakeClockAt(sp.now())
+	verifiedAssertion, err := validation.Validate(assertions[0])
+	if err != nil && err != dsig.ErrMissingSignature {
+		return nil, fmt.Errorf("invalid assertion signature: %s", err.Error())
+	}
+	assertionSigned := err == nil
+
+	// only the verified elements are read from here on, the assertion included
+	var resp response
+	verifiedResponse, err := validation.Validate(root)
+	switch {
+	case err == nil:
+		if err := unmarshalElement(verifiedResponse, &resp); err != nil {
+			return nil, err
+		}
+	case err == dsig.ErrMissingSignature && assertionSigned:
+		if err := unmarshalElement(root, &resp); err != nil {
+			return nil, err
+		}
+		resp.Assertion = &assertion{}
+		if err := unmarshalElement(verifiedAssertion, resp.Assertion); err != nil {
+			return nil, err
+		}
+	case err == dsig.ErrMissingSignature:
+		return nil, errors.New("neither the response nor the assertion is signed")
+	default:
+		return nil, fmt.Errorf("invalid response signature: %s", err.Error())
+	}
+	return sp.validate(&resp, isRequestId)
+}
+
+func unmarshalElement(e *etree.Element, v interface{}) error {
+	doc := etree.NewDocument()
+	doc.SetRoot(e.Copy())
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, v)
+}
+
+func (sp *ServiceProvider) now() time.Time {
+	if sp.clock != nil {
+		return sp.clock()
+	}
+	return time.Now()
+}
+
+func (sp *ServiceProvider) validate(resp *response, isRequestId func(string) bool) (*Assertion, error) {
+	now := sp.now()
+	if resp.StatusCode.Value != statusSuccess {
+		status := resp.StatusCode.Value
+		if resp.StatusCode.StatusCode != nil {
+			status = resp.StatusCode.StatusCode.Value
+		}
+		return nil, fmt.Errorf("identity provider returned status %s", status)
+	}
+	if resp.Destination != "" && resp.Destination != sp.AcsUrl {
+		return nil, fmt.Errorf("response destination %s does not match %s", resp.Destination, sp.AcsUrl)
+	}
+	if resp.Issuer != "" && sp.IdpEntityId != "" && strings.TrimSpace(resp.Issuer) != sp.IdpEntityId {
+		return nil, fmt.Errorf("response issuer %s is not trusted", resp.Issuer)
+	}
+	a := resp.Assertion
+	if a == nil {
+		return nil, errors.New("response has no assertion")
+	}
+	if sp.IdpEntityId != "" && strings.TrimSpace(a.Issuer) != sp.IdpEntityId {
+		return nil, fmt.Errorf("assertion issuer %s is not trusted", a.Issuer)
+	}
+	if a.Conditions != nil {
+		if !a.Conditions.NotBefore.IsZero() && now.Add(sp.ClockSkew).Before(a.Conditions.NotBefore) {
+			return nil, errors.New("assertion is not yet valid")
+		}
+		if !a.Conditions.NotOnOrAfter.IsZero() && !now.Add(-sp.ClockSkew).Before(a.Conditions.NotOnOrAfter) {
+			return nil, errors.New("assertion is expired")
+		}
+		if len(a.Conditions.Audiences) > 0 {
+			matched := false
+			for _, audience := range a.Conditions.Audiences {
+				matched = matched || strings.TrimSpace(audience) == sp.EntityId
+			}
+			if !matched {
+				return nil, errors.New("assertion is not addressed to this service provider")
+			}
+		}
+	}
+
+	inResponseTo := resp.InResponseTo
+	var confirmation *subjectConfirmationData
+	for i := range a.Subject.Confirmations {
+		c := a.Subject.Confirmations[i]
+		if c.Method != confirmationBearer {
+			continue
+		}
+		if c.Data.Recipient != "" && c.Data.Recipient != sp.AcsUrl {
+			continue
+		}
+		if c.Data.NotOnOrAfter.IsZero() || !now.Add(-sp.ClockSkew).Before(c.Data.NotOnOrAfter) {
+			continue
+		}
+		if c.Data.InResponseTo != "" && inResponseTo != "" && c.Data.InResponseTo != inResponseTo {
+			continue
+		}
+		confirmation = &c.Data
+		break
+	}
+	if confirmation == nil {
+		return nil, errors.New("assertion has no valid bearer subject confirmation")
+	}
+	if inResponseTo == "" {
+		inResponseTo = confirmation.InResponseTo
+	}
+	if inResponseTo == "" {
+		if !sp.AllowIdpInitiated {
+			return nil, errors.New("identity provider initiated login is not allowed")
+		}
+	} else if isRequestId == nil || !isRequestId(inResponseTo) {
+		return nil, errors.New("response does not answer a pending login request")
+	}
+
+	nameId := strings.TrimSpace(a.Subject.NameID)
+	if nameId == "" {
+		return nil, errors.New("assertion has no subject")
+	}
+	result := &Assertion{
+		ID:           a.ID,
+		NameID:       nameId,
+		InResponseTo: inResponseTo,
+		NotOnOrAfter: confirmation.NotOnOrAfter,
+		Attributes:   map[string][]string{},
+	}
+	if a.AuthnStatement != nil {
+		result.SessionIndex = a.AuthnStatement.SessionIndex
+	}
+	for _, attr := range a.Attributes {
+		var values []string
+		for _, v := range attr.Values {
+			values = append(values, strings.TrimSpace(v))
+		}
+		result.Attributes[attr.Name] = append(result.Attributes[attr.Name], values...)
+		if attr.FriendlyName != "" && attr.FriendlyName != attr.Name {
+			result.Attributes[attr.FriendlyName] = append(result.Attributes[attr.FriendlyName], values...)
+		}
+	}
+	return result, nil
+}
+
+// IdpMetadata is the part of the identity provider metadata the service provider needs
+type IdpMetadata struct {
+	EntityId     string
+	SsoUrl       string
+	Certificates []*x509.Certificate
+}
+
+// ParseIdpMetadata reads the entity id, the HTTP-Redirect single sign on url
+// and the signing certificates from identity provider metadata.
+func ParseIdpMetadata(data []byte) (*IdpMetadata, error) {
+	var ed entityDescriptor
+	if err := xml.Unmarshal(data, &ed); err != nil {
+		return nil, err
+	}
+	if ed.IDPSSODescriptor == nil {
+		return nil, errors.New("metadata has no IDPSSODescriptor")
+	}
+	m := &IdpMetadata{EntityId: ed.EntityID}
+	for _, s := range ed.IDPSSODescriptor.SingleSignOnService {
+		if s.Binding == BindingHttpRedirect {
+			m.SsoUrl = s.Location
+			break
+		}
+	}
+	if m.SsoUrl == "" {
+		return nil, errors.New("metadata has no HTTP-Redirect single sign on service")
+	}
+	for _, kd := range ed.IDPSSODescriptor.KeyDescriptors {
+		if kd.Use != "" && kd.Use != "signing" {
+			continue
+		}
+		for _, c := range kd.Certificates {
+			cert, err := ParseCertificate(c)
+			if err != nil {
+				return nil, err
+			}
+			m.Certificates = append(m.Certificates, cert)
+		}
+	}
+	if len(m.Certificates) == 0 {
+		return nil, errors.New("metadata has no signing certificate")
+	}
+	return m, nil
+}
+
+// ParseCertificate accepts a PEM certificate or the bare base64 content used in metadata
+func ParseCertificate(s string) (*x509.Certificate, error) {
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		return x509.ParseCertificate(block.Bytes)
+	}
+	der, err := decodeBase64(s)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
+
+func newId() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "id-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,234 @@
+package saml
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	testIdp = "https://idp.example.com"
+	testSp  = "https://kubepi.example.com/kubepi/api/v1/sessions/saml/metadata"
+	testAcs = "https://kubepi.example.com/kubepi/api/v1/sessions/saml/acs"
+)
+
+func newTestIdp(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func testAssertion(id, inResponseTo string) string {
+	now := time.Now().UTC()
+	return fmt.Sprintf(`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="%s" Version="2.0" IssueInstant="%s">`+
+		`<saml:Issuer>%s</saml:Issuer>`+
+		`<saml:Subject><saml:NameID>alice</saml:NameID>`+
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">`+
+		`<saml:SubjectConfirmationData InResponseTo="%s" NotOnOrAfter="%s" Recipient="%s"/></saml:SubjectConfirmation></saml:Subject>`+
+		`<saml:Conditions NotBefore="%s" NotOnOrAfter="%s"><saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction></saml:Conditions>`+
+		`<saml:AuthnStatement AuthnInstant="%s" SessionIndex="s1"/>`+
+		`<saml:AttributeStatement><saml:Attribute Name="groups"><saml:AttributeValue>dev</saml:AttributeValue><saml:AttributeValue>ops</saml:AttributeValue></saml:Attribute></saml:AttributeStatement>`+
+		`</saml:Assertion>`,
+		id, now.Format(time.RFC3339), testIdp, inResponseTo, now.Add(5*time.Minute).Format(time.RFC3339), testAcs,
+		now.Add(-time.Minute).Format(time.RFC3339), now.Add(5*time.Minute).Format(time.RFC3339), testSp, now.Format(time.RFC3339))
+}
+
+type testKeyStore struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func (ks testKeyStore) GetKeyPair() (*rsa.PrivateKey, []byte, error) {
+	return ks.key, ks.cert.Raw, nil
+}
+
+// sign adds an enveloped signature to the element with the given id
+func sign(t *testing.T, doc string, id string, key *rsa.PrivateKey, cert *x509.Certificate) string {
+	d := etree.NewDocument()
+	if err := d.ReadFromString(doc); err != nil {
+		t.Fatal(err)
+	}
+	target := d.FindElement(fmt.Sprintf("//[@ID='%s']", id))
+	// identity providers use the exclusive canonicalization, the inclusive one breaks on embedding the signed element
+	signing := dsig.NewDefaultSigningContext(testKeyStore{key: key, cert: cert})
+	signing.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	signed, err := signing.SignEnveloped(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent := target.Parent(); parent != nil {
+		parent.InsertChildAt(target.Index(), signed)
+		parent.RemoveChild(target)
+	} else {
+		d.SetRoot(signed)
+	}
+	out, err := d.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func testResponse(inResponseTo string, assertion string) string {
+	return fmt.Sprintf(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="r1" Version="2.0" InResponseTo="%s" Destination="%s">`+
+		`<saml:Issuer>%s</saml:Issuer>`+
+		`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>%s</samlp:Response>`,
+		inResponseTo, testAcs, testIdp, assertion)
+}
+
+func testServiceProvider(cert *x509.Certificate) *ServiceProvider {
+	return &ServiceProvider{
+		EntityId:        testSp,
+		AcsUrl:          testAcs,
+		IdpEntityId:     testIdp,
+		IdpSsoUrl:       "https://idp.example.com/sso",
+		IdpCertificates: []*x509.Certificate{cert},
+		ClockSkew:       time.Minute,
+	}
+}
+
+func encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestParseSignedAssertion(t *testing.T) {
+	key, cert := newTestIdp(t)
+	sp := testServiceProvider(cert)
+	requestId, _, err := sp.AuthnRequestUrl("/kubepi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	isRequestId := func(id string) bool { return id == requestId }
+
+	doc := testResponse(requestId, sign(t, testAssertion("a1", requestId), "a1", key, cert))
+	a, err := sp.ParseResponse(encode(doc), isRequestId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.NameID != "alice" || a.SessionIndex != "s1" || strings.Join(a.Attributes["groups"], ",") != "dev,ops" {
+		t.Fatalf("unexpected assertion %+v", a)
+	}
+
+	if _, err := sp.ParseResponse(encode(strings.Replace(doc, "alice", "admin", 1)), isRequestId); err == nil {
+		t.Fatal("tampered assertion is accepted")
+	}
+	if _, err := sp.ParseResponse(encode(doc), func(string) bool { return false }); err == nil {
+		t.Fatal("response to an unknown request is accepted")
+	}
+	_, other := newTestIdp(t)
+	if _, err := testServiceProvider(other).ParseResponse(encode(doc), isRequestId); err == nil {
+		t.Fatal("assertion signed by an untrusted key is accepted")
+	}
+}
+
+func TestParseSignedResponse(t *testing.T) {
+	key, cert := newTestIdp(t)
+	sp := testServiceProvider(cert)
+	doc := sign(t, testResponse("", testAssertion("a1", "")), "r1", key, cert)
+	if _, err := sp.ParseResponse(encode(doc), nil); err == nil {
+		t.Fatal("idp initiated login is accepted while disabled")
+	}
+	sp.AllowIdpInitiated = true
+	if _, err := sp.ParseResponse(encode(doc), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseUnsignedResponse(t *testing.T) {
+	_, cert := newTestIdp(t)
+	sp := testServiceProvider(cert)
+	sp.AllowIdpInitiated = true
+	if _, err := sp.ParseResponse(encode(testResponse("", testAssertion("a1", ""))), nil); err == nil {
+		t.Fatal("unsigned response is accepted")
+	}
+}
+
+func TestParseMultipleAssertions(t *testing.T) {
+	key, cert := newTestIdp(t)
+	sp := testServiceProvider(cert)
+	sp.AllowIdpInitiated = true
+	doc := testResponse("", sign(t, testAssertion("a1", ""), "a1", key, cert)+sign(t, testAssertion("a2", ""), "a2", key, cert))
+	if _, err := sp.ParseResponse(encode(doc), nil); err == nil {
+		t.Fatal("response with two assertions is accepted")
+	}
+	if _, err := sp.ParseResponse(encode(sign(t, doc, "r1", key, cert)), nil); err == nil {
+		t.Fatal("signed response with two assertions is accepted")
+	}
+}
+
+// onelogin returns the service provider the response of the OneLogin identity provider in testdata was made for
+func onelogin(t *testing.T) (*ServiceProvider, func(string) bool) {
+	data, err := ioutil.ReadFile("testdata/onelogin_cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ParseCertificate(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := &ServiceProvider{
+		EntityId:        "https://29ee6d2e.ngrok.io/saml/metadata",
+		AcsUrl:          "https://29ee6d2e.ngrok.io/saml/acs",
+		IdpEntityId:     "https://app.onelogin.com/saml/metadata/503983",
+		IdpCertificates: []*x509.Certificate{cert},
+		clock: func() time.Time {
+			return time.Date(2016, 1, 5, 17, 53, 12, 0, time.UTC)
+		},
+	}
+	return sp, func(id string) bool { return id == "id-d40c15c104b52691eccf0a2a5c8a15595be75423" }
+}
+
+func TestParseIdpResponse(t *testing.T) {
+	sp, isRequestId := onelogin(t)
+	data, err := ioutil.ReadFile("testdata/onelogin_response")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := sp.ParseResponse(string(data), isRequestId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.NameID != "ross@kndr.org" || a.Attribute("User.email") != "ross@kndr.org" {
+		t.Fatalf("unexpected assertion %+v", a)
+	}
+}
+
+func TestParseWrappedSignature(t *testing.T) {
+	sp, isRequestId := onelogin(t)
+	for i := 1; i <= 9; i++ {
+		data, err := ioutil.ReadFile(fmt.Sprintf("testdata/xsw_%d_response", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a, err := sp.ParseResponse(string(data), isRequestId); err == nil {
+			t.Fatalf("signature wrapping permutation %d is accepted as %s", i, a.NameID)
+		}
+	}
+}
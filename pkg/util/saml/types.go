@@ -0,0 +1,136 @@
+package saml
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+
+	BindingHttpPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	BindingHttpRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+
+	statusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	nameIdUnspecified  = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	confirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+type authnRequest struct {
+	XMLName                     xml.Name  `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string    `xml:"ID,attr"`
+	Version                     string    `xml:"Version,attr"`
+	IssueInstant                time.Time `xml:"IssueInstant,attr"`
+	Destination                 string    `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string    `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string    `xml:"ProtocolBinding,attr"`
+	Issuer                      string    `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+}
+
+type entityDescriptor struct {
+	XMLName          xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID         string            `xml:"entityID,attr"`
+	SPSSODescriptor  *spSSODescriptor  `xml:"urn:oasis:names:tc:SAML:2.0:metadata SPSSODescriptor,omitempty"`
+	IDPSSODescriptor *idpSSODescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor,omitempty"`
+}
+
+type spSSODescriptor struct {
+	AuthnRequestsSigned        bool       `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned       bool       `xml:"WantAssertionsSigned,attr"`
+	ProtocolSupportEnumeration string     `xml:"protocolSupportEnumeration,attr"`
+	NameIDFormats              []string   `xml:"urn:oasis:names:tc:SAML:2.0:metadata NameIDFormat"`
+	AssertionConsumerServices  []endpoint `xml:"urn:oasis:names:tc:SAML:2.0:metadata AssertionConsumerService"`
+}
+
+type idpSSODescriptor struct {
+	KeyDescriptors      []keyDescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+	SingleSignOnService []endpoint      `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleSignOnService"`
+}
+
+type keyDescriptor struct {
+	Use          string   `xml:"use,attr"`
+	Certificates []string `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo>X509Data>X509Certificate"`
+}
+
+type endpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+	Index    int    `xml:"index,attr,omitempty"`
+}
+
+type response struct {
+	XMLName      xml.Name   `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+	ID           string     `xml:"ID,attr"`
+	InResponseTo string     `xml:"InResponseTo,attr"`
+	Destination  string     `xml:"Destination,attr"`
+	Issuer       string     `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	StatusCode   statusCode `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status>StatusCode"`
+	Assertion    *assertion `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+}
+
+type statusCode struct {
+	Value      string      `xml:"Value,attr"`
+	StatusCode *statusCode `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusCode"`
+}
+
+type assertion struct {
+	XMLName        xml.Name        `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	ID             string          `xml:"ID,attr"`
+	Issuer         string          `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Subject        subject         `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject"`
+	Conditions     *conditions     `xml:"urn:oasis:names:tc:SAML:2.0:assertion Conditions"`
+	AuthnStatement *authnStatement `xml:"urn:oasis:names:tc:SAML:2.0:assertion AuthnStatement"`
+	Attributes     []attribute     `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeStatement>Attribute"`
+}
+
+type subject struct {
+	NameID        string                `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	Confirmations []subjectConfirmation `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmation"`
+}
+
+type subjectConfirmation struct {
+	Method string                  `xml:"Method,attr"`
+	Data   subjectConfirmationData `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmationData"`
+}
+
+type subjectConfirmationData struct {
+	InResponseTo string    `xml:"InResponseTo,attr"`
+	NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+	Recipient    string    `xml:"Recipient,attr"`
+}
+
+type conditions struct {
+	NotBefore    time.Time `xml:"NotBefore,attr"`
+	NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+	Audiences    []string  `xml:"urn:oasis:names:tc:SAML:2.0:assertion AudienceRestriction>Audience"`
+}
+
+type authnStatement struct {
+	SessionIndex string `xml:"SessionIndex,attr"`
+}
+
+type attribute struct {
+	Name         string   `xml:"Name,attr"`
+	FriendlyName string   `xml:"FriendlyName,attr"`
+	Values       []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeValue"`
+}
+
+// Assertion is the verified result of a SAML login
+type Assertion struct {
+	ID           string
+	NameID       string
+	SessionIndex string
+	InResponseTo string
+	NotOnOrAfter time.Time
+	// Attributes are keyed by both the name and the friendly name of the attribute
+	Attributes map[string][]string
+}
+
+// Attribute returns the first value of the attribute
+func (a *Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
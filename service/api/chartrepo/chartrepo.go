@@ -0,0 +1,335 @@
+package chartrepo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KubeOperator/kubepi/pkg/util/helm"
+	"github.com/KubeOperator/kubepi/service/api/v1/session"
+	v1Role "github.com/KubeOperator/kubepi/service/model/v1/role"
+	v1User "github.com/KubeOperator/kubepi/service/model/v1/user"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/KubeOperator/kubepi/service/service/v1/chart"
+	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"github.com/KubeOperator/kubepi/service/service/v1/ldap"
+	"github.com/KubeOperator/kubepi/service/service/v1/role"
+	"github.com/KubeOperator/kubepi/service/service/v1/rolebinding"
+	"github.com/KubeOperator/kubepi/service/service/v1/user"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// resource is the name role rules grant pushing and deleting charts with
+const resource = "chartrepos"
+
+type Handler struct {
+	userService        user.Service
+	ldapService        ldap.Service
+	roleService        role.Service
+	roleBindingService rolebinding.Service
+	chartService       chart.Service
+}
+
+func NewHandler() *Handler {
+	return &Handler{
+		userService:        user.NewService(),
+		ldapService:        ldap.NewService(),
+		roleService:        role.NewService(),
+		roleBindingService: rolebinding.NewService(),
+		chartService:       chart.NewService(),
+	}
+}
+
+// failedLogins counts the wrong passwords sent for each user within failedLoginWindow, once maxFailedLogins is
+// reached the user can not authenticate with the chart repository until the window passed
+var failedLogins = struct {
+	sync.Mutex
+	items map[string]*failedLogin
+}{items: map[string]*failedLogin{}}
+
+const (
+	maxFailedLogins   = 5
+	failedLoginWindow = 15 * time.Minute
+)
+
+type failedLogin struct {
+	count int
+	since time.Time
+}
+
+// loginLocked reports whether the user sent too many wrong passwords
+func loginLocked(name string) bool {
+	failedLogins.Lock()
+	defer failedLogins.Unlock()
+	f, ok := failedLogins.items[name]
+	if !ok {
+		return false
+	}
+	if time.Since(f.since) > failedLoginWindow {
+		delete(failedLogins.items, name)
+		return false
+	}
+	return f.count >= maxFailedLogins
+}
+
+func recordLogin(name string, succeeded bool) {
+	failedLogins.Lock()
+	defer failedLogins.Unlock()
+	if succeeded {
+		delete(failedLogins.items, name)
+		return
+	}
+	f, ok := failedLogins.items[name]
+	if !ok || time.Since(f.since) > failedLoginWindow {
+		f = &failedLogin{since: time.Now()}
+		failedLogins.items[name] = f
+	}
+	f.count++
+}
+
+// authHandler authenticates helm with the basic auth credentials of a KubePi user. Users with mfa can not
+// authenticate with their password alone, and a user is locked out for a while after too many wrong passwords.
+func (h *Handler) authHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		username, password, ok := ctx.Request().BasicAuth()
+		if !ok {
+			unauthorized(ctx)
+			return
+		}
+		u, err := h.userService.GetByNameOrEmail(username, common.DBOptions{})
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				unauthorized(ctx)
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			ctx.StopExecution()
+			return
+		}
+		if loginLocked(u.Name) {
+			ctx.StatusCode(iris.StatusTooManyRequests)
+			ctx.Values().Set("message", "too many failed logins, try again later")
+			ctx.StopExecution()
+			return
+		}
+		if u.Type == v1User.LDAP {
+			err = h.ldapService.Login(*u, password, common.DBOptions{})
+		} else {
+			err = bcrypt.CompareHashAndPassword([]byte(u.Authenticate.Password), []byte(password))
+		}
+		recordLogin(u.Name, err == nil)
+		if err != nil || u.Disabled {
+			unauthorized(ctx)
+			return
+		}
+		mfaEnforced, err := session.IsMfaEnforced(u.Name)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			ctx.StopExecution()
+			return
+		}
+		if u.Mfa.Enable || mfaEnforced {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", "users with mfa can not authenticate with the chart repository")
+			ctx.StopExecution()
+			return
+		}
+		roles, err := h.userRoles(u)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			ctx.StopExecution()
+			return
+		}
+		// the profile and the roles are the ones the api routes see, for session.HasVerb
+		ctx.Values().Set("profile", session.UserProfile{Name: u.Name, NickName: u.NickName, Email: u.Email, IsAdministrator: u.IsAdmin})
+		ctx.Values().Set("roles", roles)
+		ctx.Next()
+	}
+}
+
+// userRoles returns the roles the user is bound to
+func (h *Handler) userRoles(u *v1User.User) ([]v1Role.Role, error) {
+	if u.IsAdmin {
+		return nil, nil
+	}
+	bindings, err := h.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: u.Name}, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	var names []string
+	for i := range bindings {
+		names = append(names, bindings[i].RoleRef)
+	}
+	roles, err := h.roleService.GetByNames(names, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// permitted reports whether a role of the user grants the verb on the chart repository, the request is
+// answered with forbidden otherwise
+func permitted(ctx *context.Context, verb string) bool {
+	if session.HasVerb(ctx, resource, verb) {
+		return true
+	}
+	profile := ctx.Values().Get("profile").(session.UserProfile)
+	ctx.StatusCode(iris.StatusForbidden)
+	ctx.Values().Set("message", fmt.Sprintf("user %s can not %s charts of the chart repository", profile.Name, verb))
+	return false
+}
+
+func unauthorized(ctx *context.Context) {
+	ctx.Header("WWW-Authenticate", `Basic realm="kubepi"`)
+	ctx.StatusCode(iris.StatusUnauthorized)
+	ctx.Values().Set("message", "username or password error")
+	ctx.StopExecution()
+}
+
+func (h *Handler) GetFile() iris.Handler {
+	return func(ctx *context.Context) {
+		repo := chart.LocalRepo()
+		name := ctx.Params().GetString("file")
+		var (
+			p   string
+			err error
+		)
+		if name == "index.yaml" {
+			p, err = repo.IndexFile()
+		} else {
+			p, err = repo.Archive(name)
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				ctx.StatusCode(iris.StatusNotFound)
+			} else {
+				ctx.StatusCode(iris.StatusBadRequest)
+			}
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Header("Content-Type", server.ContentTypeDownload)
+		_, _ = ctx.Write(data)
+	}
+}
+
+// ReadArchive reads a pushed chart archive, either the multipart file "chart" like
+// chartmuseum accepts it, the multipart file "file" or the raw request body.
+func ReadArchive(ctx *context.Context) ([]byte, error) {
+	ctx.SetMaxRequestBodySize(helm.MaxArchiveSize + 1<<20)
+	var r io.Reader = ctx.Request().Body
+	if strings.HasPrefix(ctx.GetContentTypeRequested(), "multipart/") {
+		f, _, err := ctx.FormFile("chart")
+		if err != nil {
+			if f, _, err = ctx.FormFile("file"); err != nil {
+				return nil, err
+			}
+		}
+		defer f.Close()
+		r = f
+	}
+	return io.ReadAll(io.LimitReader(r, helm.MaxArchiveSize+1))
+}
+
+func (h *Handler) ListCharts() iris.Handler {
+	return func(ctx *context.Context) {
+		charts, err := h.chartService.ListLocalCharts()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", charts)
+	}
+}
+
+func (h *Handler) GetChart() iris.Handler {
+	return func(ctx *context.Context) {
+		versions, err := h.chartService.GetLocalChart(ctx.Params().GetString("name"))
+		if err != nil {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", versions)
+	}
+}
+
+// PushChart accepts the uploads of helm cm-push, an existing version is replaced with force=true
+func (h *Handler) PushChart() iris.Handler {
+	return func(ctx *context.Context) {
+		if !permitted(ctx, "create") {
+			return
+		}
+		data, err := ReadArchive(ctx)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		metadata, err := h.chartService.PushLocalChart(data, ctx.URLParamBoolDefault("force", false))
+		if err != nil {
+			if errors.Is(err, helm.ErrVersionExists) {
+				ctx.StatusCode(iris.StatusConflict)
+			} else {
+				ctx.StatusCode(iris.StatusBadRequest)
+			}
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.StatusCode(iris.StatusCreated)
+		ctx.Values().Set("data", metadata)
+	}
+}
+
+func (h *Handler) DeleteChart() iris.Handler {
+	return func(ctx *context.Context) {
+		if !permitted(ctx, "delete") {
+			return
+		}
+		name := ctx.Params().GetString("name")
+		version := ctx.Params().GetString("version")
+		if err := h.chartService.DeleteLocalChart(name, version); err != nil {
+			if os.IsNotExist(err) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", fmt.Sprintf("chart %s version %s not found", name, version))
+			} else {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+			}
+			return
+		}
+		ctx.Values().Set("data", "")
+	}
+}
+
+// Install serves the local chart repository, it can be added to clusters
+// with the url <kubepi>/kubepi/api/chartrepo and the credentials of a KubePi user.
+// Every user can read the charts, pushing and deleting needs a role granting it on chartrepos.
+func Install(parent iris.Party) {
+	handler := NewHandler()
+	sp := parent.Party("/chartrepo")
+	sp.Use(handler.authHandler())
+	sp.Get("/{file}", handler.GetFile())
+	// the chartmuseum api used by helm cm-push
+	sp.Get("/api/charts", handler.ListCharts())
+	sp.Get("/api/charts/{name}", handler.GetChart())
+	sp.Post("/api/charts", handler.PushChart())
+	sp.Delete("/api/charts/{name}/{version}", handler.DeleteChart())
+}
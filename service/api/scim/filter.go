@@ -0,0 +1,161 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// filter is a parsed scim filter, only flat expressions joined by "and" / "or"
+// are supported, "and" binds tighter than "or". Grouping with parentheses is not.
+type filter [][]comparison
+
+type comparison struct {
+	attr  string
+	op    string
+	value string
+}
+
+// attributes are the lower case attribute paths of a resource with their values
+type attributes map[string][]string
+
+func parseFilter(expr string) (filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	var result filter
+	var group []comparison
+	for i := 0; i < len(tokens); {
+		if i+1 >= len(tokens) {
+			return nil, fmt.Errorf("invalid filter: %s", expr)
+		}
+		c := comparison{attr: strings.ToLower(tokens[i]), op: strings.ToLower(tokens[i+1])}
+		i += 2
+		if c.op != "pr" {
+			if i >= len(tokens) {
+				return nil, fmt.Errorf("invalid filter: %s", expr)
+			}
+			c.value = tokens[i]
+			i++
+		}
+		switch c.op {
+		case "eq", "ne", "co", "sw", "ew", "pr":
+		default:
+			return nil, fmt.Errorf("unsupported filter operator: %s", c.op)
+		}
+		group = append(group, c)
+		if i < len(tokens) {
+			switch strings.ToLower(tokens[i]) {
+			case "and":
+			case "or":
+				result = append(result, group)
+				group = nil
+			default:
+				return nil, fmt.Errorf("invalid filter: %s", expr)
+			}
+			i++
+			if i >= len(tokens) {
+				return nil, fmt.Errorf("invalid filter: %s", expr)
+			}
+		}
+	}
+	if len(group) > 0 {
+		result = append(result, group)
+	}
+	return result, nil
+}
+
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	inQuote := false
+	quoted := false
+	for i := 0; i < len(expr); i++ {
+		ch := expr[i]
+		switch {
+		case inQuote && ch == '\\' && i+1 < len(expr):
+			i++
+			current.WriteByte(expr[i])
+		case ch == '"':
+			inQuote = !inQuote
+			quoted = true
+		case !inQuote && (ch == ' ' || ch == '\t'):
+			if current.Len() > 0 || quoted {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				quoted = false
+			}
+		default:
+			current.WriteByte(ch)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated string in filter: %s", expr)
+	}
+	if current.Len() > 0 || quoted {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+func (f filter) match(attrs attributes) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, group := range f {
+		matched := true
+		for _, c := range group {
+			if !c.match(attrs) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparison) match(attrs attributes) bool {
+	values := attrs[c.attr]
+	if c.op == "pr" {
+		for _, v := range values {
+			if v != "" {
+				return true
+			}
+		}
+		return false
+	}
+	expected := strings.ToLower(c.value)
+	if c.op == "ne" {
+		for _, v := range values {
+			if strings.ToLower(v) == expected {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		v = strings.ToLower(v)
+		switch c.op {
+		case "eq":
+			if v == expected {
+				return true
+			}
+		case "co":
+			if strings.Contains(v, expected) {
+				return true
+			}
+		case "sw":
+			if strings.HasPrefix(v, expected) {
+				return true
+			}
+		case "ew":
+			if strings.HasSuffix(v, expected) {
+				return true
+			}
+		}
+	}
+	return false
+}
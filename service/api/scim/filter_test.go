@@ -0,0 +1,44 @@
+package scim
+
+import "testing"
+
+func TestParseFilter(t *testing.T) {
+	attrs := attributes{
+		"username":     {"Alice"},
+		"externalid":   {"00u1"},
+		"emails.value": {"alice@example.com"},
+		"active":       {"true"},
+	}
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{``, true},
+		{`userName eq "alice"`, true},
+		{`userName eq "bob"`, false},
+		{`userName ne "bob"`, true},
+		{`emails.value ew "@example.com"`, true},
+		{`userName sw "al" and externalId eq "00u2"`, false},
+		{`userName eq "bob" or externalId eq "00u1"`, true},
+		{`displayName pr`, false},
+		{`active eq true`, true},
+		{`userName eq "a \"quoted\" name"`, false},
+	}
+	for _, c := range cases {
+		f, err := parseFilter(c.expr)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.expr, err)
+		}
+		if f.match(attrs) != c.match {
+			t.Errorf("filter %q: expected %v", c.expr, c.match)
+		}
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, expr := range []string{`userName eq`, `userName gt "a"`, `userName eq "a" and`, `userName eq "a`} {
+		if _, err := parseFilter(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
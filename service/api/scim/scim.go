@@ -0,0 +1,1070 @@
+package scim
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	"github.com/KubeOperator/kubepi/pkg/loginsession"
+	"github.com/KubeOperator/kubepi/service/api/v1/session"
+	v1 "github.com/KubeOperator/kubepi/service/model/v1"
+	v1Role "github.com/KubeOperator/kubepi/service/model/v1/role"
+	v1User "github.com/KubeOperator/kubepi/service/model/v1/user"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"github.com/KubeOperator/kubepi/service/service/v1/role"
+	"github.com/KubeOperator/kubepi/service/service/v1/rolebinding"
+	"github.com/KubeOperator/kubepi/service/service/v1/user"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// bindingCreator marks the role bindings created through scim group membership
+const bindingCreator = "scim"
+
+const defaultRoleName = "Common User"
+
+const basePath = "/kubepi/api/scim/v2"
+
+const defaultCount = 100
+
+type Handler struct {
+	userService        user.Service
+	roleService        role.Service
+	roleBindingService rolebinding.Service
+}
+
+func NewHandler() *Handler {
+	return &Handler{
+		userService:        user.NewService(),
+		roleService:        role.NewService(),
+		roleBindingService: rolebinding.NewService(),
+	}
+}
+
+func write(ctx *context.Context, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+		return
+	}
+	ctx.ResponseWriter().Header().Set("Content-Type", server.ContentTypeScim)
+	ctx.StatusCode(status)
+	_, _ = ctx.Write(data)
+}
+
+func writeError(ctx *context.Context, status int, scimType string, detail string) {
+	data, _ := json.Marshal(Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+	ctx.ResponseWriter().Header().Set("Content-Type", server.ContentTypeScim)
+	ctx.StatusCode(status)
+	_, _ = ctx.Write(data)
+	ctx.StopExecution()
+}
+
+// authHandler authenticates the identity provider with the dedicated scim bearer token
+func authHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		c := server.Config().Spec.Scim
+		if !c.Enable || c.Token == "" {
+			writeError(ctx, iris.StatusNotFound, "", "scim provisioning is not enabled")
+			return
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer"))
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) != 1 {
+			writeError(ctx, iris.StatusUnauthorized, "", "invalid bearer token")
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func readBody(ctx *context.Context, v interface{}) bool {
+	if err := json.NewDecoder(ctx.Request().Body).Decode(v); err != nil {
+		writeError(ctx, iris.StatusBadRequest, "invalidSyntax", err.Error())
+		return false
+	}
+	return true
+}
+
+func pagination(ctx *context.Context) (int, int) {
+	startIndex := ctx.URLParamIntDefault("startIndex", 1)
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count := ctx.URLParamIntDefault("count", defaultCount)
+	if count < 0 {
+		count = 0
+	}
+	return startIndex, count
+}
+
+func listResponse(items []interface{}, startIndex, count int) ListResponse {
+	resp := ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(items),
+		StartIndex:   startIndex,
+		Resources:    []interface{}{},
+	}
+	from := startIndex - 1
+	if from < len(items) {
+		to := from + count
+		if to > len(items) {
+			to = len(items)
+		}
+		resp.Resources = items[from:to]
+	}
+	resp.ItemsPerPage = len(resp.Resources)
+	return resp
+}
+
+func (h *Handler) getUser(id string) (*v1User.User, error) {
+	var u v1User.User
+	if err := h.userService.GetDB(common.DBOptions{}).One("UUID", id, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (h *Handler) getRole(id string) (*v1Role.Role, error) {
+	var r v1Role.Role
+	if err := h.roleService.GetDB(common.DBOptions{}).One("UUID", id, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (h *Handler) userGroups(name string) ([]GroupRef, error) {
+	bindings, err := h.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: name}, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	var names []string
+	for i := range bindings {
+		names = append(names, bindings[i].RoleRef)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	roles, err := h.roleService.GetByNames(names, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	var refs []GroupRef
+	for i := range roles {
+		refs = append(refs, GroupRef{
+			Value:   roles[i].UUID,
+			Display: roles[i].Name,
+			Ref:     fmt.Sprintf("%s/Groups/%s", basePath, roles[i].UUID),
+		})
+	}
+	return refs, nil
+}
+
+func toUser(u *v1User.User, groups []GroupRef) User {
+	active := !u.Disabled
+	return User{
+		Schemas:     []string{SchemaUser},
+		Id:          u.UUID,
+		ExternalId:  u.ExternalId,
+		UserName:    u.Name,
+		Name:        &Name{Formatted: u.NickName},
+		DisplayName: u.NickName,
+		Emails:      []Email{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Groups:      groups,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      u.CreateAt,
+			LastModified: u.UpdateAt,
+			Location:     fmt.Sprintf("%s/Users/%s", basePath, u.UUID),
+		},
+	}
+}
+
+func userAttributes(u User) attributes {
+	attrs := attributes{
+		"id":          {u.Id},
+		"username":    {u.UserName},
+		"externalid":  {u.ExternalId},
+		"displayname": {u.DisplayName},
+	}
+	if u.Active != nil {
+		attrs["active"] = []string{strconv.FormatBool(*u.Active)}
+	}
+	if u.Name != nil {
+		attrs["name.formatted"] = []string{u.Name.Formatted}
+	}
+	for _, e := range u.Emails {
+		attrs["emails"] = append(attrs["emails"], e.Value)
+		attrs["emails.value"] = append(attrs["emails.value"], e.Value)
+	}
+	return attrs
+}
+
+func primaryEmail(u User) string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+func displayName(u User) string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name != nil {
+		if u.Name.Formatted != "" {
+			return u.Name.Formatted
+		}
+		if n := strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName); n != "" {
+			return n
+		}
+	}
+	return u.UserName
+}
+
+func (h *Handler) ListUsers() iris.Handler {
+	return func(ctx *context.Context) {
+		f, err := parseFilter(ctx.URLParam("filter"))
+		if err != nil {
+			writeError(ctx, iris.StatusBadRequest, "invalidFilter", err.Error())
+			return
+		}
+		users, err := h.userService.List(common.DBOptions{})
+		if err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		sort.Slice(users, func(i, j int) bool {
+			return users[i].CreateAt.Before(users[j].CreateAt)
+		})
+		items := make([]interface{}, 0)
+		for i := range users {
+			su := toUser(&users[i], nil)
+			if !f.match(userAttributes(su)) {
+				continue
+			}
+			items = append(items, su)
+		}
+		startIndex, count := pagination(ctx)
+		write(ctx, iris.StatusOK, listResponse(items, startIndex, count))
+	}
+}
+
+func (h *Handler) GetUser() iris.Handler {
+	return func(ctx *context.Context) {
+		u, err := h.getUser(ctx.Params().GetString("id"))
+		if err != nil {
+			writeError(ctx, iris.StatusNotFound, "", "user not found")
+			return
+		}
+		groups, err := h.userGroups(u.Name)
+		if err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		write(ctx, iris.StatusOK, toUser(u, groups))
+	}
+}
+
+func (h *Handler) CreateUser() iris.Handler {
+	return func(ctx *context.Context) {
+		var req User
+		if !readBody(ctx, &req) {
+			return
+		}
+		if req.UserName == "" {
+			writeError(ctx, iris.StatusBadRequest, "invalidValue", "userName is required")
+			return
+		}
+		if primaryEmail(req) == "" {
+			writeError(ctx, iris.StatusBadRequest, "invalidValue", "emails is required")
+			return
+		}
+		if existing, err := h.userService.GetByNameOrEmail(req.UserName, common.DBOptions{}); err == nil && existing != nil {
+			writeError(ctx, iris.StatusConflict, "uniqueness", "userName already exists")
+			return
+		}
+		u := &v1User.User{
+			BaseModel: v1.BaseModel{
+				ApiVersion: "v1",
+				Kind:       "User",
+				CreatedBy:  bindingCreator,
+			},
+			Metadata: v1.Metadata{
+				Name: req.UserName,
+			},
+			NickName:   displayName(req),
+			Email:      primaryEmail(req),
+			Type:       v1User.SCIM,
+			ExternalId: req.ExternalId,
+			Authenticate: v1User.Authenticate{
+				Password: req.Password,
+			},
+		}
+		if req.Active != nil {
+			u.Disabled = !*req.Active
+		}
+		tx, err := server.DB().Begin(true)
+		if err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		if err := h.userService.Create(u, common.DBOptions{DB: tx}); err != nil {
+			_ = tx.Rollback()
+			if errors.Is(err, storm.ErrAlreadyExists) {
+				writeError(ctx, iris.StatusConflict, "uniqueness", "userName or email already exists")
+				return
+			}
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		binding := rolebinding.NewUserRoleBinding(defaultRoleName, u.Name, "admin")
+		if err := h.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
+			_ = tx.Rollback()
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		_ = tx.Commit()
+		groups, _ := h.userGroups(u.Name)
+		write(ctx, iris.StatusCreated, toUser(u, groups))
+	}
+}
+
+// managed reports whether scim may change the user: only the users it provisioned, never administrators
+func managed(u *v1User.User) bool {
+	return u.Type == v1User.SCIM && !u.IsAdmin
+}
+
+func writeUnmanaged(ctx *context.Context) {
+	writeError(ctx, iris.StatusForbidden, "", "the user is not provisioned through scim")
+}
+
+// saveUser writes the scim representation back onto the KubePi user
+func (h *Handler) saveUser(u *v1User.User, su User) error {
+	if su.UserName != "" && su.UserName != u.Name {
+		return errMutability
+	}
+	db := h.userService.GetDB(common.DBOptions{})
+	u.NickName = displayName(su)
+	if email := primaryEmail(su); email != "" {
+		u.Email = email
+	}
+	u.UpdateAt = time.Now()
+	if err := db.Update(u); err != nil {
+		return err
+	}
+	if err := db.UpdateField(u, "ExternalId", su.ExternalId); err != nil {
+		return err
+	}
+	if su.Active != nil && u.Disabled == *su.Active {
+		if err := db.UpdateField(u, "Disabled", !*su.Active); err != nil {
+			return err
+		}
+		if !*su.Active {
+			// offboarding, terminate all sessions of the user
+			for _, s := range loginsession.LoginSessions.List(u.Name) {
+				session.RevokeLoginSession(s.Id, "the user has been disabled")
+			}
+		}
+	}
+	if su.Password != "" {
+		if err := h.userService.ResetPassword(u.Name, su.Password, common.DBOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errMutability = errors.New("userName can not be changed")
+
+func (h *Handler) writeSaveError(ctx *context.Context, err error) {
+	if errors.Is(err, errMutability) {
+		writeError(ctx, iris.StatusBadRequest, "mutability", err.Error())
+		return
+	}
+	if errors.Is(err, storm.ErrAlreadyExists) {
+		writeError(ctx, iris.StatusConflict, "uniqueness", err.Error())
+		return
+	}
+	writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+}
+
+func (h *Handler) ReplaceUser() iris.Handler {
+	return func(ctx *context.Context) {
+		u, err := h.getUser(ctx.Params().GetString("id"))
+		if err != nil {
+			writeError(ctx, iris.StatusNotFound, "", "user not found")
+			return
+		}
+		if !managed(u) {
+			writeUnmanaged(ctx)
+			return
+		}
+		var req User
+		if !readBody(ctx, &req) {
+			return
+		}
+		if err := h.saveUser(u, req); err != nil {
+			h.writeSaveError(ctx, err)
+			return
+		}
+		u, _ = h.getUser(u.UUID)
+		groups, _ := h.userGroups(u.Name)
+		write(ctx, iris.StatusOK, toUser(u, groups))
+	}
+}
+
+func (h *Handler) PatchUser() iris.Handler {
+	return func(ctx *context.Context) {
+		u, err := h.getUser(ctx.Params().GetString("id"))
+		if err != nil {
+			writeError(ctx, iris.StatusNotFound, "", "user not found")
+			return
+		}
+		if !managed(u) {
+			writeUnmanaged(ctx)
+			return
+		}
+		var req PatchRequest
+		if !readBody(ctx, &req) {
+			return
+		}
+		su := toUser(u, nil)
+		su.ExternalId = u.ExternalId
+		for _, op := range req.Operations {
+			if err := patchUser(&su, op); err != nil {
+				writeError(ctx, iris.StatusBadRequest, "invalidPath", err.Error())
+				return
+			}
+		}
+		if err := h.saveUser(u, su); err != nil {
+			h.writeSaveError(ctx, err)
+			return
+		}
+		u, _ = h.getUser(u.UUID)
+		groups, _ := h.userGroups(u.Name)
+		write(ctx, iris.StatusOK, toUser(u, groups))
+	}
+}
+
+func patchUser(su *User, op PatchOperation) error {
+	operation := strings.ToLower(op.Op)
+	if operation != "add" && operation != "replace" && operation != "remove" {
+		return fmt.Errorf("unsupported patch operation %s", op.Op)
+	}
+	if op.Path == "" {
+		values, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return errors.New("patch value must be an object when path is empty")
+		}
+		for k, v := range values {
+			if err := setUserAttribute(su, k, v, operation == "remove"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return setUserAttribute(su, op.Path, op.Value, operation == "remove")
+}
+
+func setUserAttribute(su *User, path string, value interface{}, remove bool) error {
+	str := func() string {
+		if remove || value == nil {
+			return ""
+		}
+		switch v := value.(type) {
+		case string:
+			return v
+		case []interface{}:
+			// some providers wrap single values, e.g. [{"value": "x"}]
+			if len(v) > 0 {
+				if m, ok := v[0].(map[string]interface{}); ok {
+					if s, ok := m["value"].(string); ok {
+						return s
+					}
+				}
+			}
+		}
+		return fmt.Sprintf("%v", value)
+	}
+	if su.Name == nil {
+		su.Name = &Name{}
+	}
+	lower := strings.ToLower(path)
+	switch {
+	case lower == "active":
+		active := !remove
+		switch v := value.(type) {
+		case bool:
+			active = v && !remove
+		case string:
+			active = strings.EqualFold(v, "true") && !remove
+		}
+		su.Active = &active
+	case lower == "username":
+		su.UserName = str()
+	case lower == "displayname":
+		su.DisplayName = str()
+	case lower == "externalid":
+		su.ExternalId = str()
+	case lower == "password":
+		su.Password = str()
+	case lower == "name.formatted":
+		su.Name.Formatted = str()
+		su.DisplayName = ""
+	case lower == "name.givenname":
+		su.Name.GivenName = str()
+		su.Name.Formatted = ""
+		su.DisplayName = ""
+	case lower == "name.familyname":
+		su.Name.FamilyName = str()
+		su.Name.Formatted = ""
+		su.DisplayName = ""
+	case lower == "name":
+		if m, ok := value.(map[string]interface{}); ok {
+			for k, v := range m {
+				if err := setUserAttribute(su, "name."+k, v, remove); err != nil {
+					return err
+				}
+			}
+		}
+	case strings.HasPrefix(lower, "emails"):
+		if remove {
+			su.Emails = nil
+			return nil
+		}
+		if list, ok := value.([]interface{}); ok {
+			su.Emails = nil
+			for _, item := range list {
+				if m, ok := item.(map[string]interface{}); ok {
+					e := Email{}
+					e.Value, _ = m["value"].(string)
+					e.Primary, _ = m["primary"].(bool)
+					su.Emails = append(su.Emails, e)
+				}
+			}
+			return nil
+		}
+		su.Emails = []Email{{Value: str(), Primary: true}}
+	case strings.HasPrefix(lower, "urn:") || lower == "schemas":
+		// extension attributes are not stored
+	default:
+		return fmt.Errorf("unsupported attribute path %s", path)
+	}
+	return nil
+}
+
+func (h *Handler) DeleteUser() iris.Handler {
+	return func(ctx *context.Context) {
+		u, err := h.getUser(ctx.Params().GetString("id"))
+		if err != nil {
+			writeError(ctx, iris.StatusNotFound, "", "user not found")
+			return
+		}
+		if !managed(u) {
+			writeUnmanaged(ctx)
+			return
+		}
+		if err := h.userService.DeleteWithBindings(u.Name, common.DBOptions{}); err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		for _, s := range loginsession.LoginSessions.List(u.Name) {
+			session.RevokeLoginSession(s.Id, "the user has been deleted")
+		}
+		ctx.StatusCode(iris.StatusNoContent)
+		ctx.ResponseWriter().Header().Set("Content-Type", server.ContentTypeScim)
+	}
+}
+
+// toGroup maps a KubePi role onto a scim group whose members are the users bound to it
+func (h *Handler) toGroup(r *v1Role.Role, usersByName map[string]v1User.User) (Group, error) {
+	g := Group{
+		Schemas:     []string{SchemaGroup},
+		Id:          r.UUID,
+		DisplayName: r.Name,
+		Members:     []Member{},
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      r.CreateAt,
+			LastModified: r.UpdateAt,
+			Location:     fmt.Sprintf("%s/Groups/%s", basePath, r.UUID),
+		},
+	}
+	bindings, err := h.roleBindingService.GetRoleBindingsByRoleName(r.Name, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return g, err
+	}
+	for i := range bindings {
+		if bindings[i].Subject.Kind != "User" {
+			continue
+		}
+		u, ok := usersByName[bindings[i].Subject.Name]
+		if !ok {
+			continue
+		}
+		g.Members = append(g.Members, Member{
+			Value:   u.UUID,
+			Display: u.Name,
+			Ref:     fmt.Sprintf("%s/Users/%s", basePath, u.UUID),
+		})
+	}
+	return g, nil
+}
+
+func groupAttributes(g Group) attributes {
+	attrs := attributes{
+		"id":          {g.Id},
+		"displayname": {g.DisplayName},
+		"externalid":  {g.ExternalId},
+	}
+	for _, m := range g.Members {
+		attrs["members"] = append(attrs["members"], m.Value)
+		attrs["members.value"] = append(attrs["members.value"], m.Value)
+	}
+	return attrs
+}
+
+func (h *Handler) usersByName() (map[string]v1User.User, map[string]v1User.User, error) {
+	users, err := h.userService.List(common.DBOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	byName := map[string]v1User.User{}
+	byId := map[string]v1User.User{}
+	for i := range users {
+		byName[users[i].Name] = users[i]
+		byId[users[i].UUID] = users[i]
+	}
+	return byName, byId, nil
+}
+
+func (h *Handler) ListGroups() iris.Handler {
+	return func(ctx *context.Context) {
+		f, err := parseFilter(ctx.URLParam("filter"))
+		if err != nil {
+			writeError(ctx, iris.StatusBadRequest, "invalidFilter", err.Error())
+			return
+		}
+		roles, err := h.roleService.List(common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		byName, _, err := h.usersByName()
+		if err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		sort.Slice(roles, func(i, j int) bool {
+			return roles[i].CreateAt.Before(roles[j].CreateAt)
+		})
+		excludeMembers := strings.Contains(strings.ToLower(ctx.URLParam("excludedAttributes")), "members")
+		items := make([]interface{}, 0)
+		for i := range roles {
+			g, err := h.toGroup(&roles[i], byName)
+			if err != nil {
+				writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+				return
+			}
+			if !f.match(groupAttributes(g)) {
+				continue
+			}
+			if excludeMembers {
+				g.Members = []Member{}
+			}
+			items = append(items, g)
+		}
+		startIndex, count := pagination(ctx)
+		write(ctx, iris.StatusOK, listResponse(items, startIndex, count))
+	}
+}
+
+func (h *Handler) GetGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		r, err := h.getRole(ctx.Params().GetString("id"))
+		if err != nil {
+			writeError(ctx, iris.StatusNotFound, "", "group not found")
+			return
+		}
+		byName, _, err := h.usersByName()
+		if err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		g, err := h.toGroup(r, byName)
+		if err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		write(ctx, iris.StatusOK, g)
+	}
+}
+
+func (h *Handler) CreateGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		var req Group
+		if !readBody(ctx, &req) {
+			return
+		}
+		if req.DisplayName == "" {
+			writeError(ctx, iris.StatusBadRequest, "invalidValue", "displayName is required")
+			return
+		}
+		if _, err := h.roleService.Get(req.DisplayName, common.DBOptions{}); err == nil {
+			writeError(ctx, iris.StatusConflict, "uniqueness", "group already exists")
+			return
+		}
+		// the role starts without rules, administrators grant permissions in KubePi
+		r := &v1Role.Role{
+			BaseModel: v1.BaseModel{
+				ApiVersion: "v1",
+				Kind:       "Role",
+				CreatedBy:  bindingCreator,
+			},
+			Metadata: v1.Metadata{
+				Name: req.DisplayName,
+			},
+			Rules: []v1Role.PolicyRule{},
+		}
+		if err := h.roleService.Create(r, common.DBOptions{}); err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		if err := h.setMembers(r, req.Members, true); err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		byName, _, _ := h.usersByName()
+		g, _ := h.toGroup(r, byName)
+		write(ctx, iris.StatusCreated, g)
+	}
+}
+
+// setMembers adds the given members to the role, when exclusive the users not listed are unbound. Only the
+// users and the bindings provisioned through scim are changed, the ones of administrators stay.
+func (h *Handler) setMembers(r *v1Role.Role, members []Member, exclusive bool) error {
+	_, byId, err := h.usersByName()
+	if err != nil {
+		return err
+	}
+	desired := collectons.NewStringSet()
+	for _, m := range members {
+		if u, ok := byId[m.Value]; ok && managed(&u) {
+			desired.Add(u.Name)
+		}
+	}
+	bindings, err := h.roleBindingService.GetRoleBindingsByRoleName(r.Name, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	current := collectons.NewStringSet()
+	for i := range bindings {
+		if bindings[i].Subject.Kind != "User" {
+			continue
+		}
+		current.Add(bindings[i].Subject.Name)
+		if exclusive && bindings[i].CreatedBy == bindingCreator && !desired.Exists(bindings[i].Subject.Name) {
+			if err := h.roleBindingService.Delete(bindings[i].Name, common.DBOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range desired.ToSlice() {
+		if current.Exists(name) {
+			continue
+		}
+		binding := rolebinding.NewUserRoleBinding(r.Name, name, bindingCreator)
+		if err := h.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) removeMembers(r *v1Role.Role, ids []string) error {
+	_, byId, err := h.usersByName()
+	if err != nil {
+		return err
+	}
+	names := collectons.NewStringSet()
+	for _, id := range ids {
+		if u, ok := byId[id]; ok {
+			names.Add(u.Name)
+		}
+	}
+	bindings, err := h.roleBindingService.GetRoleBindingsByRoleName(r.Name, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	for i := range bindings {
+		if bindings[i].Subject.Kind == "User" && bindings[i].CreatedBy == bindingCreator && names.Exists(bindings[i].Subject.Name) {
+			if err := h.roleBindingService.Delete(bindings[i].Name, common.DBOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Handler) ReplaceGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		r, err := h.getRole(ctx.Params().GetString("id"))
+		if err != nil {
+			writeError(ctx, iris.StatusNotFound, "", "group not found")
+			return
+		}
+		var req Group
+		if !readBody(ctx, &req) {
+			return
+		}
+		if req.DisplayName != "" && req.DisplayName != r.Name {
+			writeError(ctx, iris.StatusBadRequest, "mutability", "displayName can not be changed")
+			return
+		}
+		if err := h.setMembers(r, req.Members, true); err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		byName, _, _ := h.usersByName()
+		g, _ := h.toGroup(r, byName)
+		write(ctx, iris.StatusOK, g)
+	}
+}
+
+func (h *Handler) PatchGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		r, err := h.getRole(ctx.Params().GetString("id"))
+		if err != nil {
+			writeError(ctx, iris.StatusNotFound, "", "group not found")
+			return
+		}
+		var req PatchRequest
+		if !readBody(ctx, &req) {
+			return
+		}
+		for _, op := range req.Operations {
+			if err := h.patchGroup(r, op); err != nil {
+				writeError(ctx, iris.StatusBadRequest, "invalidValue", err.Error())
+				return
+			}
+		}
+		byName, _, _ := h.usersByName()
+		g, _ := h.toGroup(r, byName)
+		write(ctx, iris.StatusOK, g)
+	}
+}
+
+func (h *Handler) patchGroup(r *v1Role.Role, op PatchOperation) error {
+	operation := strings.ToLower(op.Op)
+	path := strings.ToLower(op.Path)
+	if path == "" {
+		values, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return errors.New("patch value must be an object when path is empty")
+		}
+		for k, v := range values {
+			if err := h.patchGroup(r, PatchOperation{Op: op.Op, Path: k, Value: v}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch {
+	case path == "displayname":
+		if name, _ := op.Value.(string); name != r.Name {
+			return errors.New("displayName can not be changed")
+		}
+		return nil
+	case path == "externalid":
+		return nil
+	case path == "members":
+		members := parseMembers(op.Value)
+		switch operation {
+		case "add":
+			return h.setMembers(r, members, false)
+		case "replace":
+			return h.setMembers(r, members, true)
+		case "remove":
+			if len(members) == 0 {
+				return h.setMembers(r, nil, true)
+			}
+			var ids []string
+			for _, m := range members {
+				ids = append(ids, m.Value)
+			}
+			return h.removeMembers(r, ids)
+		}
+	case strings.HasPrefix(path, "members[") && operation == "remove":
+		// members[value eq "id"]
+		expr := strings.TrimSuffix(strings.TrimPrefix(op.Path[len("members"):], "["), "]")
+		f, err := parseFilter(expr)
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for _, group := range f {
+			for _, c := range group {
+				if c.attr == "value" && c.op == "eq" {
+					ids = append(ids, c.value)
+				}
+			}
+		}
+		return h.removeMembers(r, ids)
+	}
+	return fmt.Errorf("unsupported patch operation %s on %s", op.Op, op.Path)
+}
+
+func parseMembers(value interface{}) []Member {
+	var members []Member
+	list, ok := value.([]interface{})
+	if !ok {
+		if value != nil {
+			list = []interface{}{value}
+		}
+	}
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			if v, ok := m["value"].(string); ok {
+				members = append(members, Member{Value: v})
+			}
+		}
+	}
+	return members
+}
+
+func (h *Handler) DeleteGroup() iris.Handler {
+	return func(ctx *context.Context) {
+		r, err := h.getRole(ctx.Params().GetString("id"))
+		if err != nil {
+			writeError(ctx, iris.StatusNotFound, "", "group not found")
+			return
+		}
+		if r.BuiltIn {
+			writeError(ctx, iris.StatusBadRequest, "mutability", "can not delete a built-in role")
+			return
+		}
+		if r.CreatedBy != bindingCreator {
+			writeError(ctx, iris.StatusForbidden, "", "the group is not provisioned through scim")
+			return
+		}
+		if err := h.deleteGroup(r); err != nil {
+			writeError(ctx, iris.StatusInternalServerError, "", err.Error())
+			return
+		}
+		ctx.StatusCode(iris.StatusNoContent)
+		ctx.ResponseWriter().Header().Set("Content-Type", server.ContentTypeScim)
+	}
+}
+
+// deleteGroup removes the role together with all of its bindings, the ones administrators added as well
+func (h *Handler) deleteGroup(r *v1Role.Role) error {
+	tx, err := server.DB().Begin(true)
+	if err != nil {
+		return err
+	}
+	bindings, err := h.roleBindingService.GetRoleBindingsByRoleName(r.Name, common.DBOptions{DB: tx})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		_ = tx.Rollback()
+		return err
+	}
+	for i := range bindings {
+		if err := h.roleBindingService.Delete(bindings[i].Name, common.DBOptions{DB: tx}); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := h.roleService.Delete(r.Name, common.DBOptions{DB: tx}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (h *Handler) GetServiceProviderConfig() iris.Handler {
+	return func(ctx *context.Context) {
+		write(ctx, iris.StatusOK, ServiceProviderConfig{
+			Schemas:        []string{SchemaServiceProviderConfig},
+			Patch:          supported{Supported: true},
+			Bulk:           bulkSupported{Supported: false},
+			Filter:         filterSupported{Supported: true, MaxResults: defaultCount},
+			ChangePassword: supported{Supported: true},
+			Sort:           supported{Supported: false},
+			Etag:           supported{Supported: false},
+			AuthenticationSchemes: []authenticationScheme{
+				{
+					Type:        "oauthbearertoken",
+					Name:        "OAuth Bearer Token",
+					Description: "Authentication with the scim token configured in KubePi",
+					Primary:     true,
+				},
+			},
+			Meta: Meta{
+				ResourceType: "ServiceProviderConfig",
+				Location:     basePath + "/ServiceProviderConfig",
+			},
+		})
+	}
+}
+
+func (h *Handler) ListResourceTypes() iris.Handler {
+	return func(ctx *context.Context) {
+		items := []interface{}{
+			ResourceType{
+				Schemas:     []string{SchemaResourceType},
+				Id:          "User",
+				Name:        "User",
+				Endpoint:    "/Users",
+				Description: "KubePi users",
+				Schema:      SchemaUser,
+			},
+			ResourceType{
+				Schemas:     []string{SchemaResourceType},
+				Id:          "Group",
+				Name:        "Group",
+				Endpoint:    "/Groups",
+				Description: "KubePi roles, members are the users bound to the role",
+				Schema:      SchemaGroup,
+			},
+		}
+		write(ctx, iris.StatusOK, listResponse(items, 1, len(items)))
+	}
+}
+
+func Install(parent iris.Party) {
+	handler := NewHandler()
+	sp := parent.Party("/scim/v2")
+	sp.Use(authHandler())
+	sp.Get("/ServiceProviderConfig", handler.GetServiceProviderConfig())
+	sp.Get("/ResourceTypes", handler.ListResourceTypes())
+	sp.Get("/Users", handler.ListUsers())
+	sp.Post("/Users", handler.CreateUser())
+	sp.Get("/Users/{id}", handler.GetUser())
+	sp.Put("/Users/{id}", handler.ReplaceUser())
+	sp.Patch("/Users/{id}", handler.PatchUser())
+	sp.Delete("/Users/{id}", handler.DeleteUser())
+	sp.Get("/Groups", handler.ListGroups())
+	sp.Post("/Groups", handler.CreateGroup())
+	sp.Get("/Groups/{id}", handler.GetGroup())
+	sp.Put("/Groups/{id}", handler.ReplaceGroup())
+	sp.Patch("/Groups/{id}", handler.PatchGroup())
+	sp.Delete("/Groups/{id}", handler.DeleteGroup())
+}
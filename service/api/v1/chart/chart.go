@@ -1,20 +1,35 @@
 package chart
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/KubeOperator/kubepi/pkg/util/helm"
+	"github.com/KubeOperator/kubepi/service/api/v1/session"
+	v1 "github.com/KubeOperator/kubepi/service/model/v1"
+	v1Chart "github.com/KubeOperator/kubepi/service/model/v1/chart"
+	"github.com/KubeOperator/kubepi/service/server"
 	"github.com/KubeOperator/kubepi/service/service/v1/chart"
+	"github.com/KubeOperator/kubepi/service/service/v1/clusterbinding"
+	"github.com/KubeOperator/kubepi/service/service/v1/common"
 	pkgV1 "github.com/KubeOperator/kubepi/pkg/api/v1"
+	"github.com/asdine/storm/v3"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
+	"io"
 	"strings"
+	"time"
 )
 
 type Handler struct {
-	chartService chart.Service
+	chartService          chart.Service
+	clusterBindingService clusterbinding.Service
 }
 
 func NewHandler() *Handler {
 	return &Handler{
-		chartService: chart.NewService(),
+		chartService:          chart.NewService(),
+		clusterBindingService: clusterbinding.NewService(),
 	}
 }
 
@@ -195,31 +210,217 @@ func (h *Handler) InstallChart() iris.Handler {
 		if err := ctx.ReadJSON(&req); err != nil {
 			ctx.StatusCode(iris.StatusBadRequest)
 			ctx.Values().Set("message", err.Error())
+			return
 		}
-		err := h.chartService.InstallChart(req.Cluster, req.Repo, req.Namespace, req.Name, req.ChartName, req.ChartVersion, req.Values)
+		h.submitJob(ctx, v1Chart.JobActionInstall, &req)
+	}
+}
+
+// submitJob runs the helm action in the background, the response is the job to poll for the outcome
+func (h *Handler) submitJob(ctx *context.Context, action string, req *ChInstall) {
+	profile := ctx.Values().Get("profile").(session.UserProfile)
+	job, err := h.chartService.SubmitJob(&v1Chart.Job{
+		BaseModel:    v1.BaseModel{CreatedBy: profile.Name},
+		Cluster:      req.Cluster,
+		Namespace:    req.Namespace,
+		Release:      req.Name,
+		Action:       action,
+		Repo:         req.Repo,
+		ChartName:    req.ChartName,
+		ChartVersion: req.ChartVersion,
+		Wait:         req.Wait,
+		Timeout:      req.Timeout,
+		Upload:       req.Upload,
+	}, req.Values)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return
+	}
+	ctx.Values().Set("data", job)
+}
+
+func (h *Handler) ListJobs() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		jobs, err := h.chartService.ListJobs(cluster)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		ctx.Values().Set("data", &req)
+		ctx.Values().Set("data", jobs)
 	}
 }
 
-func (h *Handler) UpdateChart() iris.Handler {
+// GetJob returns the job with the log lines from the offset on, to poll the progress incrementally
+func (h *Handler) GetJob() iris.Handler {
 	return func(ctx *context.Context) {
-		var req ChInstall
+		cluster := ctx.Params().GetString("cluster")
+		id := ctx.Params().GetString("id")
+		job, err := h.chartService.GetJob(cluster, id)
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+			} else {
+				ctx.StatusCode(iris.StatusInternalServerError)
+			}
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		offset := ctx.URLParamIntDefault("offset", 0)
+		if offset > 0 {
+			if offset > len(job.Log) {
+				offset = len(job.Log)
+			}
+			job.Log = job.Log[offset:]
+		}
+		ctx.Values().Set("data", job)
+	}
+}
+
+func (h *Handler) CancelJob() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		id := ctx.Params().GetString("id")
+		if err := h.chartService.CancelJob(cluster, id); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+			} else {
+				ctx.StatusCode(iris.StatusBadRequest)
+			}
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", "")
+	}
+}
+
+// StreamJob sends the log lines of the job as server sent events until the job is finished,
+// the last event carries the job without its log.
+func (h *Handler) StreamJob() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		id := ctx.Params().GetString("id")
+		job, err := h.chartService.GetJob(cluster, id)
+		if err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+			} else {
+				ctx.StatusCode(iris.StatusInternalServerError)
+			}
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.ContentType(server.ContentTypeEventStream)
+		ctx.Header("Cache-Control", "no-cache")
+		offset := ctx.URLParamIntDefault("offset", 0)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			for ; offset < len(job.Log); offset++ {
+				_, _ = fmt.Fprintf(ctx.ResponseWriter(), "event: log\nid: %d\ndata: %s\n\n", offset+1, strings.ReplaceAll(job.Log[offset], "\n", "\ndata: "))
+			}
+			if job.Finished() {
+				job.Log = nil
+				data, _ := json.Marshal(job)
+				_, _ = fmt.Fprintf(ctx.ResponseWriter(), "event: job\ndata: %s\n\n", data)
+				ctx.ResponseWriter().Flush()
+				return
+			}
+			ctx.ResponseWriter().Flush()
+			select {
+			case <-ctx.Request().Context().Done():
+				return
+			case <-ticker.C:
+			}
+			if job, err = h.chartService.GetJob(cluster, id); err != nil {
+				_, _ = fmt.Fprintf(ctx.ResponseWriter(), "event: error\ndata: %s\n\n", strings.ReplaceAll(err.Error(), "\n", "\ndata: "))
+				return
+			}
+			if offset > len(job.Log) {
+				// the log was trimmed to its last lines
+				offset = 0
+			}
+		}
+	}
+}
+
+// UploadChart validates a chart archive posted as the multipart file "file", the returned id
+// installs or upgrades from it. With keep=true the chart is added to the local chart repository.
+func (h *Handler) UploadChart() iris.Handler {
+	return func(ctx *context.Context) {
+		ctx.SetMaxRequestBodySize(helm.MaxArchiveSize + 1<<20)
+		f, _, err := ctx.FormFile("file")
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, helm.MaxArchiveSize+1))
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		keep := ctx.FormValue("keep") == "true"
+		// keeping the chart pushes it to the local chart repository
+		if keep && !session.HasVerb(ctx, "chartrepos", "create") {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", "pushing charts to the local chart repository needs the create permission of chartrepos")
+			return
+		}
+		upload, err := h.chartService.UploadChart(data, keep)
+		if err != nil {
+			if errors.Is(err, helm.ErrVersionExists) {
+				ctx.StatusCode(iris.StatusConflict)
+			} else {
+				ctx.StatusCode(iris.StatusBadRequest)
+			}
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", upload)
+	}
+}
+
+func (h *Handler) PreviewChart() iris.Handler {
+	return func(ctx *context.Context) {
+		var req ChPreview
 		if err := ctx.ReadJSON(&req); err != nil {
 			ctx.StatusCode(iris.StatusBadRequest)
 			ctx.Values().Set("message", err.Error())
+			return
+		}
+		cluster := ctx.Params().GetString("cluster")
+		var (
+			preview *helm.Preview
+			err     error
+		)
+		if req.Upload != "" {
+			preview, err = h.chartService.PreviewUpload(cluster, req.Namespace, req.Name, req.Upload, req.Values, req.ServerDryRun)
+		} else {
+			preview, err = h.chartService.PreviewChart(cluster, req.Namespace, req.Repo, req.Name, req.ChartName, req.ChartVersion, req.Values, req.ServerDryRun)
 		}
-		err := h.chartService.UpgradeChart(req.Cluster, req.Namespace, req.Repo, req.Name, req.ChartName, req.ChartVersion, req.Values)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		ctx.Values().Set("data", &req)
+		ctx.Values().Set("data", preview)
+	}
+}
+
+func (h *Handler) UpdateChart() iris.Handler {
+	return func(ctx *context.Context) {
+		var req ChInstall
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		h.submitJob(ctx, v1Chart.JobActionUpgrade, &req)
 	}
 }
 
@@ -240,32 +441,223 @@ func (h *Handler) AllInstalled() iris.Handler {
 	}
 }
 
+// Inventory lists the helm releases of all clusters the user is bound to, filtered by
+// ?cluster (repeatable or comma separated), ?chart, ?repo and ?outdated
+func (h *Handler) Inventory() iris.Handler {
+	return func(ctx *context.Context) {
+		filter := v1Chart.InventoryFilter{
+			Chart:    ctx.URLParam("chart"),
+			Repo:     ctx.URLParam("repo"),
+			Outdated: ctx.URLParamBoolDefault("outdated", false),
+		}
+		for _, param := range ctx.URLParamSlice("cluster") {
+			for _, name := range strings.Split(param, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					filter.Clusters = append(filter.Clusters, name)
+				}
+			}
+		}
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		if !profile.IsAdministrator {
+			bindings, err := h.clusterBindingService.GetBindingsByUserName(profile.Name, common.DBOptions{})
+			if err != nil && !errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			bound := map[string]bool{}
+			for _, b := range bindings {
+				bound[b.ClusterRef] = true
+			}
+			var clusters []string
+			if len(filter.Clusters) == 0 {
+				for name := range bound {
+					clusters = append(clusters, name)
+				}
+			}
+			for _, name := range filter.Clusters {
+				if bound[name] {
+					clusters = append(clusters, name)
+				}
+			}
+			if len(clusters) == 0 {
+				ctx.Values().Set("data", &v1Chart.Inventory{Items: []v1Chart.InventoryItem{}, Errors: map[string]string{}})
+				return
+			}
+			filter.Clusters = clusters
+		}
+		inventory, err := h.chartService.Inventory(filter)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", inventory)
+	}
+}
+
 func (h *Handler) UnInstall() iris.Handler {
 	return func(ctx *context.Context) {
 		cluster := ctx.Params().GetString("cluster")
 		name := ctx.Params().GetString("name")
 		namespace := ctx.Params().GetString("namespace")
-		err := h.chartService.UnInstallChart(cluster, namespace, name)
+		h.submitJob(ctx, v1Chart.JobActionUninstall, &ChInstall{
+			Cluster:   cluster,
+			Namespace: namespace,
+			Name:      name,
+			Wait:      ctx.URLParamBoolDefault("wait", false),
+			Timeout:   ctx.URLParamIntDefault("timeout", 0),
+		})
+	}
+}
+
+func (h *Handler) GetAppDetail() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		name := ctx.Params().GetString("name")
+		data, err := h.chartService.GetAppDetail(cluster, name)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		ctx.Values().Set("data", "")
+		ctx.Values().Set("data", data)
 	}
 }
 
-func (h *Handler) GetAppDetail() iris.Handler {
+func (h *Handler) GetAppHistory() iris.Handler {
 	return func(ctx *context.Context) {
 		cluster := ctx.Params().GetString("cluster")
+		namespace := ctx.Params().GetString("namespace")
 		name := ctx.Params().GetString("name")
-		data, err := h.chartService.GetAppDetail(cluster, name)
+		history, err := h.chartService.ListAppHistory(cluster, namespace, name)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		ctx.Values().Set("data", data)
+		ctx.Values().Set("data", history)
+	}
+}
+
+func (h *Handler) RollbackApp() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		namespace := ctx.Params().GetString("namespace")
+		name := ctx.Params().GetString("name")
+		var req AppRollback
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if req.Revision < 0 {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "invalid revision")
+			return
+		}
+		if err := h.chartService.RollbackApp(cluster, namespace, name, req.Revision); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", &req)
+	}
+}
+
+func (h *Handler) DiffAppValues() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		namespace := ctx.Params().GetString("namespace")
+		name := ctx.Params().GetString("name")
+		from := ctx.URLParamIntDefault("from", 0)
+		to := ctx.URLParamIntDefault("to", 0)
+		all := ctx.URLParamBoolDefault("all", false)
+		diff, err := h.chartService.DiffAppValues(cluster, namespace, name, from, to, all)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", diff)
+	}
+}
+
+func (h *Handler) ListRegistries() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		hosts, err := h.chartService.ListRegistries(cluster)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", hosts)
+	}
+}
+
+func (h *Handler) RegistryLogin() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		var req RegistryLogin
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if req.Host == "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "registry host is required")
+			return
+		}
+		if err := h.chartService.RegistryLogin(cluster, &req.RegistryLogin); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", req.Host)
+	}
+}
+
+func (h *Handler) RegistryLogout() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		host := ctx.Params().GetString("host")
+		if err := h.chartService.RegistryLogout(cluster, host); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", "")
+	}
+}
+
+func (h *Handler) GetOciChartVersions() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		ref := ctx.URLParam("ref")
+		versions, err := h.chartService.GetOciChartVersions(cluster, ref)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", versions)
+	}
+}
+
+func (h *Handler) GetOciChartDetail() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("cluster")
+		ref := ctx.URLParam("ref")
+		version := ctx.URLParam("version")
+		cs, err := h.chartService.GetChartByVersion(cluster, "", ref, version)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", cs)
 	}
 }
 
@@ -283,10 +675,25 @@ func Install(parent iris.Party) {
 	sp.Get("/search", handler.ListCharts())
 	sp.Get("/detail/:name", handler.GetChartByVersion())
 	sp.Post("/install", handler.InstallChart())
+	sp.Post("/preview", handler.PreviewChart())
+	sp.Post("/upload", handler.UploadChart())
+	sp.Get("/jobs", handler.ListJobs())
+	sp.Get("/jobs/:id", handler.GetJob())
+	sp.Get("/jobs/:id/stream", handler.StreamJob())
+	sp.Put("/jobs/:id/cancel", handler.CancelJob())
+	sp.Get("/registries", handler.ListRegistries())
+	sp.Post("/registries", handler.RegistryLogin())
+	sp.Delete("/registries/:host", handler.RegistryLogout())
+	sp.Get("/oci/versions", handler.GetOciChartVersions())
+	sp.Get("/oci/detail", handler.GetOciChartDetail())
+	parent.Get("/apps/inventory", handler.Inventory())
 	app := parent.Party("/apps/:cluster")
 	app.Get("/search", handler.AllInstalled())
 	app.Delete("/:namespace/:name", handler.UnInstall())
 	app.Get("/:name", handler.GetAppDetail())
 	app.Get("/update/:name", handler.GetChartForUpdate())
 	app.Put("/upgrade/:name", handler.UpdateChart())
+	app.Get("/history/:namespace/:name", handler.GetAppHistory())
+	app.Get("/diff/:namespace/:name", handler.DiffAppValues())
+	app.Put("/rollback/:namespace/:name", handler.RollbackApp())
 }
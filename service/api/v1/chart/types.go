@@ -29,6 +29,10 @@ type Detail struct {
 	Versions string `json:"versions"`
 }
 
+type RegistryLogin struct {
+	v1Chart.RegistryLogin
+}
+
 type ChInstall struct {
 	Name         string                 `json:"name"`
 	ChartName    string                 `json:"chartName"`
@@ -37,6 +41,22 @@ type ChInstall struct {
 	Cluster      string                 `json:"cluster"`
 	Values       map[string]interface{} `json:"values"`
 	Namespace    string                 `json:"namespace"`
+	// Upload is the id of an uploaded chart archive to install instead of the repo chart
+	Upload string `json:"upload"`
+	// Wait and Timeout in seconds apply to the helm job running the install or upgrade
+	Wait    bool `json:"wait"`
+	Timeout int  `json:"timeout"`
+}
+
+type ChPreview struct {
+	ChInstall
+	// ServerDryRun also sends the rendered resources to the api server as a dry run
+	ServerDryRun bool `json:"serverDryRun"`
+}
+
+type AppRollback struct {
+	// Revision to roll back to, 0 is the previous revision
+	Revision int `json:"revision"`
 }
 
 type HelmInstalled struct {
@@ -0,0 +1,104 @@
+package chartrepo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/KubeOperator/kubepi/pkg/util/helm"
+	"github.com/KubeOperator/kubepi/service/api/chartrepo"
+	"github.com/KubeOperator/kubepi/service/service/v1/chart"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+type Handler struct {
+	chartService chart.Service
+}
+
+func NewHandler() *Handler {
+	return &Handler{
+		chartService: chart.NewService(),
+	}
+}
+
+// GetRepo returns the url to add the local chart repository to a cluster with
+func (h *Handler) GetRepo() iris.Handler {
+	return func(ctx *context.Context) {
+		ctx.Values().Set("data", h.chartService.GetLocalRepo())
+	}
+}
+
+func (h *Handler) ListCharts() iris.Handler {
+	return func(ctx *context.Context) {
+		charts, err := h.chartService.ListLocalCharts()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", charts)
+	}
+}
+
+func (h *Handler) GetChart() iris.Handler {
+	return func(ctx *context.Context) {
+		versions, err := h.chartService.GetLocalChart(ctx.Params().GetString("name"))
+		if err != nil {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", versions)
+	}
+}
+
+func (h *Handler) PushChart() iris.Handler {
+	return func(ctx *context.Context) {
+		data, err := chartrepo.ReadArchive(ctx)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		metadata, err := h.chartService.PushLocalChart(data, ctx.URLParamBoolDefault("force", false))
+		if err != nil {
+			if errors.Is(err, helm.ErrVersionExists) {
+				ctx.StatusCode(iris.StatusConflict)
+			} else {
+				ctx.StatusCode(iris.StatusBadRequest)
+			}
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", metadata)
+	}
+}
+
+func (h *Handler) DeleteChart() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		version := ctx.Params().GetString("version")
+		if err := h.chartService.DeleteLocalChart(name, version); err != nil {
+			if os.IsNotExist(err) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", fmt.Sprintf("chart %s version %s not found", name, version))
+			} else {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+			}
+			return
+		}
+		ctx.Values().Set("data", "")
+	}
+}
+
+func Install(parent iris.Party) {
+	handler := NewHandler()
+	sp := parent.Party("/chartrepos")
+	sp.Get("/", handler.GetRepo())
+	sp.Get("/charts", handler.ListCharts())
+	sp.Get("/charts/:name", handler.GetChart())
+	sp.Post("/charts", handler.PushChart())
+	sp.Delete("/charts/:name/:version", handler.DeleteChart())
+}
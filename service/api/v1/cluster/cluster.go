@@ -20,6 +20,8 @@ import (
 	"github.com/KubeOperator/kubepi/service/service/v1/cluster"
 	"github.com/KubeOperator/kubepi/service/service/v1/clusterbinding"
 	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"github.com/KubeOperator/kubepi/service/service/v1/rolebinding"
+	"github.com/KubeOperator/kubepi/service/service/v1/system"
 	pkgV1 "github.com/KubeOperator/kubepi/pkg/api/v1"
 	"github.com/KubeOperator/kubepi/pkg/certificate"
 	"github.com/KubeOperator/kubepi/pkg/kubernetes"
@@ -36,6 +38,8 @@ type Handler struct {
 	clusterRepoService    clusterrepo.Service
 	imageRepoService      imagerepo.Service
 	clusterAppService     clusterapp.Service
+	roleBindingService    rolebinding.Service
+	systemService         system.Service
 }
 
 func NewHandler() *Handler {
@@ -45,6 +49,8 @@ func NewHandler() *Handler {
 		clusterRepoService:    clusterrepo.NewService(),
 		imageRepoService:      imagerepo.NewService(),
 		clusterAppService:     clusterapp.NewService(),
+		roleBindingService:    rolebinding.NewService(),
+		systemService:         system.NewService(),
 	}
 }
 
@@ -596,6 +602,7 @@ func (h *Handler) DeleteCluster() iris.Handler {
 		}
 		k := kubernetes.NewKubernetes(c)
 		_ = k.CleanAllRBACResource()
+		_ = k.DeletePullSecrets("")
 		_ = tx.Commit()
 		ctx.StatusCode(iris.StatusOK)
 	}
@@ -623,9 +630,19 @@ func Install(parent iris.Party) {
 	sp.Get("/:name/apigroups/{group:path}", handler.ListApiGroupResources())
 	sp.Get("/:name/namespaces", handler.ListNamespace())
 	sp.Get("/:name/terminal/session", handler.TerminalSessionHandler())
+	sp.Get("/:name/terminal/debug", handler.DebugTerminalSessionHandler())
+	sp.Get("/:name/terminal/node", handler.NodeShellSessionHandler())
+	sp.Get("/:name/terminal/join", handler.JoinTerminalHandler())
+	sp.Get("/:name/terminal/sessions/:id/shares", handler.ListTerminalSharingHandler())
+	sp.Post("/:name/terminal/sessions/:id/shares", handler.ShareTerminalHandler())
+	sp.Delete("/:name/terminal/sessions/:id/shares", handler.RevokeTerminalSharesHandler())
 	sp.Get("/:name/logging/session", handler.LoggingHandler())
+	sp.Get("/:name/logging/aggregate", handler.AggregatedLoggingHandler())
+	sp.Get("/:name/logging/download", handler.DownloadLoggingHandler())
 	sp.Get("/:name/repos", handler.ListClusterRepos())
 	sp.Get("/:name/repos/detail", handler.ListClusterReposDetail())
 	sp.Post("/:name/repos", handler.AddCLusterRepo())
 	sp.Delete("/:name/repos/:repo", handler.DeleteClusterRepo())
+	sp.Put("/:name/repos/:repo/pullsecret", handler.UpdateClusterRepoPullSecret())
+	sp.Post("/:name/repos/:repo/pullsecret/sync", handler.SyncClusterRepoPullSecret())
 }
@@ -1,6 +1,9 @@
 package cluster
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/KubeOperator/kubepi/service/model/v1/clusterrepo"
 	_ "github.com/KubeOperator/kubepi/service/model/v1/imagerepo"
 	"github.com/KubeOperator/kubepi/service/server"
@@ -85,6 +88,11 @@ func (h *Handler) AddCLusterRepo() iris.Handler {
 			clusterRepo := &clusterrepo.ClusterRepo{
 				Cluster: req.Cluster,
 				Repo:    v,
+				PullSecret: clusterrepo.PullSecret{
+					Enable:              req.PullSecret.Enable,
+					Namespaces:          req.PullSecret.Namespaces,
+					PatchServiceAccount: req.PullSecret.PatchServiceAccount,
+				},
 			}
 			err := h.clusterRepoService.Create(clusterRepo, txOptions)
 			if err != nil {
@@ -95,6 +103,19 @@ func (h *Handler) AddCLusterRepo() iris.Handler {
 			}
 		}
 		_ = tx.Commit()
+		if req.PullSecret.Enable {
+			var msgs []string
+			for _, v := range req.Repos {
+				if err := h.clusterRepoService.SyncPullSecret(req.Cluster, v); err != nil {
+					msgs = append(msgs, fmt.Sprintf("%s: %s", v, err.Error()))
+				}
+			}
+			if len(msgs) > 0 {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", fmt.Sprintf("repos are added, but the pull secrets failed: %s", strings.Join(msgs, "; ")))
+				return
+			}
+		}
 		ctx.Values().Set("data", &req)
 	}
 }
@@ -107,6 +128,7 @@ func (h *Handler) AddCLusterRepo() iris.Handler {
 // @Produce  json
 // @Param cluster path string true "集群名称"
 // @Param repo path string true "镜像仓库名称"
+// @Param force query bool false "keep the pull secrets when they can not be removed"
 // @Success 200 {number} 200
 // @Security ApiKeyAuth
 // @Router /clusters/{cluster}/repos/{repo} [delete]
@@ -114,7 +136,15 @@ func (h *Handler) DeleteClusterRepo() iris.Handler {
 	return func(ctx *context.Context) {
 		cluster := ctx.Params().GetString("name")
 		repo := ctx.Params().GetString("repo")
-		err := h.clusterRepoService.Delete(cluster, repo, common.DBOptions{})
+		clusterRepo, err := h.clusterRepoService.Get(cluster, repo, common.DBOptions{})
+		if err == nil && clusterRepo.PullSecret.Enable {
+			if err := h.clusterRepoService.RemovePullSecret(cluster, repo); err != nil && !ctx.URLParamBoolDefault("force", false) {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", fmt.Sprintf("remove pull secrets failed: %s", err.Error()))
+				return
+			}
+		}
+		err = h.clusterRepoService.Delete(cluster, repo, common.DBOptions{})
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -123,3 +153,65 @@ func (h *Handler) DeleteClusterRepo() iris.Handler {
 		ctx.Values().Set("data", "")
 	}
 }
+
+// Update ClusterRepo Pull Secret
+// @Tags clusters
+// @Summary Update the pull secret of a clusterRepo
+// @Description Update the pull secret settings of a clusterRepo and apply them to the cluster
+// @Accept  json
+// @Produce  json
+// @Param cluster path string true "集群名称"
+// @Param repo path string true "镜像仓库名称"
+// @Param request body clusterrepo.PullSecret true "request"
+// @Success 200 {object} clusterrepo.ClusterRepo
+// @Security ApiKeyAuth
+// @Router /clusters/{cluster}/repos/{repo}/pullsecret [put]
+func (h *Handler) UpdateClusterRepoPullSecret() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("name")
+		repo := ctx.Params().GetString("repo")
+		var req clusterrepo.PullSecret
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		clusterRepo, err := h.clusterRepoService.UpdatePullSecret(cluster, repo, req)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", clusterRepo)
+	}
+}
+
+// Sync ClusterRepo Pull Secret
+// @Tags clusters
+// @Summary Sync the pull secret of a clusterRepo
+// @Description Apply the pull secret of a clusterRepo to the cluster again
+// @Accept  json
+// @Produce  json
+// @Param cluster path string true "集群名称"
+// @Param repo path string true "镜像仓库名称"
+// @Success 200 {object} clusterrepo.ClusterRepo
+// @Security ApiKeyAuth
+// @Router /clusters/{cluster}/repos/{repo}/pullsecret/sync [post]
+func (h *Handler) SyncClusterRepoPullSecret() iris.Handler {
+	return func(ctx *context.Context) {
+		cluster := ctx.Params().GetString("name")
+		repo := ctx.Params().GetString("repo")
+		if err := h.clusterRepoService.SyncPullSecret(cluster, repo); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		clusterRepo, err := h.clusterRepoService.Get(cluster, repo, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", clusterRepo)
+	}
+}
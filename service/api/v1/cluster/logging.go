@@ -1,11 +1,22 @@
 package cluster
 
 import (
+	"errors"
+	"fmt"
+	"mime"
+	"regexp"
+	"time"
+
+	"github.com/KubeOperator/kubepi/service/api/v1/session"
 	"github.com/KubeOperator/kubepi/service/service/v1/common"
 	"github.com/KubeOperator/kubepi/pkg/kubernetes"
 	"github.com/KubeOperator/kubepi/pkg/logging"
+	"github.com/KubeOperator/kubepi/service/server"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 func (h *Handler) LoggingHandler() iris.Handler {
@@ -50,11 +61,239 @@ func (h *Handler) LoggingHandler() iris.Handler {
 			ctx.Values().Set("message", err)
 			return
 		}
-		logging.LogSessions.Set(sessionId, logging.LogSession{
-			Id:    sessionId,
-			Bound: make(chan error),
-		})
+		if !h.registerLogSession(ctx, sessionId, clusterName, namespace+"/"+podName+"/"+containerName) {
+			return
+		}
 		go logging.WaitForLoggingStream(client, namespace, podName, containerName, tailLines, follow, sessionId)
 		ctx.Values().Set("data", TerminalResponse{ID: sessionId})
 	}
 }
+
+// registerLogSession registers the log session of the current user unless the user has as many open as the
+// limit of the user allows
+func (h *Handler) registerLogSession(ctx *context.Context, sessionId, clusterName, target string) bool {
+	profile := ctx.Values().Get("profile").(session.UserProfile)
+	limits, err := h.sessionLimits(profile.Name)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err)
+		return false
+	}
+	if limits.MaxLogSessions > 0 && logging.LogSessions.CountByUser(profile.Name) >= limits.MaxLogSessions {
+		ctx.StatusCode(iris.StatusTooManyRequests)
+		ctx.Values().Set("message", fmt.Sprintf("you can not open more than %d log streams at once", limits.MaxLogSessions))
+		return false
+	}
+	logging.LogSessions.Set(sessionId, logging.LogSession{
+		Id:             sessionId,
+		LoginSessionId: profile.SessionID,
+		User:           profile.Name,
+		Cluster:        clusterName,
+		Target:         target,
+		CreatedAt:      time.Now(),
+		Bound:          make(chan error),
+	})
+	return true
+}
+
+// AggregatedLoggingHandler opens a log session of all containers of the pods matching the selector param, or of
+// the pods of the workload given by the kind and workload params
+func (h *Handler) AggregatedLoggingHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		clusterName := ctx.Params().GetString("name")
+		opts, err := multiLogOptionsFromQuery(ctx, 100)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		sessionId, err := logging.GenLoggingSessionId()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err)
+			return
+		}
+		c, err := h.clusterService.Get(clusterName, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err)
+			return
+		}
+		client, err := kubernetes.NewKubernetes(c).Client()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err)
+			return
+		}
+		if workload := ctx.URLParam("workload"); workload != "" {
+			opts.Selector, err = logging.WorkloadSelector(client, opts.Namespace, ctx.URLParam("kind"), workload)
+			if err != nil {
+				ctx.StatusCode(iris.StatusBadRequest)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+		}
+		target := opts.Namespace + "/" + opts.Selector.String()
+		if workload := ctx.URLParam("workload"); workload != "" {
+			target = opts.Namespace + "/" + ctx.URLParam("kind") + "/" + workload
+		}
+		if !h.registerLogSession(ctx, sessionId, clusterName, target) {
+			return
+		}
+		go logging.WaitForMultiLoggingStream(client, opts, sessionId)
+		ctx.Values().Set("data", TerminalResponse{ID: sessionId})
+	}
+}
+
+// DownloadLoggingHandler downloads the unmodified logs of the containerName container of the podName pod as a
+// file, without a container it downloads a zip archive of the logs of all containers of the pod, or of the pods
+// of the selector or workload params when no pod is given. The whole log is downloaded unless tailLines is given.
+func (h *Handler) DownloadLoggingHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		clusterName := ctx.Params().GetString("name")
+		podName := ctx.URLParam("podName")
+		containerName := ctx.URLParam("containerName")
+		opts, err := multiLogOptionsFromQuery(ctx, -1)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		c, err := h.clusterService.Get(clusterName, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err)
+			return
+		}
+		client, err := kubernetes.NewKubernetes(c).Client()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err)
+			return
+		}
+
+		var filename string
+		var download func() error
+		switch {
+		case podName != "" && containerName != "":
+			filename = fmt.Sprintf("%s-%s.log", podName, containerName)
+			download = func() error {
+				return logging.DownloadLogs(ctx.Request().Context(), client, opts.Namespace, podName, containerName, opts, ctx.ResponseWriter())
+			}
+		default:
+			var pods []v1.Pod
+			if podName != "" {
+				filename = fmt.Sprintf("%s-logs.zip", podName)
+				pod, err := client.CoreV1().Pods(opts.Namespace).Get(ctx.Request().Context(), podName, metav1.GetOptions{})
+				if err != nil {
+					ctx.StatusCode(iris.StatusInternalServerError)
+					ctx.Values().Set("message", err.Error())
+					return
+				}
+				pods = []v1.Pod{*pod}
+			} else {
+				filename = fmt.Sprintf("%s-logs.zip", opts.Namespace)
+				if workload := ctx.URLParam("workload"); workload != "" {
+					filename = fmt.Sprintf("%s-logs.zip", workload)
+					opts.Selector, err = logging.WorkloadSelector(client, opts.Namespace, ctx.URLParam("kind"), workload)
+					if err != nil {
+						ctx.StatusCode(iris.StatusBadRequest)
+						ctx.Values().Set("message", err.Error())
+						return
+					}
+				}
+				list, err := client.CoreV1().Pods(opts.Namespace).List(ctx.Request().Context(), metav1.ListOptions{LabelSelector: opts.Selector.String()})
+				if err != nil {
+					ctx.StatusCode(iris.StatusInternalServerError)
+					ctx.Values().Set("message", err.Error())
+					return
+				}
+				if len(list.Items) == 0 {
+					ctx.StatusCode(iris.StatusNotFound)
+					ctx.Values().Set("message", "no pods match the selector")
+					return
+				}
+				pods = list.Items
+			}
+			download = func() error {
+				return logging.ArchiveLogs(ctx.Request().Context(), client, pods, opts, ctx.ResponseWriter())
+			}
+		}
+
+		ctx.ContentType(server.ContentTypeDownload)
+		ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		if err := download(); err != nil {
+			if ctx.ResponseWriter().Written() != context.NoWritten {
+				// the response is on its way, the client sees a broken download
+				server.Logger().Errorf("download logs %s of cluster %s failed: %s", filename, clusterName, err)
+				return
+			}
+			ctx.Header("Content-Disposition", "")
+			ctx.ContentType(context.ContentJSONHeaderValue)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+		}
+	}
+}
+
+// multiLogOptionsFromQuery reads the log options of the query, the tail lines default to defaultTailLines and
+// a negative value streams the whole log
+func multiLogOptionsFromQuery(ctx *context.Context, defaultTailLines int) (logging.MultiLogOptions, error) {
+	opts := logging.MultiLogOptions{
+		Namespace:  ctx.URLParam("namespace"),
+		Follow:     ctx.URLParamBoolDefault("follow", false),
+		Previous:   ctx.URLParamBoolDefault("previous", false),
+		Timestamps: ctx.URLParamBoolDefault("timestamps", false),
+	}
+	if opts.Namespace == "" {
+		return opts, errors.New("namespace is required")
+	}
+	if ctx.URLParam("workload") == "" {
+		selector, err := labels.Parse(ctx.URLParam("selector"))
+		if err != nil {
+			return opts, err
+		}
+		opts.Selector = selector
+	}
+	if container := ctx.URLParam("container"); container != "" {
+		e, err := regexp.Compile(container)
+		if err != nil {
+			return opts, err
+		}
+		opts.Container = e
+	}
+	tailLines := int64(ctx.URLParamIntDefault("tailLines", defaultTailLines))
+	if tailLines >= 0 {
+		opts.TailLines = &tailLines
+	}
+	if ctx.URLParamExists("sinceSeconds") {
+		sinceSeconds, err := ctx.URLParamInt64("sinceSeconds")
+		if err != nil || sinceSeconds <= 0 {
+			return opts, errors.New("sinceSeconds must be a positive number")
+		}
+		opts.SinceSeconds = &sinceSeconds
+	}
+	if sinceTime := ctx.URLParam("sinceTime"); sinceTime != "" {
+		t, err := time.Parse(time.RFC3339, sinceTime)
+		if err != nil {
+			return opts, err
+		}
+		opts.SinceTime = &metav1.Time{Time: t}
+	}
+	for _, param := range []struct {
+		name    string
+		filters *[]*regexp.Regexp
+	}{{"include", &opts.Include}, {"exclude", &opts.Exclude}} {
+		for _, expr := range ctx.Request().URL.Query()[param.name] {
+			if expr == "" {
+				continue
+			}
+			e, err := regexp.Compile(expr)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s filter: %s", param.name, err.Error())
+			}
+			*param.filters = append(*param.filters, e)
+		}
+	}
+	return opts, nil
+}
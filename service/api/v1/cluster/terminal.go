@@ -1,61 +1,337 @@
 package cluster
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/KubeOperator/kubepi/service/api/v1/session"
+	v1Config "github.com/KubeOperator/kubepi/service/model/v1/config"
+	v1Role "github.com/KubeOperator/kubepi/service/model/v1/role"
+	v1System "github.com/KubeOperator/kubepi/service/model/v1/system"
+	"github.com/KubeOperator/kubepi/service/server"
 	"github.com/KubeOperator/kubepi/service/service/v1/common"
 	"github.com/KubeOperator/kubepi/pkg/kubernetes"
 	"github.com/KubeOperator/kubepi/pkg/terminal"
+	"github.com/asdine/storm/v3"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
-	"k8s.io/client-go/tools/remotecommand"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 type TerminalResponse struct {
 	ID string `json:"id"`
 }
 
+// userRoles returns the names of the KubePi roles the user is bound to
+func (h *Handler) userRoles(user string) ([]string, error) {
+	bindings, err := h.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{
+		Kind: "User",
+		Name: user,
+	}, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	roles := make([]string, 0, len(bindings))
+	for i := range bindings {
+		roles = append(roles, bindings[i].RoleRef)
+	}
+	return roles, nil
+}
+
+// sessionLimits returns the terminal limits of the user, the ones configured for the roles of the user
+// override the global ones
+func (h *Handler) sessionLimits(user string) (v1Config.TerminalLimits, error) {
+	spec := server.Config().Spec.Terminal
+	if len(spec.RoleLimits) == 0 {
+		return spec.TerminalLimits, nil
+	}
+	roles, err := h.userRoles(user)
+	if err != nil {
+		return v1Config.TerminalLimits{}, err
+	}
+	return spec.LimitsOf(roles), nil
+}
+
+// commandRules compiles the command policies which apply to the terminals of the user bound to the roles in
+// the namespace of the cluster
+func commandRules(cluster, namespace string, roles []string) ([]terminal.CommandRule, error) {
+	var rules []terminal.CommandRule
+	for _, policy := range server.Config().Spec.Terminal.CommandPolicies {
+		if !policy.Applies(cluster, namespace, roles) {
+			continue
+		}
+		rule := terminal.CommandRule{Name: policy.Name}
+		for _, list := range []struct {
+			exprs []string
+			into  *[]*regexp.Regexp
+		}{{policy.Allow, &rule.Allow}, {policy.Deny, &rule.Deny}} {
+			for _, expr := range list.exprs {
+				e, err := regexp.Compile(expr)
+				if err != nil {
+					return nil, fmt.Errorf("invalid expression %s of the command policy %s: %s", expr, policy.Name, err.Error())
+				}
+				*list.into = append(*list.into, e)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// auditCommand writes a command line typed into a terminal to the operation log
+func (h *Handler) auditCommand(s terminal.TerminalSession, user, command, deniedBy string) {
+	log := v1System.OperationLog{
+		Operator:            user,
+		Operation:           "exec",
+		OperationDomain:     "clusters_terminal",
+		SpecificInformation: fmt.Sprintf("[%s] %s (session %s, owner %s): %s", s.Cluster, s.Target, s.Id, s.Owner, command),
+	}
+	if deniedBy != "" {
+		log.Operation = "deny"
+		log.SpecificInformation += fmt.Sprintf(" (policy %s)", deniedBy)
+	}
+	go h.systemService.CreateOperationLog(&log, common.DBOptions{})
+}
+
+// newTerminalSession registers a terminal session of the target in the namespace of the cluster, the process is
+// started by the caller once the SockJS connection binds it. verb is the dedicated verb the route needed, if any.
+func (h *Handler) newTerminalSession(ctx *context.Context, namespace, target, verb string) (string, k8s.Interface, *rest.Config, bool) {
+	sessionID, err := terminal.GenTerminalSessionId()
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err)
+		return "", nil, nil, false
+	}
+	clusterName := ctx.Params().GetString("name")
+	c, err := h.clusterService.Get(clusterName, common.DBOptions{})
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err)
+		return "", nil, nil, false
+	}
+	k := kubernetes.NewKubernetes(c)
+	conf, err := k.Config()
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err)
+		return "", nil, nil, false
+	}
+	client, err := k.Client()
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err)
+		return "", nil, nil, false
+	}
+	profile := ctx.Values().Get("profile").(session.UserProfile)
+	roles, err := h.userRoles(profile.Name)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err)
+		return "", nil, nil, false
+	}
+	spec := server.Config().Spec.Terminal
+	limits := spec.LimitsOf(roles)
+	if limits.MaxTerminalSessions > 0 && terminal.TerminalSessions.CountByOwner(profile.Name) >= limits.MaxTerminalSessions {
+		ctx.StatusCode(iris.StatusTooManyRequests)
+		ctx.Values().Set("message", fmt.Sprintf("you can not open more than %d terminals at once", limits.MaxTerminalSessions))
+		return "", nil, nil, false
+	}
+	s := terminal.NewTerminalSession(sessionID, profile.SessionID, profile.Name, clusterName, target)
+	s.Namespace = namespace
+	s.Verb = verb
+	s.Limits = terminal.Limits{
+		IdleTimeout: time.Duration(limits.IdleTimeout) * time.Minute,
+		MaxDuration: time.Duration(limits.MaxDuration) * time.Minute,
+		WarnBefore:  time.Duration(spec.WarningBefore) * time.Second,
+	}
+	s.CommandRules, err = commandRules(clusterName, namespace, roles)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.Values().Set("message", err.Error())
+		return "", nil, nil, false
+	}
+	// the users who write to a share of the terminal may be denied commands by policies which do not apply to
+	// the owner
+	if spec.AuditCommands || len(spec.CommandPolicies) > 0 {
+		s.CommandAudit = h.auditCommand
+	}
+	terminal.TerminalSessions.Set(sessionID, s)
+	return sessionID, client, conf, true
+}
+
+func terminalShell(ctx *context.Context) string {
+	if shell := ctx.URLParam("shell"); shell != "" {
+		return shell
+	}
+	return "sh"
+}
+
 func (h *Handler) TerminalSessionHandler() iris.Handler {
 	return func(ctx *context.Context) {
 		namespace := ctx.URLParam("namespace")
 		podName := ctx.URLParam("podName")
 		containerName := ctx.URLParam("containerName")
-		shell := ctx.URLParam("shell")
+		sessionID, client, conf, ok := h.newTerminalSession(ctx, namespace, namespace+"/"+podName+"/"+containerName, "")
+		if !ok {
+			return
+		}
+		go terminal.WaitForTerminal(client, conf, namespace, podName, containerName, sessionID, terminalShell(ctx))
+		resp := TerminalResponse{ID: sessionID}
+		ctx.Values().Set("data", resp)
+	}
+}
 
-		sessionID, err := terminal.GenTerminalSessionId()
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err)
+// DebugTerminalSessionHandler opens a terminal in a new ephemeral container of the image param, or of the
+// configured debug image, targeting the container of the pod
+func (h *Handler) DebugTerminalSessionHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		namespace := ctx.URLParam("namespace")
+		podName := ctx.URLParam("podName")
+		containerName := ctx.URLParam("containerName")
+		if namespace == "" || podName == "" || containerName == "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "namespace, podName and containerName are required")
 			return
 		}
-		clusterName := ctx.Params().GetString("name")
-		c, err := h.clusterService.Get(clusterName, common.DBOptions{})
-		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err)
+		image := ctx.URLParamDefault("image", server.Config().Spec.Terminal.DebugImage)
+		sessionID, client, conf, ok := h.newTerminalSession(ctx, namespace, namespace+"/"+podName+"/"+containerName+" (debug)", "debug")
+		if !ok {
+			return
+		}
+		go terminal.WaitForDebugTerminal(client, conf, namespace, podName, containerName, image, sessionID, terminalShell(ctx))
+		ctx.Values().Set("data", TerminalResponse{ID: sessionID})
+	}
+}
+
+// NodeShellSessionHandler opens a terminal on the host of the node through a privileged pod
+func (h *Handler) NodeShellSessionHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		nodeName := ctx.URLParam("nodeName")
+		if nodeName == "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "nodeName is required")
+			return
+		}
+		spec := server.Config().Spec.Terminal
+		sessionID, client, conf, ok := h.newTerminalSession(ctx, "", "node/"+nodeName, "nodeshell")
+		if !ok {
+			return
+		}
+		go terminal.WaitForNodeShell(client, conf, spec.NodeShellNamespace, nodeName, spec.NodeShellImage, sessionID, terminalShell(ctx))
+		ctx.Values().Set("data", TerminalResponse{ID: sessionID})
+	}
+}
+
+type ShareTerminalRequest struct {
+	Writable bool     `json:"writable"`
+	Users    []string `json:"users"`
+	// ExpiresIn is how many seconds the link can be joined, terminal.ShareTTL if not set
+	ExpiresIn int `json:"expiresIn"`
+}
+
+type TerminalSharingResponse struct {
+	Shares  []terminal.Share  `json:"shares"`
+	Viewers []terminal.Viewer `json:"viewers"`
+}
+
+type JoinTerminalResponse struct {
+	ID       string `json:"id"`
+	Owner    string `json:"owner"`
+	Target   string `json:"target"`
+	Writable bool   `json:"writable"`
+}
+
+// ShareTerminalHandler creates a link other users of the cluster join the terminal session of the current user
+// with, as read-only observers or as co-writers
+func (h *Handler) ShareTerminalHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		var req ShareTerminalRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
 			return
 		}
-		k := kubernetes.NewKubernetes(c)
-		conf, err := k.Config()
+		ttl := terminal.ShareTTL
+		if req.ExpiresIn > 0 {
+			ttl = time.Duration(req.ExpiresIn) * time.Second
+		}
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		share, err := terminal.TerminalSessions.ShareTerminal(ctx.Params().GetString("id"), profile.Name, req.Writable, req.Users, ttl)
 		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err)
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.Values().Set("message", err.Error())
 			return
 		}
-		client, err := k.Client()
+		ctx.Values().Set("data", share)
+	}
+}
+
+// ListTerminalSharingHandler lists the share links and the viewers of the terminal session of the current user
+func (h *Handler) ListTerminalSharingHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		shares, viewers, err := terminal.TerminalSessions.ListSharing(ctx.Params().GetString("id"), profile.Name)
 		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
-			ctx.Values().Set("message", err)
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.Values().Set("message", err.Error())
 			return
 		}
-		if shell == "" {
-			shell = "sh"
+		ctx.Values().Set("data", TerminalSharingResponse{Shares: shares, Viewers: viewers})
+	}
+}
+
+// RevokeTerminalSharesHandler invalidates the share links of the terminal session of the current user and
+// disconnects its viewers
+func (h *Handler) RevokeTerminalSharesHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		if err := terminal.TerminalSessions.RevokeShares(ctx.Params().GetString("id"), profile.Name); err != nil {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.Values().Set("message", err.Error())
+			return
 		}
-		terminal.TerminalSessions.Set(sessionID, terminal.TerminalSession{
-			Id:       sessionID,
-			Bound:    make(chan error),
-			SizeChan: make(chan remotecommand.TerminalSize),
+	}
+}
+
+// JoinTerminalHandler attaches the current user to the terminal session shared with the share param, only
+// administrators and members of the cluster may join, writable shares of debug terminals and node shells only
+// users with the debug or nodeshell permission. The command policies of the current user apply to what it types
+// into a writable share. The returned id is bound like the one of a terminal session.
+func (h *Handler) JoinTerminalHandler() iris.Handler {
+	return func(ctx *context.Context) {
+		clusterName := ctx.Params().GetString("name")
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		if !profile.IsAdministrator {
+			if _, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(clusterName, profile.Name, common.DBOptions{}); err != nil {
+				ctx.StatusCode(iris.StatusForbidden)
+				ctx.Values().Set("message", "you are not a member of the cluster")
+				return
+			}
+		}
+		s, viewer, err := terminal.TerminalSessions.JoinTerminal(clusterName, ctx.URLParam("share"), profile.Name, profile.SessionID, func(verb string) bool {
+			return session.HasVerb(ctx, "clusters", verb)
+		}, func(namespace string) ([]terminal.CommandRule, error) {
+			roles, err := h.userRoles(profile.Name)
+			if err != nil {
+				return nil, err
+			}
+			return commandRules(clusterName, namespace, roles)
 		})
-		go terminal.WaitForTerminal(client, conf, namespace, podName, containerName, sessionID, shell)
-		resp := TerminalResponse{ID: sessionID}
-		ctx.Values().Set("data", resp)
+		if err != nil {
+			switch {
+			case errors.Is(err, terminal.ErrShareNotFound):
+				ctx.StatusCode(iris.StatusNotFound)
+			case errors.Is(err, terminal.ErrShareDenied):
+				ctx.StatusCode(iris.StatusForbidden)
+			default:
+				ctx.StatusCode(iris.StatusInternalServerError)
+			}
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", JoinTerminalResponse{ID: viewer.Id, Owner: s.Owner, Target: s.Target, Writable: viewer.Writable})
 	}
 }
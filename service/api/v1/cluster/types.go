@@ -69,6 +69,7 @@ type Repo struct {
 }
 
 type CreateRepo struct {
-	Repos   []string
-	Cluster string
+	Repos      []string
+	Cluster    string
+	PullSecret V1ClusterRepo.PullSecret `json:"pullSecret"`
 }
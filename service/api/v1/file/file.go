@@ -1,18 +1,18 @@
 package file
 
 import (
-	"archive/tar"
 	"errors"
-	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/KubeOperator/kubepi/pkg/util/podtool"
+	"github.com/KubeOperator/kubepi/service/api/v1/session"
 	fileModel "github.com/KubeOperator/kubepi/service/model/v1/file"
+	"github.com/KubeOperator/kubepi/service/server"
 	"github.com/KubeOperator/kubepi/service/service/v1/file"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
-	"io"
-	"os"
-	"path"
-	"path/filepath"
-	"time"
 )
 
 type Handler struct {
@@ -33,6 +33,7 @@ func (h *Handler) ListFiles() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		req.Debug = canDebug(ctx)
 		res, err := h.fileService.ListFiles(req)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
@@ -51,8 +52,8 @@ func (h *Handler) CreateFolder() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		req.Commands = []string{"mkdir", req.Path}
-		if _, err := h.fileService.ExecNewCommand(req); err != nil {
+		req.Debug = canDebug(ctx)
+		if err := h.fileService.CreateFolder(req); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
@@ -68,10 +69,9 @@ func (h *Handler) CreateFile() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		command := "echo '" + req.Content + "' >> " + req.Path
-		req.Commands = []string{"sh", "-c", command}
-		if _, err := h.fileService.ExecNewCommand(req); err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
+		req.Debug = canDebug(ctx)
+		if err := h.fileService.CreateFile(req); err != nil {
+			ctx.StatusCode(transferErrorStatus(err))
 			ctx.Values().Set("message", err.Error())
 			return
 		}
@@ -86,11 +86,18 @@ func (h *Handler) UpdateFile() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		if err := h.fileService.EditFile(req); err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
+		req.Debug = canDebug(ctx)
+		checksum, err := h.fileService.EditFile(req)
+		if err != nil {
+			if errors.Is(err, podtool.ErrFileChanged) {
+				ctx.StatusCode(iris.StatusConflict)
+			} else {
+				ctx.StatusCode(iris.StatusInternalServerError)
+			}
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		ctx.Values().Set("data", fileModel.Content{Checksum: checksum})
 	}
 }
 
@@ -102,13 +109,14 @@ func (h *Handler) OpenFile() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		req.Debug = canDebug(ctx)
 		res, err := h.fileService.CatFile(req)
 		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.StatusCode(transferErrorStatus(err))
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		ctx.Values().Set("data", string(res))
+		ctx.Values().Set("data", res)
 	}
 }
 
@@ -120,14 +128,13 @@ func (h *Handler) ReNameFile() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		req.Debug = canDebug(ctx)
 		if req.OldPath == "" {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", "file or path is not exist")
 			return
 		}
-		req.Commands = []string{"mv", req.OldPath, req.Path}
-		_, err := h.fileService.ExecNewCommand(req)
-		if err != nil {
+		if err := h.fileService.Rename(req); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
@@ -143,8 +150,8 @@ func (h *Handler) RmFolder() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		req.Commands = []string{"rm", req.Path}
-		if _, err := h.fileService.ExecNewCommand(req); err != nil {
+		req.Debug = canDebug(ctx)
+		if err := h.fileService.Remove(req); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
@@ -152,98 +159,158 @@ func (h *Handler) RmFolder() iris.Handler {
 	}
 }
 
-func (h *Handler) DownloadFile() iris.Handler {
-	return func(ctx *context.Context) {
+func fileRequestFromQuery(ctx *context.Context) fileModel.Request {
+	var req fileModel.Request
+	req.Path = ctx.URLParam("path")
+	req.Namespace = ctx.URLParam("namespace")
+	req.Cluster = ctx.URLParam("cluster")
+	req.PodName = ctx.URLParam("podName")
+	req.ContainerName = ctx.URLParam("containerName")
+	req.Debug = canDebug(ctx)
+	return req
+}
 
-		var req fileModel.Request
-		req.Path = ctx.URLParam("path")
-		req.Namespace = ctx.URLParam("namespace")
-		req.Cluster = ctx.URLParam("cluster")
-		req.PodName = ctx.URLParam("podName")
-		req.ContainerName = ctx.URLParam("containerName")
+// canDebug reports whether the user may reach the files of containers without file tools through a debug
+// container, it takes the debug verb of clusters like the debug terminal
+func canDebug(ctx *context.Context) bool {
+	return session.HasVerb(ctx, "clusters", "debug")
+}
 
-		file, err := h.fileService.DownloadFile(req)
+func transferErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, file.ErrDebugNotAllowed):
+		return iris.StatusForbidden
+	case errors.Is(err, file.ErrBinaryFile):
+		return iris.StatusUnsupportedMediaType
+	case errors.Is(err, file.ErrUploadSessionNotFound):
+		return iris.StatusNotFound
+	case errors.Is(err, podtool.ErrOffsetMismatch), errors.Is(err, podtool.ErrFileExists):
+		return iris.StatusConflict
+	case errors.Is(err, file.ErrTooLarge), errors.As(err, &maxBytesErr):
+		return iris.StatusRequestEntityTooLarge
+	}
+	return iris.StatusInternalServerError
+}
+
+// DownloadFile streams the file from the container, directories are archived as tar.gz or
+// the archive format of the format param: tar, tar.gz or zip
+func (h *Handler) DownloadFile() iris.Handler {
+	return func(ctx *context.Context) {
+		req := fileRequestFromQuery(ctx)
+		download, err := h.fileService.Download(req, ctx.URLParam("format"))
 		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.StatusCode(transferErrorStatus(err))
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		filename := path.Base(file)
-		err = ctx.SendFile(file, filename)
-		if err != nil {
+		ctx.ContentType(server.ContentTypeDownload)
+		ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": download.Name}))
+		if download.Size >= 0 {
+			ctx.Header("Content-Length", strconv.FormatInt(download.Size, 10))
+		}
+		if err := download.Stream(ctx.ResponseWriter()); err != nil {
+			if ctx.ResponseWriter().Written() != context.NoWritten {
+				// the response is on its way, the client sees a broken download
+				server.Logger().Errorf("download %s from pod %s/%s failed: %s", req.Path, req.Namespace, req.PodName, err)
+				return
+			}
+			ctx.Header("Content-Disposition", "")
+			ctx.Header("Content-Length", "")
+			ctx.ContentType(context.ContentJSONHeaderValue)
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
-			return
 		}
-		os.RemoveAll(file)
 	}
 }
 
+// UploadFile streams the files of the multipart form into the directory of the path param,
+// file names may be relative paths to upload directory trees
 func (h *Handler) UploadFile() iris.Handler {
 	return func(ctx *context.Context) {
-		var req fileModel.Request
-		req.Path = ctx.URLParam("path")
-		req.Namespace = ctx.URLParam("namespace")
-		req.Cluster = ctx.URLParam("cluster")
-		req.PodName = ctx.URLParam("podName")
-		req.ContainerName = ctx.URLParam("containerName")
-
-		srcPath := filepath.Join(os.TempDir(), fmt.Sprintf("%d", time.Now().UnixNano()))
-		err := saveTarFile(ctx, srcPath)
+		req := fileRequestFromQuery(ctx)
+		if max := file.MaxUploadSize(); max > 0 {
+			ctx.Request().Body = http.MaxBytesReader(ctx.ResponseWriter(), ctx.Request().Body, max)
+		}
+		reader, err := ctx.Request().MultipartReader()
 		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.StatusCode(iris.StatusBadRequest)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		if err := h.fileService.UploadFiles(req, reader); err != nil {
+			ctx.StatusCode(transferErrorStatus(err))
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
 
-		req.FilePath = srcPath
-		err = h.fileService.UploadFile(req)
+// CreateUploadSession starts a resumable upload of a file, the content is sent in chunks with UploadChunk
+func (h *Handler) CreateUploadSession() iris.Handler {
+	return func(ctx *context.Context) {
+		var req fileModel.UploadSession
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		req.Debug = canDebug(ctx)
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		uploadSession, err := h.fileService.CreateUploadSession(req, profile.Name)
 		if err != nil {
-			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.StatusCode(transferErrorStatus(err))
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		ctx.Values().Set("data", uploadSession)
 	}
 }
 
-func saveTarFile(ctx *context.Context, srcPath string) error {
-	maxSize := ctx.Application().ConfigurationReadOnly().GetPostMaxMemory()
-	err := ctx.Request().ParseMultipartForm(maxSize)
-	if err != nil {
-		return err
-	}
-	form := ctx.Request().MultipartForm
-	files := form.File["files"]
-	if len(files) == 0 {
-		return errors.New("files is null")
-	}
-	fw, err := os.Create(srcPath)
-	defer fw.Close()
-	if err != nil {
-		return err
-	}
-	tw := tar.NewWriter(fw)
-	defer tw.Close()
-	for _, f := range files {
-		hdr := &tar.Header{
-			Name: f.Filename,
-			Mode: 0644,
-			Size: f.Size,
-		}
-		err = tw.WriteHeader(hdr)
+// GetUploadSession returns the session with the offset to resume the upload from
+func (h *Handler) GetUploadSession() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		uploadSession, err := h.fileService.GetUploadSession(ctx.Params().GetString("id"), profile.Name)
 		if err != nil {
-			return err
+			ctx.StatusCode(transferErrorStatus(err))
+			ctx.Values().Set("message", err.Error())
+			return
 		}
-		_f, err := f.Open()
+		ctx.Values().Set("data", uploadSession)
+	}
+}
+
+// UploadChunk appends the request body at the offset param, a conflict means the offset is not
+// the uploaded size and the upload has to resume from the offset of the session
+func (h *Handler) UploadChunk() iris.Handler {
+	return func(ctx *context.Context) {
+		offset, err := ctx.URLParamInt64("offset")
 		if err != nil {
-			return err
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
 		}
-		_, err = io.Copy(tw, _f)
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		uploadSession, err := h.fileService.UploadChunk(ctx.Params().GetString("id"), profile.Name, offset, ctx.Request().Body)
 		if err != nil {
-			return err
+			ctx.StatusCode(transferErrorStatus(err))
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", uploadSession)
+	}
+}
+
+func (h *Handler) DeleteUploadSession() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		if err := h.fileService.DeleteUploadSession(ctx.Params().GetString("id"), profile.Name); err != nil {
+			ctx.StatusCode(transferErrorStatus(err))
+			ctx.Values().Set("message", err.Error())
+			return
 		}
 	}
-	return nil
 }
 
 func Install(parent iris.Party) {
@@ -258,4 +325,8 @@ func Install(parent iris.Party) {
 	sp.Post("/files/upload", handler.UploadFile())
 	sp.Post("/files/update", handler.UpdateFile())
 	sp.Get("/files/download", handler.DownloadFile())
+	sp.Post("/files/uploads", handler.CreateUploadSession())
+	sp.Get("/files/uploads/:id", handler.GetUploadSession())
+	sp.Put("/files/uploads/:id", handler.UploadChunk())
+	sp.Delete("/files/uploads/:id", handler.DeleteUploadSession())
 }
@@ -3,6 +3,7 @@ package imagerepo
 import (
 	"errors"
 
+	"github.com/KubeOperator/kubepi/pkg/util/imagerepo/repos"
 	"github.com/KubeOperator/kubepi/service/api/v1/commons"
 	v1ImageRepo "github.com/KubeOperator/kubepi/service/model/v1/imagerepo"
 	"github.com/KubeOperator/kubepi/service/server"
@@ -130,6 +131,8 @@ func (h *Handler) UpdateRepo() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
+		// the pull secrets follow the credentials, the result is recorded on each cluster repo
+		go h.clusterRepoService.SyncPullSecretsByRepo(imageRepoName)
 	}
 }
 
@@ -153,6 +156,15 @@ func (h *Handler) DeleteRepo() iris.Handler {
 			return
 		}
 		txOptions := common.DBOptions{DB: tx}
+		// the clusters are looked up before their links are deleted, the pull secrets are only removed once the
+		// deletion is committed
+		clusters, err := h.clusterRepoService.PullSecretClusters(imageRepoName, txOptions)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			_ = tx.Rollback()
+			return
+		}
 		if err := h.imageRepoService.Delete(imageRepoName, txOptions); err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
@@ -165,7 +177,12 @@ func (h *Handler) DeleteRepo() iris.Handler {
 			_ = tx.Rollback()
 			return
 		}
-		_ = tx.Commit()
+		if err := tx.Commit(); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		h.clusterRepoService.RemovePullSecrets(imageRepoName, clusters)
 	}
 }
 
@@ -270,6 +287,80 @@ func (h *Handler) ListImagesByRepo() iris.Handler {
 	}
 }
 
+// Get Image Manifest
+// @Tags repos
+// @Summary Get the manifest of an image tag
+// @Description Get the manifest of an image tag
+// @Accept  json
+// @Produce  json
+// @Param name path string true "镜像仓库名称"
+// @Param image query string true "镜像名称"
+// @Param tag query string true "镜像标签"
+// @Success 200 {object} repos.Manifest
+// @Security ApiKeyAuth
+// @Router /imagerepos/{name}/manifest [get]
+func (h *Handler) GetManifest() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		manifest, err := h.imageRepoService.GetManifest(name, ctx.URLParam("image"), ctx.URLParam("tag"), common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", manifest)
+	}
+}
+
+// Delete Image Tag
+// @Tags repos
+// @Summary Delete an image tag
+// @Description Delete an image tag, a docker registry deletes every tag of the same digest
+// @Accept  json
+// @Produce  json
+// @Param name path string true "镜像仓库名称"
+// @Param image query string true "镜像名称"
+// @Param tag query string true "镜像标签"
+// @Security ApiKeyAuth
+// @Router /imagerepos/{name}/tags [delete]
+func (h *Handler) DeleteTag() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		if err := h.imageRepoService.DeleteTag(name, ctx.URLParam("image"), ctx.URLParam("tag"), common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
+
+// Retag Image
+// @Tags repos
+// @Summary Retag or copy an image within the repo
+// @Description Retag or copy an image within the repo
+// @Accept  json
+// @Produce  json
+// @Param name path string true "镜像仓库名称"
+// @Param request body repos.RetagRequest true "request"
+// @Security ApiKeyAuth
+// @Router /imagerepos/{name}/retag [post]
+func (h *Handler) Retag() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		var req repos.RetagRequest
+		if err := ctx.ReadJSON(&req); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if err := h.imageRepoService.Retag(name, req, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+	}
+}
+
 func Install(parent iris.Party) {
 	handler := NewHandler()
 	sp := parent.Party("/imagerepos")
@@ -282,4 +373,7 @@ func Install(parent iris.Party) {
 	sp.Get("/cluster/:cluster", handler.ListRepoForCluster())
 	sp.Get("/images/:cluster/:repo", handler.ListImages())
 	sp.Post("/images/:repo/search", handler.ListImagesByRepo())
+	sp.Get("/:name/manifest", handler.GetManifest())
+	sp.Delete("/:name/tags", handler.DeleteTag())
+	sp.Post("/:name/retag", handler.Retag())
 }
@@ -125,6 +125,35 @@ func (h *Handler) ImportUser() iris.Handler {
 	}
 }
 
+// RunSync synchronizes users and group role mappings from the directory,
+// with dryRun=true only the report of the changes is produced.
+func (h *Handler) RunSync() iris.Handler {
+	return func(ctx *context.Context) {
+		id := ctx.Params().GetString("id")
+		dryRun := ctx.URLParamBoolDefault("dryRun", false)
+		record, err := h.ldapService.StartSync(id, dryRun, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", record)
+	}
+}
+
+func (h *Handler) ListSyncRecords() iris.Handler {
+	return func(ctx *context.Context) {
+		id := ctx.Params().GetString("id")
+		records, err := h.ldapService.ListSyncRecords(id, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", records)
+	}
+}
+
 func Install(parent iris.Party) {
 	handler := NewHandler()
 	sp := parent.Party("/ldap")
@@ -135,4 +164,6 @@ func Install(parent iris.Party) {
 	sp.Post("/test/connect", handler.TestConnect())
 	sp.Post("/test/login", handler.TestLogin())
 	sp.Post("/import", handler.ImportUser())
+	sp.Post("/:id/sync", handler.RunSync())
+	sp.Get("/:id/sync/records", handler.ListSyncRecords())
 }
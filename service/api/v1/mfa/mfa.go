@@ -13,12 +13,14 @@ import (
 )
 
 type Handler struct {
-	userService user.Service
+	userService    user.Service
+	sessionHandler *sessionAuth.Handler
 }
 
 func NewHandler() *Handler {
 	return &Handler{
-		userService: user.NewService(),
+		userService:    user.NewService(),
+		sessionHandler: sessionAuth.NewHandler(),
 	}
 }
 
@@ -37,7 +39,7 @@ func (m *Handler) MfaValidate() iris.Handler {
 			ctx.Values().Set("message", "can not parse to session user")
 			return
 		}
-		if p.Mfa.Enable == false {
+		if p.Mfa.Enable == false || p.Mfa.Approved {
 			ctx.StatusCode(iris.StatusOK)
 			return
 		}
@@ -47,17 +49,33 @@ func (m *Handler) MfaValidate() iris.Handler {
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		success := mfaUtil.ValidCode(mfa.Code, mfa.Secret)
+		var success bool
+		if mfa.RecoveryCode != "" {
+			ok, err := m.userService.UseRecoveryCode(p.Name, mfa.RecoveryCode, common.DBOptions{})
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
+			success = ok
+		} else {
+			success = mfaUtil.ValidCode(mfa.Code, p.Mfa.Secret, server.Config().Spec.Mfa.Skew)
+		}
 		if !success {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", "code is invalid")
 			return
-		} else {
-			p.Mfa.Approved = true
-			session.Set("profile", p)
-			ctx.StatusCode(iris.StatusOK)
+		}
+		_, token, err := m.sessionHandler.CompleteMfaLogin(ctx, p)
+		if err != nil {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", err.Error())
 			return
 		}
+		ctx.StatusCode(iris.StatusOK)
+		if token != nil {
+			ctx.Values().Set("token", token)
+		}
 	}
 }
 
@@ -80,34 +98,59 @@ func (m *Handler) MfaBind() iris.Handler {
 			ctx.StatusCode(iris.StatusOK)
 			return
 		}
+		// a login which only passed the password must not replace the second factor of the user
+		if p.Mfa.Secret != "" {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "mfa is already bound, it has to be reset first")
+			return
+		}
+		u, err := m.userService.GetByNameOrEmail(p.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if u.Mfa.Bound() {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", "mfa is already bound, it has to be reset first")
+			return
+		}
 		var mfa sessionAuth.MfaCredential
 		if err := ctx.ReadJSON(&mfa); err != nil {
 			ctx.StatusCode(iris.StatusBadRequest)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		success := mfaUtil.ValidCode(mfa.Code, mfa.Secret)
+		success := mfaUtil.ValidCode(mfa.Code, mfa.Secret, server.Config().Spec.Mfa.Skew)
 		if !success {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", "code is invalid")
 			return
 		} else {
+			codes, hashes, err := mfaUtil.GenerateRecoveryCodes(server.Config().Spec.Mfa.RecoveryCodeCount)
+			if err != nil {
+				ctx.StatusCode(iris.StatusInternalServerError)
+				ctx.Values().Set("message", err.Error())
+				return
+			}
 			session.Delete("profile")
 			us := &v1User.User{
 				Metadata: v1.Metadata{
-					Name: mfa.Username,
+					Name: p.Name,
 				},
 				Mfa: v1User.Mfa{
-					Enable: true,
-					Secret: mfa.Secret,
+					Enable:        true,
+					Secret:        mfa.Secret,
+					RecoveryCodes: hashes,
 				},
 			}
-			if err := m.userService.Update(mfa.Username, us, common.DBOptions{}); err != nil {
+			if err := m.userService.Update(p.Name, us, common.DBOptions{}); err != nil {
 				ctx.StatusCode(iris.StatusInternalServerError)
 				ctx.Values().Set("message", err.Error())
 				return
 			}
 			ctx.StatusCode(iris.StatusOK)
+			ctx.Values().Set("data", RecoveryCodes{Codes: codes})
 			return
 		}
 	}
@@ -145,10 +188,55 @@ func (m *Handler) GetMfa() iris.Handler {
 	}
 }
 
+// RegenerateRecoveryCodes replaces the recovery codes of the current user,
+// invalidating all previously issued ones.
+func (m *Handler) RegenerateRecoveryCodes() iris.Handler {
+	return func(ctx *context.Context) {
+		session := server.SessionMgr.Start(ctx)
+		loginUser := session.Get("profile")
+		if loginUser == nil {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "no login user")
+			return
+		}
+		p, ok := loginUser.(sessionAuth.UserProfile)
+		if !ok {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", "can not parse to session user")
+			return
+		}
+		if !p.Mfa.Enable || !p.Mfa.Approved {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", "mfa is not approved")
+			return
+		}
+		u, err := m.userService.GetByNameOrEmail(p.Name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		codes, hashes, err := mfaUtil.GenerateRecoveryCodes(server.Config().Spec.Mfa.RecoveryCodeCount)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		u.Mfa.RecoveryCodes = hashes
+		if err := m.userService.Update(p.Name, u, common.DBOptions{}); err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", RecoveryCodes{Codes: codes})
+	}
+}
+
 func Install(parent iris.Party) {
 	handler := NewHandler()
 	sp := parent.Party("/mfa")
 	sp.Get("/", handler.GetMfa())
 	sp.Post("/bind", handler.MfaBind())
 	sp.Post("/valid", handler.MfaValidate())
+	sp.Post("/recovery-codes", handler.RegenerateRecoveryCodes())
 }
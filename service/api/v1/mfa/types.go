@@ -0,0 +1,6 @@
+package mfa
+
+// RecoveryCodes are returned in plain text exactly once, when generated.
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
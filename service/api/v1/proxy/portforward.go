@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/KubeOperator/kubepi/service/api/v1/session"
+	v1PortForward "github.com/KubeOperator/kubepi/service/model/v1/portforward"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"github.com/KubeOperator/kubepi/service/service/v1/portforward"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// PortForwardProxy proxies the request to the port of the pod or the service with the permissions of the user,
+// the path below the port is the path of the target
+func (h *Handler) PortForwardProxy() iris.Handler {
+	return func(ctx *context.Context) {
+		name := ctx.Params().GetString("name")
+		target := v1PortForward.PortForward{
+			Cluster:   name,
+			Kind:      ctx.Params().GetString("kind"),
+			Namespace: ctx.Params().GetString("namespace"),
+			Name:      ctx.Params().GetString("target"),
+			Port:      ctx.Params().GetIntDefault("port", 0),
+		}
+		if target.Kind != v1PortForward.KindPod && target.Kind != v1PortForward.KindService {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", fmt.Sprintf("unsupported port forward kind %s", target.Kind))
+			return
+		}
+		target.Path = fmt.Sprintf("%s%s/portforward/%s/%s/%s/%d", server.ProxyPathPrefix, target.Cluster, target.Kind, target.Namespace, target.Name, target.Port)
+
+		p := ctx.Params().GetString("p")
+		if p == "" && !server.HasTrailingSlash(ctx.Request()) {
+			// relative links of the root page resolve below the port forward only with the slash
+			u := *ctx.Request().URL
+			u.Path = target.Path + "/"
+			u.RawPath = ""
+			ctx.Redirect(u.String(), iris.StatusFound)
+			return
+		}
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		target.CreatedBy = profile.Name
+
+		c, err := h.clusterService.Get(name, common.DBOptions{})
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", fmt.Sprintf("get cluster failed: %s", err.Error()))
+			return
+		}
+		conf, err := h.generateConfig(c, profile)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err)
+			return
+		}
+		f, err := h.portForwardService.Open(target, conf)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err)
+			return
+		}
+
+		r := ctx.Request().Clone(ctx.Request().Context())
+		r.URL.Path = "/" + strings.TrimPrefix(p, "/")
+		if p != "" && server.HasTrailingSlash(ctx.Request()) {
+			r.URL.Path += "/"
+		}
+		r.URL.RawPath = ""
+		f.ServeHTTP(ctx.ResponseWriter(), r)
+	}
+}
+
+// ListPortForwards lists the open port forwards of the user, administrators see the ones of all users
+func (h *Handler) ListPortForwards() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		user := profile.Name
+		if profile.IsAdministrator {
+			user = ""
+		}
+		items := h.portForwardService.List(user)
+		if cluster := ctx.URLParam("cluster"); cluster != "" {
+			var filtered []v1PortForward.PortForward
+			for i := range items {
+				if items[i].Cluster == cluster {
+					filtered = append(filtered, items[i])
+				}
+			}
+			items = filtered
+		}
+		_ = ctx.JSON(iris.Map{
+			"success": true,
+			"data":    items,
+		})
+	}
+}
+
+// DeletePortForward closes the port forward, administrators may close the ones of other users
+func (h *Handler) DeletePortForward() iris.Handler {
+	return func(ctx *context.Context) {
+		profile := ctx.Values().Get("profile").(session.UserProfile)
+		user := profile.Name
+		if profile.IsAdministrator {
+			user = ""
+		}
+		if err := h.portForwardService.Delete(ctx.Params().GetString("id"), user); err != nil {
+			if errors.Is(err, portforward.ErrPortForwardNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+			} else {
+				ctx.StatusCode(iris.StatusInternalServerError)
+			}
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		_ = ctx.JSON(iris.Map{
+			"success": true,
+		})
+	}
+}
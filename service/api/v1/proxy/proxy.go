@@ -21,6 +21,7 @@ import (
 	"github.com/KubeOperator/kubepi/service/service/v1/cluster"
 	"github.com/KubeOperator/kubepi/service/service/v1/clusterbinding"
 	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"github.com/KubeOperator/kubepi/service/service/v1/portforward"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -30,12 +31,14 @@ import (
 type Handler struct {
 	clusterService        cluster.Service
 	clusterBindingService clusterbinding.Service
+	portForwardService    portforward.Service
 }
 
 func NewHandler() *Handler {
 	return &Handler{
 		clusterService:        cluster.NewService(),
 		clusterBindingService: clusterbinding.NewService(),
+		portForwardService:    portforward.NewService(),
 	}
 }
 
@@ -425,29 +428,32 @@ func fetchMultiNamespaceResource(client *http.Client, namespaces []string, apiUr
 }
 
 func (h *Handler) generateTLSTransport(c *v1Cluster.Cluster, profile session.UserProfile) (http.RoundTripper, error) {
+	kubeConf, err := h.generateConfig(c, profile)
+	if err != nil {
+		return nil, err
+	}
+	return rest.TransportFor(kubeConf)
+}
+
+// generateConfig returns the config of the cluster the user reaches it with, the admin config for administrators
+func (h *Handler) generateConfig(c *v1Cluster.Cluster, profile session.UserProfile) (*rest.Config, error) {
 	if profile.IsAdministrator {
 		c := kubernetes.NewKubernetes(c)
-		adminConfig, err := c.Config()
-		if err != nil {
-			return nil, err
-		}
-		return rest.TransportFor(adminConfig)
-
+		return c.Config()
 	}
 
 	binding, err := h.clusterBindingService.GetBindingByClusterNameAndUserName(c.Name, profile.Name, common.DBOptions{})
 	if err != nil {
 		return nil, err
 	}
-	kubeConf := &rest.Config{
+	return &rest.Config{
 		Host: c.Spec.Connect.Forward.ApiServer,
 		TLSClientConfig: rest.TLSClientConfig{
 			Insecure: true,
 			CertData: binding.Certificate,
 			KeyData:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: c.PrivateKey}),
 		},
-	}
-	return rest.TransportFor(kubeConf)
+	}, nil
 }
 
 func ensureProxyPathValid(path string) string {
@@ -477,4 +483,8 @@ func Install(parent iris.Party) {
 	handler := NewHandler()
 	sp := parent.Party("/proxy")
 	sp.Any("/:name/k8s/{p:path}", handler.KubernetesAPIProxy())
+	sp.Any("/:name/portforward/:kind/:namespace/:target/{port:int}", handler.PortForwardProxy())
+	sp.Any("/:name/portforward/:kind/:namespace/:target/{port:int}/{p:path}", handler.PortForwardProxy())
+	sp.Get("/portforwards", handler.ListPortForwards())
+	sp.Delete("/portforwards/:id", handler.DeletePortForward())
 }
@@ -45,6 +45,8 @@ func (h *Handler) UpdateProfile() iris.Handler {
 			Language:            user.Language,
 			ResourcePermissions: profile.ResourcePermissions,
 			IsAdministrator:     user.IsAdmin,
+			SessionID:           profile.SessionID,
+			Mfa:                 profile.Mfa,
 		}
 		session.Set("profile", profile)
 		ctx.Values().Set("data", "ok")
@@ -0,0 +1,400 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	"github.com/KubeOperator/kubepi/pkg/util/saml"
+	v1 "github.com/KubeOperator/kubepi/service/model/v1"
+	v1Config "github.com/KubeOperator/kubepi/service/model/v1/config"
+	v1Role "github.com/KubeOperator/kubepi/service/model/v1/role"
+	v1User "github.com/KubeOperator/kubepi/service/model/v1/user"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"github.com/KubeOperator/kubepi/service/service/v1/rolebinding"
+	"github.com/asdine/storm/v3"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// samlBindingCreator marks the role bindings managed by the saml group mappings
+const samlBindingCreator = "saml"
+
+const samlRequestTimeout = 10 * time.Minute
+
+const samlDefaultRedirect = "/kubepi"
+
+// samlMfaRedirect is the login page of the ui, which asks for the second factor of users with mfa
+const samlMfaRedirect = "/kubepi/login"
+
+type samlRequest struct {
+	authMethod string
+	redirect   string
+	expireAt   time.Time
+}
+
+// samlState keeps the pending AuthnRequests and the consumed assertion ids,
+// the latter prevent a captured response from being replayed.
+type samlState struct {
+	requests   map[string]samlRequest
+	assertions map[string]time.Time
+	lock       sync.Mutex
+}
+
+var samlStates = samlState{requests: map[string]samlRequest{}, assertions: map[string]time.Time{}}
+
+func (s *samlState) addRequest(id string, r samlRequest) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.gc()
+	s.requests[id] = r
+}
+
+func (s *samlState) takeRequest(id string) (samlRequest, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.requests[id]
+	delete(s.requests, id)
+	return r, ok && r.expireAt.After(time.Now())
+}
+
+func (s *samlState) peekRequest(id string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.requests[id]
+	return ok && r.expireAt.After(time.Now())
+}
+
+func (s *samlState) consumeAssertion(id string, expireAt time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.gc()
+	if _, ok := s.assertions[id]; ok {
+		return false
+	}
+	s.assertions[id] = expireAt
+	return true
+}
+
+func (s *samlState) gc() {
+	now := time.Now()
+	for id, r := range s.requests {
+		if r.expireAt.Before(now) {
+			delete(s.requests, id)
+		}
+	}
+	for id, expireAt := range s.assertions {
+		if expireAt.Before(now) {
+			delete(s.assertions, id)
+		}
+	}
+}
+
+func samlEndpoint(c v1Config.SamlConfig, name string) string {
+	return strings.TrimSuffix(c.BaseUrl, "/") + "/kubepi/api/v1/sessions/saml/" + name
+}
+
+func newServiceProvider() (*saml.ServiceProvider, error) {
+	c := server.Config().Spec.Saml
+	if !c.Enable {
+		return nil, errors.New("saml login is not enabled")
+	}
+	sp := &saml.ServiceProvider{
+		EntityId:          c.EntityId,
+		AcsUrl:            samlEndpoint(c, "acs"),
+		IdpEntityId:       c.Idp.EntityId,
+		IdpSsoUrl:         c.Idp.SsoUrl,
+		ClockSkew:         time.Duration(c.ClockSkew) * time.Second,
+		AllowIdpInitiated: c.AllowIdpInitiated,
+	}
+	if sp.EntityId == "" {
+		sp.EntityId = samlEndpoint(c, "metadata")
+	}
+	if c.Idp.MetadataFile != "" {
+		data, err := ioutil.ReadFile(c.Idp.MetadataFile)
+		if err != nil {
+			return nil, err
+		}
+		m, err := saml.ParseIdpMetadata(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse identity provider metadata failed: %s", err.Error())
+		}
+		sp.IdpEntityId = m.EntityId
+		sp.IdpSsoUrl = m.SsoUrl
+		sp.IdpCertificates = m.Certificates
+	} else if c.Idp.Certificate != "" {
+		cert, err := saml.ParseCertificate(c.Idp.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("parse identity provider certificate failed: %s", err.Error())
+		}
+		sp.IdpCertificates = append(sp.IdpCertificates, cert)
+	}
+	if len(sp.IdpCertificates) == 0 {
+		return nil, errors.New("identity provider certificate is not configured")
+	}
+	return sp, nil
+}
+
+// safeRedirect only allows paths of KubePi itself, to avoid an open redirect
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return samlDefaultRedirect
+	}
+	return redirect
+}
+
+// SamlMetadata
+// @Tags sessions
+// @Summary SAML service provider metadata
+// @Description SAML service provider metadata
+// @Produce  xml
+// @Router /sessions/saml/metadata [get]
+func (h *Handler) SamlMetadata() iris.Handler {
+	return func(ctx *context.Context) {
+		sp, err := newServiceProvider()
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		data, err := sp.Metadata()
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.ContentType(server.ContentTypeSamlMetadata)
+		_, _ = ctx.Write(data)
+	}
+}
+
+// SamlStatus
+// @Tags sessions
+// @Summary Whether SAML login is enabled
+// @Description Whether SAML login is enabled, the login page offers it then
+// @Produce  json
+// @Router /sessions/saml/status [get]
+func (h *Handler) SamlStatus() iris.Handler {
+	return func(ctx *context.Context) {
+		ctx.Values().Set("data", server.Config().Spec.Saml.Enable)
+	}
+}
+
+// SamlLogin
+// @Tags sessions
+// @Summary Start a SAML login
+// @Description Redirect to the identity provider, authMethod "jwt" returns the token in the fragment of the redirect url
+// @Param authMethod query string false "cookie or jwt"
+// @Param redirect query string false "path to return to after login"
+// @Router /sessions/saml/login [get]
+func (h *Handler) SamlLogin() iris.Handler {
+	return func(ctx *context.Context) {
+		sp, err := newServiceProvider()
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		redirect := safeRedirect(ctx.URLParamDefault("redirect", samlDefaultRedirect))
+		id, u, err := sp.AuthnRequestUrl(redirect)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		samlStates.addRequest(id, samlRequest{
+			authMethod: ctx.URLParam("authMethod"),
+			redirect:   redirect,
+			expireAt:   time.Now().Add(samlRequestTimeout),
+		})
+		ctx.Redirect(u, iris.StatusFound)
+	}
+}
+
+// SamlAcs
+// @Tags sessions
+// @Summary SAML assertion consumer service
+// @Description Receives the response of the identity provider with the HTTP-POST binding
+// @Accept  x-www-form-urlencoded
+// @Router /sessions/saml/acs [post]
+func (h *Handler) SamlAcs() iris.Handler {
+	return func(ctx *context.Context) {
+		sp, err := newServiceProvider()
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		assertion, err := sp.ParseResponse(ctx.PostValue("SAMLResponse"), samlStates.peekRequest)
+		if err != nil {
+			server.Logger().Warnf("reject saml response: %s", err.Error())
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", fmt.Sprintf("saml login failed: %s", err.Error()))
+			return
+		}
+		request := samlRequest{redirect: safeRedirect(ctx.PostValue("RelayState"))}
+		if assertion.InResponseTo != "" {
+			r, ok := samlStates.takeRequest(assertion.InResponseTo)
+			if !ok {
+				ctx.StatusCode(iris.StatusUnauthorized)
+				ctx.Values().Set("message", "saml login failed: login request is already used")
+				return
+			}
+			request = r
+		}
+		if !samlStates.consumeAssertion(assertion.ID, assertion.NotOnOrAfter) {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "saml login failed: assertion is already used")
+			return
+		}
+
+		u, err := h.provisionSamlUser(assertion)
+		if err != nil {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		if u.Disabled {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", "user is disabled")
+			return
+		}
+		profile, token, err := h.startLoginSession(ctx, u, request.authMethod)
+		if err != nil {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		go saveLoginLog(ctx, profile.Name)
+		// the fragment is never sent to a server, the ui reads the token or whether the login waits for the
+		// second factor from it
+		redirect := request.redirect
+		switch {
+		case profile.Mfa.Enable && !profile.Mfa.Approved:
+			redirect = fmt.Sprintf("%s#mfa=%t", samlMfaRedirect, profile.Mfa.Bound)
+		case token != nil:
+			redirect = fmt.Sprintf("%s#token=%s", redirect, url.QueryEscape(string(token)))
+		}
+		ctx.Redirect(redirect, iris.StatusSeeOther)
+	}
+}
+
+// provisionSamlUser creates or updates the user from the assertion attributes
+// and binds the roles mapped from the user's groups.
+func (h *Handler) provisionSamlUser(assertion *saml.Assertion) (*v1User.User, error) {
+	c := server.Config().Spec.Saml
+	attr := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		return assertion.Attribute(name)
+	}
+	name := attr(c.Attributes.Name)
+	if name == "" {
+		name = assertion.NameID
+	}
+	nickName := attr(c.Attributes.NickName)
+	email := attr(c.Attributes.Email)
+
+	u, err := h.userService.GetByNameOrEmail(name, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	if u == nil || errors.Is(err, storm.ErrNotFound) {
+		if email == "" {
+			return nil, fmt.Errorf("the identity provider sent no email of user %s", name)
+		}
+		u = &v1User.User{
+			BaseModel: v1.BaseModel{
+				ApiVersion: "v1",
+				Kind:       "User",
+				CreatedBy:  samlBindingCreator,
+			},
+			Metadata: v1.Metadata{
+				Name: name,
+			},
+			NickName: nickName,
+			Email:    email,
+			Type:     v1User.SAML,
+		}
+		if u.NickName == "" {
+			u.NickName = name
+		}
+		if err := h.userService.Create(u, common.DBOptions{}); err != nil {
+			return nil, fmt.Errorf("create user %s failed: %s", name, err.Error())
+		}
+	} else {
+		// never let the identity provider take over a local or ldap account
+		if u.Type != v1User.SAML || u.Name != name {
+			return nil, fmt.Errorf("user %s is not a saml user", name)
+		}
+		if (nickName != "" && nickName != u.NickName) || (email != "" && email != u.Email) {
+			if nickName != "" {
+				u.NickName = nickName
+			}
+			if email != "" {
+				u.Email = email
+			}
+			u.UpdateAt = time.Now()
+			if err := h.userService.GetDB(common.DBOptions{}).Update(u); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := h.syncSamlRoleBindings(u.Name, assertion.Attributes[c.Attributes.Groups]); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (h *Handler) syncSamlRoleBindings(userName string, groups []string) error {
+	c := server.Config().Spec.Saml
+	userGroups := collectons.NewStringSet()
+	for _, g := range groups {
+		userGroups.Add(strings.ToLower(g))
+	}
+	desired := collectons.NewStringSet()
+	for _, r := range c.DefaultRoles {
+		desired.Add(r)
+	}
+	for _, m := range c.GroupMappings {
+		if userGroups.Exists(strings.ToLower(m.Group)) {
+			for _, r := range m.Roles {
+				desired.Add(r)
+			}
+		}
+	}
+	bindings, err := h.rolebindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: userName}, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	current := collectons.NewStringSet()
+	for i := range bindings {
+		current.Add(bindings[i].RoleRef)
+		if bindings[i].CreatedBy == samlBindingCreator && !desired.Exists(bindings[i].RoleRef) {
+			if err := h.rolebindingService.Delete(bindings[i].Name, common.DBOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range desired.ToSlice() {
+		if current.Exists(r) {
+			continue
+		}
+		if _, err := h.roleService.Get(r, common.DBOptions{}); err != nil {
+			server.Logger().Warnf("skip saml role mapping of user %s, role %s: %s", userName, r, err.Error())
+			continue
+		}
+		binding := rolebinding.NewUserRoleBinding(r, userName, samlBindingCreator)
+		if err := h.rolebindingService.CreateRoleBinding(&binding, common.DBOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
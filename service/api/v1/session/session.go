@@ -21,12 +21,15 @@ import (
 	"github.com/KubeOperator/kubepi/pkg/collectons"
 	"github.com/KubeOperator/kubepi/pkg/kubernetes"
 	"github.com/KubeOperator/kubepi/pkg/logging"
+	"github.com/KubeOperator/kubepi/pkg/loginsession"
 	"github.com/KubeOperator/kubepi/pkg/network/ip"
 	"github.com/KubeOperator/kubepi/pkg/terminal"
 	"github.com/asdine/storm/v3"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kataras/iris/v12/middleware/jwt"
+	"github.com/kataras/iris/v12/sessions"
+	kjwt "github.com/kataras/jwt"
 	"golang.org/x/crypto/bcrypt"
 	v1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -34,6 +37,13 @@ import (
 
 var jwtMaxAge = 10 * time.Minute
 
+// pendingLoginKey holds the login session waiting for the mfa code in the cookie session
+const pendingLoginKey = "pendingLogin"
+
+// JwtBlocklist holds the jwt tokens of revoked login sessions,
+// it is attached to the jwt verifier of the api.
+var JwtBlocklist = kjwt.NewBlocklist(30 * time.Minute)
+
 type Handler struct {
 	userService        user.Service
 	roleService        role.Service
@@ -129,47 +139,22 @@ func (h *Handler) Login() iris.Handler {
 			}
 		}
 
-		permissions, err := h.aggregateResourcePermissions(loginCredential.Username)
+		if u.Disabled {
+			ctx.StatusCode(iris.StatusForbidden)
+			ctx.Values().Set("message", "user is disabled")
+			return
+		}
+
+		profile, token, err := h.startLoginSession(ctx, u, loginCredential.AuthMethod)
 		if err != nil {
 			ctx.StatusCode(iris.StatusInternalServerError)
 			ctx.Values().Set("message", err.Error())
 			return
 		}
-		profile := UserProfile{
-			Name:                u.Name,
-			NickName:            u.NickName,
-			Email:               u.Email,
-			Language:            u.Language,
-			ResourcePermissions: permissions,
-			IsAdministrator:     u.IsAdmin,
-			Mfa: Mfa{
-				Secret:   u.Mfa.Secret,
-				Enable:   u.Mfa.Enable,
-				Approved: false,
-			},
-		}
-
-		authMethod := loginCredential.AuthMethod
-
-		switch authMethod {
-		case "jwt":
-			token, err := h.jwtSigner.Sign(profile)
-			if err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.Values().Set("message", err.Error())
-			}
+		if token != nil {
 			ctx.StatusCode(iris.StatusOK)
 			ctx.Values().Set("token", token)
 			return
-		default:
-			sId := ctx.GetCookie(server.SessionCookieName)
-			if sId != "" {
-				ctx.RemoveCookie(server.SessionCookieName)
-				ctx.Request().Header.Del("Cookie")
-			}
-			sess := server.SessionMgr.Start(ctx)
-			ctx.SetCookieKV(server.SessionCookieName, sess.ID())
-			sess.Set("profile", profile)
 		}
 
 		ctx.StatusCode(iris.StatusOK)
@@ -178,6 +163,156 @@ func (h *Handler) Login() iris.Handler {
 	}
 }
 
+// startLoginSession creates the login session of an authenticated user, with
+// the jwt auth method the signed token is returned, otherwise the session cookie is set.
+// The login session of a user with mfa waits in the cookie session for the mfa code instead.
+func (h *Handler) startLoginSession(ctx *context.Context, u *v1User.User, authMethod string) (UserProfile, []byte, error) {
+	permissions, err := h.aggregateResourcePermissions(u.Name)
+	if err != nil {
+		return UserProfile{}, nil, err
+	}
+	mfaEnforced, err := IsMfaEnforced(u.Name)
+	if err != nil {
+		return UserProfile{}, nil, err
+	}
+	profile := UserProfile{
+		Name:                u.Name,
+		NickName:            u.NickName,
+		Email:               u.Email,
+		Language:            u.Language,
+		ResourcePermissions: permissions,
+		IsAdministrator:     u.IsAdmin,
+		Mfa: Mfa{
+			Secret:   u.Mfa.Secret,
+			Bound:    u.Mfa.Bound(),
+			Enable:   u.Mfa.Enable || mfaEnforced,
+			Approved: false,
+			Enforced: mfaEnforced,
+		},
+	}
+
+	loginSessionId, err := loginsession.GenLoginSessionId()
+	if err != nil {
+		return UserProfile{}, nil, err
+	}
+	profile.SessionID = loginSessionId
+	now := time.Now()
+	loginSession := loginsession.LoginSession{
+		Id:        loginSessionId,
+		UserName:  u.Name,
+		Ip:        ctx.RemoteAddr(),
+		UserAgent: ctx.GetHeader("User-Agent"),
+		Method:    loginsession.MethodCookie,
+		CreateAt:  now,
+		LastSeen:  now,
+	}
+	if authMethod == "jwt" {
+		loginSession.Method = loginsession.MethodJwt
+	}
+
+	if profile.Mfa.Enable {
+		// the login session is only activated once the second factor is validated, until then the cookie
+		// session only reaches the mfa routes
+		sess := renewCookieSession(ctx)
+		sess.Set("profile", profile)
+		sess.Set(pendingLoginKey, loginSession)
+		return profile, nil, nil
+	}
+	return h.activateLoginSession(ctx, profile, loginSession)
+}
+
+// CompleteMfaLogin activates the login session which waits for the second factor of the user, the jwt token
+// is returned if the user logged in with the jwt auth method
+func (h *Handler) CompleteMfaLogin(ctx *context.Context, profile UserProfile) (UserProfile, []byte, error) {
+	sess := server.SessionMgr.Start(ctx)
+	pending, ok := sess.Get(pendingLoginKey).(loginsession.LoginSession)
+	if !ok || pending.Id != profile.SessionID {
+		return UserProfile{}, nil, errors.New("no login waits for the mfa code")
+	}
+	server.SessionMgr.Destroy(ctx)
+	profile.Mfa.Approved = true
+	return h.activateLoginSession(ctx, profile, pending)
+}
+
+// activateLoginSession registers the login session, with the jwt auth method the signed token is returned,
+// otherwise the session cookie is set.
+func (h *Handler) activateLoginSession(ctx *context.Context, profile UserProfile, loginSession loginsession.LoginSession) (UserProfile, []byte, error) {
+	if loginSession.Method == loginsession.MethodJwt {
+		token, err := h.jwtSigner.Sign(profile, jwt.ID(loginSession.Id))
+		if err != nil {
+			return UserProfile{}, nil, err
+		}
+		loginSession.ExpireAt = time.Now().Add(jwtMaxAge)
+		loginsession.LoginSessions.Set(loginSession)
+		return profile, token, nil
+	}
+	sess := renewCookieSession(ctx)
+	sess.Set("profile", profile)
+	loginSession.CookieId = sess.ID()
+	loginSession.ExpireAt = time.Now().Add(time.Duration(server.Config().Spec.Session.Expires) * time.Hour)
+	loginsession.LoginSessions.Set(loginSession)
+	return profile, nil, nil
+}
+
+// renewCookieSession starts a new cookie session, dropping the one the request came with
+func renewCookieSession(ctx *context.Context) *sessions.Session {
+	sId := ctx.GetCookie(server.SessionCookieName)
+	if sId != "" {
+		ctx.RemoveCookie(server.SessionCookieName)
+		ctx.Request().Header.Del("Cookie")
+	}
+	sess := server.SessionMgr.Start(ctx)
+	ctx.SetCookieKV(server.SessionCookieName, sess.ID())
+	return sess
+}
+
+// JwtHandler verifies the jwt token of the requests which do not come with a cookie session
+func JwtHandler() iris.Handler {
+	verifier := jwt.NewVerifier(jwt.HS256, server.Config().Spec.Jwt.Key)
+	verifier.Blocklist = JwtBlocklist
+	verifyMiddleware := verifier.Verify(func() interface{} {
+		return new(UserProfile)
+	})
+	return func(ctx *context.Context) {
+		sess := server.SessionMgr.Start(ctx)
+		if sess.Get("profile") != nil {
+			ctx.Next()
+			return
+		}
+		verifyMiddleware(ctx)
+	}
+}
+
+// loginProfile returns the profile of the cookie session or of the jwt token verified by JwtHandler, the
+// login session it belongs to must still be live
+func loginProfile(ctx *context.Context) (UserProfile, bool) {
+	p, ok := server.SessionMgr.Start(ctx).Get("profile").(UserProfile)
+	if !ok {
+		claims, isJwt := jwt.Get(ctx).(*UserProfile)
+		if !isJwt {
+			return UserProfile{}, false
+		}
+		p = *claims
+	}
+	if _, live := loginsession.LoginSessions.Get(p.SessionID); !live {
+		return UserProfile{}, false
+	}
+	return p, true
+}
+
+// mfaApproved stops the requests of a login which still waits for the second factor of the user
+func mfaApproved() iris.Handler {
+	return func(ctx *context.Context) {
+		p, ok := server.SessionMgr.Start(ctx).Get("profile").(UserProfile)
+		if ok && p.Mfa.Enable && !p.Mfa.Approved {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "mfa is not approved")
+			return
+		}
+		ctx.Next()
+	}
+}
+
 func saveLoginLog(ctx *context.Context, userName string) {
 	var logItem v1System.LoginLog
 	logItem.UserName = userName
@@ -192,6 +327,61 @@ func saveLoginLog(ctx *context.Context, userName string) {
 	systemService.CreateLoginLog(&logItem, common.DBOptions{})
 }
 
+// IsMfaEnforced reports whether the mfa policy requires the user to enroll,
+// either globally or through one of the user's roles.
+func IsMfaEnforced(name string) (bool, error) {
+	policy := server.Config().Spec.Mfa
+	if policy.Enforce {
+		return true, nil
+	}
+	if len(policy.EnforceRoles) == 0 {
+		return false, nil
+	}
+	bindings, err := rolebinding.NewService().GetRoleBindingBySubject(v1Role.Subject{
+		Kind: "User",
+		Name: name,
+	}, common.DBOptions{})
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return false, err
+	}
+	enforceRoles := collectons.NewStringSet()
+	for i := range policy.EnforceRoles {
+		enforceRoles.Add(policy.EnforceRoles[i])
+	}
+	for i := range bindings {
+		if enforceRoles.Exists(bindings[i].RoleRef) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// HasVerb reports whether the current user may use the verb on the resource of KubePi, administrators may use
+// all of them and other users through the roles the role handler resolved for the request
+func HasVerb(ctx *context.Context, resource, verb string) bool {
+	if profile, ok := ctx.Values().Get("profile").(UserProfile); ok && profile.IsAdministrator {
+		return true
+	}
+	roles, _ := ctx.Values().Get("roles").([]v1Role.Role)
+	for i := range roles {
+		for _, rule := range roles[i].Rules {
+			if containsOrWildcard(rule.Resource, resource) && containsOrWildcard(rule.Verbs, verb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsOrWildcard(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *Handler) aggregateResourcePermissions(name string) (map[string][]string, error) {
 	userRoleBindings, err := h.rolebindingService.GetRoleBindingBySubject(v1Role.Subject{
 		Kind: "User",
@@ -249,14 +439,81 @@ func (h *Handler) Logout() iris.Handler {
 			ctx.Values().Set("message", "no login user")
 			return
 		}
+		p, ok := loginUser.(UserProfile)
+		if !ok {
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", "can not parse to session user")
+			return
+		}
 		session.Delete("profile")
-		logging.LogSessions.Clean()
-		terminal.TerminalSessions.Clean()
+		RevokeLoginSession(p.SessionID, "system is logout, please retry...")
 		ctx.StatusCode(iris.StatusOK)
 		ctx.Values().Set("data", "logout success")
 	}
 }
 
+func init() {
+	loginsession.RevokeHook = releaseLoginSession
+}
+
+// RevokeLoginSession terminates a login session: the cookie session is destroyed,
+// its jwt tokens are blocked and only the terminals and log streams it opened are closed.
+func RevokeLoginSession(id string, reason string) bool {
+	if id == "" {
+		return false
+	}
+	return loginsession.LoginSessions.Revoke(id, reason)
+}
+
+func releaseLoginSession(s loginsession.LoginSession, reason string) {
+	switch s.Method {
+	case loginsession.MethodCookie:
+		server.SessionMgr.DestroyByID(s.CookieId)
+	case loginsession.MethodJwt:
+		_ = JwtBlocklist.InvalidateToken([]byte(s.Id), kjwt.Claims{ID: s.Id, Expiry: s.ExpireAt.Unix()})
+	}
+	terminal.TerminalSessions.CloseByLoginSession(s.Id, reason)
+	logging.LogSessions.CloseByLoginSession(s.Id, reason)
+}
+
+// ListLoginSessions lists the live login sessions of the current user
+func (h *Handler) ListLoginSessions() iris.Handler {
+	return func(ctx *context.Context) {
+		p, ok := loginProfile(ctx)
+		if !ok {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "no login user")
+			return
+		}
+		items := loginsession.LoginSessions.List(p.Name)
+		for i := range items {
+			items[i].Current = items[i].Id == p.SessionID
+		}
+		ctx.Values().Set("data", items)
+	}
+}
+
+// DeleteLoginSession revokes one of the login sessions of the current user
+func (h *Handler) DeleteLoginSession() iris.Handler {
+	return func(ctx *context.Context) {
+		p, ok := loginProfile(ctx)
+		if !ok {
+			ctx.StatusCode(iris.StatusUnauthorized)
+			ctx.Values().Set("message", "no login user")
+			return
+		}
+		id := ctx.Params().GetString("id")
+		s, exists := loginsession.LoginSessions.Get(id)
+		if !exists || s.UserName != p.Name {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.Values().Set("message", fmt.Sprintf("login session %s not found", id))
+			return
+		}
+		RevokeLoginSession(id, "the session has been revoked")
+		ctx.Values().Set("data", "ok")
+	}
+}
+
 func (h *Handler) GetProfile() iris.Handler {
 	return func(ctx *context.Context) {
 		session := server.SessionMgr.Start(ctx)
@@ -285,6 +542,8 @@ func (h *Handler) GetProfile() iris.Handler {
 			Email:           user.Email,
 			Language:        user.Language,
 			IsAdministrator: user.IsAdmin,
+			SessionID:       p.SessionID,
+			Mfa:             p.Mfa,
 		}
 		if !user.IsAdmin {
 			permissions, err := h.aggregateResourcePermissions(p.Name)
@@ -419,10 +678,17 @@ func Install(parent iris.Party) {
 	sp := parent.Party("/sessions")
 	sp.Post("", handler.Login())
 	sp.Delete("", handler.Logout())
-	sp.Get("", handler.GetProfile())
-	sp.Get("/:cluster_name", handler.GetClusterProfile())
+	sp.Get("", mfaApproved(), handler.GetProfile())
+	sp.Get("/:cluster_name", mfaApproved(), handler.GetClusterProfile())
 	sp.Get("/status", handler.IsLogin())
-	sp.Get("/:cluster_name/namespaces", handler.ListUserNamespace())
-	sp.Put("", handler.UpdateProfile())
-	sp.Put("/password", handler.UpdatePassword())
+	sp.Get("/:cluster_name/namespaces", mfaApproved(), handler.ListUserNamespace())
+	sp.Put("", mfaApproved(), handler.UpdateProfile())
+	sp.Put("/password", mfaApproved(), handler.UpdatePassword())
+	// the login sessions are also listed and revoked with jwt tokens
+	sp.Get("/login-sessions", JwtHandler(), mfaApproved(), handler.ListLoginSessions())
+	sp.Delete("/login-sessions/:id", JwtHandler(), mfaApproved(), handler.DeleteLoginSession())
+	sp.Get("/saml/status", handler.SamlStatus())
+	sp.Get("/saml/metadata", handler.SamlMetadata())
+	sp.Get("/saml/login", handler.SamlLogin())
+	sp.Post("/saml/acs", handler.SamlAcs())
 }
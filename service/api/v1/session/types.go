@@ -9,9 +9,10 @@ type LoginCredential struct {
 	AuthMethod string `json:"authMethod"`
 }
 type MfaCredential struct {
-	Username string `json:"username"`
-	Secret   string `json:"secret"`
-	Code     string `json:"code"`
+	Username     string `json:"username"`
+	Secret       string `json:"secret"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
 }
 
 type PasswordSetter struct {
@@ -33,6 +34,7 @@ type UserProfile struct {
 	ResourcePermissions map[string][]string `json:"resourcePermissions"`
 	IsAdministrator     bool                `json:"isAdministrator"`
 	Mfa                 Mfa                 `json:"mfa"`
+	SessionID           string              `json:"sessionId"`
 }
 
 type ClusterUserProfile struct {
@@ -41,7 +43,10 @@ type ClusterUserProfile struct {
 }
 
 type Mfa struct {
-	Enable   bool   `json:"enable"`
-	Secret   string `json:"secret"`
+	Enable bool `json:"enable"`
+	// Secret stays on the server, the client only learns whether a device is bound
+	Secret   string `json:"-"`
+	Bound    bool   `json:"bound"`
 	Approved bool   `json:"approved"`
+	Enforced bool   `json:"enforced"`
 }
@@ -0,0 +1,113 @@
+package terminalsession
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/KubeOperator/kubepi/pkg/logging"
+	"github.com/KubeOperator/kubepi/pkg/terminal"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+const (
+	KindTerminal = "terminal"
+	KindLogging  = "logging"
+)
+
+// TerminalSession is a live terminal or log stream of a user
+type TerminalSession struct {
+	Id        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	User      string    `json:"user"`
+	Cluster   string    `json:"cluster"`
+	Target    string    `json:"target"`
+	Connected bool      `json:"connected"`
+	CreatedAt time.Time `json:"createdAt"`
+	// IdleDeadline is when an idle terminal is closed, unset if idle terminals are kept
+	IdleDeadline *time.Time `json:"idleDeadline,omitempty"`
+	// Viewers are the users attached to a shared terminal
+	Viewers []string `json:"viewers,omitempty"`
+}
+
+type Handler struct{}
+
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+// ListTerminalSessions lists the live terminals and log streams of all users, filtered by the user and
+// cluster params
+func (h *Handler) ListTerminalSessions() iris.Handler {
+	return func(ctx *context.Context) {
+		user := ctx.URLParam("user")
+		cluster := ctx.URLParam("cluster")
+		items := []TerminalSession{}
+		for _, s := range terminal.TerminalSessions.List() {
+			item := TerminalSession{
+				Id:        s.Id,
+				Kind:      KindTerminal,
+				User:      s.Owner,
+				Cluster:   s.Cluster,
+				Target:    s.Target,
+				Connected: s.Connected(),
+				CreatedAt: s.CreatedAt,
+			}
+			if s.Limits.IdleTimeout > 0 {
+				deadline := s.TimeOut
+				item.IdleDeadline = &deadline
+			}
+			for _, v := range s.Viewers() {
+				item.Viewers = append(item.Viewers, v.User)
+			}
+			items = append(items, item)
+		}
+		for _, s := range logging.LogSessions.List() {
+			items = append(items, TerminalSession{
+				Id:        s.Id,
+				Kind:      KindLogging,
+				User:      s.User,
+				Cluster:   s.Cluster,
+				Target:    s.Target,
+				Connected: s.Connected(),
+				CreatedAt: s.CreatedAt,
+			})
+		}
+		filtered := items[:0]
+		for _, item := range items {
+			if (user == "" || item.User == user) && (cluster == "" || item.Cluster == cluster) {
+				filtered = append(filtered, item)
+			}
+		}
+		sort.Slice(filtered, func(i, j int) bool {
+			return filtered[i].CreatedAt.Before(filtered[j].CreatedAt)
+		})
+		ctx.Values().Set("data", filtered)
+	}
+}
+
+// DeleteTerminalSession force closes the terminal or log stream, its user is shown the reason
+func (h *Handler) DeleteTerminalSession() iris.Handler {
+	return func(ctx *context.Context) {
+		id := ctx.Params().GetString("id")
+		const reason = "the session has been closed by an administrator"
+		if s := terminal.TerminalSessions.Get(id); s.Id != "" {
+			terminal.TerminalSessions.Close(id, 2, reason)
+			return
+		}
+		if s := logging.LogSessions.Get(id); s.Id != "" {
+			logging.LogSessions.Close(id, reason, 2)
+			return
+		}
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.Values().Set("message", fmt.Sprintf("terminal session %s not found", id))
+	}
+}
+
+func Install(parent iris.Party) {
+	handler := NewHandler()
+	sp := parent.Party("/terminalsessions")
+	sp.Get("", handler.ListTerminalSessions())
+	sp.Delete("/:id", handler.DeleteTerminalSession())
+}
@@ -18,6 +18,7 @@ import (
 	pkgV1 "github.com/KubeOperator/kubepi/pkg/api/v1"
 	"github.com/KubeOperator/kubepi/pkg/collectons"
 	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	"github.com/KubeOperator/kubepi/pkg/loginsession"
 	"github.com/asdine/storm/v3"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
@@ -71,6 +72,7 @@ func (h *Handler) SearchUsers() iris.Handler {
 		us := make([]User, 0)
 		for i := range users {
 			users[i].Authenticate = v1User.Authenticate{}
+			users[i].Mfa.Reset()
 			bindings, err := h.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: users[i].Name}, common.DBOptions{})
 			if err != nil && !errors.As(err, &storm.ErrNotFound) {
 				ctx.StatusCode(iris.StatusInternalServerError)
@@ -254,6 +256,9 @@ func (h *Handler) DeleteUser() iris.Handler {
 			return
 		}
 		_ = tx.Commit()
+		for _, s := range loginsession.LoginSessions.List(userName) {
+			session.RevokeLoginSession(s.Id, "the user has been deleted")
+		}
 	}
 }
 
@@ -277,6 +282,7 @@ func (h *Handler) GetUser() iris.Handler {
 			return
 		}
 		u.Authenticate = v1User.Authenticate{}
+		u.Mfa.Reset()
 		bindings, err := h.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: u.Name}, common.DBOptions{})
 		if err != nil && !errors.As(err, &storm.ErrNotFound) {
 			ctx.StatusCode(iris.StatusInternalServerError)
@@ -412,6 +418,74 @@ func (h *Handler) UpdateUser() iris.Handler {
 	}
 }
 
+// Reset User Mfa
+// @Tags users
+// @Summary Reset user mfa by name
+// @Description Remove the bound mfa device and recovery codes, the user has to bind again at next login
+// @Accept  json
+// @Produce  json
+// @Param name path string true "用户名称"
+// @Security ApiKeyAuth
+// @Router /users/{name}/mfa [delete]
+func (h *Handler) ResetUserMfa() iris.Handler {
+	return func(ctx *context.Context) {
+		userName := ctx.Params().GetString("name")
+		if err := h.userService.ResetMfa(userName, common.DBOptions{}); err != nil {
+			if errors.Is(err, storm.ErrNotFound) {
+				ctx.StatusCode(iris.StatusNotFound)
+				ctx.Values().Set("message", fmt.Sprintf("user %s not found", userName))
+				return
+			}
+			ctx.StatusCode(iris.StatusInternalServerError)
+			ctx.Values().Set("message", err.Error())
+			return
+		}
+		ctx.Values().Set("data", "ok")
+	}
+}
+
+// List User Sessions
+// @Tags users
+// @Summary List login sessions of user
+// @Description List the live login sessions of user
+// @Accept  json
+// @Produce  json
+// @Param name path string true "用户名称"
+// @Success 200 {object} []loginsession.LoginSession
+// @Security ApiKeyAuth
+// @Router /users/{name}/sessions [get]
+func (h *Handler) ListUserSessions() iris.Handler {
+	return func(ctx *context.Context) {
+		userName := ctx.Params().GetString("name")
+		ctx.Values().Set("data", loginsession.LoginSessions.List(userName))
+	}
+}
+
+// Revoke User Session
+// @Tags users
+// @Summary Revoke login session of user
+// @Description Revoke a login session of user, its tokens, terminals and log streams are closed
+// @Accept  json
+// @Produce  json
+// @Param name path string true "用户名称"
+// @Param id path string true "会话ID"
+// @Security ApiKeyAuth
+// @Router /users/{name}/sessions/{id} [delete]
+func (h *Handler) RevokeUserSession() iris.Handler {
+	return func(ctx *context.Context) {
+		userName := ctx.Params().GetString("name")
+		id := ctx.Params().GetString("id")
+		s, ok := loginsession.LoginSessions.Get(id)
+		if !ok || s.UserName != userName {
+			ctx.StatusCode(iris.StatusNotFound)
+			ctx.Values().Set("message", fmt.Sprintf("login session %s not found", id))
+			return
+		}
+		session.RevokeLoginSession(id, "the session has been revoked by administrator")
+		ctx.Values().Set("data", "ok")
+	}
+}
+
 func Install(parent iris.Party) {
 	handler := NewHandler()
 	sp := parent.Party("/users")
@@ -420,5 +494,8 @@ func Install(parent iris.Party) {
 	sp.Delete("/:name", handler.DeleteUser())
 	sp.Get("/:name", handler.GetUser())
 	sp.Put("/:name", handler.UpdateUser())
+	sp.Delete("/:name/mfa", handler.ResetUserMfa())
+	sp.Get("/:name/sessions", handler.ListUserSessions())
+	sp.Delete("/:name/sessions/:id", handler.RevokeUserSession())
 	sp.Get("/", handler.GetUsers())
 }
@@ -15,6 +15,7 @@ import (
 	"github.com/kataras/iris/v12/middleware/jwt"
 
 	"github.com/KubeOperator/kubepi/service/api/v1/chart"
+	"github.com/KubeOperator/kubepi/service/api/v1/chartrepo"
 	"github.com/KubeOperator/kubepi/service/api/v1/cluster"
 	"github.com/KubeOperator/kubepi/service/api/v1/imagerepo"
 	"github.com/KubeOperator/kubepi/service/api/v1/ldap"
@@ -22,6 +23,7 @@ import (
 	"github.com/KubeOperator/kubepi/service/api/v1/role"
 	"github.com/KubeOperator/kubepi/service/api/v1/session"
 	"github.com/KubeOperator/kubepi/service/api/v1/system"
+	"github.com/KubeOperator/kubepi/service/api/v1/terminalsession"
 	"github.com/KubeOperator/kubepi/service/api/v1/user"
 	"github.com/KubeOperator/kubepi/service/api/v1/webkubectl"
 	"github.com/KubeOperator/kubepi/service/api/v1/ws"
@@ -35,6 +37,7 @@ import (
 	pkgV1 "github.com/KubeOperator/kubepi/pkg/api/v1"
 	"github.com/KubeOperator/kubepi/pkg/collectons"
 	"github.com/KubeOperator/kubepi/pkg/i18n"
+	"github.com/KubeOperator/kubepi/pkg/loginsession"
 	"github.com/asdine/storm/v3"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
@@ -69,6 +72,18 @@ func authHandler() iris.Handler {
 			ctx.StopWithStatus(iris.StatusUnauthorized)
 			return
 		}
+		if p.Mfa.Enable && !p.Mfa.Approved {
+			ctx.Values().Set("message", "mfa is not approved")
+			ctx.StopWithStatus(iris.StatusUnauthorized)
+			return
+		}
+		// the login session may have been revoked by its owner or an administrator
+		if _, ok := loginsession.LoginSessions.Get(p.SessionID); !ok {
+			ctx.Values().Set("message", "please login")
+			ctx.StopWithStatus(iris.StatusUnauthorized)
+			return
+		}
+		loginsession.LoginSessions.Touch(p.SessionID)
 		ctx.Values().Set("profile", p)
 		ctx.Next()
 	}
@@ -268,7 +283,18 @@ func roleHandler() iris.Handler {
 	}
 }
 
+// dedicatedVerbs are the verbs of routes which need a permission of their own rather than the one of their method
+var dedicatedVerbs = map[string]string{
+	"/kubepi/api/v1/clusters/:name/terminal/debug": "debug",
+	"/kubepi/api/v1/clusters/:name/terminal/node":  "nodeshell",
+	// sharing is limited to the owner of the terminal session, who already reached the cluster
+	"/kubepi/api/v1/clusters/:name/terminal/sessions/:id/shares": "get",
+}
+
 func getVerbByRoute(path, method string) string {
+	if verb, ok := dedicatedVerbs[path]; ok {
+		return verb
+	}
 	switch strings.ToLower(method) {
 	case "put":
 		return "update"
@@ -400,22 +426,6 @@ func resourceNameInvalidHandler() iris.Handler {
 	}
 }
 
-func WarpedJwtHandler() iris.Handler {
-	verifier := jwt.NewVerifier(jwt.HS256, server.Config().Spec.Jwt.Key)
-	verifier.WithDefaultBlocklist()
-	verifyMiddleware := verifier.Verify(func() interface{} {
-		return new(session.UserProfile)
-	})
-	return func(ctx *context.Context) {
-		sess := server.SessionMgr.Start(ctx)
-		if sess.Get("profile") != nil {
-			ctx.Next()
-			return
-		}
-		verifyMiddleware(ctx)
-	}
-}
-
 func AddV1Route(app iris.Party) {
 
 	v1Party := app.Party("/v1")
@@ -426,7 +436,7 @@ func AddV1Route(app iris.Party) {
 	v1Party.Use(pageHandler())
 	
 	authParty := v1Party.Party("")
-	authParty.Use(WarpedJwtHandler())
+	authParty.Use(session.JwtHandler())
 	authParty.Use(authHandler())
 	authParty.Use(resourceExtractHandler())
 	authParty.Use(roleHandler())
@@ -441,8 +451,10 @@ func AddV1Route(app iris.Party) {
 	proxy.Install(authParty)
 	ws.Install(authParty)
 	chart.Install(authParty)
+	chartrepo.Install(authParty)
 	webkubectl.Install(authParty, v1Party)
 	ldap.Install(authParty)
 	imagerepo.Install(authParty)
 	file.Install(authParty)
+	terminalsession.Install(authParty)
 }
@@ -51,3 +51,79 @@ type Repo struct {
 	UserName string `json:"userName"`
 	Password string `json:"password"`
 }
+
+type ReleaseRevision struct {
+	Revision      int       `json:"revision"`
+	Status        string    `json:"status"`
+	Chart         string    `json:"chart"`
+	ChartVersion  string    `json:"chartVersion"`
+	AppVersion    string    `json:"appVersion"`
+	Description   string    `json:"description"`
+	FirstDeployed time.Time `json:"firstDeployed"`
+	LastDeployed  time.Time `json:"lastDeployed"`
+}
+
+type ValuesDiff struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Diff string `json:"diff"`
+}
+
+type RegistryLogin struct {
+	// Host of the oci registry, e.g. registry.example.com:5000
+	Host     string `json:"host"`
+	UserName string `json:"userName"`
+	Password string `json:"password"`
+	// Insecure allows a registry with a self signed certificate
+	Insecure bool `json:"insecure"`
+}
+
+// ChartUpload is an uploaded chart archive which can be installed by its id
+type ChartUpload struct {
+	Id       string                 `json:"id"`
+	Metadata chart.Metadata         `json:"metadata"`
+	Readme   string                 `json:"readme"`
+	Values   map[string]interface{} `json:"values"`
+	// Kept in the local chart repository
+	Kept bool `json:"kept"`
+}
+
+// LocalRepo is the chart repository served by KubePi, it is added to clusters
+// with this url and the credentials of a KubePi user
+type LocalRepo struct {
+	Url string `json:"url"`
+}
+
+// InventoryItem is a helm release of a cluster compared against the index of its repo
+type InventoryItem struct {
+	Cluster      string    `json:"cluster"`
+	Namespace    string    `json:"namespace"`
+	Name         string    `json:"name"`
+	Status       string    `json:"status"`
+	Revision     int       `json:"revision"`
+	Updated      time.Time `json:"updated"`
+	Chart        string    `json:"chart"`
+	ChartVersion string    `json:"chartVersion"`
+	AppVersion   string    `json:"appVersion"`
+	// Repo is the repo the release was installed from, empty when it is unknown
+	Repo             string `json:"repo"`
+	LatestVersion    string `json:"latestVersion"`
+	LatestAppVersion string `json:"latestAppVersion"`
+	UpgradeAvailable bool   `json:"upgradeAvailable"`
+	// Error of looking up the versions in the repo
+	Error string `json:"error"`
+}
+
+type InventoryFilter struct {
+	Clusters []string
+	Chart    string
+	Repo     string
+	// Outdated keeps only the releases with a newer version available
+	Outdated bool
+}
+
+type Inventory struct {
+	Items []InventoryItem `json:"items"`
+	// Errors of the clusters whose releases could not be listed, by cluster name
+	Errors map[string]string `json:"errors"`
+}
@@ -0,0 +1,50 @@
+package chart
+
+import (
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/service/model/v1"
+)
+
+const (
+	JobActionInstall   = "install"
+	JobActionUpgrade   = "upgrade"
+	JobActionUninstall = "uninstall"
+)
+
+const (
+	JobStatusPending  = "Pending"
+	JobStatusRunning  = "Running"
+	JobStatusSuccess  = "Success"
+	JobStatusFailed   = "Failed"
+	JobStatusCanceled = "Canceled"
+)
+
+// Job is a helm install, upgrade or uninstall running in the background
+type Job struct {
+	v1.BaseModel `storm:"inline"`
+	v1.Metadata  `storm:"inline"`
+	Cluster      string `json:"cluster" storm:"index"`
+	Namespace    string `json:"namespace"`
+	Release      string `json:"release"`
+	Action       string `json:"action"`
+	Repo         string `json:"repo"`
+	ChartName    string `json:"chartName"`
+	ChartVersion string `json:"chartVersion"`
+	// Upload is the id of the uploaded chart archive installed instead of a repo chart
+	Upload string `json:"upload"`
+	// Wait for the resources to be ready, or deleted on uninstall, before the job succeeds
+	Wait bool `json:"wait"`
+	// Timeout in seconds of the kubernetes operations and the wait
+	Timeout int       `json:"timeout"`
+	Status  string    `json:"status"`
+	Message string    `json:"message"`
+	Log     []string  `json:"log"`
+	StartAt time.Time `json:"startAt"`
+	EndAt   time.Time `json:"endAt"`
+}
+
+// Finished reports whether the job will not change anymore
+func (j *Job) Finished() bool {
+	return j.Status == JobStatusSuccess || j.Status == JobStatusFailed || j.Status == JobStatusCanceled
+}
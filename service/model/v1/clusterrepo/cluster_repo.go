@@ -1,11 +1,26 @@
 package clusterrepo
 
-import v1 "github.com/KubeOperator/kubepi/service/model/v1"
+import (
+	"time"
+
+	v1 "github.com/KubeOperator/kubepi/service/model/v1"
+)
 
 type ClusterRepo struct {
 	v1.BaseModel `storm:"inline"`
 	v1.Metadata  `storm:"inline"`
-	Cluster      string `json:"cluster"`
-	Repo         string `json:"repo"`
+	Cluster      string     `json:"cluster"`
+	Repo         string     `json:"repo"`
+	PullSecret   PullSecret `json:"pullSecret"`
 }
 
+// PullSecret provisions a kubernetes.io/dockerconfigjson secret of the repo in the cluster
+type PullSecret struct {
+	Enable bool `json:"enable"`
+	// Namespaces to create the secret in, all namespaces when empty
+	Namespaces []string `json:"namespaces"`
+	// PatchServiceAccount adds the secret to the imagePullSecrets of the default service account
+	PatchServiceAccount bool      `json:"patchServiceAccount"`
+	SyncedAt            time.Time `json:"syncedAt"`
+	Message             string    `json:"message"`
+}
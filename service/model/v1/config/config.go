@@ -8,12 +8,19 @@ type Config struct {
 	Spec Spec `json:"spec"`
 }
 type Spec struct {
-	Server  ServerConfig  `json:"server"`
-	DB      DBConfig      `json:"db"`
-	Session SessionConfig `json:"session"`
-	Logger  LoggerConfig  `json:"logger"`
-	Jwt     JwtConfig     `json:"jwt"`
-	AppId   string        `json:"appId"`
+	Server      ServerConfig      `json:"server"`
+	DB          DBConfig          `json:"db"`
+	Session     SessionConfig     `json:"session"`
+	Logger      LoggerConfig      `json:"logger"`
+	Jwt         JwtConfig         `json:"jwt"`
+	Mfa         MfaConfig         `json:"mfa"`
+	Scim        ScimConfig        `json:"scim"`
+	Saml        SamlConfig        `json:"saml"`
+	ChartRepo   ChartRepoConfig   `json:"chartRepo"`
+	File        FileConfig        `json:"file"`
+	Terminal    TerminalConfig    `json:"terminal"`
+	PortForward PortForwardConfig `json:"portForward"`
+	AppId       string            `json:"appId"`
 }
 
 type ServerConfig struct {
@@ -47,3 +54,194 @@ type SessionConfig struct {
 type JwtConfig struct {
 	Key string `json:"key"`
 }
+
+type MfaConfig struct {
+	// Skew is the number of 30s TOTP intervals accepted on each side of now.
+	Skew int `json:"skew"`
+	// Enforce forces every user to enroll MFA at next login.
+	Enforce bool `json:"enforce"`
+	// EnforceRoles forces MFA enrollment for users bound to any of these roles.
+	EnforceRoles      []string `json:"enforceRoles"`
+	RecoveryCodeCount int      `json:"recoveryCodeCount"`
+}
+
+type ScimConfig struct {
+	Enable bool `json:"enable"`
+	// Token is the bearer token the identity provider authenticates with
+	Token string `json:"token"`
+}
+
+type ChartRepoConfig struct {
+	// Path keeps the uploaded chart archives and the charts of the local chart repository
+	Path string `json:"path"`
+	// Url the local chart repository is added to clusters with, defaults to KubePi on localhost
+	Url string `json:"url"`
+}
+
+type FileConfig struct {
+	// MaxUploadSize of a pod file upload in MB, 0 is unlimited
+	MaxUploadSize int64 `json:"maxUploadSize"`
+	// MaxDownloadSize of a pod file or directory download in MB, 0 is unlimited
+	MaxDownloadSize int64 `json:"maxDownloadSize"`
+	// UploadSessionExpires is the number of hours an unfinished chunked upload can be resumed
+	UploadSessionExpires int `json:"uploadSessionExpires"`
+	// DebugImage runs the ephemeral container the files of containers without a shell and tools are reached through
+	DebugImage string `json:"debugImage"`
+}
+
+type TerminalConfig struct {
+	// DebugImage is the default image of debug containers, e.g. a mirror in air-gapped environments
+	DebugImage string `json:"debugImage"`
+	// NodeShellImage runs the privileged pods node shells enter the host from, it needs nsenter
+	NodeShellImage string `json:"nodeShellImage"`
+	// NodeShellNamespace is the namespace of the node shell pods
+	NodeShellNamespace string `json:"nodeShellNamespace"`
+	TerminalLimits
+	// WarningBefore is the number of seconds the user is warned before a terminal is disconnected by a limit
+	WarningBefore int `json:"warningBefore"`
+	// RoleLimits override the limits for the users bound to the roles, a user bound to several of them gets the
+	// most permissive ones
+	RoleLimits []TerminalRoleLimits `json:"roleLimits"`
+	// AuditCommands records the command lines typed into all terminals in the operation log, they are always
+	// recorded once command policies are configured
+	AuditCommands   bool                    `json:"auditCommands"`
+	CommandPolicies []TerminalCommandPolicy `json:"commandPolicies"`
+}
+
+// TerminalLimits bound the terminals and log streams of a user, 0 is unlimited
+type TerminalLimits struct {
+	// IdleTimeout is the number of minutes a terminal without input or output is kept open
+	IdleTimeout int `json:"idleTimeout"`
+	// MaxDuration is the number of minutes a terminal is kept open at most
+	MaxDuration int `json:"maxDuration"`
+	// MaxTerminalSessions is the number of terminals a user may have open at once
+	MaxTerminalSessions int `json:"maxTerminalSessions"`
+	// MaxLogSessions is the number of log streams a user may have open at once
+	MaxLogSessions int `json:"maxLogSessions"`
+}
+
+// TerminalRoleLimits are the limits of the users bound to the role, unset limits are the global ones
+type TerminalRoleLimits struct {
+	Role                string `json:"role"`
+	IdleTimeout         *int   `json:"idleTimeout"`
+	MaxDuration         *int   `json:"maxDuration"`
+	MaxTerminalSessions *int   `json:"maxTerminalSessions"`
+	MaxLogSessions      *int   `json:"maxLogSessions"`
+}
+
+// LimitsOf returns the limits of a user bound to the roles
+func (c TerminalConfig) LimitsOf(roles []string) TerminalLimits {
+	limits := c.TerminalLimits
+	fields := []*int{&limits.IdleTimeout, &limits.MaxDuration, &limits.MaxTerminalSessions, &limits.MaxLogSessions}
+	overrides := make([]*int, len(fields))
+	for _, rl := range c.RoleLimits {
+		bound := false
+		for _, role := range roles {
+			bound = bound || role == rl.Role
+		}
+		if !bound {
+			continue
+		}
+		for i, v := range []*int{rl.IdleTimeout, rl.MaxDuration, rl.MaxTerminalSessions, rl.MaxLogSessions} {
+			// 0 is unlimited and so more permissive than any other limit
+			if v != nil && (overrides[i] == nil || (*overrides[i] > 0 && (*v <= 0 || *v > *overrides[i]))) {
+				overrides[i] = v
+			}
+		}
+	}
+	for i := range fields {
+		if overrides[i] != nil {
+			*fields[i] = *overrides[i]
+		}
+	}
+	return limits
+}
+
+type PortForwardConfig struct {
+	// IdleTimeout is the number of minutes a port forward without requests is kept open
+	IdleTimeout int `json:"idleTimeout"`
+}
+
+type SamlConfig struct {
+	Enable bool `json:"enable"`
+	// BaseUrl is the external url of KubePi the identity provider posts back to, e.g. https://kubepi.example.com
+	BaseUrl string `json:"baseUrl"`
+	// EntityId of KubePi, defaults to the url of the metadata endpoint
+	EntityId string `json:"entityId"`
+	// AllowIdpInitiated accepts logins started from the identity provider portal
+	AllowIdpInitiated bool `json:"allowIdpInitiated"`
+	// ClockSkew is the number of seconds tolerated between the clocks of the identity provider and KubePi
+	ClockSkew     int                  `json:"clockSkew"`
+	Idp           SamlIdpConfig        `json:"idp"`
+	Attributes    SamlAttributeMapping `json:"attributes"`
+	GroupMappings []SamlGroupMapping   `json:"groupMappings"`
+	// DefaultRoles are bound to every saml user besides the mapped ones
+	DefaultRoles []string `json:"defaultRoles"`
+}
+
+type SamlIdpConfig struct {
+	// MetadataFile is the path of the identity provider metadata, it takes precedence over the fields below
+	MetadataFile string `json:"metadataFile"`
+	EntityId     string `json:"entityId"`
+	SsoUrl       string `json:"ssoUrl"`
+	// Certificate is the PEM encoded signing certificate of the identity provider
+	Certificate string `json:"certificate"`
+}
+
+// SamlAttributeMapping names the assertion attributes which fill the user profile,
+// the user name falls back to the NameID of the subject
+type SamlAttributeMapping struct {
+	Name     string `json:"name"`
+	NickName string `json:"nickName"`
+	Email    string `json:"email"`
+	Groups   string `json:"groups"`
+}
+
+type SamlGroupMapping struct {
+	Group string   `json:"group"`
+	Roles []string `json:"roles"`
+}
+
+// TerminalCommandPolicy allows or denies the command lines typed into the terminals in its scope, a scope left
+// empty matches all clusters, namespaces or roles. Node shells are only in the scope of policies without namespaces.
+// Policies check the keystrokes rather than what the shell runs, so they are a guard against mistakes and not a
+// sandbox: lines edited in the line editor of the shell, e.g. recalled from history or pasted, are denied and a
+// program started by an allowed command line is not checked at all.
+type TerminalCommandPolicy struct {
+	Name       string   `json:"name"`
+	Clusters   []string `json:"clusters"`
+	Namespaces []string `json:"namespaces"`
+	Roles      []string `json:"roles"`
+	// Allow are the regular expressions of the only command lines permitted when not empty, Deny the ones of the
+	// command lines which are rejected
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// Applies reports whether the terminals of the user bound to the roles in the namespace of the cluster are in
+// the scope of the policy
+func (p TerminalCommandPolicy) Applies(cluster, namespace string, roles []string) bool {
+	contains := func(items []string, item string) bool {
+		for i := range items {
+			if items[i] == item {
+				return true
+			}
+		}
+		return false
+	}
+	if len(p.Clusters) > 0 && !contains(p.Clusters, cluster) {
+		return false
+	}
+	if len(p.Namespaces) > 0 && !contains(p.Namespaces, namespace) {
+		return false
+	}
+	if len(p.Roles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if contains(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,9 @@
 package file
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 type Request struct {
 	Cluster       string    `json:"cluster" validate:"required"`
@@ -10,8 +13,44 @@ type Request struct {
 	Namespace     string    `json:"namespace" validate:"required"`
 	Path          string    `json:"path"`
 	OldPath       string    `json:"oldPath"`
-	Commands      []string  `json:"-"`
 	Stdin         io.Reader `json:"-"`
 	Content       string    `json:"content"`
-	FilePath      string    `json:"filePath"`
+	Checksum      string    `json:"checksum"`
+	Backup        bool      `json:"backup"`
+	// Debug allows the files of containers without file tools to be reached through an ephemeral debug container
+	Debug bool `json:"-"`
+}
+
+type Content struct {
+	Content  string `json:"content"`
+	Checksum string `json:"checksum"`
+}
+
+// UploadSession is a chunked upload of a file, the chunks are appended to a temp file next to the
+// destination which is renamed when the upload is complete
+type UploadSession struct {
+	ID            string    `json:"id"`
+	Cluster       string    `json:"cluster" validate:"required"`
+	PodName       string    `json:"podName" validate:"required"`
+	ContainerName string    `json:"containerName"`
+	Namespace     string    `json:"namespace" validate:"required"`
+	Path          string    `json:"path" validate:"required"`
+	Size          int64     `json:"size"`
+	Offset        int64     `json:"offset"`
+	Completed     bool      `json:"completed"`
+	CreatedBy     string    `json:"createdBy"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	Debug         bool      `json:"-"`
+}
+
+func (s *UploadSession) Request() Request {
+	return Request{
+		Cluster:       s.Cluster,
+		PodName:       s.PodName,
+		ContainerName: s.ContainerName,
+		Namespace:     s.Namespace,
+		Path:          s.Path,
+		Debug:         s.Debug,
+	}
 }
@@ -2,6 +2,8 @@ package ldap
 
 import (
 	"encoding/json"
+	"time"
+
 	v1 "github.com/KubeOperator/kubepi/service/model/v1"
 )
 
@@ -19,6 +21,58 @@ type Ldap struct {
 	Enable       bool   `json:"enable"`
 	SizeLimit    int    `json:"sizeLimit"`
 	TimeLimit    int    `json:"timeLimit"`
+	StartTLS     bool   `json:"startTLS"`
+	// Servers are failover "host:port" addresses of the same directory
+	Servers []string   `json:"servers"`
+	Sync    SyncConfig `json:"sync"`
+}
+
+const (
+	AbsentUserKeep    = ""
+	AbsentUserDisable = "disable"
+	AbsentUserDelete  = "delete"
+)
+
+type SyncConfig struct {
+	// Interval in minutes between scheduled syncs, 0 disables the schedule
+	Interval int `json:"interval"`
+	// AbsentUserAction is applied to ldap users no longer found in the directory
+	AbsentUserAction string `json:"absentUserAction"`
+	// GroupDn and GroupFilter search groups, when GroupFilter is empty
+	// the memberOf attribute of users is used instead
+	GroupDn         string         `json:"groupDn"`
+	GroupFilter     string         `json:"groupFilter"`
+	GroupMemberAttr string         `json:"groupMemberAttr"`
+	GroupMappings   []GroupMapping `json:"groupMappings"`
+	LastSyncAt      time.Time      `json:"lastSyncAt"`
+}
+
+// GroupMapping binds the members of an ldap group, matched by dn or cn, to KubePi roles
+type GroupMapping struct {
+	Group string   `json:"group"`
+	Roles []string `json:"roles"`
+}
+
+const (
+	SyncStatusRunning = "Running"
+	SyncStatusSuccess = "Success"
+	SyncStatusFailed  = "Failed"
+)
+
+type SyncRecord struct {
+	v1.BaseModel        `storm:"inline"`
+	v1.Metadata         `storm:"inline"`
+	LdapRef             string    `json:"ldapRef" storm:"index"`
+	DryRun              bool      `json:"dryRun"`
+	Status              string    `json:"status"`
+	Message             string    `json:"message"`
+	CreatedUsers        []string  `json:"createdUsers"`
+	DisabledUsers       []string  `json:"disabledUsers"`
+	EnabledUsers        []string  `json:"enabledUsers"`
+	DeletedUsers        []string  `json:"deletedUsers"`
+	AddedRoleBindings   []string  `json:"addedRoleBindings"`
+	RemovedRoleBindings []string  `json:"removedRoleBindings"`
+	EndAt               time.Time `json:"endAt"`
 }
 
 func (l *Ldap) GetAttributes() ([]string, error) {
@@ -0,0 +1,28 @@
+package portforward
+
+import "time"
+
+const (
+	KindPod     = "pods"
+	KindService = "services"
+)
+
+// PortForward proxies the HTTP and WebSocket requests of a user to a port of a pod, through the portforward
+// subresource, or of a service, through the proxy subresource
+type PortForward struct {
+	ID        string `json:"id"`
+	Cluster   string `json:"cluster"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Port      int    `json:"port"`
+	// Path is the url of the proxy in KubePi
+	Path string `json:"path"`
+	// Connections is the number of requests in flight, including open WebSockets
+	Connections  int       `json:"connections"`
+	CreatedBy    string    `json:"createdBy"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastActiveAt time.Time `json:"lastActiveAt"`
+	// ExpiresAt is the time the port forward is closed unless it is used, it does not expire while connections are open
+	ExpiresAt time.Time `json:"expiresAt"`
+}
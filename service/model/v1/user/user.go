@@ -12,6 +12,10 @@ type User struct {
 	Authenticate Authenticate `json:"authenticate"`
 	Type         string       `json:"type"`
 	Mfa          Mfa          `json:"mfa"`
+	// Disabled users can not login, e.g. ldap users who left the directory
+	Disabled bool `json:"disabled"`
+	// ExternalId is the identifier of the user in the provisioning identity provider
+	ExternalId string `json:"externalId"`
 }
 
 type Authenticate struct {
@@ -22,11 +26,28 @@ type Authenticate struct {
 type Mfa struct {
 	Enable bool   `json:"enable"`
 	Secret string `json:"secret"`
+	// RecoveryCodes holds bcrypt hashes of the unused one-time recovery codes.
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// Bound reports whether a device is bound, mfa may be enabled without one, e.g. after a reset or when an
+// administrator turned it on, the user binds a device at the next login then
+func (m Mfa) Bound() bool {
+	return m.Secret != ""
+}
+
+// Reset unbinds the device and drops the recovery codes, the enable flag is kept so the user is asked to bind
+// a new device at the next login
+func (m *Mfa) Reset() {
+	m.Secret = ""
+	m.RecoveryCodes = nil
 }
 
 const (
 	LDAP  = "LDAP"
 	LOCAL = "LOCAL"
+	SCIM  = "SCIM"
+	SAML  = "SAML"
 )
 
 type ImportUser struct {
@@ -0,0 +1,20 @@
+package user
+
+import "testing"
+
+func TestMfaBound(t *testing.T) {
+	// enabled by an administrator, no device yet
+	mfa := Mfa{Enable: true}
+	if mfa.Bound() {
+		t.Fatal("expected enabled mfa without a secret to be bindable")
+	}
+	mfa.Secret = "secret"
+	mfa.RecoveryCodes = []string{"hash"}
+	if !mfa.Bound() {
+		t.Fatal("expected mfa with a secret to be bound")
+	}
+	mfa.Reset()
+	if mfa.Bound() || !mfa.Enable || len(mfa.RecoveryCodes) != 0 {
+		t.Fatalf("expected the reset mfa to stay enabled and be bindable again, got %+v", mfa)
+	}
+}
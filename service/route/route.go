@@ -1,13 +1,27 @@
 package route
 
 import (
+	"github.com/KubeOperator/kubepi/service/api/chartrepo"
+	"github.com/KubeOperator/kubepi/service/api/scim"
 	v1 "github.com/KubeOperator/kubepi/service/api/v1"
+	"github.com/KubeOperator/kubepi/service/service/v1/chart"
+	"github.com/KubeOperator/kubepi/service/service/v1/clusterrepo"
+	"github.com/KubeOperator/kubepi/service/service/v1/ldap"
 	"github.com/kataras/iris/v12"
 )
 
+// BackgroundJobs are started with the server rather than with the routes they serve
+var BackgroundJobs = []func(){
+	chart.FailInterruptedJobs,
+	ldap.StartSyncScheduler,
+	clusterrepo.StartPullSecretScheduler,
+}
+
 func InitRoute(party iris.Party) {
 	apiParty := party.Party("/api")
 	v1.AddV1Route(apiParty)
+	scim.Install(apiParty)
+	chartrepo.Install(apiParty)
 	//ws.AddWebSocketRoute(apiParty)
 	//terminal.AddWebSocketRoute(apiParty)
 }
@@ -1,6 +1,7 @@
 package server
 
 import (
+	goContext "context"
 	"embed"
 	"fmt"
 	"net/http"
@@ -22,6 +23,7 @@ import (
 	"github.com/KubeOperator/kubepi/migrate"
 	"github.com/KubeOperator/kubepi/pkg/file"
 	"github.com/KubeOperator/kubepi/pkg/i18n"
+	"github.com/KubeOperator/kubepi/pkg/loginsession"
 	"github.com/asdine/storm/v3"
 	"github.com/coreos/etcd/pkg/fileutil"
 	"github.com/kataras/iris/v12"
@@ -66,6 +68,13 @@ func WithCustomConfigFilePath(path string) Option {
 	}
 }
 
+// WithBackgroundJobs runs the jobs once the server is set up, before it serves requests
+func WithBackgroundJobs(jobs ...func()) Option {
+	return func(server *KubePiServer) {
+		server.backgroundJobs = jobs
+	}
+}
+
 type KubePiServer struct {
 	app                  *iris.Application
 	db                   *storm.DB
@@ -73,6 +82,7 @@ type KubePiServer struct {
 	configCustomFilePath string
 	config               *v1Config.Config
 	rootRoute            iris.Party
+	backgroundJobs       []func()
 }
 
 func NewKubePiSerer(opts ...Option) *KubePiServer {
@@ -123,6 +133,12 @@ func (e *KubePiServer) setUpDB() {
 	e.db = d
 }
 
+func (e *KubePiServer) setUpLoginSessions() {
+	if err := loginsession.LoginSessions.Persist(e.db); err != nil {
+		e.logger.Errorf("can not load login sessions: %s", err)
+	}
+}
+
 func (e *KubePiServer) setUpRootRoute() {
 	e.app.Any("/", func(ctx *context.Context) {
 		ctx.Redirect("/kubepi")
@@ -158,11 +174,19 @@ func (e *KubePiServer) setUpSession() {
 
 const ContentTypeDownload = "application/download"
 
+// ContentTypeScim responses are written by the scim handlers and not wrapped
+const ContentTypeScim = "application/scim+json"
+
+const ContentTypeSamlMetadata = "application/samlmetadata+xml"
+
+// ContentTypeEventStream responses are streamed by the handlers and not wrapped
+const ContentTypeEventStream = "text/event-stream"
+
 func (e *KubePiServer) setResultHandler() {
 	e.rootRoute.Use(func(ctx *context.Context) {
 		ctx.Next()
 		contentType := ctx.ResponseWriter().Header().Get("Content-Type")
-		if contentType == ContentTypeDownload {
+		if contentType == ContentTypeDownload || contentType == ContentTypeSamlMetadata || strings.HasPrefix(contentType, ContentTypeEventStream) || strings.HasPrefix(contentType, ContentTypeScim) {
 			return
 		}
 		isProxyPath := func() bool {
@@ -275,6 +299,32 @@ func (e *KubePiServer) setWebkubectlProxy() {
 	e.rootRoute.Any("webkubectl", handler)
 }
 
+// ProxyPathPrefix is the prefix of the port forward proxy, the trailing slash of its paths is part of the
+// proxied path and must survive routing
+const ProxyPathPrefix = "/kubepi/api/v1/proxy/"
+
+type trailingSlashKey struct{}
+
+// HasTrailingSlash reports whether the trailing slash of the proxied path of the request was removed before routing
+func HasTrailingSlash(r *http.Request) bool {
+	v, _ := r.Context().Value(trailingSlashKey{}).(bool)
+	return v
+}
+
+// setUpProxyPathCorrection removes the trailing slash of port forward paths before the router redirects them
+// without it, the handler adds it back to the proxied path
+func (e *KubePiServer) setUpProxyPathCorrection() {
+	e.app.WrapRouter(func(w http.ResponseWriter, r *http.Request, router http.HandlerFunc) {
+		p := r.URL.Path
+		if strings.HasPrefix(p, ProxyPathPrefix) && strings.Contains(p, "/portforward/") && strings.HasSuffix(p, "/") {
+			r.URL.Path = strings.TrimRight(p, "/")
+			r.URL.RawPath = ""
+			r = r.WithContext(goContext.WithValue(r.Context(), trailingSlashKey{}, true))
+		}
+		router(w, r)
+	})
+}
+
 func (e *KubePiServer) setUpTtyEntrypoint() {
 	f, err := os.OpenFile("init-kube.sh", os.O_CREATE|os.O_RDWR, 0755)
 	if err != nil {
@@ -292,10 +342,12 @@ func (e *KubePiServer) bootstrap() *KubePiServer {
 	e.setUpStaticFile()
 	e.setUpLogger()
 	e.setUpDB()
+	e.setUpLoginSessions()
 	e.setUpSession()
 	e.setResultHandler()
 	e.setUpErrHandler()
 	e.setWebkubectlProxy()
+	e.setUpProxyPathCorrection()
 	e.runMigrations()
 	e.setUpTtyEntrypoint()
 	e.startTty()
@@ -319,6 +371,9 @@ func Logger() *logrus.Logger {
 func Listen(route func(party iris.Party), options ...Option) error {
 	es = NewKubePiSerer(options...)
 	route(es.rootRoute)
+	for _, job := range es.backgroundJobs {
+		job()
+	}
 	return es.app.Run(iris.Addr(fmt.Sprintf("%s:%d", es.config.Spec.Server.Bind.Host, es.config.Spec.Server.Bind.Port)))
 }
 
@@ -347,6 +402,36 @@ func getDefaultConfig() *v1Config.Config {
 			},
 			Logger: v1Config.LoggerConfig{Level: "debug"},
 			Jwt:    v1Config.JwtConfig{},
+			Mfa: v1Config.MfaConfig{
+				Skew:              1,
+				RecoveryCodeCount: 10,
+			},
+			Saml: v1Config.SamlConfig{
+				ClockSkew: 60,
+				Attributes: v1Config.SamlAttributeMapping{
+					Groups: "groups",
+				},
+				DefaultRoles: []string{"Common User"},
+			},
+			ChartRepo: v1Config.ChartRepoConfig{
+				Path: "/var/lib/kubepi/charts",
+			},
+			File: v1Config.FileConfig{
+				UploadSessionExpires: 24,
+				DebugImage:           "busybox:1.36",
+			},
+			Terminal: v1Config.TerminalConfig{
+				DebugImage:         "busybox:1.36",
+				NodeShellImage:     "busybox:1.36",
+				NodeShellNamespace: "kube-system",
+				TerminalLimits: v1Config.TerminalLimits{
+					IdleTimeout: 5,
+				},
+				WarningBefore: 60,
+			},
+			PortForward: v1Config.PortForwardConfig{
+				IdleTimeout: 30,
+			},
 		},
 	}
 }
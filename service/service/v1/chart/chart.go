@@ -2,6 +2,7 @@ package chart
 
 import (
 	"errors"
+	"fmt"
 	v1Chart "github.com/KubeOperator/kubepi/service/model/v1/chart"
 	v1ClusterApp "github.com/KubeOperator/kubepi/service/model/v1/clusterapp"
 	"github.com/KubeOperator/kubepi/service/service/v1/cluster"
@@ -9,7 +10,12 @@ import (
 	"github.com/KubeOperator/kubepi/service/service/v1/common"
 	"github.com/KubeOperator/kubepi/pkg/kubernetes"
 	"github.com/KubeOperator/kubepi/pkg/util/helm"
-	"github.com/asdine/storm/v3"
+	"github.com/pmezard/go-difflib/difflib"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/registry"
+	"sigs.k8s.io/yaml"
 	"helm.sh/helm/v3/cmd/helm/search"
 	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/repo"
@@ -25,13 +31,30 @@ type Service interface {
 	ListCharts(cluster, repo string, num, size int, pattern string) ([]*search.Result, int, error)
 	GetCharts(cluster, repo, name string) (*v1Chart.ChArrayResult, error)
 	GetChartByVersion(cluster, repo, name, version string) (*v1Chart.ChDetail, error)
-	InstallChart(cluster, repoName, namespace, name, chartName, chartVersion string, values map[string]interface{}) error
 	ListAllInstalled(cluster, namespace string, num, size int, pattern string) ([]*release.Release, int, error)
-	UnInstallChart(cluster, namespace, name string) error
 	GetAppDetail(cluster string, name string) (*release.Release, error)
 	GetChartsUpdate(cluster, chart, name string) (*v1Chart.UpdateResult, error)
-	UpgradeChart(cluster, namespace, repoName, name, chartName, chartVersion string, values map[string]interface{}) error
 	SyncRepo(cluster,name string) error
+	ListAppHistory(cluster, namespace, name string) ([]v1Chart.ReleaseRevision, error)
+	RollbackApp(cluster, namespace, name string, revision int) error
+	DiffAppValues(cluster, namespace, name string, from, to int, all bool) (*v1Chart.ValuesDiff, error)
+	ListRegistries(cluster string) ([]string, error)
+	RegistryLogin(cluster string, login *v1Chart.RegistryLogin) error
+	RegistryLogout(cluster, host string) error
+	GetOciChartVersions(cluster, ref string) ([]string, error)
+	SubmitJob(job *v1Chart.Job, values map[string]interface{}) (*v1Chart.Job, error)
+	GetJob(cluster, id string) (*v1Chart.Job, error)
+	ListJobs(cluster string) ([]v1Chart.Job, error)
+	CancelJob(cluster, id string) error
+	UploadChart(data []byte, keep bool) (*v1Chart.ChartUpload, error)
+	GetLocalRepo() *v1Chart.LocalRepo
+	ListLocalCharts() (map[string]repo.ChartVersions, error)
+	GetLocalChart(name string) (repo.ChartVersions, error)
+	PushLocalChart(data []byte, force bool) (*chart.Metadata, error)
+	DeleteLocalChart(name, version string) error
+	PreviewUpload(cluster, namespace, name, upload string, values map[string]interface{}, serverDryRun bool) (*helm.Preview, error)
+	PreviewChart(cluster, namespace, repoName, name, chartName, chartVersion string, values map[string]interface{}, serverDryRun bool) (*helm.Preview, error)
+	Inventory(filter v1Chart.InventoryFilter) (*v1Chart.Inventory, error)
 }
 
 func NewService() Service {
@@ -193,14 +216,24 @@ func (c *service) GetChartsUpdate(cluster, chart, name string) (*v1Chart.UpdateR
 	if err != nil {
 		return nil, errors.New("There is no version that can be upgraded")
 	}
-	allVersionCharts, err := helmClient.GetCharts(clusterApp.Repo, chart)
-	if err != nil {
-		return nil, err
-	}
 	update := &v1Chart.UpdateResult{
 		Repo:     clusterApp.Repo,
 		Versions: []v1Chart.UpdateVersion{},
 	}
+	if registry.IsOCI(clusterApp.Repo) {
+		versions, err := helmClient.OciChartVersions(clusterApp.Repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			update.Versions = append(update.Versions, v1Chart.UpdateVersion{Version: v})
+		}
+		return update, nil
+	}
+	allVersionCharts, err := helmClient.GetCharts(clusterApp.Repo, chart)
+	if err != nil {
+		return nil, err
+	}
 	for _, chart := range allVersionCharts {
 		update.Versions = append(update.Versions, v1Chart.UpdateVersion{
 			Version:    chart.Chart.Version,
@@ -230,52 +263,37 @@ func (c *service) GetChartByVersion(cluster, repo, name, version string) (*v1Cha
 	return &result, nil
 }
 
-func (c *service) InstallChart(cluster, repoName, namespace, name, chartName, chartVersion string, values map[string]interface{}) error {
-	helmClient, err := NewHelmClient(cluster, namespace)
-	if err != nil {
-		return err
+// recordApp remembers the repo an app is installed from to look up its upgrades
+func (c *service) recordApp(cluster, repoName, name, chartName string) error {
+	if registry.IsOCI(chartName) {
+		// the reference is kept as the repo of the app to look up its upgrades
+		repoName = chartName
 	}
-	_, err = helmClient.Install(name, repoName, chartName, chartVersion, values)
-	if err != nil {
-		return err
-	}
-	err = c.clusterAppService.Create(&v1ClusterApp.ClusterApp{
+	return c.clusterAppService.Create(&v1ClusterApp.ClusterApp{
 		AppName: name,
 		Repo:    repoName,
 		Cluster: cluster,
 	}, common.DBOptions{})
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
-func (c *service) UpgradeChart(cluster, namespace, repoName, name, chartName, chartVersion string, values map[string]interface{}) error {
+func (c *service) PreviewChart(cluster, namespace, repoName, name, chartName, chartVersion string, values map[string]interface{}, serverDryRun bool) (*helm.Preview, error) {
 	helmClient, err := NewHelmClient(cluster, namespace)
 	if err != nil {
-		return err
-	}
-	_, err = helmClient.Upgrade(name, repoName, chartName, chartVersion, values)
-	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return helmClient.Preview(name, repoName, chartName, chartVersion, values, serverDryRun)
 }
 
-func (c *service) UnInstallChart(cluster, namespace, name string) error {
-	helmClient, err := NewHelmClient(cluster, namespace)
+func (c *service) PreviewUpload(cluster, namespace, name, upload string, values map[string]interface{}, serverDryRun bool) (*helm.Preview, error) {
+	ct, err := loadUpload(upload)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = helmClient.Uninstall(name)
+	helmClient, err := NewHelmClient(cluster, namespace)
 	if err != nil {
-		return err
-	}
-	err = c.clusterAppService.Delete(name, cluster, common.DBOptions{})
-	if err != nil && err != storm.ErrNotFound {
-		return err
+		return nil, err
 	}
-	return nil
+	return helmClient.PreviewChart(name, ct, values, serverDryRun)
 }
 
 func (c *service) ListAllInstalled(cluster, namespace string, num, size int, pattern string) ([]*release.Release, int, error) {
@@ -306,7 +324,139 @@ func (c *service) SyncRepo(cluster,name string) error {
 	return helmClient.UpdateRepo(name)
 }
 
+func (c *service) ListAppHistory(cluster, namespace, name string) ([]v1Chart.ReleaseRevision, error) {
+	helmClient, err := NewHelmClient(cluster, namespace)
+	if err != nil {
+		return nil, err
+	}
+	releases, err := helmClient.History(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]v1Chart.ReleaseRevision, 0, len(releases))
+	for i := len(releases) - 1; i >= 0; i-- {
+		r := releases[i]
+		revision := v1Chart.ReleaseRevision{
+			Revision: r.Version,
+		}
+		if r.Info != nil {
+			revision.Status = r.Info.Status.String()
+			revision.Description = r.Info.Description
+			revision.FirstDeployed = r.Info.FirstDeployed.Time
+			revision.LastDeployed = r.Info.LastDeployed.Time
+		}
+		if r.Chart != nil && r.Chart.Metadata != nil {
+			revision.Chart = r.Chart.Metadata.Name
+			revision.ChartVersion = r.Chart.Metadata.Version
+			revision.AppVersion = r.Chart.Metadata.AppVersion
+		}
+		result = append(result, revision)
+	}
+	return result, nil
+}
+
+func (c *service) RollbackApp(cluster, namespace, name string, revision int) error {
+	helmClient, err := NewHelmClient(cluster, namespace)
+	if err != nil {
+		return err
+	}
+	return helmClient.Rollback(name, revision)
+}
+
+// DiffAppValues compares the values of two revisions as a unified diff, with all
+// the chart defaults are merged in, otherwise only the user supplied values are compared.
+func (c *service) DiffAppValues(cluster, namespace, name string, from, to int, all bool) (*v1Chart.ValuesDiff, error) {
+	helmClient, err := NewHelmClient(cluster, namespace)
+	if err != nil {
+		return nil, err
+	}
+	values := func(revision int) (int, string, error) {
+		r, err := helmClient.GetRevision(name, revision)
+		if err != nil {
+			return 0, "", err
+		}
+		vals := r.Config
+		if all && r.Chart != nil {
+			if vals, err = chartutil.CoalesceValues(r.Chart, r.Config); err != nil {
+				return 0, "", err
+			}
+		}
+		if len(vals) == 0 {
+			return r.Version, "", nil
+		}
+		data, err := yaml.Marshal(vals)
+		if err != nil {
+			return 0, "", err
+		}
+		return r.Version, string(data), nil
+	}
+	toRevision, toValues, err := values(to)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		fromRevision int
+		fromValues   string
+	)
+	if from == 0 {
+		from = toRevision - 1
+	}
+	// the first revision has no previous one, it is diffed against empty values
+	if from > 0 {
+		if fromRevision, fromValues, err = values(from); err != nil {
+			return nil, err
+		}
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(fromValues),
+		B:        difflib.SplitLines(toValues),
+		FromFile: fmt.Sprintf("revision %d", fromRevision),
+		ToFile:   fmt.Sprintf("revision %d", toRevision),
+		Context:  3,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &v1Chart.ValuesDiff{From: fromRevision, To: toRevision, Diff: diff}, nil
+}
+
+func (c *service) ListRegistries(cluster string) ([]string, error) {
+	helmClient, err := NewHelmClient(cluster, "")
+	if err != nil {
+		return nil, err
+	}
+	return helmClient.ListRegistries()
+}
+
+func (c *service) RegistryLogin(cluster string, login *v1Chart.RegistryLogin) error {
+	helmClient, err := NewHelmClient(cluster, "")
+	if err != nil {
+		return err
+	}
+	return helmClient.RegistryLogin(login.Host, login.UserName, login.Password, login.Insecure)
+}
+
+func (c *service) RegistryLogout(cluster, host string) error {
+	helmClient, err := NewHelmClient(cluster, "")
+	if err != nil {
+		return err
+	}
+	return helmClient.RegistryLogout(host)
+}
+
+func (c *service) GetOciChartVersions(cluster, ref string) ([]string, error) {
+	helmClient, err := NewHelmClient(cluster, "")
+	if err != nil {
+		return nil, err
+	}
+	return helmClient.OciChartVersions(ref)
+}
+
 func NewHelmClient(clusterName, namespace string) (*helm.Client, error) {
+	return newHelmClient(clusterName, namespace, nil)
+}
+
+func newHelmClient(clusterName, namespace string, log action.DebugLog) (*helm.Client, error) {
 	clu, err := cluster.NewService().Get(clusterName, common.DBOptions{})
 	if err != nil {
 		return nil, err
@@ -320,6 +470,7 @@ func NewHelmClient(clusterName, namespace string) (*helm.Client, error) {
 		ClusterName: clusterName,
 		KubeConfig:  kubeConfig,
 		Namespace:   namespace,
+		Log:         log,
 	})
 	if err != nil {
 		return nil, err
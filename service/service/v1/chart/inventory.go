@@ -0,0 +1,179 @@
+package chart
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/KubeOperator/kubepi/pkg/util/helm"
+	v1Chart "github.com/KubeOperator/kubepi/service/model/v1/chart"
+	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// Inventory lists the helm releases of the clusters concurrently and compares each
+// against the index of its repo, a cluster which can not be reached is reported in the errors.
+// All clusters are listed when the filter names none.
+func (c *service) Inventory(filter v1Chart.InventoryFilter) (*v1Chart.Inventory, error) {
+	if len(filter.Clusters) == 0 {
+		clusters, err := c.clusterService.List(common.DBOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, clu := range clusters {
+			filter.Clusters = append(filter.Clusters, clu.Name)
+		}
+	}
+	result := &v1Chart.Inventory{
+		Items:  []v1Chart.InventoryItem{},
+		Errors: map[string]string{},
+	}
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	for _, name := range filter.Clusters {
+		wg.Add(1)
+		go func(cluster string) {
+			defer wg.Done()
+			items, err := c.clusterInventory(cluster, filter)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				result.Errors[cluster] = err.Error()
+				return
+			}
+			result.Items = append(result.Items, items...)
+		}(name)
+	}
+	wg.Wait()
+	sort.Slice(result.Items, func(i, j int) bool {
+		a, b := result.Items[i], result.Items[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return result, nil
+}
+
+// repoVersions looks up the chart versions of the repos of one cluster, every index is loaded once
+type repoVersions struct {
+	client  *helm.Client
+	repos   []*repo.Entry
+	indexes map[string]*repo.IndexFile
+	errs    map[string]error
+}
+
+func (r *repoVersions) index(repoName string) (*repo.IndexFile, error) {
+	if index, ok := r.indexes[repoName]; ok {
+		return index, r.errs[repoName]
+	}
+	index, err := r.client.RepoIndex(repoName)
+	r.indexes[repoName] = index
+	r.errs[repoName] = err
+	return index, err
+}
+
+// findRepo returns the first repo of the cluster with the chart, for releases installed before their repo was recorded
+func (r *repoVersions) findRepo(chartName string) string {
+	for _, re := range r.repos {
+		if index, err := r.index(re.Name); err == nil && index.Has(chartName, "") {
+			return re.Name
+		}
+	}
+	return ""
+}
+
+// latest returns the newest version and app version of the chart in the repo
+func (r *repoVersions) latest(repoName, chartName string) (string, string, error) {
+	if registry.IsOCI(repoName) {
+		versions, err := r.client.OciChartVersions(repoName)
+		if err != nil {
+			return "", "", err
+		}
+		return helm.LatestVersion(versions), "", nil
+	}
+	index, err := r.index(repoName)
+	if err != nil {
+		return "", "", err
+	}
+	var versions []string
+	for _, cv := range index.Entries[chartName] {
+		versions = append(versions, cv.Version)
+	}
+	latest := helm.LatestVersion(versions)
+	for _, cv := range index.Entries[chartName] {
+		if cv.Version == latest {
+			return latest, cv.AppVersion, nil
+		}
+	}
+	return latest, "", nil
+}
+
+func (c *service) clusterInventory(cluster string, filter v1Chart.InventoryFilter) ([]v1Chart.InventoryItem, error) {
+	helmClient, err := NewHelmClient(cluster, "")
+	if err != nil {
+		return nil, err
+	}
+	releases, err := helmClient.Releases()
+	if err != nil {
+		return nil, err
+	}
+	// a cluster without repos still lists its releases, only their versions stay unknown
+	repos, _ := helmClient.ListRepo()
+	versions := &repoVersions{
+		client:  helmClient,
+		repos:   repos,
+		indexes: map[string]*repo.IndexFile{},
+		errs:    map[string]error{},
+	}
+	items := make([]v1Chart.InventoryItem, 0)
+	for _, rel := range releases {
+		if rel.Chart == nil || rel.Chart.Metadata == nil {
+			continue
+		}
+		item := v1Chart.InventoryItem{
+			Cluster:      cluster,
+			Namespace:    rel.Namespace,
+			Name:         rel.Name,
+			Revision:     rel.Version,
+			Chart:        rel.Chart.Metadata.Name,
+			ChartVersion: rel.Chart.Metadata.Version,
+			AppVersion:   rel.Chart.Metadata.AppVersion,
+		}
+		if rel.Info != nil {
+			item.Status = rel.Info.Status.String()
+			item.Updated = rel.Info.LastDeployed.Time
+		}
+		if filter.Chart != "" && item.Chart != filter.Chart {
+			continue
+		}
+		if app, err := c.clusterAppService.Get(rel.Name, cluster, common.DBOptions{}); err == nil {
+			item.Repo = app.Repo
+		}
+		if item.Repo == "" {
+			item.Repo = versions.findRepo(item.Chart)
+		}
+		if filter.Repo != "" && item.Repo != filter.Repo {
+			continue
+		}
+		if item.Repo != "" {
+			latest, appVersion, err := versions.latest(item.Repo, item.Chart)
+			if err != nil {
+				item.Error = err.Error()
+			}
+			item.LatestVersion = latest
+			item.LatestAppVersion = appVersion
+			item.UpgradeAvailable = helm.NewerVersion(item.ChartVersion, latest)
+		}
+		if filter.Outdated && !item.UpgradeAvailable {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,266 @@
+package chart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/KubeOperator/kubepi/pkg/util/helm"
+	v1 "github.com/KubeOperator/kubepi/service/model/v1"
+	v1Chart "github.com/KubeOperator/kubepi/service/model/v1/chart"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/google/uuid"
+)
+
+// maxJobLogLines bounds the helm output kept for a job, older lines are dropped
+const maxJobLogLines = 2000
+
+// runningJob is the in memory state of a job until it is finished
+type runningJob struct {
+	sync.Mutex
+	job    *v1Chart.Job
+	log    []string
+	cancel context.CancelFunc
+}
+
+func (r *runningJob) printf(format string, v ...interface{}) {
+	r.Lock()
+	defer r.Unlock()
+	r.log = append(r.log, fmt.Sprintf("%s %s", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, v...)))
+	if len(r.log) > maxJobLogLines {
+		r.log = r.log[len(r.log)-maxJobLogLines:]
+	}
+}
+
+func (r *runningJob) snapshot() v1Chart.Job {
+	r.Lock()
+	defer r.Unlock()
+	job := *r.job
+	job.Log = append([]string{}, r.log...)
+	return job
+}
+
+var runningJobs = struct {
+	sync.Mutex
+	jobs map[string]*runningJob
+}{jobs: map[string]*runningJob{}}
+
+// SubmitJob persists the job and runs it in the background, only one job runs for a release at a time
+func (c *service) SubmitJob(job *v1Chart.Job, values map[string]interface{}) (*v1Chart.Job, error) {
+	switch job.Action {
+	case v1Chart.JobActionInstall, v1Chart.JobActionUpgrade:
+		if job.Upload != "" {
+			ct, err := loadUpload(job.Upload)
+			if err != nil {
+				return nil, err
+			}
+			job.Repo = ""
+			job.ChartName = ct.Name()
+			job.ChartVersion = ct.Metadata.Version
+		}
+		if job.ChartName == "" {
+			return nil, errors.New("chart name is required")
+		}
+	case v1Chart.JobActionUninstall:
+	default:
+		return nil, fmt.Errorf("unsupported action %s", job.Action)
+	}
+	if job.Release == "" {
+		return nil, errors.New("release name is required")
+	}
+	now := time.Now()
+	job.BaseModel = v1.BaseModel{
+		ApiVersion: "v1",
+		Kind:       "HelmJob",
+		CreateAt:   now,
+		UpdateAt:   now,
+		CreatedBy:  job.CreatedBy,
+	}
+	job.Metadata = v1.Metadata{
+		Name: fmt.Sprintf("helm-%s-%s-%d", job.Action, job.Release, now.UnixNano()),
+		UUID: uuid.New().String(),
+	}
+	job.Status = v1Chart.JobStatusPending
+	job.Log = []string{}
+
+	runningJobs.Lock()
+	defer runningJobs.Unlock()
+	for _, r := range runningJobs.jobs {
+		if r.job.Cluster == job.Cluster && r.job.Namespace == job.Namespace && r.job.Release == job.Release {
+			return nil, fmt.Errorf("job %s of release %s is still running", r.job.UUID, job.Release)
+		}
+	}
+	if err := c.GetDB(common.DBOptions{}).Save(job); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &runningJob{job: job, log: []string{}, cancel: cancel}
+	runningJobs.jobs[job.UUID] = r
+	result := *job
+	go c.runJob(ctx, r, values)
+	return &result, nil
+}
+
+func (c *service) runJob(ctx context.Context, r *runningJob, values map[string]interface{}) {
+	defer func() {
+		runningJobs.Lock()
+		delete(runningJobs.jobs, r.job.UUID)
+		runningJobs.Unlock()
+		r.cancel()
+	}()
+	r.Lock()
+	job := r.job
+	job.Status = v1Chart.JobStatusRunning
+	job.StartAt = time.Now()
+	job.UpdateAt = job.StartAt
+	r.Unlock()
+	db := c.GetDB(common.DBOptions{})
+	if err := db.Save(job); err != nil {
+		server.Logger().Errorf("can not save helm job %s: %s", job.UUID, err)
+	}
+
+	err := c.execJob(ctx, r, values)
+
+	r.Lock()
+	switch {
+	case err == nil:
+		job.Status = v1Chart.JobStatusSuccess
+	case ctx.Err() != nil:
+		job.Status = v1Chart.JobStatusCanceled
+		job.Message = err.Error()
+	default:
+		job.Status = v1Chart.JobStatusFailed
+		job.Message = err.Error()
+	}
+	// helm may keep logging after a canceled install returned
+	job.Log = append([]string{}, r.log...)
+	job.EndAt = time.Now()
+	job.UpdateAt = job.EndAt
+	r.Unlock()
+	if err := db.Save(job); err != nil {
+		server.Logger().Errorf("can not save helm job %s: %s", job.UUID, err)
+	}
+}
+
+func (c *service) execJob(ctx context.Context, r *runningJob, values map[string]interface{}) error {
+	job := r.job
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	helmClient, err := newHelmClient(job.Cluster, job.Namespace, r.printf)
+	if err != nil {
+		return err
+	}
+	options := helm.Options{Wait: job.Wait, Timeout: time.Duration(job.Timeout) * time.Second}
+	if job.Upload != "" && job.Action != v1Chart.JobActionUninstall {
+		ct, err := loadUpload(job.Upload)
+		if err != nil {
+			return err
+		}
+		if job.Action == v1Chart.JobActionUpgrade {
+			_, err := helmClient.UpgradeArchive(ctx, job.Release, ct, values, options)
+			return err
+		}
+		if _, err := helmClient.InstallArchive(ctx, job.Release, ct, values, options); err != nil {
+			return err
+		}
+		return c.recordApp(job.Cluster, "", job.Release, job.ChartName)
+	}
+	switch job.Action {
+	case v1Chart.JobActionInstall:
+		if _, err := helmClient.InstallWithContext(ctx, job.Release, job.Repo, job.ChartName, job.ChartVersion, values, options); err != nil {
+			return err
+		}
+		return c.recordApp(job.Cluster, job.Repo, job.Release, job.ChartName)
+	case v1Chart.JobActionUpgrade:
+		_, err := helmClient.UpgradeWithContext(ctx, job.Release, job.Repo, job.ChartName, job.ChartVersion, values, options)
+		return err
+	default:
+		if _, err := helmClient.UninstallWithOptions(job.Release, options); err != nil {
+			return err
+		}
+		err := c.clusterAppService.Delete(job.Release, job.Cluster, common.DBOptions{})
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+}
+
+// GetJob returns the job, the log of a running job is read from memory
+func (c *service) GetJob(cluster, id string) (*v1Chart.Job, error) {
+	runningJobs.Lock()
+	r, ok := runningJobs.jobs[id]
+	runningJobs.Unlock()
+	if ok && r.job.Cluster == cluster {
+		job := r.snapshot()
+		return &job, nil
+	}
+	var job v1Chart.Job
+	if err := c.GetDB(common.DBOptions{}).Select(q.Eq("UUID", id), q.Eq("Cluster", cluster)).First(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (c *service) ListJobs(cluster string) ([]v1Chart.Job, error) {
+	jobs := make([]v1Chart.Job, 0)
+	if err := c.GetDB(common.DBOptions{}).Select(q.Eq("Cluster", cluster)).OrderBy("CreateAt").Reverse().Find(&jobs); err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return jobs, nil
+		}
+		return nil, err
+	}
+	for i := range jobs {
+		jobs[i].Log = nil
+	}
+	return jobs, nil
+}
+
+// CancelJob stops an install or upgrade, helm marks the release as failed.
+// Uninstalls can only be canceled before they started.
+func (c *service) CancelJob(cluster, id string) error {
+	runningJobs.Lock()
+	r, ok := runningJobs.jobs[id]
+	runningJobs.Unlock()
+	if !ok || r.job.Cluster != cluster {
+		if _, err := c.GetJob(cluster, id); err != nil {
+			return err
+		}
+		return errors.New("job is already finished")
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.job.Action == v1Chart.JobActionUninstall && r.job.Status == v1Chart.JobStatusRunning {
+		return errors.New("a running uninstall can not be canceled")
+	}
+	r.cancel()
+	return nil
+}
+
+// FailInterruptedJobs marks the jobs left unfinished by a restart as failed
+func FailInterruptedJobs() {
+	db := server.DB()
+	var jobs []v1Chart.Job
+	query := db.Select(q.Or(q.Eq("Status", v1Chart.JobStatusPending), q.Eq("Status", v1Chart.JobStatusRunning)))
+	if err := query.Find(&jobs); err != nil {
+		if !errors.Is(err, storm.ErrNotFound) {
+			server.Logger().Errorf("can not list interrupted helm jobs: %s", err)
+		}
+		return
+	}
+	for i := range jobs {
+		jobs[i].Status = v1Chart.JobStatusFailed
+		jobs[i].Message = "interrupted by a restart, check the status of the release"
+		jobs[i].EndAt = time.Now()
+		jobs[i].UpdateAt = jobs[i].EndAt
+		if err := db.Save(&jobs[i]); err != nil {
+			server.Logger().Errorf("can not save helm job %s: %s", jobs[i].UUID, err)
+		}
+	}
+}
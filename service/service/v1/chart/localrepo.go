@@ -0,0 +1,72 @@
+package chart
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/KubeOperator/kubepi/pkg/file"
+	"github.com/KubeOperator/kubepi/pkg/util/helm"
+	v1Chart "github.com/KubeOperator/kubepi/service/model/v1/chart"
+	"github.com/KubeOperator/kubepi/service/server"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func chartRepoPath() string {
+	return file.ReplaceHomeDir(server.Config().Spec.ChartRepo.Path)
+}
+
+// LocalRepo is the chart repository served by KubePi
+func LocalRepo() helm.LocalRepo {
+	return helm.LocalRepo{Dir: filepath.Join(chartRepoPath(), "repo")}
+}
+
+// LocalRepoUrl is the url a cluster repo is added with, without a configured url
+// the helm client of KubePi reaches the repository on localhost.
+func LocalRepoUrl() string {
+	spec := server.Config().Spec
+	if spec.ChartRepo.Url != "" {
+		return spec.ChartRepo.Url
+	}
+	scheme := "http"
+	if spec.Server.SSL.Enable {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://127.0.0.1:%d/kubepi/api/chartrepo", scheme, spec.Server.Bind.Port)
+}
+
+func (c *service) GetLocalRepo() *v1Chart.LocalRepo {
+	return &v1Chart.LocalRepo{Url: LocalRepoUrl()}
+}
+
+func (c *service) ListLocalCharts() (map[string]repo.ChartVersions, error) {
+	return LocalRepo().Charts()
+}
+
+func (c *service) GetLocalChart(name string) (repo.ChartVersions, error) {
+	charts, err := LocalRepo().Charts()
+	if err != nil {
+		return nil, err
+	}
+	versions, ok := charts[name]
+	if !ok {
+		return nil, fmt.Errorf("chart %s not found", name)
+	}
+	return versions, nil
+}
+
+// PushLocalChart adds the archive to the local repository, an existing version is only replaced with force
+func (c *service) PushLocalChart(data []byte, force bool) (*chart.Metadata, error) {
+	ct, err := helm.LoadArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := LocalRepo().Save(ct, data, force); err != nil {
+		return nil, err
+	}
+	return ct.Metadata, nil
+}
+
+func (c *service) DeleteLocalChart(name, version string) error {
+	return LocalRepo().Delete(name, version)
+}
@@ -0,0 +1,86 @@
+package chart
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/KubeOperator/kubepi/pkg/util/helm"
+	v1Chart "github.com/KubeOperator/kubepi/service/model/v1/chart"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/google/uuid"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// uploadRetention is how long an uploaded archive can be installed by its id
+const uploadRetention = 7 * 24 * time.Hour
+
+func uploadDir() string {
+	return filepath.Join(chartRepoPath(), "uploads")
+}
+
+// UploadChart validates the archive and keeps it to install by the returned id,
+// with keep the chart is also added to the local chart repository.
+func (c *service) UploadChart(data []byte, keep bool) (*v1Chart.ChartUpload, error) {
+	ct, err := helm.LoadArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	if keep {
+		// a kept chart must not replace a published version of the local repository
+		if err := LocalRepo().Save(ct, data, false); err != nil {
+			return nil, err
+		}
+	}
+	removeExpiredUploads()
+	if err := os.MkdirAll(uploadDir(), 0755); err != nil {
+		return nil, err
+	}
+	id := uuid.New().String()
+	if err := os.WriteFile(filepath.Join(uploadDir(), id+".tgz"), data, 0644); err != nil {
+		return nil, err
+	}
+	upload := &v1Chart.ChartUpload{
+		Id:       id,
+		Metadata: *ct.Metadata,
+		Values:   ct.Values,
+		Kept:     keep,
+	}
+	for _, f := range ct.Files {
+		if f.Name == "README.md" {
+			upload.Readme = string(f.Data)
+		}
+	}
+	return upload, nil
+}
+
+func loadUpload(id string) (*chart.Chart, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("invalid upload id %s", id)
+	}
+	data, err := os.ReadFile(filepath.Join(uploadDir(), id+".tgz"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("uploaded chart %s not found or expired", id)
+		}
+		return nil, err
+	}
+	return helm.LoadArchive(data)
+}
+
+func removeExpiredUploads() {
+	entries, err := os.ReadDir(uploadDir())
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < uploadRetention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(uploadDir(), e.Name())); err != nil {
+			server.Logger().Errorf("can not remove expired chart upload %s: %s", e.Name(), err)
+		}
+	}
+}
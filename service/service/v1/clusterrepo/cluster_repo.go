@@ -20,6 +20,13 @@ type Service interface {
 	Delete(cluster, repo string, options common.DBOptions) error
 	DeleteByCluster(cluster string, options common.DBOptions) error
 	DeleteByRepo(repo string, options common.DBOptions) error
+	Get(cluster, repo string, options common.DBOptions) (*V1ClusterRepo.ClusterRepo, error)
+	UpdatePullSecret(cluster, repo string, pullSecret V1ClusterRepo.PullSecret) (*V1ClusterRepo.ClusterRepo, error)
+	SyncPullSecret(cluster, repo string) error
+	RemovePullSecret(cluster, repo string) error
+	SyncPullSecretsByRepo(repo string)
+	PullSecretClusters(repo string, options common.DBOptions) ([]string, error)
+	RemovePullSecrets(repo string, clusters []string)
 }
 
 func NewService() Service {
@@ -0,0 +1,172 @@
+package clusterrepo
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/KubeOperator/kubepi/pkg/kubernetes"
+	V1ClusterRepo "github.com/KubeOperator/kubepi/service/model/v1/clusterrepo"
+	V1ImageRepo "github.com/KubeOperator/kubepi/service/model/v1/imagerepo"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+)
+
+func (s *service) Get(cluster, repo string, options common.DBOptions) (*V1ClusterRepo.ClusterRepo, error) {
+	db := s.GetDB(options)
+	query := db.Select(q.And(q.Eq("Cluster", cluster), q.Eq("Repo", repo)))
+	var clusterRepo V1ClusterRepo.ClusterRepo
+	if err := query.First(&clusterRepo); err != nil {
+		return nil, err
+	}
+	return &clusterRepo, nil
+}
+
+// UpdatePullSecret saves the pull secret settings of the link and applies them to the cluster
+func (s *service) UpdatePullSecret(cluster, repo string, pullSecret V1ClusterRepo.PullSecret) (*V1ClusterRepo.ClusterRepo, error) {
+	clusterRepo, err := s.Get(cluster, repo, common.DBOptions{})
+	if err != nil {
+		return nil, err
+	}
+	clusterRepo.PullSecret.Enable = pullSecret.Enable
+	clusterRepo.PullSecret.Namespaces = pullSecret.Namespaces
+	clusterRepo.PullSecret.PatchServiceAccount = pullSecret.PatchServiceAccount
+	if err := s.GetDB(common.DBOptions{}).UpdateField(clusterRepo, "PullSecret", clusterRepo.PullSecret); err != nil {
+		return nil, err
+	}
+	err = s.SyncPullSecret(cluster, repo)
+	clusterRepo, _ = s.Get(cluster, repo, common.DBOptions{})
+	return clusterRepo, err
+}
+
+// pullSecretConfig is the docker config of the repo, the registry is the host images are pulled from
+func pullSecretConfig(rp V1ImageRepo.ImageRepo) ([]byte, error) {
+	if !rp.Auth || rp.Credential.Username == "" {
+		return nil, fmt.Errorf("image repo %s has no credentials for a pull secret", rp.Name)
+	}
+	registry := rp.DownloadUrl
+	if registry == "" {
+		registry = rp.EndPoint
+	}
+	if u, err := url.Parse(registry); err == nil && u.Host != "" {
+		registry = u.Host
+	}
+	return kubernetes.DockerConfigJSON(strings.TrimSuffix(registry, "/"), rp.Credential.Username, rp.Credential.Password)
+}
+
+// SyncPullSecret creates, updates or removes the pull secrets of the link as configured, the
+// result is recorded on the link
+func (s *service) SyncPullSecret(cluster, repo string) error {
+	clusterRepo, err := s.Get(cluster, repo, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	err = s.applyPullSecret(clusterRepo)
+	pullSecret := clusterRepo.PullSecret
+	pullSecret.SyncedAt = time.Now()
+	pullSecret.Message = ""
+	if err != nil {
+		pullSecret.Message = err.Error()
+	}
+	if updateErr := s.GetDB(common.DBOptions{}).UpdateField(clusterRepo, "PullSecret", pullSecret); updateErr != nil && err == nil {
+		err = updateErr
+	}
+	return err
+}
+
+func (s *service) applyPullSecret(clusterRepo *V1ClusterRepo.ClusterRepo) error {
+	clu, err := s.clusterService.Get(clusterRepo.Cluster, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	k := kubernetes.NewKubernetes(clu)
+	if !clusterRepo.PullSecret.Enable {
+		return k.DeletePullSecrets(clusterRepo.Repo)
+	}
+	rp, err := s.imgarepoService.GetByName(clusterRepo.Repo, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	config, err := pullSecretConfig(rp)
+	if err != nil {
+		return err
+	}
+	return k.ApplyPullSecret(clusterRepo.Repo, clusterRepo.PullSecret.Namespaces, config, clusterRepo.PullSecret.PatchServiceAccount)
+}
+
+// RemovePullSecret deletes the pull secrets of the link from the cluster, before the link is deleted
+func (s *service) RemovePullSecret(cluster, repo string) error {
+	clu, err := s.clusterService.Get(cluster, common.DBOptions{})
+	if err != nil {
+		return err
+	}
+	return kubernetes.NewKubernetes(clu).DeletePullSecrets(repo)
+}
+
+// SyncPullSecretsByRepo updates the pull secrets of every cluster linked to the repo, e.g. after its credentials changed
+func (s *service) SyncPullSecretsByRepo(repo string) {
+	var clusterRepos []V1ClusterRepo.ClusterRepo
+	if err := s.GetDB(common.DBOptions{}).Select(q.Eq("Repo", repo)).Find(&clusterRepos); err != nil {
+		return
+	}
+	for _, clusterRepo := range clusterRepos {
+		if !clusterRepo.PullSecret.Enable {
+			continue
+		}
+		if err := s.SyncPullSecret(clusterRepo.Cluster, repo); err != nil {
+			server.Logger().Errorf("sync pull secret of repo %s in cluster %s failed: %s", repo, clusterRepo.Cluster, err)
+		}
+	}
+}
+
+// PullSecretClusters returns the clusters linked to the repo which have its pull secrets
+func (s *service) PullSecretClusters(repo string, options common.DBOptions) ([]string, error) {
+	var clusterRepos []V1ClusterRepo.ClusterRepo
+	if err := s.GetDB(options).Select(q.Eq("Repo", repo)).Find(&clusterRepos); err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return nil, err
+	}
+	var clusters []string
+	for _, clusterRepo := range clusterRepos {
+		if clusterRepo.PullSecret.Enable {
+			clusters = append(clusters, clusterRepo.Cluster)
+		}
+	}
+	return clusters, nil
+}
+
+// RemovePullSecrets deletes the pull secrets of the repo from the clusters, after the repo has been deleted
+func (s *service) RemovePullSecrets(repo string, clusters []string) {
+	for _, cluster := range clusters {
+		if err := s.RemovePullSecret(cluster, repo); err != nil {
+			server.Logger().Errorf("remove pull secret of repo %s in cluster %s failed: %s", repo, cluster, err)
+		}
+	}
+}
+
+// StartPullSecretScheduler syncs the links provisioning all namespaces every ten minutes, so new namespaces get the secret
+func StartPullSecretScheduler() {
+	s := NewService().(*service)
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			var clusterRepos []V1ClusterRepo.ClusterRepo
+			if err := s.GetDB(common.DBOptions{}).All(&clusterRepos); err != nil {
+				server.Logger().Errorf("can not list cluster repos for pull secret sync: %s", err)
+				continue
+			}
+			for _, clusterRepo := range clusterRepos {
+				if !clusterRepo.PullSecret.Enable || len(clusterRepo.PullSecret.Namespaces) > 0 {
+					continue
+				}
+				if err := s.SyncPullSecret(clusterRepo.Cluster, clusterRepo.Repo); err != nil {
+					server.Logger().Errorf("sync pull secret of repo %s in cluster %s failed: %s", clusterRepo.Repo, clusterRepo.Cluster, err)
+				}
+			}
+		}
+	}()
+}
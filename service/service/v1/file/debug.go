@@ -0,0 +1,50 @@
+package file
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/KubeOperator/kubepi/pkg/util/podtool"
+	"github.com/KubeOperator/kubepi/service/model/v1/file"
+	"github.com/KubeOperator/kubepi/service/server"
+)
+
+var ErrDebugNotAllowed = errors.New("the container has no shell and file tools, its files are reached through a debug container which needs the debug permission")
+
+// missingFileTools remembers the containers without a shell and file tools, so they are probed once
+var missingFileTools = struct {
+	sync.Mutex
+	items map[string]bool
+}{items: map[string]bool{}}
+
+// withFileTools returns the tool of a debug container for containers without the tools the file
+// operations need, e.g. distroless and scratch images. Adding the debug container needs the permission the debug
+// terminal needs, which the request carries.
+func (f service) withFileTools(pt podtool.PodTool, request file.Request) (podtool.PodTool, error) {
+	key := strings.Join([]string{request.Cluster, request.Namespace, request.PodName, request.ContainerName}, "/")
+	missingFileTools.Lock()
+	missing, checked := missingFileTools.items[key]
+	missingFileTools.Unlock()
+	if !checked {
+		hasTools, err := pt.HasFileTools()
+		if err != nil {
+			return pt, err
+		}
+		missing = !hasTools
+		missingFileTools.Lock()
+		missingFileTools.items[key] = missing
+		missingFileTools.Unlock()
+	}
+	if !missing {
+		return pt, nil
+	}
+	if !request.Debug {
+		return pt, ErrDebugNotAllowed
+	}
+	debug, err := pt.DebugTool(server.Config().Spec.File.DebugImage)
+	if err != nil {
+		return pt, err
+	}
+	return *debug, nil
+}
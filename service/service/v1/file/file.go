@@ -1,29 +1,35 @@
 package file
 
 import (
-	"fmt"
+	"errors"
 	"github.com/KubeOperator/kubepi/service/model/v1/file"
 	"github.com/KubeOperator/kubepi/service/service/v1/cluster"
 	"github.com/KubeOperator/kubepi/service/service/v1/common"
 	kubeClient "github.com/KubeOperator/kubepi/pkg/kubernetes"
 	"github.com/KubeOperator/kubepi/pkg/util/podtool"
-	"github.com/sirupsen/logrus"
 	"io"
 	"k8s.io/client-go/kubernetes"
-	"os"
-	"path"
-	"path/filepath"
-	"strings"
-	"time"
+	"mime/multipart"
+	"unicode/utf8"
 )
 
+// ErrBinaryFile is returned by CatFile for files which are not utf-8 text, they are downloaded instead
+var ErrBinaryFile = errors.New("the file is not utf-8 text and can not be edited, download it instead")
+
 type Service interface {
 	ListFiles(request file.Request) ([]podtool.File, error)
-	DownloadFile(request file.Request) (string, error)
-	UploadFile(request file.Request) error
-	ExecNewCommand(request file.Request) ([]byte, error)
-	EditFile(request file.Request) error
-	CatFile(request file.Request) ([]byte, error)
+	Download(request file.Request, format string) (*Download, error)
+	UploadFiles(request file.Request, reader *multipart.Reader) error
+	CreateUploadSession(session file.UploadSession, user string) (*file.UploadSession, error)
+	GetUploadSession(id, user string) (*file.UploadSession, error)
+	UploadChunk(id, user string, offset int64, chunk io.Reader) (*file.UploadSession, error)
+	DeleteUploadSession(id, user string) error
+	CreateFolder(request file.Request) error
+	Rename(request file.Request) error
+	Remove(request file.Request) error
+	EditFile(request file.Request) (string, error)
+	CreateFile(request file.Request) error
+	CatFile(request file.Request) (*file.Content, error)
 }
 
 type service struct {
@@ -36,12 +42,28 @@ func NewService() Service {
 	}
 }
 
-func (f service) ExecNewCommand(request file.Request) ([]byte, error) {
+func (f service) CreateFolder(request file.Request) error {
 	pt, err := f.GetPodTool(request)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	return pt.MakeDir(request.Path)
+}
+
+func (f service) Rename(request file.Request) error {
+	pt, err := f.GetPodTool(request)
+	if err != nil {
+		return err
+	}
+	return pt.MoveFile(request.OldPath, request.Path)
+}
+
+func (f service) Remove(request file.Request) error {
+	pt, err := f.GetPodTool(request)
+	if err != nil {
+		return err
 	}
-	return pt.ExecCommand(request.Commands)
+	return pt.Remove(request.Path)
 }
 
 func (f service) GetPodTool(request file.Request) (podtool.PodTool, error) {
@@ -69,7 +91,7 @@ func (f service) GetPodTool(request file.Request) (podtool.PodTool, error) {
 			Stdin: request.Stdin,
 		},
 	}
-	return pt, nil
+	return f.withFileTools(pt, request)
 }
 
 func (f service) ListFiles(request file.Request) ([]podtool.File, error) {
@@ -80,66 +102,37 @@ func (f service) ListFiles(request file.Request) ([]podtool.File, error) {
 	return pt.ListFiles(request.Path)
 }
 
-func (f service) EditFile(request file.Request) error {
-	pt, err := f.GetPodTool(request)
-	if err != nil {
-		return err
-	}
-	return pt.EditFile(request.Path, request.Content)
-}
-
-func (f service) DownloadFile(request file.Request) (string, error) {
-
-	var fileP string
+func (f service) EditFile(request file.Request) (string, error) {
 	pt, err := f.GetPodTool(request)
-	if err != nil {
-		return fileP, err
-	}
-	fileNameWithSuffix := path.Base(request.Path)
-	fileType := path.Ext(fileNameWithSuffix)
-	fileName := strings.TrimSuffix(fileNameWithSuffix, fileType)
-	fileP = filepath.Join(os.TempDir(), fmt.Sprintf("%d", time.Now().UnixNano()))
-	err = os.MkdirAll(fileP, os.ModePerm)
 	if err != nil {
 		return "", err
 	}
-	fileP = filepath.Join(fileP, fileName+".tar")
-	err = pt.CopyFromPod(request.Path, fileP)
-	if err != nil {
-		return "", err
-	}
-
-	return fileP, nil
+	return pt.EditFile(request.Path, []byte(request.Content), podtool.EditOptions{
+		Checksum: request.Checksum,
+		Backup:   request.Backup,
+	})
 }
 
-func (f service) UploadFile(request file.Request) error {
+func (f service) CreateFile(request file.Request) error {
 	pt, err := f.GetPodTool(request)
 	if err != nil {
 		return err
 	}
-	reader, writer := io.Pipe()
-	pt.ExecConfig.Stdin = reader
-	go func() {
-		defer func() {
-			_ = writer.Close()
-		}()
-		tarFile, err := os.Open(request.FilePath)
-		if err != nil {
-			logrus.Error(err)
-			return
-		}
-		_, err = io.Copy(writer, tarFile)
-		if err != nil {
-			logrus.Error(err)
-		}
-	}()
-	return pt.CopyToContainer(request.Path)
+	return pt.CreateFile(request.Path, []byte(request.Content))
 }
 
-func (f service) CatFile(request file.Request) ([]byte, error) {
+func (f service) CatFile(request file.Request) (*file.Content, error) {
 	pt, err := f.GetPodTool(request)
 	if err != nil {
 		return nil, err
 	}
-	return pt.CatFile(request.Path)
+	content, err := pt.CatFile(request.Path)
+	if err != nil {
+		return nil, err
+	}
+	// the content travels as a json string, which can not hold the bytes of binary files
+	if !utf8.Valid(content) {
+		return nil, ErrBinaryFile
+	}
+	return &file.Content{Content: string(content), Checksum: podtool.Checksum(content)}, nil
 }
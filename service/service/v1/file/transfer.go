@@ -0,0 +1,298 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KubeOperator/kubepi/pkg/util/podtool"
+	"github.com/KubeOperator/kubepi/service/model/v1/file"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTooLarge              = errors.New("file exceeds the transfer size limit")
+	ErrUploadSessionNotFound = errors.New("upload session not found")
+)
+
+// MaxUploadSize is the upload limit in bytes, 0 is unlimited
+func MaxUploadSize() int64 {
+	return server.Config().Spec.File.MaxUploadSize * 1024 * 1024
+}
+
+func maxDownloadSize() int64 {
+	return server.Config().Spec.File.MaxDownloadSize * 1024 * 1024
+}
+
+// Download streams a pod file or an archive of it from the container
+type Download struct {
+	Name string
+	// Size of the file, -1 for archives whose size is not known before they are streamed
+	Size   int64
+	pt     podtool.PodTool
+	path   string
+	format string
+}
+
+func (d *Download) Stream(w io.Writer) error {
+	if d.format == "" {
+		return d.pt.StreamFile(d.path, w)
+	}
+	return d.pt.StreamArchive(d.path, d.format, w)
+}
+
+// Download prepares the download of the path, directories are archived as tar.gz unless another format is given
+func (f service) Download(request file.Request, format string) (*Download, error) {
+	switch format {
+	case "", podtool.ArchiveTar, podtool.ArchiveTarGz, podtool.ArchiveZip:
+	case "tgz":
+		format = podtool.ArchiveTarGz
+	default:
+		return nil, fmt.Errorf("unsupported archive format %s", format)
+	}
+	pt, err := f.GetPodTool(request)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := pt.Stat(request.Path)
+	if err != nil {
+		return nil, err
+	}
+	if max := maxDownloadSize(); max > 0 && stat.Size > max {
+		return nil, ErrTooLarge
+	}
+	if stat.Dir && format == "" {
+		format = podtool.ArchiveTarGz
+	}
+	name := path.Base(path.Clean(request.Path))
+	if name == "/" {
+		name = "root"
+	}
+	d := &Download{Name: name, Size: stat.Size, pt: pt, path: request.Path, format: format}
+	if format != "" {
+		d.Name = name + "." + format
+		d.Size = -1
+	}
+	return d, nil
+}
+
+// UploadFiles streams the files of the multipart form into the directory of the request. The file names
+// can be relative paths, e.g. of a directory upload, missing directories are created.
+func (f service) UploadFiles(request file.Request, reader *multipart.Reader) error {
+	pt, err := f.GetPodTool(request)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := partFileName(part)
+		if part.FormName() != "files" || name == "" {
+			continue
+		}
+		if err := pt.WriteFileFrom(path.Join(request.Path, name), part, false); err != nil {
+			return fmt.Errorf("upload %s failed: %s", name, err)
+		}
+		count++
+	}
+	if count == 0 {
+		return errors.New("files is null")
+	}
+	return nil
+}
+
+// partFileName is the file name of the part cleaned to a relative path, multipart.Part.FileName
+// drops the directories of the name
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	name := strings.ReplaceAll(params["filename"], "\\", "/")
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+type uploadSession struct {
+	sync.Mutex
+	file.UploadSession
+}
+
+var uploadSessions = struct {
+	sync.Mutex
+	items map[string]*uploadSession
+}{items: map[string]*uploadSession{}}
+
+func uploadTempPath(s *file.UploadSession) string {
+	return path.Join(path.Dir(s.Path), fmt.Sprintf(".%s.kubepi-upload-%s", path.Base(s.Path), s.ID))
+}
+
+// removeExpiredUploadSessions drops the sessions not updated within the configured hours together with their temp files
+func (f service) removeExpiredUploadSessions() {
+	expires := time.Duration(server.Config().Spec.File.UploadSessionExpires) * time.Hour
+	var expired []file.UploadSession
+	uploadSessions.Lock()
+	for id, s := range uploadSessions.items {
+		if time.Since(s.UpdatedAt) > expires {
+			expired = append(expired, s.UploadSession)
+			delete(uploadSessions.items, id)
+		}
+	}
+	uploadSessions.Unlock()
+	for i := range expired {
+		s := expired[i]
+		go func() {
+			pt, err := f.GetPodTool(s.Request())
+			if err == nil {
+				err = pt.RemoveFile(uploadTempPath(&s))
+			}
+			if err != nil {
+				server.Logger().Errorf("remove expired upload %s of %s failed: %s", s.ID, s.Path, err)
+			}
+		}()
+	}
+}
+
+// CreateUploadSession starts a chunked upload of session.Size bytes to session.Path
+func (f service) CreateUploadSession(session file.UploadSession, user string) (*file.UploadSession, error) {
+	if session.Size < 0 || strings.HasSuffix(session.Path, "/") {
+		return nil, errors.New("invalid upload size or path")
+	}
+	if max := MaxUploadSize(); max > 0 && session.Size > max {
+		return nil, ErrTooLarge
+	}
+	if _, err := f.GetPodTool(session.Request()); err != nil {
+		return nil, err
+	}
+	f.removeExpiredUploadSessions()
+	session.ID = uuid.New().String()
+	session.Path = path.Clean(session.Path)
+	session.Offset = 0
+	session.Completed = false
+	session.CreatedBy = user
+	session.CreatedAt = time.Now()
+	session.UpdatedAt = session.CreatedAt
+	uploadSessions.Lock()
+	uploadSessions.items[session.ID] = &uploadSession{UploadSession: session}
+	uploadSessions.Unlock()
+	return &session, nil
+}
+
+func getUploadSession(id, user string) (*uploadSession, error) {
+	uploadSessions.Lock()
+	defer uploadSessions.Unlock()
+	s, ok := uploadSessions.items[id]
+	if !ok || s.CreatedBy != user {
+		return nil, ErrUploadSessionNotFound
+	}
+	return s, nil
+}
+
+// GetUploadSession returns the session with the offset the upload can be resumed from
+func (f service) GetUploadSession(id, user string) (*file.UploadSession, error) {
+	s, err := getUploadSession(id, user)
+	if err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := f.syncUploadOffset(s); err != nil {
+		return nil, err
+	}
+	session := s.UploadSession
+	return &session, nil
+}
+
+// syncUploadOffset takes the offset from the temp file, which is what was uploaded even when a chunk response got lost
+func (f service) syncUploadOffset(s *uploadSession) error {
+	pt, err := f.GetPodTool(s.Request())
+	if err != nil {
+		return err
+	}
+	size, err := pt.FileSize(uploadTempPath(&s.UploadSession))
+	if err != nil {
+		return err
+	}
+	s.Offset = size
+	return nil
+}
+
+// UploadChunk appends the chunk at offset, the file is moved to its path once all bytes are uploaded
+func (f service) UploadChunk(id, user string, offset int64, chunk io.Reader) (*file.UploadSession, error) {
+	s, err := getUploadSession(id, user)
+	if err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if offset != s.Offset {
+		if err := f.syncUploadOffset(s); err != nil {
+			return nil, err
+		}
+		if offset != s.Offset {
+			return nil, podtool.ErrOffsetMismatch
+		}
+	}
+	pt, err := f.GetPodTool(s.Request())
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingReader{reader: io.LimitReader(chunk, s.Size-offset)}
+	if err := pt.AppendChunk(uploadTempPath(&s.UploadSession), offset, counter); err != nil {
+		return nil, err
+	}
+	s.Offset = offset + counter.n
+	s.UpdatedAt = time.Now()
+	if s.Offset == s.Size {
+		if err := pt.MoveFile(uploadTempPath(&s.UploadSession), s.Path); err != nil {
+			return nil, err
+		}
+		s.Completed = true
+		uploadSessions.Lock()
+		delete(uploadSessions.items, id)
+		uploadSessions.Unlock()
+	}
+	session := s.UploadSession
+	return &session, nil
+}
+
+// DeleteUploadSession aborts the upload and removes the uploaded bytes
+func (f service) DeleteUploadSession(id, user string) error {
+	s, err := getUploadSession(id, user)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	uploadSessions.Lock()
+	delete(uploadSessions.items, id)
+	uploadSessions.Unlock()
+	pt, err := f.GetPodTool(s.Request())
+	if err != nil {
+		return err
+	}
+	return pt.RemoveFile(uploadTempPath(&s.UploadSession))
+}
+
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
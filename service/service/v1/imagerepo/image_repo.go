@@ -2,6 +2,7 @@ package imagerepo
 
 import (
 	"errors"
+	"strings"
 	"time"
 
 	V1ClusterRepo "github.com/KubeOperator/kubepi/service/model/v1/clusterrepo"
@@ -27,6 +28,9 @@ type Service interface {
 	ListByCluster(cluster string, options common.DBOptions) (result []V1ImageRepo.ImageRepo, err error)
 	ListImages(repo, cluster string, options common.DBOptions) (names []string, err error)
 	ListImagesByRepo(repo string, page, limit int, search, token string, options common.DBOptions) (res V1ImageRepo.RepoResponse, err error)
+	GetManifest(repo, image, tag string, options common.DBOptions) (*repos.Manifest, error)
+	DeleteTag(repo, image, tag string, options common.DBOptions) error
+	Retag(repo string, request repos.RetagRequest, options common.DBOptions) error
 }
 
 func NewService() Service {
@@ -252,3 +256,72 @@ func (s *service) UpdateRepo(name string, repo *V1ImageRepo.ImageRepo, options c
 
 	return db.Update(repo)
 }
+
+func newRepoClient(rp V1ImageRepo.ImageRepo) (repoClient.RepoClient, error) {
+	client := repoClient.NewClient(repos.Config{
+		Type:     rp.Type,
+		EndPoint: rp.EndPoint,
+		Credential: repos.Credential{
+			Username: rp.Credential.Username,
+			Password: rp.Credential.Password,
+		},
+		Version: rp.Version,
+	})
+	if client == nil {
+		return nil, errors.New("repo client is not found")
+	}
+	return client, nil
+}
+
+// trimImage accepts the image names as listed, prefixed by the download url of the repo
+func trimImage(rp V1ImageRepo.ImageRepo, image string) string {
+	if rp.DownloadUrl != "" {
+		image = strings.TrimPrefix(image, rp.DownloadUrl+"/")
+	}
+	return image
+}
+
+func (s *service) GetManifest(repo, image, tag string, options common.DBOptions) (*repos.Manifest, error) {
+	rp, err := s.GetByName(repo, options)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newRepoClient(rp)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetManifest(repos.ImageRequest{Repo: rp.RepoName, Image: trimImage(rp, image), Tag: tag})
+}
+
+func (s *service) DeleteTag(repo, image, tag string, options common.DBOptions) error {
+	rp, err := s.GetByName(repo, options)
+	if err != nil {
+		return err
+	}
+	client, err := newRepoClient(rp)
+	if err != nil {
+		return err
+	}
+	return client.DeleteTag(repos.ImageRequest{Repo: rp.RepoName, Image: trimImage(rp, image), Tag: tag})
+}
+
+func (s *service) Retag(repo string, request repos.RetagRequest, options common.DBOptions) error {
+	rp, err := s.GetByName(repo, options)
+	if err != nil {
+		return err
+	}
+	client, err := newRepoClient(rp)
+	if err != nil {
+		return err
+	}
+	request.Repo = rp.RepoName
+	request.Image = trimImage(rp, request.Image)
+	request.TargetImage = trimImage(rp, request.TargetImage)
+	if request.TargetImage == "" {
+		request.TargetImage = request.Image
+	}
+	if request.Tag == "" || request.TargetTag == "" {
+		return errors.New("tag and target tag are required")
+	}
+	return client.Retag(request)
+}
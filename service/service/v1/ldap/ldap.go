@@ -12,8 +12,6 @@ import (
 	"github.com/KubeOperator/kubepi/service/service/v1/common"
 	"github.com/KubeOperator/kubepi/service/service/v1/rolebinding"
 	"github.com/KubeOperator/kubepi/service/service/v1/user"
-	ldapClient "github.com/KubeOperator/kubepi/pkg/util/ldap"
-	"github.com/asdine/storm/v3"
 	"github.com/asdine/storm/v3/q"
 	"github.com/google/uuid"
 	"reflect"
@@ -28,7 +26,8 @@ type Service interface {
 	Update(id string, ldap *v1Ldap.Ldap, options common.DBOptions) error
 	GetById(id string, options common.DBOptions) (*v1Ldap.Ldap, error)
 	Delete(id string, options common.DBOptions) error
-	Sync(id string, options common.DBOptions) error
+	StartSync(id string, dryRun bool, options common.DBOptions) (*v1Ldap.SyncRecord, error)
+	ListSyncRecords(id string, options common.DBOptions) ([]v1Ldap.SyncRecord, error)
 	Login(user v1User.User, password string, options common.DBOptions) error
 	TestConnect(ldap *v1Ldap.Ldap) (int, error)
 	TestLogin(username string, password string) error
@@ -56,11 +55,12 @@ func (l *service) Create(ldap *v1Ldap.Ldap, options common.DBOptions) error {
 	if err != nil {
 		return err
 	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc := newClient(ldap)
 	err = lc.Connect()
 	if err != nil {
 		return err
 	}
+	lc.Close()
 	db := l.GetDB(options)
 	ldap.UUID = uuid.New().String()
 	ldap.CreateAt = time.Now()
@@ -83,7 +83,7 @@ func (l *service) Update(id string, ldap *v1Ldap.Ldap, options common.DBOptions)
 	if err != nil {
 		return err
 	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc := newClient(ldap)
 	if err := lc.Connect(); err != nil {
 		return err
 	}
@@ -94,7 +94,20 @@ func (l *service) Update(id string, ldap *v1Ldap.Ldap, options common.DBOptions)
 	ldap.UUID = old.UUID
 	ldap.CreateAt = old.CreateAt
 	ldap.UpdateAt = time.Now()
+	ldap.Sync.LastSyncAt = old.Sync.LastSyncAt
 	db := l.GetDB(options)
+	if ldap.StartTLS != old.StartTLS {
+		err = db.UpdateField(ldap, "StartTLS", ldap.StartTLS)
+		if err != nil {
+			return err
+		}
+	}
+	if err := db.UpdateField(ldap, "Servers", ldap.Servers); err != nil {
+		return err
+	}
+	if err := db.UpdateField(ldap, "Sync", ldap.Sync); err != nil {
+		return err
+	}
 	if ldap.Enable != old.Enable {
 		err = db.UpdateField(ldap, "Enable", ldap.Enable)
 		if err != nil {
@@ -142,7 +155,7 @@ func (l *service) GetLdapUser() ([]v1User.ImportUser, error) {
 	if !ldap.Enable {
 		return users, errors.New("请先启用LDAP")
 	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc := newClient(&ldap)
 	if err := lc.Connect(); err != nil {
 		return users, err
 	}
@@ -193,7 +206,7 @@ func (l *service) TestConnect(ldap *v1Ldap.Ldap) (int, error) {
 		return users, errors.New("请先启用LDAP")
 	}
 
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc := newClient(ldap)
 	if err := lc.Connect(); err != nil {
 		return users, err
 	}
@@ -241,7 +254,7 @@ func (l *service) TestLogin(username string, password string) error {
 			userFilter = "(" + v + "=" + username + ")"
 		}
 	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc := newClient(&ldap)
 	if err := lc.Connect(); err != nil {
 		return err
 	}
@@ -265,7 +278,7 @@ func (l *service) Login(user v1User.User, password string, options common.DBOpti
 			userFilter = "(" + v + "=" + user.Name + ")"
 		}
 	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc := newClient(&ldap)
 	if err := lc.Connect(); err != nil {
 		return err
 	}
@@ -331,95 +344,3 @@ func (l *service) ImportUsers(users []v1User.ImportUser) (v1User.ImportResult, e
 	}
 	return result, nil
 }
-
-func (l *service) Sync(id string, options common.DBOptions) error {
-	ldap, err := l.GetById(id, options)
-	if err != nil {
-		return err
-	}
-	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
-	if err := lc.Connect(); err != nil {
-		return err
-	}
-	go func() {
-		server.Logger().Info("start sync ldap user")
-		insertCount := 0
-		attributes, err := ldap.GetAttributes()
-		if err != nil {
-			server.Logger().Errorf("can not get ldap map attributes")
-			return
-		}
-		mappings, err := ldap.GetMappings()
-		if err != nil {
-			server.Logger().Errorf("can not get ldap mappings")
-			return
-		}
-		entries, err := lc.Search(ldap.Dn, ldap.Filter, ldap.SizeLimit, ldap.TimeLimit, attributes)
-		if err != nil {
-			server.Logger().Errorf(err.Error())
-			return
-		}
-		for _, entry := range entries {
-			us := new(v1User.User)
-			rv := reflect.ValueOf(&us).Elem().Elem()
-
-			for _, at := range entry.Attributes {
-				for k, v := range mappings {
-					if v == at.Name && len(at.Values) > 0 {
-						fv := rv.FieldByName(k)
-						if fv.IsValid() {
-							fv.Set(reflect.ValueOf(strings.Trim(at.Values[0], " ")))
-						}
-					}
-				}
-			}
-			if us.Email == "" || us.Name == "" {
-				continue
-			}
-			if us.NickName == "" {
-				us.NickName = us.Name
-			}
-			us.Type = v1User.LDAP
-			_, err := l.userService.GetByNameOrEmail(us.Name, options)
-			if errors.Is(err, storm.ErrNotFound) {
-				tx, err := server.DB().Begin(true)
-				if err != nil {
-					server.Logger().Errorf("create tx err:  %s", err)
-				}
-				err = l.userService.Create(us, common.DBOptions{DB: tx})
-				if err != nil {
-					_ = tx.Rollback()
-					server.Logger().Errorf("can not insert user %s , err:  %s", us.Name, err)
-					continue
-				}
-				roleName := "Common User"
-				binding := v1Role.Binding{
-					BaseModel: v1.BaseModel{
-						Kind:       "RoleBind",
-						ApiVersion: "v1",
-						CreatedBy:  "admin",
-					},
-					Metadata: v1.Metadata{
-						Name: fmt.Sprintf("role-binding-%s-%s", roleName, us.Name),
-					},
-					Subject: v1Role.Subject{
-						Kind: "User",
-						Name: us.Name,
-					},
-					RoleRef: roleName,
-				}
-				if err := l.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
-					_ = tx.Rollback()
-					server.Logger().Errorf("can not create  user role %s , err:  %s", us.Name, err)
-					continue
-				}
-				_ = tx.Commit()
-				insertCount++
-			}
-		}
-
-		server.Logger().Infof("sync ldap user %d , insert user %d", len(entries), insertCount)
-	}()
-
-	return nil
-}
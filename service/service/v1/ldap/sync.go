@@ -0,0 +1,440 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	"github.com/KubeOperator/kubepi/pkg/loginsession"
+	ldapClient "github.com/KubeOperator/kubepi/pkg/util/ldap"
+	v1 "github.com/KubeOperator/kubepi/service/model/v1"
+	v1Ldap "github.com/KubeOperator/kubepi/service/model/v1/ldap"
+	v1Role "github.com/KubeOperator/kubepi/service/model/v1/role"
+	v1User "github.com/KubeOperator/kubepi/service/model/v1/user"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"github.com/KubeOperator/kubepi/service/service/v1/rolebinding"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+// roleBindingCreator marks the role bindings managed by ldap sync,
+// only those are removed when a user leaves a mapped group.
+const roleBindingCreator = "ldap-sync"
+
+const defaultRoleName = "Common User"
+
+const defaultGroupMemberAttr = "member"
+
+var syncLock = struct {
+	sync.Mutex
+	running map[string]bool
+}{running: map[string]bool{}}
+
+// ldapUser is a directory user with the groups (dn and cn, lower case) it belongs to
+type ldapUser struct {
+	user   v1User.User
+	dn     string
+	groups *collectons.StringSet
+}
+
+// syncPlan is the set of changes a sync applies
+type syncPlan struct {
+	create        []ldapUser
+	disable       []v1User.User
+	enable        []v1User.User
+	delete        []v1User.User
+	addBinding    []v1Role.Binding
+	removeBinding []v1Role.Binding
+}
+
+func newClient(ldap *v1Ldap.Ldap) *ldapClient.Ldap {
+	lc := ldapClient.NewLdapClient(ldap.Address, ldap.Port, ldap.Username, ldap.Password, ldap.TLS)
+	lc.StartTLS = ldap.StartTLS
+	lc.Servers = ldap.Servers
+	return lc
+}
+
+func (l *service) StartSync(id string, dryRun bool, options common.DBOptions) (*v1Ldap.SyncRecord, error) {
+	ldap, err := l.GetById(id, options)
+	if err != nil {
+		return nil, err
+	}
+	syncLock.Lock()
+	if syncLock.running[id] {
+		syncLock.Unlock()
+		return nil, errors.New("ldap sync is already running")
+	}
+	syncLock.running[id] = true
+	syncLock.Unlock()
+
+	record := &v1Ldap.SyncRecord{
+		BaseModel: v1.BaseModel{
+			ApiVersion: "v1",
+			Kind:       "LdapSyncRecord",
+			CreateAt:   time.Now(),
+			UpdateAt:   time.Now(),
+		},
+		Metadata: v1.Metadata{
+			Name: fmt.Sprintf("ldap-sync-%d", time.Now().UnixNano()),
+			UUID: uuid.New().String(),
+		},
+		LdapRef: ldap.UUID,
+		DryRun:  dryRun,
+		Status:  v1Ldap.SyncStatusRunning,
+	}
+	db := l.GetDB(options)
+	if err := db.Save(record); err != nil {
+		l.finishSync(id)
+		return nil, err
+	}
+	run := func() {
+		defer l.finishSync(id)
+		l.runSync(ldap, record, options)
+	}
+	if dryRun {
+		run()
+		return record, nil
+	}
+	result := *record
+	go run()
+	return &result, nil
+}
+
+func (l *service) finishSync(id string) {
+	syncLock.Lock()
+	defer syncLock.Unlock()
+	delete(syncLock.running, id)
+}
+
+func (l *service) runSync(ldap *v1Ldap.Ldap, record *v1Ldap.SyncRecord, options common.DBOptions) {
+	db := l.GetDB(options)
+	plan, err := l.planSync(ldap, options)
+	if err == nil && !record.DryRun {
+		err = l.applySync(plan, options)
+	}
+	if plan != nil {
+		plan.report(record)
+	}
+	record.Status = v1Ldap.SyncStatusSuccess
+	if err != nil {
+		record.Status = v1Ldap.SyncStatusFailed
+		record.Message = err.Error()
+		server.Logger().Errorf("sync ldap %s failed: %s", ldap.Name, err)
+	}
+	record.EndAt = time.Now()
+	record.UpdateAt = time.Now()
+	if err := db.Save(record); err != nil {
+		server.Logger().Errorf("can not save ldap sync record: %s", err)
+	}
+	if !record.DryRun {
+		ldap.Sync.LastSyncAt = record.CreateAt
+		if err := db.UpdateField(ldap, "Sync", ldap.Sync); err != nil {
+			server.Logger().Errorf("can not update ldap last sync time: %s", err)
+		}
+	}
+}
+
+func (l *service) ListSyncRecords(id string, options common.DBOptions) ([]v1Ldap.SyncRecord, error) {
+	db := l.GetDB(options)
+	records := make([]v1Ldap.SyncRecord, 0)
+	if err := db.Select(q.Eq("LdapRef", id)).OrderBy("CreateAt").Reverse().Find(&records); err != nil {
+		if errors.Is(err, storm.ErrNotFound) {
+			return records, nil
+		}
+		return nil, err
+	}
+	return records, nil
+}
+
+func (l *service) planSync(ldap *v1Ldap.Ldap, options common.DBOptions) (*syncPlan, error) {
+	dirUsers, err := l.searchDirectoryUsers(ldap)
+	if err != nil {
+		return nil, err
+	}
+	if len(dirUsers) == 0 && ldap.Sync.AbsentUserAction != v1Ldap.AbsentUserKeep {
+		// most likely a wrong filter, do not disable or delete every ldap user
+		return nil, errors.New("no user found in the directory")
+	}
+	users, err := l.userService.List(options)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]v1User.User{}
+	for i := range users {
+		existing[users[i].Name] = users[i]
+	}
+
+	plan := &syncPlan{}
+	found := collectons.NewStringSet()
+	for i := range dirUsers {
+		du := dirUsers[i]
+		found.Add(du.user.Name)
+		u, ok := existing[du.user.Name]
+		if !ok {
+			if du.user.Email == "" {
+				continue
+			}
+			plan.create = append(plan.create, du)
+		} else if u.Type != v1User.LDAP {
+			// a local user with the same name, never touch it
+			continue
+		} else if u.Disabled {
+			plan.enable = append(plan.enable, u)
+		}
+		if err := l.planRoleBindings(ldap, du, plan, options); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range users {
+		if users[i].Type != v1User.LDAP || found.Exists(users[i].Name) {
+			continue
+		}
+		switch ldap.Sync.AbsentUserAction {
+		case v1Ldap.AbsentUserDisable:
+			if !users[i].Disabled {
+				plan.disable = append(plan.disable, users[i])
+			}
+		case v1Ldap.AbsentUserDelete:
+			plan.delete = append(plan.delete, users[i])
+		}
+	}
+	return plan, nil
+}
+
+func (l *service) planRoleBindings(ldap *v1Ldap.Ldap, du ldapUser, plan *syncPlan, options common.DBOptions) error {
+	if len(ldap.Sync.GroupMappings) == 0 {
+		return nil
+	}
+	desired := collectons.NewStringSet()
+	for _, m := range ldap.Sync.GroupMappings {
+		if du.groups.Exists(strings.ToLower(m.Group)) {
+			for _, r := range m.Roles {
+				desired.Add(r)
+			}
+		}
+	}
+	bindings, err := l.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: du.user.Name}, options)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	current := collectons.NewStringSet()
+	for i := range bindings {
+		current.Add(bindings[i].RoleRef)
+		if bindings[i].CreatedBy == roleBindingCreator && !desired.Exists(bindings[i].RoleRef) {
+			plan.removeBinding = append(plan.removeBinding, bindings[i])
+		}
+	}
+	for _, r := range desired.ToSlice() {
+		if current.Exists(r) {
+			continue
+		}
+		plan.addBinding = append(plan.addBinding, rolebinding.NewUserRoleBinding(r, du.user.Name, roleBindingCreator))
+	}
+	return nil
+}
+
+func (l *service) searchDirectoryUsers(ldap *v1Ldap.Ldap) ([]ldapUser, error) {
+	attributes, err := ldap.GetAttributes()
+	if err != nil {
+		return nil, err
+	}
+	mappings, err := ldap.GetMappings()
+	if err != nil {
+		return nil, err
+	}
+	useMemberOf := len(ldap.Sync.GroupMappings) > 0 && ldap.Sync.GroupFilter == ""
+	if useMemberOf {
+		attributes = append(attributes, "memberOf")
+	}
+	lc := newClient(ldap)
+	if err := lc.Connect(); err != nil {
+		return nil, err
+	}
+	defer lc.Close()
+	entries, err := lc.SearchEntries(ldap.Dn, ldap.Filter, ldap.SizeLimit, ldap.TimeLimit, attributes)
+	if err != nil {
+		return nil, err
+	}
+	var result []ldapUser
+	byDn := map[string]int{}
+	byName := map[string]int{}
+	for _, entry := range entries {
+		du := ldapUser{dn: strings.ToLower(entry.DN), groups: collectons.NewStringSet()}
+		rv := reflect.ValueOf(&du.user).Elem()
+		for _, at := range entry.Attributes {
+			for k, v := range mappings {
+				if v == at.Name && len(at.Values) > 0 {
+					fv := rv.FieldByName(k)
+					if fv.IsValid() {
+						fv.Set(reflect.ValueOf(strings.Trim(at.Values[0], " ")))
+					}
+				}
+			}
+		}
+		if du.user.Name == "" {
+			continue
+		}
+		if useMemberOf {
+			for _, g := range entry.GetAttributeValues("memberOf") {
+				addGroup(du.groups, g)
+			}
+		}
+		byDn[du.dn] = len(result)
+		byName[strings.ToLower(du.user.Name)] = len(result)
+		result = append(result, du)
+	}
+
+	if len(ldap.Sync.GroupMappings) > 0 && ldap.Sync.GroupFilter != "" {
+		memberAttr := ldap.Sync.GroupMemberAttr
+		if memberAttr == "" {
+			memberAttr = defaultGroupMemberAttr
+		}
+		groupDn := ldap.Sync.GroupDn
+		if groupDn == "" {
+			groupDn = ldap.Dn
+		}
+		groups, err := lc.SearchEntries(groupDn, ldap.Sync.GroupFilter, ldap.SizeLimit, ldap.TimeLimit, []string{"cn", memberAttr})
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			for _, member := range g.GetAttributeValues(memberAttr) {
+				member = strings.ToLower(member)
+				index, ok := byDn[member]
+				if !ok {
+					// posix groups reference members by uid instead of dn
+					index, ok = byName[member]
+				}
+				if ok {
+					addGroup(result[index].groups, g.DN)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// addGroup records a group by its full dn and by its cn
+func addGroup(groups *collectons.StringSet, dn string) {
+	groups.Add(strings.ToLower(dn))
+	if parsed, err := goldap.ParseDN(dn); err == nil && len(parsed.RDNs) > 0 {
+		for _, attr := range parsed.RDNs[0].Attributes {
+			if strings.EqualFold(attr.Type, "cn") {
+				groups.Add(strings.ToLower(attr.Value))
+			}
+		}
+	}
+}
+
+func (l *service) applySync(plan *syncPlan, options common.DBOptions) error {
+	db := l.GetDB(options)
+	for i := range plan.create {
+		us := plan.create[i].user
+		if us.NickName == "" {
+			us.NickName = us.Name
+		}
+		us.Type = v1User.LDAP
+		tx, err := db.Begin(true)
+		if err != nil {
+			return err
+		}
+		if err := l.userService.Create(&us, common.DBOptions{DB: tx}); err != nil {
+			_ = tx.Rollback()
+			server.Logger().Errorf("can not insert user %s , err:  %s", us.Name, err)
+			continue
+		}
+		binding := rolebinding.NewUserRoleBinding(defaultRoleName, us.Name, "admin")
+		if err := l.roleBindingService.CreateRoleBinding(&binding, common.DBOptions{DB: tx}); err != nil {
+			_ = tx.Rollback()
+			server.Logger().Errorf("can not create  user role %s , err:  %s", us.Name, err)
+			continue
+		}
+		_ = tx.Commit()
+	}
+	for i := range plan.enable {
+		if err := db.UpdateField(&plan.enable[i], "Disabled", false); err != nil {
+			return err
+		}
+	}
+	for i := range plan.disable {
+		if err := db.UpdateField(&plan.disable[i], "Disabled", true); err != nil {
+			return err
+		}
+		loginsession.LoginSessions.RevokeUser(plan.disable[i].Name, "the user has been disabled")
+	}
+	for i := range plan.delete {
+		if err := l.userService.DeleteWithBindings(plan.delete[i].Name, options); err != nil {
+			server.Logger().Errorf("can not delete user %s , err:  %s", plan.delete[i].Name, err)
+			continue
+		}
+		loginsession.LoginSessions.RevokeUser(plan.delete[i].Name, "the user has been deleted")
+	}
+	for i := range plan.addBinding {
+		if err := l.roleBindingService.CreateRoleBinding(&plan.addBinding[i], options); err != nil {
+			server.Logger().Errorf("can not create role binding %s , err:  %s", plan.addBinding[i].Name, err)
+		}
+	}
+	for i := range plan.removeBinding {
+		if err := l.roleBindingService.Delete(plan.removeBinding[i].Name, options); err != nil {
+			server.Logger().Errorf("can not delete role binding %s , err:  %s", plan.removeBinding[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *syncPlan) report(record *v1Ldap.SyncRecord) {
+	for i := range p.create {
+		record.CreatedUsers = append(record.CreatedUsers, p.create[i].user.Name)
+	}
+	for i := range p.enable {
+		record.EnabledUsers = append(record.EnabledUsers, p.enable[i].Name)
+	}
+	for i := range p.disable {
+		record.DisabledUsers = append(record.DisabledUsers, p.disable[i].Name)
+	}
+	for i := range p.delete {
+		record.DeletedUsers = append(record.DeletedUsers, p.delete[i].Name)
+	}
+	for i := range p.addBinding {
+		record.AddedRoleBindings = append(record.AddedRoleBindings, fmt.Sprintf("%s:%s", p.addBinding[i].Subject.Name, p.addBinding[i].RoleRef))
+	}
+	for i := range p.removeBinding {
+		record.RemovedRoleBindings = append(record.RemovedRoleBindings, fmt.Sprintf("%s:%s", p.removeBinding[i].Subject.Name, p.removeBinding[i].RoleRef))
+	}
+}
+
+// StartSyncScheduler checks every minute for enabled ldap configs whose sync interval elapsed
+func StartSyncScheduler() {
+	l := NewService()
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			ldaps, err := l.List(common.DBOptions{})
+			if err != nil {
+				server.Logger().Errorf("can not list ldap for scheduled sync: %s", err)
+				continue
+			}
+			for i := range ldaps {
+				ldap := ldaps[i]
+				if !ldap.Enable || ldap.Sync.Interval <= 0 {
+					continue
+				}
+				if time.Since(ldap.Sync.LastSyncAt) < time.Duration(ldap.Sync.Interval)*time.Minute {
+					continue
+				}
+				if _, err := l.StartSync(ldap.UUID, false, common.DBOptions{}); err != nil {
+					server.Logger().Errorf("start scheduled sync of ldap %s failed: %s", ldap.Name, err)
+				}
+			}
+		}
+	}()
+}
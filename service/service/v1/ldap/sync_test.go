@@ -0,0 +1,90 @@
+package ldap
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/KubeOperator/kubepi/pkg/collectons"
+	v1 "github.com/KubeOperator/kubepi/service/model/v1"
+	v1Ldap "github.com/KubeOperator/kubepi/service/model/v1/ldap"
+	v1Role "github.com/KubeOperator/kubepi/service/model/v1/role"
+	v1User "github.com/KubeOperator/kubepi/service/model/v1/user"
+	"github.com/KubeOperator/kubepi/service/service/v1/common"
+	"github.com/KubeOperator/kubepi/service/service/v1/rolebinding"
+	"github.com/KubeOperator/kubepi/service/service/v1/user"
+	"github.com/asdine/storm/v3"
+)
+
+func TestSyncRoleBindings(t *testing.T) {
+	db, err := storm.Open(filepath.Join(t.TempDir(), "kubepi.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	options := common.DBOptions{DB: db}
+	l := &service{userService: user.NewService(), roleBindingService: rolebinding.NewService()}
+	ldap := &v1Ldap.Ldap{Sync: v1Ldap.SyncConfig{GroupMappings: []v1Ldap.GroupMapping{
+		{Group: "Developers", Roles: []string{"developer"}},
+		{Group: "cn=ops,ou=groups,dc=example,dc=org", Roles: []string{"operator"}},
+	}}}
+
+	// the bindings administrators added are never touched by the sync
+	manual := rolebinding.NewUserRoleBinding("auditor", "alice", "admin")
+	if err := l.roleBindingService.CreateRoleBinding(&manual, options); err != nil {
+		t.Fatal(err)
+	}
+
+	sync := func(groups ...string) *syncPlan {
+		du := ldapUser{user: v1User.User{Metadata: v1.Metadata{Name: "alice"}}, groups: collectons.NewStringSet()}
+		for _, g := range groups {
+			addGroup(du.groups, g)
+		}
+		plan := &syncPlan{}
+		if err := l.planRoleBindings(ldap, du, plan, options); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.applySync(plan, options); err != nil {
+			t.Fatal(err)
+		}
+		return plan
+	}
+	roles := func() []string {
+		bindings, err := l.roleBindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: "alice"}, options)
+		if err != nil && !errors.Is(err, storm.ErrNotFound) {
+			t.Fatal(err)
+		}
+		var names []string
+		for i := range bindings {
+			names = append(names, bindings[i].RoleRef+":"+bindings[i].CreatedBy)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// a member of a mapped group is bound to its roles
+	sync("cn=developers,ou=groups,dc=example,dc=org")
+	if got := roles(); !reflect.DeepEqual(got, []string{"auditor:admin", "developer:ldap-sync"}) {
+		t.Fatalf("unexpected bindings after the user joined a group %v", got)
+	}
+	if plan := sync("cn=developers,ou=groups,dc=example,dc=org"); len(plan.addBinding)+len(plan.removeBinding) != 0 {
+		t.Fatalf("expected an unchanged membership to change nothing, got %+v", plan)
+	}
+
+	// moving to another group swaps the roles
+	plan := sync("cn=ops,ou=groups,dc=example,dc=org")
+	if len(plan.addBinding) != 1 || len(plan.removeBinding) != 1 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if got := roles(); !reflect.DeepEqual(got, []string{"auditor:admin", "operator:ldap-sync"}) {
+		t.Fatalf("unexpected bindings after the user changed groups %v", got)
+	}
+
+	// leaving all mapped groups removes the roles of the sync only
+	sync("cn=others,ou=groups,dc=example,dc=org")
+	if got := roles(); !reflect.DeepEqual(got, []string{"auditor:admin"}) {
+		t.Fatalf("unexpected bindings after the user left the groups %v", got)
+	}
+}
@@ -0,0 +1,216 @@
+package portforward
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/KubeOperator/kubepi/service/model/v1/portforward"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/util/proxy"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	pf "k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+var ErrPortForwardNotFound = errors.New("port forward not found")
+
+type Service interface {
+	// Open returns the port forward of the user to the target, it is created with the config of the user
+	// unless it is open already
+	Open(target portforward.PortForward, config *rest.Config) (*Forward, error)
+	// List returns the open port forwards of the user, of all users if user is empty
+	List(user string) []portforward.PortForward
+	// Delete closes the port forward, user is empty for administrators closing the port forwards of others
+	Delete(id, user string) error
+}
+
+type service struct{}
+
+func NewService() Service {
+	return &service{}
+}
+
+var forwards = struct {
+	sync.Mutex
+	items map[string]*Forward
+}{items: map[string]*Forward{}}
+
+func idleTimeout() time.Duration {
+	minutes := server.Config().Spec.PortForward.IdleTimeout
+	if minutes <= 0 {
+		minutes = 30
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// sameTarget compares the fields a port forward is opened with, they do not change once it is open
+func sameTarget(a, b *portforward.PortForward) bool {
+	return a.Cluster == b.Cluster && a.Kind == b.Kind && a.Namespace == b.Namespace && a.Name == b.Name && a.Port == b.Port && a.CreatedBy == b.CreatedBy
+}
+
+func findForward(target portforward.PortForward) *Forward {
+	for _, f := range forwards.items {
+		if sameTarget(&f.PortForward, &target) {
+			return f
+		}
+	}
+	return nil
+}
+
+func (s *service) Open(target portforward.PortForward, config *rest.Config) (*Forward, error) {
+	forwards.Lock()
+	f := findForward(target)
+	forwards.Unlock()
+	if f != nil {
+		return f, nil
+	}
+
+	target.ID = uuid.New().String()
+	target.CreatedAt = time.Now()
+	target.LastActiveAt = target.CreatedAt
+	var err error
+	switch target.Kind {
+	case portforward.KindPod:
+		f, err = openPodForward(target, config)
+	case portforward.KindService:
+		f, err = openServiceForward(target, config)
+	default:
+		err = fmt.Errorf("unsupported port forward kind %s", target.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	forwards.Lock()
+	// another request may have opened the same port forward meanwhile
+	if existing := findForward(target); existing != nil {
+		forwards.Unlock()
+		f.Close()
+		return existing, nil
+	}
+	forwards.items[f.ID] = f
+	forwards.Unlock()
+	go f.closeWhenIdle(idleTimeout())
+	return f, nil
+}
+
+func (s *service) List(user string) []portforward.PortForward {
+	forwards.Lock()
+	defer forwards.Unlock()
+	timeout := idleTimeout()
+	result := make([]portforward.PortForward, 0, len(forwards.items))
+	for _, f := range forwards.items {
+		if user != "" && f.CreatedBy != user {
+			continue
+		}
+		result = append(result, f.snapshot(timeout))
+	}
+	return result
+}
+
+func (s *service) Delete(id, user string) error {
+	forwards.Lock()
+	f, ok := forwards.items[id]
+	if !ok || (user != "" && f.CreatedBy != user) {
+		forwards.Unlock()
+		return ErrPortForwardNotFound
+	}
+	delete(forwards.items, id)
+	forwards.Unlock()
+	f.Close()
+	return nil
+}
+
+func removeForward(f *Forward) {
+	forwards.Lock()
+	if forwards.items[f.ID] == f {
+		delete(forwards.items, f.ID)
+	}
+	forwards.Unlock()
+	f.Close()
+}
+
+// openPodForward forwards a local port of KubePi to the pod and proxies to it, the connection to the
+// pod is authorized once with the config of the user
+func openPodForward(target portforward.PortForward, config *rest.Config) (*Forward, error) {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	req := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(target.Namespace).
+		Name(target.Name).
+		SubResource("portforward")
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, err
+	}
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+
+	stopChan := make(chan struct{})
+	readyChan := make(chan struct{})
+	forwarder, err := pf.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", target.Port)}, stopChan, readyChan, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- forwarder.ForwardPorts()
+	}()
+	select {
+	case err := <-errChan:
+		if err == nil {
+			err = errors.New("port forward closed")
+		}
+		return nil, err
+	case <-readyChan:
+	}
+	ports, err := forwarder.GetPorts()
+	if err != nil || len(ports) == 0 {
+		close(stopChan)
+		if err == nil {
+			err = errors.New("port forward has no local port")
+		}
+		return nil, err
+	}
+
+	upstream := &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", ports[0].Local)}
+	// the pod answers with its own paths, the links of its pages are rewritten below the proxy path
+	rt := &proxy.Transport{PathPrepend: target.Path, RoundTripper: &http.Transport{}}
+	f := newForward(target, upstream, rt)
+	f.stop = func() { close(stopChan) }
+	go func() {
+		// the connection to the pod is lost, e.g. when it is deleted, the next request opens a new one
+		<-errChan
+		removeForward(f)
+	}()
+	return f, nil
+}
+
+// openServiceForward proxies to the service through the proxy subresource of the API server with the
+// config of the user, so every request is authorized
+func openServiceForward(target portforward.PortForward, config *rest.Config) (*Forward, error) {
+	c := rest.CopyConfig(config)
+	// WebSockets are upgraded over HTTP/1.1 only
+	c.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	transport, err := rest.TransportFor(c)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := url.Parse(c.Host)
+	if err != nil {
+		return nil, err
+	}
+	upstream.Path = path.Join(upstream.Path, fmt.Sprintf("/api/v1/namespaces/%s/services/%s:%d/proxy", target.Namespace, target.Name, target.Port))
+	// the API server rewrites the links of the service below its own proxy path
+	rt := &prefixTransport{from: upstream.Path, to: target.Path, RoundTripper: transport}
+	return newForward(target, upstream, rt), nil
+}
@@ -0,0 +1,75 @@
+package portforward
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/KubeOperator/kubepi/service/model/v1/portforward"
+	"github.com/KubeOperator/kubepi/service/server"
+	"k8s.io/client-go/rest"
+)
+
+func TestServiceForward(t *testing.T) {
+	const apiPrefix = "/api/v1/namespaces/default/services/web:80/proxy"
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie(server.SessionCookieName); err == nil {
+			t.Errorf("the session cookie of KubePi reached the service")
+		}
+		switch r.URL.Path {
+		case apiPrefix + "/login":
+			http.SetCookie(w, &http.Cookie{Name: "token", Value: "1", Path: "/", Domain: "web.default"})
+			w.Header().Set("Location", apiPrefix+"/home")
+			w.WriteHeader(http.StatusFound)
+		case apiPrefix + "/home":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, `<a href="`+apiPrefix+`/about">about</a>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer apiServer.Close()
+
+	target := portforward.PortForward{
+		Cluster:   "test",
+		Kind:      portforward.KindService,
+		Namespace: "default",
+		Name:      "web",
+		Port:      80,
+		Path:      server.ProxyPathPrefix + "test/portforward/services/default/web/80",
+	}
+	f, err := openServiceForward(target, &rest.Config{Host: apiServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/login", nil)
+	r.AddCookie(&http.Cookie{Name: server.SessionCookieName, Value: "session"})
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, r)
+	if location := w.Header().Get("Location"); location != target.Path+"/home" {
+		t.Fatalf("unexpected location %q", location)
+	}
+	cookie := w.Header().Get("Set-Cookie")
+	if !strings.Contains(cookie, "Path="+target.Path+"/") || strings.Contains(cookie, "Domain") {
+		t.Fatalf("unexpected cookie %q", cookie)
+	}
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/home", nil))
+	if body := w.Body.String(); body != `<a href="`+target.Path+`/about">about</a>` {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.HasPrefix(csp, "sandbox") || strings.Contains(csp, "allow-same-origin") {
+		t.Fatalf("unexpected content security policy %q", csp)
+	}
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("expected nosniff")
+	}
+	if f.snapshot(0).Connections != 0 {
+		t.Fatalf("expected no open connections")
+	}
+}
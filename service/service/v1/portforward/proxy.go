@@ -0,0 +1,200 @@
+package portforward
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KubeOperator/kubepi/service/model/v1/portforward"
+	"github.com/KubeOperator/kubepi/service/server"
+)
+
+// Forward is an open port forward, it proxies the requests of its user to the target
+type Forward struct {
+	portforward.PortForward
+	proxy *httputil.ReverseProxy
+	// stop closes the connection to the target, if the port forward holds one
+	stop      func()
+	done      chan struct{}
+	closeOnce sync.Once
+	lock      sync.Mutex
+}
+
+func newForward(target portforward.PortForward, upstream *url.URL, rt http.RoundTripper) *Forward {
+	f := &Forward{PortForward: target, done: make(chan struct{})}
+	f.proxy = &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = upstream.Scheme
+			r.URL.Host = upstream.Host
+			r.URL.Path = strings.TrimSuffix(upstream.Path, "/") + r.URL.Path
+			r.URL.RawPath = ""
+			r.Host = upstream.Host
+			removeKubePiCredentials(r)
+			r.Header.Set("X-Forwarded-Prefix", target.Path)
+			// responses are rewritten, the transport decompresses them
+			r.Header.Del("Accept-Encoding")
+		},
+		Transport: rt,
+		ModifyResponse: func(resp *http.Response) error {
+			rewriteCookies(resp, target.Path)
+			sandbox(resp.Header)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			sandbox(w.Header())
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = io.WriteString(w, err.Error())
+		},
+		// flush right away, the target may stream, e.g. server-sent events
+		FlushInterval: -1,
+	}
+	return f
+}
+
+// ServeHTTP proxies the request, whose url path is the path below the port forward, to the target
+func (f *Forward) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	f.Connections++
+	f.LastActiveAt = time.Now()
+	f.lock.Unlock()
+	defer func() {
+		f.lock.Lock()
+		f.Connections--
+		f.LastActiveAt = time.Now()
+		f.lock.Unlock()
+	}()
+
+	// closing the port forward ends its requests, the upgraded ones included
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-f.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	f.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (f *Forward) Close() {
+	f.closeOnce.Do(func() {
+		close(f.done)
+		if f.stop != nil {
+			f.stop()
+		}
+	})
+}
+
+func (f *Forward) snapshot(timeout time.Duration) portforward.PortForward {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	s := f.PortForward
+	s.ExpiresAt = s.LastActiveAt.Add(timeout)
+	if s.Connections > 0 {
+		s.ExpiresAt = time.Now().Add(timeout)
+	}
+	return s
+}
+
+func (f *Forward) closeWhenIdle(timeout time.Duration) {
+	interval := timeout / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			if time.Now().After(f.snapshot(timeout).ExpiresAt) {
+				removeForward(f)
+				return
+			}
+		}
+	}
+}
+
+// removeKubePiCredentials keeps the session of KubePi from the target
+func removeKubePiCredentials(r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		r.Header.Del("Authorization")
+	}
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != server.SessionCookieName {
+			r.AddCookie(c)
+		}
+	}
+}
+
+// rewriteCookies scopes the cookies of the target to the path of the port forward, so the cookies of
+// different targets do not collide in KubePi
+func rewriteCookies(resp *http.Response, prefix string) {
+	cookies := resp.Cookies()
+	if len(cookies) == 0 {
+		return
+	}
+	resp.Header.Del("Set-Cookie")
+	for _, c := range cookies {
+		c.Domain = ""
+		c.Path = strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(c.Path, "/")
+		resp.Header.Add("Set-Cookie", c.String())
+	}
+}
+
+// sandbox keeps the pages of the target, which are served from the origin of KubePi, out of that origin:
+// without allow-same-origin they get an opaque origin and can not read the cookies or call the API of
+// KubePi. The policy is added, so a policy of the target still applies as well
+func sandbox(h http.Header) {
+	h.Add("Content-Security-Policy", "sandbox allow-scripts allow-forms allow-popups allow-modals allow-downloads")
+	h.Set("X-Content-Type-Options", "nosniff")
+}
+
+// prefixTransport replaces the path prefix the API server rewrites the redirects and the links of HTML
+// pages of services with by the path of the port forward
+type prefixTransport struct {
+	from string
+	to   string
+	http.RoundTripper
+}
+
+func (t *prefixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		if u, err := url.Parse(location); err == nil && strings.HasPrefix(u.Path, t.from) {
+			u.Scheme, u.Host = "", ""
+			u.Path = t.to + strings.TrimPrefix(u.Path, t.from)
+			u.RawPath = ""
+			resp.Header.Set("Location", u.String())
+		}
+	}
+	contentType := strings.TrimSpace(strings.SplitN(resp.Header.Get("Content-Type"), ";", 2)[0])
+	if contentType != "text/html" || resp.Header.Get("Content-Encoding") != "" {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.ReplaceAll(body, []byte(t.from), []byte(t.to))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return resp, nil
+}
@@ -2,6 +2,8 @@ package rolebinding
 
 import (
 	"errors"
+	"fmt"
+	v1 "github.com/KubeOperator/kubepi/service/model/v1"
 	v1Role "github.com/KubeOperator/kubepi/service/model/v1/role"
 	"github.com/KubeOperator/kubepi/service/service/v1/common"
 	"github.com/asdine/storm/v3/q"
@@ -17,6 +19,26 @@ type Service interface {
 	Delete(name string, options common.DBOptions) error
 }
 
+// NewUserRoleBinding returns the binding of the user to the role, createdBy tells who manages it, e.g. an
+// identity provider which removes only its own bindings
+func NewUserRoleBinding(roleName, userName, createdBy string) v1Role.Binding {
+	return v1Role.Binding{
+		BaseModel: v1.BaseModel{
+			Kind:       "RoleBind",
+			ApiVersion: "v1",
+			CreatedBy:  createdBy,
+		},
+		Metadata: v1.Metadata{
+			Name: fmt.Sprintf("role-binding-%s-%s", roleName, userName),
+		},
+		Subject: v1Role.Subject{
+			Kind: "User",
+			Name: userName,
+		},
+		RoleRef: roleName,
+	}
+}
+
 func NewService() Service {
 	return &service{
 	}
@@ -4,12 +4,19 @@ import (
 	"errors"
 	"time"
 
+	v1Role "github.com/KubeOperator/kubepi/service/model/v1/role"
 	v1User "github.com/KubeOperator/kubepi/service/model/v1/user"
+	"github.com/KubeOperator/kubepi/service/server"
+	"github.com/KubeOperator/kubepi/service/service/v1/cluster"
+	"github.com/KubeOperator/kubepi/service/service/v1/clusterbinding"
 	"github.com/KubeOperator/kubepi/service/service/v1/common"
 	"github.com/KubeOperator/kubepi/service/service/v1/role"
 	"github.com/KubeOperator/kubepi/service/service/v1/rolebinding"
+	"github.com/KubeOperator/kubepi/pkg/kubernetes"
 	costomStorm "github.com/KubeOperator/kubepi/pkg/storm"
 	"github.com/KubeOperator/kubepi/pkg/util/lang"
+	"github.com/KubeOperator/kubepi/pkg/util/mfa"
+	"github.com/asdine/storm/v3"
 	"github.com/asdine/storm/v3/q"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -25,16 +32,59 @@ type Service interface {
 	Update(name string, u *v1User.User, options common.DBOptions) error
 	UpdatePassword(name string, oldPassword string, newPassword string, options common.DBOptions) error
 	ResetPassword(name string, newPassword string, options common.DBOptions) error
+	ResetMfa(name string, options common.DBOptions) error
+	UseRecoveryCode(name string, code string, options common.DBOptions) (bool, error)
+	DeleteWithBindings(name string, options common.DBOptions) error
 }
 
 func NewService() Service {
-	return &service{}
+	return &service{
+		rolebindingService:    rolebinding.NewService(),
+		roleService:           role.NewService(),
+		clusterService:        cluster.NewService(),
+		clusterBindingService: clusterbinding.NewService(),
+	}
 }
 
 type service struct {
 	common.DefaultDBService
-	rolebindingService rolebinding.Service
-	roleService        role.Service
+	rolebindingService    rolebinding.Service
+	roleService           role.Service
+	clusterService        cluster.Service
+	clusterBindingService clusterbinding.Service
+}
+
+// DeleteWithBindings removes a user together with its role bindings and cluster
+// memberships, the managed kubernetes role bindings are cleaned on a best effort basis.
+func (u *service) DeleteWithBindings(name string, options common.DBOptions) error {
+	rbs, err := u.rolebindingService.GetRoleBindingBySubject(v1Role.Subject{Kind: "User", Name: name}, options)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	for i := range rbs {
+		if err := u.rolebindingService.Delete(rbs[i].Name, options); err != nil {
+			return err
+		}
+	}
+	cbs, err := u.clusterBindingService.GetBindingsByUserName(name, options)
+	if err != nil && !errors.Is(err, storm.ErrNotFound) {
+		return err
+	}
+	for i := range cbs {
+		if c, err := u.clusterService.Get(cbs[i].ClusterRef, options); err == nil {
+			k := kubernetes.NewKubernetes(c)
+			if err := k.CleanManagedClusterRoleBinding(cbs[i].UserRef); err != nil {
+				server.Logger().Errorf("can not delete cluster member %s : %s", cbs[i].UserRef, err)
+			}
+			if err := k.CleanManagedRoleBinding(cbs[i].UserRef); err != nil {
+				server.Logger().Errorf("can not delete cluster member %s : %s", cbs[i].UserRef, err)
+			}
+		}
+		if err := u.clusterBindingService.Delete(cbs[i].Name, options); err != nil {
+			return err
+		}
+	}
+	return u.Delete(name, options)
 }
 
 func (u *service) ResetPassword(name string, newPassword string, options common.DBOptions) error {
@@ -52,6 +102,35 @@ func (u *service) ResetPassword(name string, newPassword string, options common.
 	return db.Update(cu)
 }
 
+func (u *service) ResetMfa(name string, options common.DBOptions) error {
+	cu, err := u.GetByNameOrEmail(name, options)
+	if err != nil {
+		return err
+	}
+	cu.Mfa.Reset()
+	cu.UpdateAt = time.Now()
+	db := u.GetDB(options)
+	return db.UpdateField(cu, "Mfa", cu.Mfa)
+}
+
+func (u *service) UseRecoveryCode(name string, code string, options common.DBOptions) (bool, error) {
+	cu, err := u.GetByNameOrEmail(name, options)
+	if err != nil {
+		return false, err
+	}
+	index := mfa.MatchRecoveryCode(code, cu.Mfa.RecoveryCodes)
+	if index < 0 {
+		return false, nil
+	}
+	// recovery codes are single use
+	cu.Mfa.RecoveryCodes = append(cu.Mfa.RecoveryCodes[:index], cu.Mfa.RecoveryCodes[index+1:]...)
+	db := u.GetDB(options)
+	if err := db.UpdateField(cu, "Mfa", cu.Mfa); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (u *service) UpdatePassword(name string, oldPassword string, newPassword string, options common.DBOptions) error {
 	cu, err := u.GetByNameOrEmail(name, options)
 	if err != nil {
@@ -86,6 +165,7 @@ func (u *service) Update(name string, us *v1User.User, options common.DBOptions)
 	us.UpdateAt = time.Now()
 	if !us.Mfa.Enable {
 		us.Mfa.Secret = ""
+		us.Mfa.RecoveryCodes = nil
 		err = db.UpdateField(us, "Mfa", us.Mfa)
 		if err != nil {
 			return err
@@ -112,6 +112,28 @@ export function upgradeChart (cluster, name, data) {
   return put(`${appUrl(cluster)}/${name}`, data)
 }
 
+export function getJob (cluster, id) {
+  return get(`${chartUrl(cluster)}/jobs/${id}`)
+}
+
+// installs, upgrades and uninstalls run as jobs in the background, waitForJob polls the job until it is
+// finished and resolves with it, whatever its status
+export function waitForJob (cluster, id, interval = 2000) {
+  return new Promise((resolve, reject) => {
+    const poll = () => {
+      getJob(cluster, id).then(res => {
+        const job = res.data
+        if (["Success", "Failed", "Canceled"].includes(job.status)) {
+          resolve(job)
+        } else {
+          setTimeout(poll, interval)
+        }
+      }).catch(reject)
+    }
+    poll()
+  })
+}
+
 
 
 
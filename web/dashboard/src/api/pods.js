@@ -1,4 +1,4 @@
-import {get, del, post, put, postFile} from "@/plugins/request"
+import {get, del, post, put, postFile, request} from "@/plugins/request"
 
 const podUrl = (cluster_name) => {
   return `/api/v1/proxy/${cluster_name}/k8s/api/v1/pods`
@@ -50,6 +50,12 @@ export function getPodLogsByName (cluster_name, namespace, pod, params) {
   return get(`${podUrlWithNs(cluster_name, namespace)}/${pod}/log`, params)
 }
 
+// podLogsDownloadUrl is the url downloading the unmodified logs of a container, or a zip archive of the logs
+// of the containers of a pod or workload
+export function podLogsDownloadUrl (cluster_name, params) {
+  return `/kubepi/api/v1/clusters/${cluster_name}/logging/download?${new URLSearchParams(params).toString()}`
+}
+
 export function createPod (cluster_name, namespace, pod) {
   return post(`${podUrlWithNs(cluster_name, namespace)}`, pod)
 }
@@ -86,8 +92,24 @@ export function openFile(data) {
   return postFile(podFileUrl+"/files/open",data)
 }
 
-export function uploadFile(data,params) {
-  return postFile(podFileUrl+"/files/upload",data,params)
+export function uploadFile(data,params,onUploadProgress) {
+  return request({url: podFileUrl+"/files/upload", method: "post", data: data, params: params, headers: {"Content-Type": "multipart/form-data"}, timeout: 0, onUploadProgress: onUploadProgress}).then(res => res.data)
+}
+
+export function createUploadSession(data) {
+  return post(podFileUrl+"/files/uploads",data)
+}
+
+export function getUploadSession(id) {
+  return get(podFileUrl+"/files/uploads/"+id)
+}
+
+export function uploadChunk(id,offset,chunk,onUploadProgress) {
+  return request({url: podFileUrl+"/files/uploads/"+id, method: "put", data: chunk, params: {offset: offset}, headers: {"Content-Type": "application/octet-stream"}, timeout: 0, onUploadProgress: onUploadProgress}).then(res => res.data)
+}
+
+export function deleteUploadSession(id) {
+  return del(podFileUrl+"/files/uploads/"+id)
 }
 
 export function renameFile(data) {
@@ -0,0 +1,16 @@
+import {del, get} from "@/plugins/request"
+
+const portForwardsUrl = "/api/v1/proxy/portforwards"
+
+// portForwardUrl is the page of the port of a pod or a service proxied through KubePi, kind is pods or services
+export function portForwardUrl (cluster_name, kind, namespace, name, port) {
+  return `/kubepi/api/v1/proxy/${cluster_name}/portforward/${kind}/${namespace}/${name}/${port}/`
+}
+
+export function listPortForwards (cluster_name) {
+  return get(portForwardsUrl, { cluster: cluster_name })
+}
+
+export function deletePortForward (id) {
+  return del(`${portForwardsUrl}/${id}`)
+}
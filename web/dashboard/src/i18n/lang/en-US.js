@@ -179,11 +179,22 @@ const message = {
       message: "Message",
       restart: "Count"
     },
+    port_forward: {
+      title: "Port Forward",
+      port: "Port",
+      open: "Open",
+      close: "Close",
+      user: "User",
+      connections: "Connections",
+      expires: "Closes When Idle At",
+      help: "The port is opened in a new tab through KubePi with your permissions, the port forward is closed when it is not used for a while",
+    },
     node: {
       cordon: "Cordon",
       uncordon: "Uncordon",
       drain: "Drain",
       existing_cordoned: "The node cordoned already exists",
+      node_shell: "Node Shell",
       existing_actived: "The node actived already exists",
       ready: "Ready",
       role: "Role",
@@ -239,9 +250,14 @@ const message = {
       last_500_lines: "Last 500 lines",
       controller: "Controller  ",
       download_logs: "Download logs",
+      timestamps: "Timestamps",
+      previous: "Previous",
+      include: "Include",
+      exclude: "Exclude",
+      filter_help: "Regular expression",
       start_time: "Start time",
-      limit_byte: "Limit byte",
-      download_log_help: "You will start downloading the Max {1}Mb log files from {0} !",
+      all_containers: "All containers",
+      download_log_help: "You will download the unmodified logs written since {0}, the logs of several containers are downloaded as a zip archive with a file per container",
       last_half_hour: "half hour ago",
       last_three_hour: "3 hours ago",
       last_day: "A day ago",
@@ -251,6 +267,8 @@ const message = {
       last_three_month: "3 months ago",
       metric_server_tip: "Since the  Metrics-Server service is not started, there is no data in the Cpu (Cores) and Memory (bytes) ",
       pod_file: "File Browser",
+      debug: "Debug",
+      debug_image: "Debug Image",
       permission: "Permission",
       last_update: "Last Update Time",
       create_folder: "Create Folder",
@@ -268,6 +286,8 @@ const message = {
       upload_tip: "Files with the same name will be overwritten",
       name_helper: "Support '/' to create multi-level directories",
       link_tip: "This operation is not supported for linked files/folders",
+      backup_file: "Keep a backup of the previous content (.bak)",
+      download_zip: "Download as zip",
     },
     namespace: {
       namespace: "Namespace",
@@ -180,11 +180,22 @@ const message = {
       message: "消息",
       count: "次数"
     },
+    port_forward: {
+      title: "端口转发",
+      port: "端口",
+      open: "打开",
+      close: "关闭",
+      user: "用户",
+      connections: "连接数",
+      expires: "空闲关闭时间",
+      help: "端口将以您的权限通过 KubePi 在新标签页中打开，长时间未使用的端口转发会被自动关闭",
+    },
     node: {
       cordon: "暂停",
       uncordon: "恢复",
       drain: "驱散",
       existing_cordoned: "存在已暂停节点",
+      node_shell: "节点终端",
       existing_actived: "存在已激活节点",
       ready: "准备就绪",
       role: "角色",
@@ -240,9 +251,14 @@ const message = {
       last_500_lines: "最后500行",
       controller: "控制台  ",
       download_logs: "日志下载",
+      timestamps: "时间戳",
+      previous: "上次运行",
+      include: "包含",
+      exclude: "排除",
+      filter_help: "正则表达式",
       start_time: "开始时间",
-      limit_byte: "最大限制",
-      download_log_help: "您将开始下载自 {0} 开始的最大 {1}Mb 日志文件 !",
+      all_containers: "所有容器",
+      download_log_help: "您将下载自 {0} 开始的原始日志，多个容器的日志将打包为 zip 文件，每个容器一个文件",
       last_half_hour: "最近半小时",
       last_three_hour: "最近三小时",
       last_day: "最近一天",
@@ -252,6 +268,8 @@ const message = {
       last_three_month: "最近三月",
       metric_server_tip: "由于 Metrics-Server 服务异常(可能是未启动)，所以暂无数据",
       pod_file: "文件浏览器",
+      debug: "调试",
+      debug_image: "调试镜像",
       permission: "权限",
       last_update: "最后修改时间",
       create_folder: "创建文件夹",
@@ -269,6 +287,8 @@ const message = {
       upload_tip: "同名文件会被覆盖",
       name_helper: "支持 '/' 来创建多级目录",
       link_tip: "链接文件/文件夹 不支持此操作",
+      backup_file: "保留修改前的备份 (.bak)",
+      download_zip: "下载为 zip",
     },
     namespace: {
       namespace: "命名空间",
@@ -7,3 +7,18 @@ export function downloadYaml (name, getDetail) {
     return download(name,yaml.dump(res))
   })
 }
+
+// openWorkloadLogs opens the aggregated logs of all pods of the workload in a new tab
+export function openWorkloadLogs (router, cluster, kind, row) {
+  let routeUrl = router.resolve({
+    path: "/terminal",
+    query: {
+      cluster: cluster,
+      namespace: row.metadata.namespace,
+      kind: kind,
+      workload: row.metadata.name,
+      type: "aggregate"
+    }
+  })
+  window.open(routeUrl.href, "_blank")
+}
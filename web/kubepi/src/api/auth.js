@@ -25,3 +25,13 @@ export function updateProfile(data) {
 export function updatePassword(data) {
     return put(`${authUrl}/password`, data)
 }
+
+export function samlStatus() {
+    return get(`${authUrl}/saml/status`)
+}
+
+// samlLoginUrl is where the browser starts a saml login, it returns to the redirect path of the ui afterwards
+export function samlLoginUrl(redirect) {
+    const path = "/kubepi" + (redirect || "/")
+    return `/kubepi${authUrl}/saml/login?redirect=${encodeURIComponent(path)}`
+}
@@ -98,3 +98,11 @@ export function addClusterRepo(name,data) {
 export function deleteClusterRepo(cluster,repo) {
   return del(`${baseUrl}/${cluster}/repos/${repo}`)
 }
+
+export function updateClusterRepoPullSecret(cluster, repo, pullSecret) {
+  return put(`${baseUrl}/${cluster}/repos/${repo}/pullsecret`, pullSecret)
+}
+
+export function syncClusterRepoPullSecret(cluster, repo) {
+  return post(`${baseUrl}/${cluster}/repos/${repo}/pullsecret/sync`)
+}
@@ -108,6 +108,8 @@ const message = {
             expires: "The authentication information has expired. Please log in again.",
             mfa_helper: "Scan the QR code below with the MFA Authenticator app to get a 6-digit verification code",
             mfa_login_helper:"Please enter 6-digit verification for MFA Authenticator",
+            saml: "Login with SAML",
+            saml_token: "Login succeeded, copy the token for the API client",
         },
     },
     business: {
@@ -154,7 +156,13 @@ const message = {
             ready: "Ready",
             not_ready: "NotReady",
             repo: "Repo",
-            repo_auth: "Repo Authorization"
+            repo_auth: "Repo Authorization",
+            pull_secret: "Pull Secret",
+            pull_secret_failed: "Pull secret failed",
+            all_namespaces: "All namespaces",
+            pull_secret_namespaces: "Pull secret namespaces",
+            patch_service_account: "Add to default ServiceAccount",
+            sync_pull_secret: "Sync pull secret"
         },
         cluster_role: {
             none: "None",
@@ -247,6 +255,7 @@ const description = {
     i18n_user_manage_cluster: "The Cluster Administrator has all the permissions of the cluster object.",
     i18n_user_manage_rbac: "Role and user administrators have all the permissions of the user objects.",
     i18n_user_manage_repo: "Image repostries administrators have all the permissions of the image repostries objects.",
+    i18n_user_manage_chart_repo: "Chart repository administrators can push and delete the charts of the KubePi chart repository.",
     i18n_user_manage_readonly: "Read only user with access to all objects only,",
     i18n_user_common_user: "Ordinary users only have access to cluster objects",
     i18n_user_manage_chart: "Chart warehouse administrator, has all rights to the Chart warehouse",
@@ -286,6 +295,7 @@ const apiObjects = {
     roles: "roles",
     clusters: "clusters",
     systems: "systems",
+    terminalsessions: "terminal sessions",
 }
 
 const apiVerbs = {
@@ -294,7 +304,9 @@ const apiVerbs = {
     "get": "get",
     "list": "list",
     "create": "create",
-    "authorization": "authorization"
+    "authorization": "authorization",
+    "debug": "debug",
+    "nodeshell": "node shell"
 }
 
 const system_logs = {
@@ -309,6 +321,10 @@ const system_logs = {
     clusters_clusterroles: "Cluster Role",
     clusters_repos: "Cluster Repos",
     imagerepos: "Image Registries",
+    terminalsessions: "Terminal Sessions",
+    clusters_terminal: "Cluster Terminal",
+    exec: "execute",
+    deny: "deny",
     ldap: "LDAP",
 }
 
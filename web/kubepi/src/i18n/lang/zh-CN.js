@@ -108,6 +108,8 @@ const message = {
             expires: "认证信息已过期，请重新登录",
             mfa_helper: "使用 MFA 验证器应用扫描以下二维码，获取6位验证码",
             mfa_login_helper: "请输入MFA验证器的6位验证码",
+            saml: "使用 SAML 登录",
+            saml_token: "登录成功，请复制令牌供 API 客户端使用",
         },
     },
     business: {
@@ -155,6 +157,12 @@ const message = {
             not_ready: "异常",
             repo: "仓库",
             repo_auth: "仓库授权",
+            pull_secret: "拉取凭证",
+            pull_secret_failed: "拉取凭证同步失败",
+            all_namespaces: "所有命名空间",
+            pull_secret_namespaces: "拉取凭证命名空间",
+            patch_service_account: "添加到默认 ServiceAccount",
+            sync_pull_secret: "同步拉取凭证",
         },
         cluster_role: {
             none: "无",
@@ -247,6 +255,10 @@ const apiObjects = {
     roles: "角色",
     clusters: "集群",
     systems: "日志审计",
+    terminalsessions: "终端会话",
+    clusters_terminal: "集群终端",
+    exec: "执行",
+    deny: "拒绝",
 }
 
 const apiVerbs = {
@@ -256,7 +268,9 @@ const apiVerbs = {
     list: "列表",
     create: "创建",
     privilege: "特权",
-    authorization: "授权"
+    authorization: "授权",
+    debug: "调试",
+    nodeshell: "节点终端"
 }
 
 const system_logs = {
@@ -271,6 +285,7 @@ const system_logs = {
     clusters_clusterroles: "集群角色",
     clusters_repos: "集群仓库",
     imagerepos: "镜像仓库",
+    terminalsessions: "终端会话",
     ldap: "LDAP",
     sync: "同步",
     import: "导入",
@@ -284,6 +299,7 @@ const description = {
     i18n_user_manage_cluster: "集群管理员，拥有集群对象的所有权限",
     i18n_user_manage_rbac: "角色与用户管理员，拥有用户管理对象的所有权限",
     i18n_user_manage_repo: "镜像仓库管理员，拥有镜像仓库对象的所有权限",
+    i18n_user_manage_chart_repo: "Chart 仓库管理员，可以推送和删除 KubePi Chart 仓库中的 Chart",
     i18n_user_manage_readonly: "只读用户，只拥有所有对象的访问权限",
     i18n_user_common_user: "普通用户，只拥有集群对象访问权限",
     i18n_user_manage_chart: "Chart仓库管理员， 拥有对Chart仓库的所有权限",
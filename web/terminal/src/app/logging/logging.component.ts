@@ -23,6 +23,17 @@ export class LoggingComponent implements AfterViewInit {
     this.container = this.activatedRoute_.snapshot.queryParams["container"]
     this.tailLines = this.activatedRoute_.snapshot.queryParams["tailLines"]
     this.follow = this.activatedRoute_.snapshot.queryParams["follow"]
+    const query = this.activatedRoute_.snapshot.queryParamMap
+    for (const key of ["selector", "kind", "workload", "previous", "timestamps", "sinceSeconds", "sinceTime"]) {
+      if (query.has(key)) {
+        this.aggregateParams[key] = query.get(key)
+      }
+    }
+    for (const key of ["include", "exclude"]) {
+      if (query.has(key)) {
+        this.aggregateParams[key] = query.getAll(key)
+      }
+    }
   }
 
   @ViewChild('anchor', {static: true}) anchorRef: ElementRef;
@@ -33,6 +44,8 @@ export class LoggingComponent implements AfterViewInit {
   container: string;
   tailLines: number;
   follow: boolean;
+  // aggregateParams select the pods of an aggregated log session, by a selector or a workload
+  aggregateParams: { [key: string]: any } = {};
 
   private conn_: WebSocket
 
@@ -41,7 +54,7 @@ export class LoggingComponent implements AfterViewInit {
 
 
   ngAfterViewInit(): void {
-    if (this.clusterName && this.namespace && this.podName) {
+    if (this.clusterName && this.namespace && (this.podName || this.isAggregated())) {
       this.setupConnection()
       this.initTerm()
     } else {
@@ -66,9 +79,25 @@ export class LoggingComponent implements AfterViewInit {
     this.debouncedFit_();
   }
 
+  isAggregated(): boolean {
+    return "selector" in this.aggregateParams || "workload" in this.aggregateParams
+  }
+
+  createSession() {
+    if (this.isAggregated()) {
+      return this.loggingService.createAggregatedLoggingSession(this.clusterName, this.namespace, {
+        ...this.aggregateParams,
+        container: this.container,
+        tailLines: this.tailLines,
+        follow: this.follow,
+      })
+    }
+    return this.loggingService.createLoggingSession(this.clusterName, this.namespace, this.podName, this.container, this.tailLines, this.follow)
+  }
+
   async setupConnection() {
     try {
-      const {data} = await this.loggingService.createLoggingSession(this.clusterName, this.namespace, this.podName, this.container, this.tailLines, this.follow).toPromise()
+      const {data} = await this.createSession().toPromise()
       const id = data.id
       this.conn_ = new SockJS(`/kubepi/api/v1/ws/logging/sockjs?${id}`)
       this.conn_.onopen = () => {
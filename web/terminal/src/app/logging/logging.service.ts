@@ -34,4 +34,21 @@ export class LoggingService {
     }()
     return this.http.get<any>(url)
   }
+
+  // createAggregatedLoggingSession streams the pods of the selector or the workload, params holds the optional
+  // container, tailLines, follow, previous, timestamps, sinceSeconds, sinceTime, include and exclude filters
+  createAggregatedLoggingSession(clusterName: string, namespace: string, params: { [key: string]: any }): Observable<any> {
+    const query = new URLSearchParams()
+    query.append("namespace", namespace)
+    for (const key of Object.keys(params)) {
+      const value = params[key]
+      if (value === undefined || value === null || value === "") {
+        continue
+      }
+      for (const v of Array.isArray(value) ? value : [value]) {
+        query.append(key, v)
+      }
+    }
+    return this.http.get<any>(`/kubepi/api/v1/clusters/${clusterName}/logging/aggregate?${query.toString()}`)
+  }
 }
@@ -2,7 +2,7 @@ import {AfterViewInit, ChangeDetectorRef, Component, ElementRef, OnInit, ViewChi
 import {Terminal} from 'xterm';
 import {FitAddon} from 'xterm-addon-fit';
 import {ReplaySubject, Subject} from "rxjs";
-import {ShellFrame, SJSCloseEvent, SJSMessageEvent} from "./terminal";
+import {ShellFrame, SJSCloseEvent, SJSMessageEvent, TerminalViewer} from "./terminal";
 import {ActivatedRoute, Router} from "@angular/router";
 import {debounce} from 'lodash';
 import {takeUntil} from "rxjs/operators";
@@ -23,6 +23,19 @@ export class TerminalComponent implements AfterViewInit {
   podName: string;
   container: string;
   shell: string;
+  // type is "debug" for an ephemeral debug container, "node" for a node shell, "shared" for a terminal
+  // shared by another user, or empty for the container
+  type: string;
+  image: string;
+  node: string;
+  share: string;
+  // sessionId is the terminal session, owner, target and writable describe the joined one of a shared terminal
+  sessionId: string;
+  owner: string;
+  target: string;
+  writable = true;
+  viewers: TerminalViewer[] = [];
+  shareLink: string;
 
   private readonly namespace_: string
   clusterName: string;
@@ -48,10 +61,30 @@ export class TerminalComponent implements AfterViewInit {
     this.podName = this.activatedRoute_.snapshot.queryParams["pod"]
     this.container = this.activatedRoute_.snapshot.queryParams["container"]
     this.shell = this.activatedRoute_.snapshot.queryParams["shell"]
+    this.type = this.activatedRoute_.snapshot.queryParams["type"]
+    this.image = this.activatedRoute_.snapshot.queryParams["image"]
+    this.node = this.activatedRoute_.snapshot.queryParams["node"]
+    this.share = this.activatedRoute_.snapshot.queryParams["share"]
   }
 
 
   ngAfterViewInit(): void {
+    if (this.type === 'shared') {
+      if (this.share) {
+        this.setupConnection()
+      } else {
+        alert("please set param: share")
+      }
+      return
+    }
+    if (this.type === 'node') {
+      if (this.node) {
+        this.setupConnection()
+      } else {
+        alert("please set param: node name ")
+      }
+      return
+    }
     if (this.namespace_ && this.podName && this.container) {
       this.setupConnection()
     } else {
@@ -157,7 +190,10 @@ export class TerminalComponent implements AfterViewInit {
 
 
   private async setupConnection(): Promise<void> {
-    if (!(this.container && this.podName && this.namespace_ && !this.connecting_)) {
+    if (this.connecting_) {
+      return;
+    }
+    if (!this.hasTarget()) {
       return;
     }
 
@@ -165,8 +201,14 @@ export class TerminalComponent implements AfterViewInit {
     this.connectionClosed_ = false;
 
     try {
-      const {data} = await this.terminalService.createTerminalSession(this.clusterName, this.namespace_, this.podName, this.container, this.shell).toPromise()
+      const {data} = await this.createSession().toPromise()
       const id = data.id
+      this.sessionId = id
+      if (this.type === 'shared') {
+        this.owner = data.owner
+        this.target = data.target
+        this.writable = data.writable
+      }
       this.conn_ = new SockJS(`/kubepi/api/v1/ws/terminal/sockjs?${id}`);
       this.conn_.onopen = this.onConnectionOpen.bind(this, id);
       this.conn_.onmessage = this.onConnectionMessage.bind(this);
@@ -178,6 +220,30 @@ export class TerminalComponent implements AfterViewInit {
     }
   }
 
+  private hasTarget(): boolean {
+    switch (this.type) {
+      case 'shared':
+        return !!this.share
+      case 'node':
+        return !!this.node
+      default:
+        return !!(this.container && this.podName && this.namespace_)
+    }
+  }
+
+  private createSession() {
+    switch (this.type) {
+      case 'shared':
+        return this.terminalService.joinSharedSession(this.clusterName, this.share)
+      case 'debug':
+        return this.terminalService.createDebugSession(this.clusterName, this.namespace_, this.podName, this.container, this.image, this.shell)
+      case 'node':
+        return this.terminalService.createNodeShellSession(this.clusterName, this.node, this.shell)
+      default:
+        return this.terminalService.createTerminalSession(this.clusterName, this.namespace_, this.podName, this.container, this.shell)
+    }
+  }
+
   private handleConnectionMessage(frame: ShellFrame): void {
     if (frame.Op === 'stdout') {
       if (frame.Data)
@@ -188,6 +254,10 @@ export class TerminalComponent implements AfterViewInit {
       alert(frame.Data)
     }
 
+    if (frame.Op === 'viewers') {
+      this.viewers = JSON.parse(frame.Data || '[]')
+    }
+
     this.incommingMessage$_.next(frame);
     this.cdr_.markForCheck();
   }
@@ -210,8 +280,28 @@ export class TerminalComponent implements AfterViewInit {
     this.cdr_.markForCheck();
   }
 
+  async shareTerminal(writable: boolean): Promise<void> {
+    try {
+      const {data} = await this.terminalService.shareSession(this.clusterName, this.sessionId, writable).toPromise()
+      this.shareLink = `${location.origin}${location.pathname}?type=shared&cluster=${this.clusterName}&share=${data.token}`
+      this.cdr_.markForCheck()
+    } catch (e: any) {
+      alert(e.error.message)
+    }
+  }
+
+  async stopSharing(): Promise<void> {
+    try {
+      await this.terminalService.revokeShares(this.clusterName, this.sessionId).toPromise()
+      this.shareLink = ''
+      this.cdr_.markForCheck()
+    } catch (e: any) {
+      alert(e.error.message)
+    }
+  }
+
   private onTerminalSendingString(str: string): void {
-    if (this.connected_) {
+    if (this.connected_ && this.writable) {
       this.conn_.send(JSON.stringify({
         Op: 'stdin',
         Data: str,
@@ -222,7 +312,8 @@ export class TerminalComponent implements AfterViewInit {
   }
 
   private onTerminalResize(): void {
-    if (this.connected_) {
+    // the size of a shared terminal is the one of its owner
+    if (this.connected_ && this.type !== 'shared') {
       this.conn_.send(
         JSON.stringify({
           Op: 'resize',
@@ -21,4 +21,28 @@ export class TerminalService {
     }()
     return this.http.get<any>(url)
   }
+
+  createDebugSession(clusterName: string, namespace: string, podName: string, containerName: string, image: string, shell: string): Observable<any> {
+    let url = `/kubepi/api/v1/clusters/${clusterName}/terminal/debug?namespace=${namespace}&&podName=${podName}&&containerName=${containerName}&&shell=${shell}`
+    if (image) {
+      url = `${url}&&image=${encodeURIComponent(image)}`
+    }
+    return this.http.get<any>(url)
+  }
+
+  createNodeShellSession(clusterName: string, nodeName: string, shell: string): Observable<any> {
+    return this.http.get<any>(`/kubepi/api/v1/clusters/${clusterName}/terminal/node?nodeName=${nodeName}&&shell=${shell}`)
+  }
+
+  joinSharedSession(clusterName: string, share: string): Observable<any> {
+    return this.http.get<any>(`/kubepi/api/v1/clusters/${clusterName}/terminal/join?share=${share}`)
+  }
+
+  shareSession(clusterName: string, sessionId: string, writable: boolean): Observable<any> {
+    return this.http.post<any>(`/kubepi/api/v1/clusters/${clusterName}/terminal/sessions/${sessionId}/shares`, {writable: writable})
+  }
+
+  revokeShares(clusterName: string, sessionId: string): Observable<any> {
+    return this.http.delete<any>(`/kubepi/api/v1/clusters/${clusterName}/terminal/sessions/${sessionId}/shares`)
+  }
 }
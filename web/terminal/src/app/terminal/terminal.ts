@@ -14,6 +14,14 @@ export interface TerminalResponse {
   id: string
 }
 
+export interface TerminalViewer {
+  id: string
+  user: string
+  writable: boolean
+  joinedAt: string
+  connected: boolean
+}
+
 export interface Pod {
   kind: string
   apiVersion: string